@@ -0,0 +1,120 @@
+package cellularlog
+
+import (
+	"time"
+)
+
+// ClockMessageType is the MessageType of the entries that record steps of the host's wall clock and
+// changes of its synchronisation, so that wall times can be corrected afterwards (see pkg/retime).
+const ClockMessageType = "clock"
+
+// ClockStepThreshold is how far the wall clock must move against the monotonic clock between two
+// polls to be taken for a step. NTP slews the clock far more slowly.
+const ClockStepThreshold = 500 * time.Millisecond
+
+const (
+	// ClockStep is a jump of the wall clock, e.g. when NTP or GPS first sets a clock that booted wrong.
+	ClockStep = "step"
+	// ClockSynchronized is the kernel starting to consider the wall clock synchronised.
+	ClockSynchronized = "synchronized"
+	// ClockUnsynchronized is the kernel no longer considering the wall clock synchronised.
+	ClockUnsynchronized = "unsynchronized"
+)
+
+// ClockEvent is the data of a ClockMessageType entry.
+type ClockEvent struct {
+	Event  string        `json:"event"`
+	Before time.Time     `json:"before,omitzero"` // wall time the clock would have shown without the step
+	After  time.Time     `json:"after"`           // wall time the clock shows
+	Step   time.Duration `json:"step,omitempty"`
+
+	// Synchronized is whether the kernel considers the wall clock synchronised, nil where it does not
+	// tell.
+	Synchronized *bool `json:"synchronized,omitempty"`
+}
+
+// clockMonitor compares the wall clock with the monotonic clock at every poll.
+type clockMonitor struct {
+	wall         func() time.Time     // without a monotonic reading
+	offset       func() time.Duration // on the monotonic clock, since the session started
+	synchronized func() (bool, bool)  // see clockSynchronized
+
+	lastWall   time.Time
+	lastOffset time.Duration
+	state      *bool // synchronised at the last check, nil if unknown
+}
+
+func newClockMonitor(started time.Time) clockMonitor {
+	monitor := clockMonitor{
+		wall:         func() time.Time { return time.Now().Round(0) },
+		offset:       func() time.Duration { return time.Since(started) },
+		synchronized: clockSynchronized,
+	}
+	monitor.reset()
+	return monitor
+}
+
+// reset takes the current readings as the reference of the next check.
+func (c *clockMonitor) reset() {
+	c.lastWall, c.lastOffset = c.wall(), c.offset()
+	c.state = nil
+	if synchronized, known := c.synchronized(); known {
+		c.state = &synchronized
+	}
+}
+
+// check returns the events since the last check, and the wall time and session offset it took them
+// at.
+func (c *clockMonitor) check() ([]ClockEvent, time.Time, time.Duration) {
+	var events []ClockEvent
+
+	now, offset := c.wall(), c.offset()
+	before := c.lastWall.Add(offset - c.lastOffset)
+	if step := now.Sub(before); step >= ClockStepThreshold || step <= -ClockStepThreshold {
+		events = append(events, ClockEvent{Event: ClockStep, Before: before, After: now, Step: step})
+	}
+	c.lastWall, c.lastOffset = now, offset
+
+	if synchronized, known := c.synchronized(); known {
+		if c.state != nil && *c.state != synchronized {
+			event := ClockSynchronized
+			if !synchronized {
+				event = ClockUnsynchronized
+			}
+			events = append(events, ClockEvent{Event: event, After: now})
+		}
+		c.state = &synchronized
+	}
+
+	for i := range events {
+		if events[i].Synchronized == nil {
+			events[i].Synchronized = c.state
+		}
+	}
+	return events, now, offset
+}
+
+// checkClock logs the clock events since the last poll.
+func (p *Processor) checkClock() {
+	events, now, offset := p.clock.check()
+	for _, event := range events {
+		p.addLogEntry(LogEntry{
+			Index:         p.clockIndex,
+			MessageType:   ClockMessageType,
+			Success:       true,
+			Data:          event,
+			RequestTime:   now,
+			ResponseTime:  now,
+			SessionOffset: offset, // the wall time no longer tells it
+		})
+		p.clockIndex++
+	}
+}
+
+// sessionOffset returns the offset in the session of t, or of now if t is zero.
+func (p *Processor) sessionOffset(t time.Time) time.Duration {
+	if t.IsZero() {
+		return time.Since(p.started)
+	}
+	return t.Sub(p.started)
+}
@@ -0,0 +1,16 @@
+//go:build linux
+
+package cellularlog
+
+import "golang.org/x/sys/unix"
+
+// clockSynchronized reports whether the kernel considers the wall clock synchronised, as NTP
+// daemons and chrony tell it, and whether that is known at all.
+func clockSynchronized() (bool, bool) {
+	var timex unix.Timex // no modes set: only reads the state
+	state, err := unix.Adjtimex(&timex)
+	if err != nil {
+		return false, false
+	}
+	return state != unix.TIME_ERROR && timex.Status&unix.STA_UNSYNC == 0, true
+}
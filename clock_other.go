@@ -0,0 +1,7 @@
+//go:build !linux
+
+package cellularlog
+
+func clockSynchronized() (bool, bool) {
+	return false, false
+}
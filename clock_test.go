@@ -0,0 +1,77 @@
+package cellularlog
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeClock is a wall clock that can be stepped and a monotonic clock that cannot.
+type fakeClock struct {
+	wall         time.Time
+	offset       time.Duration
+	synchronized bool
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.wall = c.wall.Add(d)
+	c.offset += d
+}
+
+func (c *fakeClock) monitor() clockMonitor {
+	monitor := clockMonitor{
+		wall:         func() time.Time { return c.wall },
+		offset:       func() time.Duration { return c.offset },
+		synchronized: func() (bool, bool) { return c.synchronized, true },
+	}
+	monitor.reset()
+	return monitor
+}
+
+func TestClockStepLogged(t *testing.T) {
+	clock := &fakeClock{wall: time.Date(1970, 1, 1, 0, 5, 0, 0, time.UTC), offset: time.Minute}
+	writer := &memoryWriter{}
+	p := NewProcessor(context.Background(), time.Second, time.Hour, writer, 100)
+	p.clock = clock.monitor()
+
+	// Slewing within the threshold is not a step.
+	clock.advance(time.Second)
+	clock.wall = clock.wall.Add(100 * time.Millisecond)
+	p.checkClock()
+
+	// NTP sets the clock and reports it synchronised.
+	clock.advance(time.Second)
+	set := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	step := set.Sub(clock.wall)
+	clock.wall, clock.synchronized = set, true
+	p.checkClock()
+
+	clock.advance(time.Second)
+	p.checkClock()
+
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(writer.entries) != 2 {
+		t.Fatalf("expected a step and a synchronisation entry, got %d entries", len(writer.entries))
+	}
+
+	entry := writer.entries[0]
+	event, ok := entry.Data.(ClockEvent)
+	if !ok || entry.MessageType != ClockMessageType || event.Event != ClockStep {
+		t.Fatalf("expected a clock step entry, got %+v", entry)
+	}
+	if event.Step != step || !event.After.Equal(set) || !event.Before.Equal(set.Add(-step)) {
+		t.Errorf("expected a step of %v to %v, got %+v", step, set, event)
+	}
+	if event.Synchronized == nil || !*event.Synchronized {
+		t.Errorf("expected the step to be recorded as synchronised, got %v", event.Synchronized)
+	}
+	if !entry.RequestTime.Equal(set) || entry.SessionOffset != time.Minute+2*time.Second {
+		t.Errorf("expected the entry at %v and offset %v, got %v and %v", set, time.Minute+2*time.Second, entry.RequestTime, entry.SessionOffset)
+	}
+
+	if event := writer.entries[1].Data.(ClockEvent); event.Event != ClockSynchronized {
+		t.Errorf("expected a synchronisation entry, got %+v", event)
+	}
+}
@@ -0,0 +1,143 @@
+// Command decrypt decrypts log files written with the logger's --encrypt-key, and generates the key
+// pairs to encrypt them for. Each file is written to the output directory, or next to it, under its
+// name without .enc.
+//
+//	decrypt -generate analyst                      # writes analyst.key and analyst.pub
+//	decrypt -key analyst.key -out plain logs/*.enc
+package main
+
+import (
+	"crypto/ecdh"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/harshabose/cellular_localisation_logging/pkg/envelope"
+)
+
+func main() {
+	generate := flag.String("generate", "", "Generate a key pair, writing NAME.key (keep it private) and NAME.pub (for --encrypt-key)")
+	keyFile := flag.String("key", "", "Private key the files were encrypted for")
+	out := flag.String("out", "", "Directory the decrypted files are written to (default: next to each file)")
+	flag.Parse()
+
+	var err error
+	switch {
+	case *generate != "":
+		err = generateKey(*generate)
+	case *keyFile != "" && flag.NArg() > 0:
+		err = run(*keyFile, *out, flag.Args())
+	default:
+		fmt.Println("Usage: decrypt -generate NAME | decrypt -key NAME.key [-out DIR] FILE.enc...")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func generateKey(name string) error {
+	key, err := envelope.GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	private, err := envelope.MarshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	public, err := envelope.MarshalPublicKey(key.PublicKey())
+	if err != nil {
+		return err
+	}
+
+	// O_EXCL, so that a key logs were encrypted for is never overwritten.
+	file, err := os.OpenFile(name+".key", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(private); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(name+".pub", public, 0o644); err != nil {
+		return err
+	}
+
+	fmt.Printf("wrote %s.key and %s.pub (key ID %x); give %s.pub to the loggers with --encrypt-key\n",
+		name, name, envelope.KeyID(key.PublicKey()), name)
+	return nil
+}
+
+func run(keyFile, out string, inputs []string) error {
+	key, err := envelope.LoadPrivateKey(keyFile)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, input := range inputs {
+		dir := out
+		if dir == "" {
+			dir = filepath.Dir(input)
+		}
+		output := filepath.Join(dir, strings.TrimSuffix(filepath.Base(input), envelope.Ext))
+		if output == filepath.Join(filepath.Dir(input), filepath.Base(input)) {
+			return fmt.Errorf("%s: has no %s extension", input, envelope.Ext)
+		}
+
+		n, err := decryptFile(key, input, output)
+		switch {
+		case errors.Is(err, envelope.ErrTruncated):
+			fmt.Printf("warning: %s: %v; the %d bytes before it were written to %s\n", input, err, n, output)
+		case err != nil:
+			fmt.Printf("%s: %v\n", input, err)
+			failed++
+		default:
+			fmt.Printf("%s: %d bytes written to %s\n", input, n, output)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files could not be decrypted", failed, len(inputs))
+	}
+	return nil
+}
+
+// decryptFile writes what can be decrypted of input to output, returning its size.
+func decryptFile(key *ecdh.PrivateKey, input, output string) (int64, error) {
+	in, err := os.Open(input)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	r, err := envelope.NewReader(in, key)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(file, r)
+	if e := file.Close(); err == nil {
+		err = e
+	}
+	if err != nil && !errors.Is(err, envelope.ErrTruncated) {
+		_ = os.Remove(output)
+	}
+	return n, err
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileConfig is a session described in a YAML file and loaded with --config. Flags given on the
+// command line override the values read from the file.
+//
+//	polling_interval: 1s
+//	requesters:
+//	  modem: {type: at, device: /dev/ttyUSB2, timeout: 5s}
+//	messages:
+//	  - {source: modem, name: '+QENG="servingcell"', parser: qeng, interval: 5s, tags: {antenna: roof}}
+//	writers:
+//	  - {format: json, path: 'logs/${VEHICLE}_{time}', rotation: {max_size: 100MB}, compression: gzip}
+//	fusion:
+//	  position_reference: gpsd
+type FileConfig struct {
+	PollingInterval Duration      `yaml:"polling_interval"`
+	WriterInterval  Duration      `yaml:"writer_interval"`
+	BufferSize      int           `yaml:"buffer_size"`
+	Control         string        `yaml:"control"` // control API address, see --control
+	Metrics         string        `yaml:"metrics"` // Prometheus endpoint address, see --metrics
+	WAL             WALConfig     `yaml:"wal"`
+	MaxBuffered     *int          `yaml:"max_buffered"` // see --max-buffered
+	History         HistoryConfig `yaml:"history"`
+	StatsInterval   *Duration     `yaml:"stats_interval"` // see --stats-interval
+	Redact          RedactConfig  `yaml:"redact"`
+
+	// Requesters are keyed by the name messages refer to in their source. Sources that are not
+	// declared here are available under their own prefix with the settings from the flags.
+	Requesters map[string]RequesterConfig `yaml:"requesters"`
+	Messages   []MessageConfig            `yaml:"messages"`
+	Writers    []WriterConfig             `yaml:"writers"`
+	Fusion     FusionConfig               `yaml:"fusion"`
+	Upload     UploadConfig               `yaml:"upload"`
+	Rules      []RuleConfig               `yaml:"rules"`
+}
+
+// RequesterConfig holds the settings of one requester. Which keys apply depends on the source type,
+// see Source.RequesterKeys; zero values keep the flag defaults.
+type RequesterConfig struct {
+	Type string `yaml:"type"` // source prefix, defaults to the requester's name
+
+	Device    string   `yaml:"device"`
+	Baud      int      `yaml:"baud"`
+	Address   string   `yaml:"address"`
+	Interface string   `yaml:"interface"`
+	Timeout   Duration `yaml:"timeout"`
+	GNSS      *bool    `yaml:"gnss"`
+
+	SigningKey string `yaml:"signing_key"` // file, see --mav-signing-key
+	Signing    string `yaml:"signing"`     // verify or require
+
+	UDPCount    int      `yaml:"udp_count"`
+	UDPInterval Duration `yaml:"udp_interval"`
+	UDPSize     int      `yaml:"udp_size"`
+	BurstSize   ByteSize `yaml:"burst_size"`
+}
+
+// keys returns the yaml keys set to a non-zero value, other than type.
+func (r RequesterConfig) keys() []string {
+	var keys []string
+
+	v := reflect.ValueOf(r)
+	for i := 0; i < v.NumField(); i++ {
+		key := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if key != "type" && !v.Field(i).IsZero() {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// MessageConfig describes a message in the config file and in the control API.
+type MessageConfig struct {
+	Source   string            `yaml:"source" json:"source"` // requester name or source prefix
+	Name     string            `yaml:"name" json:"name"`
+	Interval Duration          `yaml:"interval" json:"interval,omitempty"`
+	Parser   string            `yaml:"parser" json:"parser,omitempty"`
+	Tags     map[string]string `yaml:"tags" json:"tags,omitempty"`
+	// Priority under backpressure: lower is dropped first, negative is paused, see --max-buffered.
+	Priority int `yaml:"priority" json:"priority,omitempty"`
+}
+
+type WriterConfig struct {
+	Format string `yaml:"format"` // json, csv, binary, influx or mqtt
+	// Path is the file prefix, the extension is added. {time} is replaced by the session start time.
+	// It is not used by mqtt writers and influx writers with a url.
+	Path        string           `yaml:"path"`
+	Rotation    RotationConfig   `yaml:"rotation"`
+	Compression string           `yaml:"compression"` // none or gzip
+	Encryption  EncryptionConfig `yaml:"encryption"`
+	Signing     SigningConfig    `yaml:"signing"`
+	MQTT        MQTTConfig       `yaml:"mqtt"`
+	Influx      InfluxConfig     `yaml:"influx"`
+	OnError     FailureConfig    `yaml:"on_error"`
+
+	recipient *ecdh.PublicKey    // of Encryption.PublicKey, set by validateWriters
+	signer    ed25519.PrivateKey // of Signing.Key, set by validateWriters
+}
+
+// EncryptionConfig encrypts the files of a writer for the holder of a private key, see pkg/envelope
+// and --encrypt-key.
+type EncryptionConfig struct {
+	PublicKey string `yaml:"public_key"` // PEM file of an X25519 key
+}
+
+// SigningConfig links the entries of a writer's files into a hash chain and signs a manifest of
+// each closed file, see pkg/chain and --sign-key.
+type SigningConfig struct {
+	Key string `yaml:"key"` // PEM file of the device's Ed25519 private key
+}
+
+// FailureConfig is what a writer does when it fails, see cellularlog.FailurePolicy. By default a
+// failed batch is left to the processor, which keeps it up to --max-buffered entries.
+type FailureConfig struct {
+	Retries      int      `yaml:"retries"` // failures in a row tried again at once, before backing off
+	Backoff      Duration `yaml:"backoff"` // after the retries, doubled for each further failure
+	MaxBackoff   Duration `yaml:"max_backoff"`
+	Spill        string   `yaml:"spill"` // directory failed batches are queued in
+	SpillMaxSize ByteSize `yaml:"spill_max_size"`
+}
+
+// MQTTConfig configures an mqtt writer. Empty values are taken from the --mqtt-* flags.
+type MQTTConfig struct {
+	Broker       string   `yaml:"broker"` // e.g. tcp://ground:1883
+	ClientID     string   `yaml:"client_id"`
+	Username     string   `yaml:"username"`
+	Password     string   `yaml:"password"`
+	Vehicle      string   `yaml:"vehicle"`
+	Topic        string   `yaml:"topic"` // first topic level
+	QoS          *int     `yaml:"qos"`
+	Batch        bool     `yaml:"batch"`
+	Timeout      Duration `yaml:"timeout"`
+	Queue        string   `yaml:"queue"` // directory of the on-disk queue, "none" to disable it
+	QueueMaxSize ByteSize `yaml:"queue_max_size"`
+}
+
+// withFlags fills in the values the config file left empty from the flags.
+func (m MQTTConfig) withFlags(config *Config) MQTTConfig {
+	setString(&m.Broker, config.MQTTBroker)
+	setString(&m.Vehicle, config.MQTTVehicle)
+	setString(&m.Topic, config.MQTTTopic)
+	setString(&m.Queue, config.MQTTQueue)
+	if m.QoS == nil {
+		qos := config.MQTTQoS
+		m.QoS = &qos
+	}
+	m.Batch = m.Batch || config.MQTTBatch
+
+	return m
+}
+
+// UploadConfig forwards closed segments to a tus server, see pkg/upload. It is enabled by the url
+// or --upload-url; empty values are taken from the --upload-* flags.
+type UploadConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"` // e.g. Authorization: Bearer ${UPLOAD_TOKEN}
+	// Patterns are globs of the files to upload, by default the segments of the file writers.
+	Patterns  []string `yaml:"patterns"`
+	State     string   `yaml:"state"`
+	ChunkSize ByteSize `yaml:"chunk_size"`
+	Rate      ByteSize `yaml:"rate"` // per second
+	Interval  Duration `yaml:"interval"`
+	MinAge    Duration `yaml:"min_age"`
+	Delete    bool     `yaml:"delete"`
+}
+
+// InfluxConfig configures an influx writer, which writes line protocol to a .lp file, or to the
+// write endpoint at url if set. Empty values are taken from the --influx-* flags.
+type InfluxConfig struct {
+	URL       string   `yaml:"url"` // e.g. http://influx:8086/api/v2/write?org=team&bucket=flights
+	Token     string   `yaml:"token"`
+	Vehicle   string   `yaml:"vehicle"`
+	BatchSize int      `yaml:"batch_size"` // lines per request
+	Timeout   Duration `yaml:"timeout"`
+}
+
+// withFlags fills in the values the config file left empty from the flags.
+func (c InfluxConfig) withFlags(config *Config) InfluxConfig {
+	setString(&c.URL, config.InfluxURL)
+	setString(&c.Token, config.InfluxToken)
+	setString(&c.Vehicle, config.InfluxVehicle)
+
+	return c
+}
+
+type RotationConfig struct {
+	MaxSize ByteSize `yaml:"max_size"`
+	MaxAge  Duration `yaml:"max_age"`
+}
+
+// WALConfig configures the write-ahead log of the buffer, see --wal.
+type WALConfig struct {
+	Dir          string   `yaml:"dir"`
+	Sync         string   `yaml:"sync"` // always, interval or never
+	SyncInterval Duration `yaml:"sync_interval"`
+}
+
+// HistoryConfig bounds the entries kept in memory per message, see --history.
+type HistoryConfig struct {
+	Entries int      `yaml:"entries"`
+	MaxAge  Duration `yaml:"max_age"`
+}
+
+// RedactConfig removes identifiers and precise positions before the writers, see --redact.
+type RedactConfig struct {
+	Identifiers []string `yaml:"identifiers"` // imei, imsi, iccid, phone
+	Mode        string   `yaml:"mode"`        // hash or mask
+	Grid        float64  `yaml:"grid"`        // degrees
+	KeyFile     string   `yaml:"key_file"`
+}
+
+// RuleConfig changes how messages are requested while a condition on their logged values holds, see
+// cellularlog.Rule. Messages are referred to as source:name, as in --messages.
+type RuleConfig struct {
+	Name string          `yaml:"name"`
+	When ConditionConfig `yaml:"when"`
+	Then ActionConfig    `yaml:"then"`
+	For  Duration        `yaml:"for"` // active this long after the condition last held
+}
+
+// ConditionConfig compares a field of a message's entries with exactly one of the comparisons.
+type ConditionConfig struct {
+	Message   string      `yaml:"message"`
+	Field     string      `yaml:"field"` // dot-separated path into the data as logged, e.g. RSRP
+	Below     *float64    `yaml:"below"`
+	Above     *float64    `yaml:"above"`
+	Equals    interface{} `yaml:"equals"`
+	NotEquals interface{} `yaml:"not_equals"`
+	Contains  string      `yaml:"contains"`
+	Excludes  string      `yaml:"excludes"`
+	Changed   bool        `yaml:"changed"`
+}
+
+// ActionConfig requests messages at another interval, or pauses them.
+type ActionConfig struct {
+	Messages  []string `yaml:"messages"`
+	Requester string   `yaml:"requester"` // all of its messages
+	Interval  Duration `yaml:"interval"`
+	Pause     bool     `yaml:"pause"`
+}
+
+type FusionConfig struct {
+	PositionReference string `yaml:"position_reference"`
+}
+
+// Duration is a time.Duration written as a string in the config file, e.g. "500ms".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration %q", value.Line, s)
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// ByteSize is a size in bytes written as a plain number or with a unit, e.g. "512KiB" or "100MB".
+type ByteSize int64
+
+var byteUnits = map[string]int64{
+	"": 1, "B": 1,
+	"KB": 1e3, "MB": 1e6, "GB": 1e9,
+	"KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30,
+}
+
+func (b *ByteSize) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+
+	s = strings.TrimSpace(s)
+	number := strings.TrimRightFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+
+	unit, ok := byteUnits[strings.TrimSpace(s[len(number):])]
+	n, err := strconv.ParseInt(number, 10, 64)
+	if !ok || err != nil {
+		return fmt.Errorf("line %d: invalid size %q (e.g. 1048576, 512KiB, 100MB)", value.Line, s)
+	}
+
+	*b = ByteSize(n * unit)
+	return nil
+}
+
+// loadFileConfig reads a config file, expanding environment variables first. Unknown keys are
+// errors so that typos do not silently fall back to defaults.
+func loadFileConfig(path string) (*FileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	expanded, err := expandEnv(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	decoder := yaml.NewDecoder(strings.NewReader(expanded))
+	decoder.KnownFields(true)
+
+	config := &FileConfig{}
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+var envPattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces ${VAR} and ${VAR:-default} with the environment. $${ is a literal ${. Lines
+// that are entirely comments are left alone.
+func expandEnv(s string) (string, error) {
+	var (
+		builder strings.Builder
+		errs    []string
+		last    int
+	)
+
+	for _, m := range envPattern.FindAllStringSubmatchIndex(s, -1) {
+		lineStart := strings.LastIndex(s[:m[0]], "\n") + 1
+		if strings.HasPrefix(strings.TrimSpace(s[lineStart:m[0]]), "#") {
+			continue
+		}
+
+		builder.WriteString(s[last:m[0]])
+		last = m[1]
+
+		if s[m[0]:m[1]] == "$${" {
+			builder.WriteString("${")
+			continue
+		}
+
+		name := s[m[2]:m[3]]
+		if value, ok := os.LookupEnv(name); ok {
+			builder.WriteString(value)
+			continue
+		}
+		if m[4] >= 0 {
+			builder.WriteString(s[m[6]:m[7]])
+			continue
+		}
+
+		line := strings.Count(s[:m[0]], "\n") + 1
+		errs = append(errs, fmt.Sprintf("line %d: environment variable %s is not set", line, name))
+	}
+	builder.WriteString(s[last:])
+
+	if len(errs) > 0 {
+		return "", errors.New(strings.Join(errs, "; "))
+	}
+
+	return builder.String(), nil
+}
+
+// applyGlobals copies the session wide settings of the file into config.
+func (f *FileConfig) applyGlobals(config *Config) {
+	setDuration(&config.PollingInterval, f.PollingInterval)
+	setDuration(&config.WriterInterval, f.WriterInterval)
+	setInt(&config.BufferSize, f.BufferSize)
+	if f.MaxBuffered != nil {
+		config.MaxBuffered = *f.MaxBuffered
+	}
+	setString(&config.PositionReference, f.Fusion.PositionReference)
+	setString(&config.ControlAddress, f.Control)
+	setString(&config.MetricsAddress, f.Metrics)
+	setString(&config.WALDir, f.WAL.Dir)
+	setString(&config.WALSync, f.WAL.Sync)
+	setDuration(&config.WALSyncInterval, f.WAL.SyncInterval)
+	setInt(&config.HistoryEntries, f.History.Entries)
+	setDuration(&config.HistoryAge, f.History.MaxAge)
+	if f.StatsInterval != nil {
+		config.StatsInterval = time.Duration(*f.StatsInterval)
+	}
+	if len(f.Redact.Identifiers) > 0 {
+		config.Redact = strings.Join(f.Redact.Identifiers, ",")
+	}
+	setString(&config.RedactMode, f.Redact.Mode)
+	if f.Redact.Grid != 0 {
+		config.RedactGrid = f.Redact.Grid
+	}
+	setString(&config.RedactKeyFile, f.Redact.KeyFile)
+	setString(&config.UploadURL, f.Upload.URL)
+	setString(&config.UploadState, f.Upload.State)
+	if f.Upload.Rate != 0 {
+		config.UploadRate = int64(f.Upload.Rate)
+	}
+}
+
+func setString(dst *string, v string) {
+	if v != "" {
+		*dst = v
+	}
+}
+
+func setInt(dst *int, v int) {
+	if v != 0 {
+		*dst = v
+	}
+}
+
+func setDuration(dst *time.Duration, v Duration) {
+	if v != 0 {
+		*dst = time.Duration(v)
+	}
+}
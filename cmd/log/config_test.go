@@ -0,0 +1,381 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/chain"
+	"github.com/harshabose/cellular_localisation_logging/pkg/envelope"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "session.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExampleConfig(t *testing.T) {
+	s, err := loadSession(context.Background(), []string{"--config=session.example.yaml"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(s.messages) != 7 || len(s.writers) != 2 {
+		t.Errorf("expected 7 messages and 2 writers, got %d and %d", len(s.messages), len(s.writers))
+	}
+	if s.config.PositionReference != "gpsd" {
+		t.Errorf("expected gpsd as position reference, got %q", s.config.PositionReference)
+	}
+
+	var modem *Config
+	for _, r := range s.requesters {
+		if r.name == "modem" {
+			modem = r.config
+		}
+	}
+	if modem == nil || modem.ATDevice != "/dev/ttyUSB2" {
+		t.Fatalf("expected the modem requester on the default device, got %+v", modem)
+	}
+	if got := s.messages[0].message.GetRequester(); got != "modem" {
+		t.Errorf("expected the first message to target modem, got %s", got)
+	}
+	if s.messages[0].options.Interval != 5*time.Second || s.messages[0].options.Tags["antenna"] != "roof" {
+		t.Errorf("unexpected options %+v", s.messages[0].options)
+	}
+	if len(s.rules) != 2 || s.rules[0].When.Message != s.messages[0].message || s.rules[1].When.Op != cellularlog.RuleChanged {
+		t.Errorf("unexpected rules %+v", s.rules)
+	}
+}
+
+func TestEnvironmentAndFlagOverrides(t *testing.T) {
+	t.Setenv("TEST_IFACE", "usb0")
+
+	path := writeConfig(t, `
+polling_interval: ${TEST_POLL:-2s}
+writer_interval: 10s
+requesters:
+  gps: {type: gpsd, address: "10.0.0.1:2947"}
+messages:
+  - {source: sys, name: "${TEST_IFACE}"}
+  - {source: gps, name: TPV}
+`)
+
+	s, err := loadSession(context.Background(), []string{"--config", path, "--polling-interval=5s", "--gpsd-timeout=7s"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if s.config.PollingInterval != 5*time.Second || s.config.WriterInterval != 10*time.Second {
+		t.Errorf("expected the flag to override only the polling interval, got %v and %v", s.config.PollingInterval, s.config.WriterInterval)
+	}
+	if s.messages[0].message.GetType() != "sys-usb0" {
+		t.Errorf("expected the interface from the environment, got %s", s.messages[0].message.GetType())
+	}
+
+	for _, r := range s.requesters {
+		if r.name == "gps" && (r.config.GPSDAddress != "10.0.0.1:2947" || r.config.GPSDTimeout != 7*time.Second) {
+			t.Errorf("unexpected gps requester config %s %v", r.config.GPSDAddress, r.config.GPSDTimeout)
+		}
+	}
+
+	// Without a config file the flags alone describe the session.
+	s, err = loadSession(context.Background(), []string{"--messages=sys:wwan0", "--output=json,csv"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(s.messages) != 1 || len(s.writers) != 2 || s.requesters[0].name != "sys" {
+		t.Errorf("unexpected flag session %+v", s)
+	}
+}
+
+func TestUploadDefaultsToWriterSegments(t *testing.T) {
+	path := writeConfig(t, `
+messages:
+  - {source: sys, name: lo}
+writers:
+  - {format: json, path: "logs/session_{time}"}
+  - {format: binary, path: logs/raw}
+upload:
+  url: https://ground.example.com/files/
+  rate: 64KiB
+`)
+
+	s, err := loadSession(context.Background(), []string{"--config", path, "--upload-state=state.json"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.upload == nil || s.upload.Rate != 64<<10 || s.upload.State != "state.json" {
+		t.Fatalf("unexpected upload config %+v", s.upload)
+	}
+	if want := []string{"logs/session_**.json*", "logs/raw*.bin*"}; strings.Join(s.upload.Patterns, " ") != strings.Join(want, " ") {
+		t.Errorf("expected patterns %v, got %v", want, s.upload.Patterns)
+	}
+}
+
+func TestSessionHeader(t *testing.T) {
+	path := writeConfig(t, `
+requesters:
+  gps: {type: gpsd, address: "10.0.0.1:2947"}
+messages:
+  - {source: gps, name: TPV, interval: 5s}
+writers:
+  - {format: influx, influx: {url: "http://influx:8086/write?db=flights", token: secret-token}}
+  - {format: mqtt, mqtt: {broker: "tcp://ground:1883", password: secret-password}}
+`)
+
+	s, err := loadSession(context.Background(), []string{"--config", path, "--influx-token=secret-flag"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	header := sessionHeader(s)
+	if header.Devices["gps"] != "10.0.0.1:2947" || header.Version == "" {
+		t.Errorf("unexpected header %+v", header)
+	}
+
+	data, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("expected secrets to be redacted, got %s", data)
+	}
+
+	config := header.Config.(effectiveConfig)
+	if gps := config.Requesters["gps"]; gps.Type != "gpsd" || gps.Flags["gpsd-address"] != "10.0.0.1:2947" {
+		t.Errorf("unexpected requester %+v", gps)
+	}
+	if config.Flags["gpsd-address"] == "10.0.0.1:2947" || len(config.Writers) != 2 || config.Messages[0].Interval != Duration(5*time.Second) {
+		t.Errorf("unexpected effective config %+v", config)
+	}
+}
+
+func TestRedactConfig(t *testing.T) {
+	path := writeConfig(t, `
+messages:
+  - {source: at, name: +CGSN}
+redact: {identifiers: [imei, imsi], mode: mask, grid: 0.01}
+`)
+
+	s, err := loadSession(context.Background(), []string{"--config", path, "--redact-mode=hash"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if policy := s.redactor.Policy(); len(policy.Identifiers) != 2 || policy.Mode != "hash" || policy.Grid != 0.01 || policy.KeyID == "" {
+		t.Errorf("unexpected policy %+v", policy)
+	}
+
+	if _, err := loadSession(context.Background(), []string{"--config", path, "--redact=imei,email"}); err == nil || !strings.Contains(err.Error(), "redact: unknown identifier 'email'") {
+		t.Errorf("expected an error for the identifier, got %v", err)
+	}
+}
+
+func TestValidationErrorsNameTheKey(t *testing.T) {
+	path := writeConfig(t, `
+requesters:
+  modem: {type: at, address: "1.2.3.4:5"}
+  radio: {type: lora}
+messages:
+  - {source: nowhere, name: x}
+writers:
+  - {format: xml, path: out, compression: zip}
+`)
+
+	_, err := loadSession(context.Background(), []string{"--config", path})
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, key := range []string{"requesters.modem.address", "requesters.radio.type", "messages[0].source"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("expected an error for %s, got: %v", key, err)
+		}
+	}
+
+	path = writeConfig(t, `
+messages:
+  - {source: sys, name: wwan0, parser: qeng}
+  - {source: at, name: +CSQ, parser: nope}
+  - {source: sys, name: ""}
+writers:
+  - {format: xml, path: out, compression: zip}
+`)
+	_, err = loadSession(context.Background(), []string{"--config", path})
+	for _, key := range []string{"messages[0].parser", "messages[1].parser", "messages[2].name"} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("expected an error for %s, got: %v", key, err)
+		}
+	}
+
+	path = writeConfig(t, "messages:\n  - {source: sys, name: lo}\nwriters:\n  - {format: mqtt, mqtt: {qos: 3}}\n  - {format: json, path: out, on_error: {retries: -1}}\n")
+	_, err = loadSession(context.Background(), []string{"--config", path})
+	for _, key := range []string{"writers[0].mqtt.broker", "writers[0].mqtt.qos", "writers[1].on_error"} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("expected an error for %s, got: %v", key, err)
+		}
+	}
+
+	path = writeConfig(t, "messages:\n  - {source: sys, name: wwan0, intervall: 5s}\n")
+	if _, err := loadSession(context.Background(), []string{"--config", path}); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an unknown key error with its line, got: %v", err)
+	}
+
+	path = writeConfig(t, "polling_interval: ${TEST_UNSET_VARIABLE}\n")
+	if _, err := loadSession(context.Background(), []string{"--config", path}); err == nil || !strings.Contains(err.Error(), "TEST_UNSET_VARIABLE") {
+		t.Errorf("expected an unset variable error, got: %v", err)
+	}
+}
+
+func TestRotatingCompressedWriter(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "session")
+
+	writer, err := createSingleWriter(context.Background(), WriterConfig{
+		Format:      "json",
+		Path:        prefix,
+		Rotation:    RotationConfig{MaxSize: 200},
+		Compression: "gzip",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		entry := cellularlog.LogEntry{Index: uint64(i), MessageType: "test", Success: true, Data: strings.Repeat("x", 200)}
+		if err := writer.Write([]cellularlog.LogEntry{entry}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(prefix + "_*")
+	if len(files) != 3 {
+		t.Fatalf("expected 3 compressed segments, got %v", files)
+	}
+
+	file, err := os.Open(prefix + "_001.json.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entry cellularlog.LogEntry
+	if err := json.NewDecoder(reader).Decode(&entry); err != nil || entry.Index != 1 {
+		t.Errorf("expected entry 1 in the second segment, got %+v (%v)", entry, err)
+	}
+}
+
+func TestRotatingEncryptedWriter(t *testing.T) {
+	dir := t.TempDir()
+	key, err := envelope.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, _ := envelope.MarshalPublicKey(key.PublicKey())
+	keyFile := filepath.Join(dir, "analyst.pub")
+	if err := os.WriteFile(keyFile, public, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := filepath.Join(dir, "session")
+	path := writeConfig(t, `
+messages:
+  - {source: at, name: +CSQ}
+writers:
+  - {format: binary, path: `+prefix+`, rotation: {max_size: 200}, compression: gzip}
+`)
+	if _, err := loadSession(context.Background(), []string{"--config", path, "--encrypt-key", keyFile}); err == nil || !strings.Contains(err.Error(), "compression does not apply to encrypted files") {
+		t.Errorf("expected an error for compression, got %v", err)
+	}
+
+	writers, err := validateWriters([]WriterConfig{{Format: "binary", Path: prefix, Rotation: RotationConfig{MaxSize: 200}}}, &Config{EncryptKey: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := createSingleWriter(context.Background(), writers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		entry := cellularlog.LogEntry{Index: uint64(i), MessageType: "test", Success: true, Data: strings.Repeat("x", 200)}
+		if err := writer.Write([]cellularlog.LogEntry{entry}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(prefix + "_001.bin" + envelope.Ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	plain, err := envelope.NewReader(file, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := cellularlog.NewBinaryReader(plain).Read()
+	if err != nil || entry.Index != 1 {
+		t.Errorf("expected entry 1 in the second segment, got %+v (%v)", entry, err)
+	}
+}
+
+func TestSignedWriter(t *testing.T) {
+	dir := t.TempDir()
+	key, err := chain.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, _ := chain.MarshalPrivateKey(key)
+	keyFile := filepath.Join(dir, "device.key")
+	if err := os.WriteFile(keyFile, private, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := validateWriters([]WriterConfig{{Format: "mqtt", Signing: SigningConfig{Key: keyFile}}}, &Config{}); err == nil || !strings.Contains(err.Error(), "signing do not apply to mqtt") {
+		t.Errorf("expected an error for mqtt, got %v", err)
+	}
+
+	prefix := filepath.Join(dir, "session")
+	writers, err := validateWriters([]WriterConfig{{Format: "json", Path: prefix}}, &Config{SignKey: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := createSingleWriter(context.Background(), writers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Write([]cellularlog.LogEntry{{MessageType: "test", Success: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := chain.ReadManifest(prefix+".json"+chain.ManifestExt, key.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !manifest.Final || manifest.Entries != 1 {
+		t.Errorf("expected a final manifest of 1 entry, got %+v", manifest)
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging"
+)
+
+// control serves a local HTTP/JSON API to inspect and change a running session:
+//
+//	GET    /messages                          active messages with success rate and latency
+//	POST   /messages                          add a message, body as a MessageConfig
+//	PATCH  /messages?requester=at&type=at-I   change interval or tags, body {"interval": "5s"}
+//	DELETE /messages?requester=at&type=at-I   stop requesting a message
+//	GET    /entries?requester=at&type=at-I    kept entries, filtered by last=N, since=index and
+//	                                          from/to (RFC 3339 request times)
+//	GET    /stats                             rolling statistics by message type and requester
+//	GET    /requesters                        registered requesters
+//	POST   /flush                             write buffered entries now
+//	POST   /rotate                            start new output files
+//
+// Messages are identified by their requester name and MessageType.
+type control struct {
+	ctx        context.Context
+	processor  *cellularlog.Processor
+	requesters map[string]string // name to source prefix
+
+	server *http.Server
+}
+
+// newControl returns a control API for processor. requesters are those the session registered.
+func newControl(ctx context.Context, processor *cellularlog.Processor, requesters []requesterSpec) *control {
+	c := &control{
+		ctx:        ctx,
+		processor:  processor,
+		requesters: make(map[string]string, len(requesters)),
+	}
+	for _, r := range requesters {
+		c.requesters[r.name] = r.prefix
+	}
+
+	return c
+}
+
+// startControl serves the control API on address, either host:port or unix:/path/to.sock.
+func startControl(ctx context.Context, address string, processor *cellularlog.Processor, requesters []requesterSpec) (*control, error) {
+	network := "tcp"
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		network, address = "unix", path
+
+		// A socket left behind by an unclean exit would make Listen fail.
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(path)
+		}
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newControl(ctx, processor, requesters)
+	c.server = &http.Server{Handler: c.handler(), ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		if err := c.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(console, "control API stopped: %v\n", err)
+		}
+	}()
+
+	return c, nil
+}
+
+func (c *control) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /messages", c.listMessages)
+	mux.HandleFunc("POST /messages", c.addMessage)
+	mux.HandleFunc("PATCH /messages", c.updateMessage)
+	mux.HandleFunc("DELETE /messages", c.removeMessage)
+	mux.HandleFunc("GET /entries", c.queryEntries)
+	mux.HandleFunc("GET /stats", c.stats)
+	mux.HandleFunc("GET /requesters", c.listRequesters)
+	mux.HandleFunc("POST /flush", c.flush)
+	mux.HandleFunc("POST /rotate", c.rotate)
+
+	return mux
+}
+
+func (c *control) Close() error {
+	if c.server == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return c.server.Shutdown(ctx)
+}
+
+type messageStatus struct {
+	Requester             string            `json:"requester"`
+	Type                  string            `json:"type"`
+	Interval              Duration          `json:"interval,omitempty"`
+	Tags                  map[string]string `json:"tags,omitempty"`
+	Priority              int               `json:"priority,omitempty"`
+	Entries               int               `json:"entries"`
+	SuccessRate           float64           `json:"success_rate"`             // percent, of every message of this type
+	AverageResponseTimeMS float64           `json:"average_response_time_ms"` // of every message of this type
+}
+
+type requesterStatus struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+func (c *control) listMessages(w http.ResponseWriter, _ *http.Request) {
+	messages := c.processor.GetMessages()
+
+	statuses := make([]messageStatus, 0, len(messages))
+	for _, message := range messages {
+		options := c.processor.GetMessageOptions(message)
+
+		statuses = append(statuses, messageStatus{
+			Requester:             message.GetRequester(),
+			Type:                  message.GetType(),
+			Interval:              Duration(options.Interval),
+			Tags:                  options.Tags,
+			Priority:              options.Priority,
+			Entries:               len(message.GetAllEntries()),
+			SuccessRate:           c.processor.GetSuccessRate(message.GetType()),
+			AverageResponseTimeMS: float64(c.processor.GetAverageResponseTime(message.GetType()).Nanoseconds()) / 1e6,
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Requester != statuses[j].Requester {
+			return statuses[i].Requester < statuses[j].Requester
+		}
+		return statuses[i].Type < statuses[j].Type
+	})
+
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (c *control) addMessage(w http.ResponseWriter, r *http.Request) {
+	var config MessageConfig
+	if err := decodeJSON(r, &config); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	prefix, ok := c.requesters[config.Source]
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("source: requester '%s' is not running (running: %s)", config.Source, strings.Join(c.processor.GetRequesterNames(), ", ")))
+		return
+	}
+
+	message, err := newMessage(c.ctx, config, prefix)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, exists := c.find(message.GetRequester(), message.GetType()); exists {
+		writeError(w, http.StatusConflict, fmt.Errorf("%s is already requested from %s", message.GetType(), message.GetRequester()))
+		return
+	}
+
+	c.processor.SetMessageOptions(message, cellularlog.MessageOptions{
+		Interval: time.Duration(config.Interval),
+		Tags:     config.Tags,
+		Priority: config.Priority,
+	})
+	c.processor.AddMessage(message)
+
+	writeJSON(w, http.StatusCreated, messageStatus{
+		Requester: message.GetRequester(),
+		Type:      message.GetType(),
+		Interval:  config.Interval,
+		Tags:      config.Tags,
+		Priority:  config.Priority,
+	})
+}
+
+type messageUpdate struct {
+	Interval *Duration         `json:"interval"`
+	Tags     map[string]string `json:"tags"`
+	Priority *int              `json:"priority"`
+}
+
+func (c *control) updateMessage(w http.ResponseWriter, r *http.Request) {
+	message, ok := c.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	var update messageUpdate
+	if err := decodeJSON(r, &update); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	options := c.processor.GetMessageOptions(message)
+	if update.Interval != nil {
+		if *update.Interval < 0 {
+			writeError(w, http.StatusBadRequest, errors.New("interval: must not be negative"))
+			return
+		}
+		options.Interval = time.Duration(*update.Interval)
+	}
+	if update.Tags != nil {
+		options.Tags = update.Tags
+	}
+	if update.Priority != nil {
+		options.Priority = *update.Priority
+	}
+	c.processor.SetMessageOptions(message, options)
+
+	writeJSON(w, http.StatusOK, messageStatus{
+		Requester: message.GetRequester(),
+		Type:      message.GetType(),
+		Interval:  Duration(options.Interval),
+		Tags:      options.Tags,
+		Priority:  options.Priority,
+	})
+}
+
+func (c *control) removeMessage(w http.ResponseWriter, r *http.Request) {
+	message, ok := c.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	c.processor.RemoveMessage(message)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *control) queryEntries(w http.ResponseWriter, r *http.Request) {
+	message, ok := c.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	var (
+		query  cellularlog.HistoryQuery
+		params = r.URL.Query()
+		err    error
+	)
+	if v := params.Get("last"); v != "" {
+		query.Last, err = strconv.Atoi(v)
+	}
+	if v := params.Get("since"); v != "" && err == nil {
+		query.SinceIndex, err = strconv.ParseUint(v, 10, 64)
+	}
+	if v := params.Get("from"); v != "" && err == nil {
+		query.From, err = time.Parse(time.RFC3339Nano, v)
+	}
+	if v := params.Get("to"); v != "" && err == nil {
+		query.To, err = time.Parse(time.RFC3339Nano, v)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid query: %w", err))
+		return
+	}
+
+	history, ok := message.(cellularlog.HistoryProvider)
+	if !ok {
+		// Filter the message's own entries the same way.
+		entries := message.GetAllEntries()
+		copied := cellularlog.NewHistory(len(entries), 0)
+		for _, entry := range entries {
+			copied.Add(entry)
+		}
+		writeJSON(w, http.StatusOK, copied.Query(query))
+		return
+	}
+
+	entries := history.History().Query(query)
+	if entries == nil {
+		entries = []cellularlog.LogEntry{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+type statsResponse struct {
+	Messages   []cellularlog.StatsSnapshot `json:"messages"`
+	Requesters []cellularlog.StatsSnapshot `json:"requesters"`
+}
+
+func (c *control) stats(w http.ResponseWriter, _ *http.Request) {
+	stats := c.processor.Stats()
+	writeJSON(w, http.StatusOK, statsResponse{Messages: stats.Messages(), Requesters: stats.Requesters()})
+}
+
+func (c *control) listRequesters(w http.ResponseWriter, _ *http.Request) {
+	names := c.processor.GetRequesterNames()
+
+	statuses := make([]requesterStatus, 0, len(names))
+	for _, name := range names {
+		statuses = append(statuses, requesterStatus{Name: name, Type: c.requesters[name]})
+	}
+
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (c *control) flush(w http.ResponseWriter, _ *http.Request) {
+	if err := c.processor.Flush(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *control) rotate(w http.ResponseWriter, _ *http.Request) {
+	if err := c.processor.Rotate(); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookup finds the message named by the requester and type query parameters, writing an error
+// response if there is none.
+func (c *control) lookup(w http.ResponseWriter, r *http.Request) (cellularlog.Message, bool) {
+	requester, messageType := r.URL.Query().Get("requester"), r.URL.Query().Get("type")
+	if requester == "" || messageType == "" {
+		writeError(w, http.StatusBadRequest, errors.New("requester and type query parameters are required"))
+		return nil, false
+	}
+
+	message, ok := c.find(requester, messageType)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s is not requested from %s", messageType, requester))
+		return nil, false
+	}
+
+	return message, true
+}
+
+func (c *control) find(requester, messageType string) (cellularlog.Message, bool) {
+	for _, message := range c.processor.GetMessages() {
+		if message.GetRequester() == requester && message.GetType() == messageType {
+			return message, true
+		}
+	}
+
+	return nil, false
+}
+
+func decodeJSON(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
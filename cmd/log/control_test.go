@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging"
+)
+
+func TestControlAPI(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, err := loadSession(ctx, []string{"--messages=sys:lo", "--polling-interval=20ms", "--writer-interval=1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := filepath.Join(t.TempDir(), "session")
+	writer, err := createSingleWriter(ctx, WriterConfig{Format: "json", Path: prefix, Rotation: RotationConfig{MaxAge: Duration(time.Hour)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	processor := cellularlog.NewProcessor(ctx, s.config.PollingInterval, s.config.WriterInterval, writer, 1000, s.messages[0].message)
+	defer processor.Close()
+	if err := initializeRequesters(ctx, processor, s.requesters); err != nil {
+		t.Fatal(err)
+	}
+	processor.Start()
+
+	server := httptest.NewServer(newControl(ctx, processor, s.requesters).handler())
+	defer server.Close()
+
+	do := func(method, path, body string, want int) *http.Response {
+		t.Helper()
+
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != want {
+			t.Fatalf("%s %s: expected %d, got %d", method, path, want, resp.StatusCode)
+		}
+		return resp
+	}
+
+	do(http.MethodPost, "/messages", `{"source": "sys", "name": "lo", "interval": "1s"}`, http.StatusConflict)
+	do(http.MethodPost, "/messages", `{"source": "at", "name": "I"}`, http.StatusBadRequest)
+	do(http.MethodPost, "/messages", `{"source": "sys", "name": "missing0", "interval": "1s", "tags": {"k": "v"}}`, http.StatusCreated)
+
+	query := "?" + url.Values{"requester": {"sys"}, "type": {"sys-lo"}}.Encode()
+	do(http.MethodPatch, "/messages"+query, `{"interval": "50ms"}`, http.StatusOK)
+
+	time.Sleep(200 * time.Millisecond)
+
+	var messages []messageStatus
+	if err := json.NewDecoder(do(http.MethodGet, "/messages", "", http.StatusOK).Body).Decode(&messages); err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 messages, got %+v", messages)
+	}
+	lo, missing := messages[0], messages[1]
+	if lo.Type != "sys-lo" || lo.Interval != Duration(50*time.Millisecond) || lo.Entries == 0 || lo.SuccessRate != 100 {
+		t.Errorf("unexpected status %+v", lo)
+	}
+	if missing.Type != "sys-missing0" || missing.Entries == 0 || missing.SuccessRate != 0 || missing.Tags["k"] != "v" {
+		t.Errorf("unexpected status %+v", missing)
+	}
+
+	var entries []cellularlog.LogEntry
+	if err := json.NewDecoder(do(http.MethodGet, "/entries"+query+"&last=2", "", http.StatusOK).Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Index >= entries[1].Index || entries[1].MessageType != "sys-lo" {
+		t.Errorf("expected the last 2 entries of sys-lo, got %+v", entries)
+	}
+	do(http.MethodGet, "/entries"+query+"&from=yesterday", "", http.StatusBadRequest)
+
+	var stats statsResponse
+	if err := json.NewDecoder(do(http.MethodGet, "/stats", "", http.StatusOK).Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Messages) != 2 || len(stats.Requesters) != 1 || stats.Requesters[0].Name != "sys" {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if lo := stats.Messages[0]; lo.Name != "sys-lo" || lo.Requests1m != lo.Requests || lo.SuccessRate1m != 100 || lo.LatencyP99 < lo.LatencyP50 {
+		t.Errorf("unexpected stats %+v", lo)
+	}
+	if missing := stats.Messages[1]; missing.Failures != missing.Requests || missing.Errors.Other+missing.Errors.IO != missing.Failures {
+		t.Errorf("expected every failure of sys-missing0 classified, got %+v", missing)
+	}
+
+	do(http.MethodDelete, "/messages?"+url.Values{"requester": {"sys"}, "type": {"sys-missing0"}}.Encode(), "", http.StatusNoContent)
+	do(http.MethodDelete, "/messages?"+url.Values{"requester": {"sys"}, "type": {"sys-missing0"}}.Encode(), "", http.StatusNotFound)
+	if n := len(processor.GetMessages()); n != 1 {
+		t.Errorf("expected 1 message after removal, got %d", n)
+	}
+
+	do(http.MethodPost, "/flush", "", http.StatusNoContent)
+	if info, err := os.Stat(prefix + "_000.json"); err != nil || info.Size() == 0 {
+		t.Errorf("expected flushed entries in the first file (%v)", err)
+	}
+
+	do(http.MethodPost, "/rotate", "", http.StatusNoContent)
+	time.Sleep(100 * time.Millisecond)
+	do(http.MethodPost, "/flush", "", http.StatusNoContent)
+	if _, err := os.Stat(prefix + "_001.json"); err != nil {
+		t.Errorf("expected a second file after rotation: %v", err)
+	}
+
+	var requesters []requesterStatus
+	if err := json.NewDecoder(do(http.MethodGet, "/requesters", "", http.StatusOK).Body).Decode(&requesters); err != nil {
+		t.Fatal(err)
+	}
+	if len(requesters) != 1 || requesters[0] != (requesterStatus{Name: "sys", Type: "sys"}) {
+		t.Errorf("unexpected requesters %+v", requesters)
+	}
+}
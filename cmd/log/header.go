@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"runtime/debug"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/harshabose/cellular_localisation_logging"
+)
+
+// version is set at build time, e.g. go build -ldflags "-X main.version=v1.4.0". Development builds
+// fall back to the VCS revision.
+var version = "dev"
+
+const redacted = "[redacted]"
+
+// secretFlags are recorded as redacted in the session header.
+var secretFlags = map[string]bool{"influx-token": true}
+
+func loggerVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if version != "dev" || !ok {
+		return version
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			if setting.Value == "true" {
+				modified = "-dirty"
+			}
+		}
+	}
+	if revision == "" {
+		return version
+	}
+	return version + "-" + revision[:min(len(revision), 12)] + modified
+}
+
+// effectiveConfig is the session as recorded in its header, without secrets.
+type effectiveConfig struct {
+	Flags      map[string]string        `json:"flags"`      // every flag, after the session file
+	Requesters map[string]requesterInfo `json:"requesters"` // by name
+	Messages   []messageInfo            `json:"messages"`
+	Writers    []interface{}            `json:"writers"` // with the keys of the session file
+	Upload     interface{}              `json:"upload,omitempty"`
+}
+
+type requesterInfo struct {
+	Type   string            `json:"type"`
+	Device string            `json:"device,omitempty"`
+	Flags  map[string]string `json:"flags,omitempty"` // where the requester's settings differ
+}
+
+type messageInfo struct {
+	Requester string            `json:"requester"`
+	Type      string            `json:"type"`
+	Interval  Duration          `json:"interval,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Priority  int               `json:"priority,omitempty"`
+}
+
+// sessionHeader describes s for the start of every log. The processor adds the identities of the
+// requesters.
+func sessionHeader(s *session) cellularlog.SessionHeader {
+	host, _ := os.Hostname()
+
+	global := flagValues(s.config)
+	effective := effectiveConfig{
+		Flags:      global,
+		Requesters: make(map[string]requesterInfo, len(s.requesters)),
+		Messages:   make([]messageInfo, 0, len(s.messages)),
+		Writers:    make([]interface{}, 0, len(s.writers)),
+	}
+
+	devices := make(map[string]string)
+	for _, r := range s.requesters {
+		info := requesterInfo{Type: r.prefix}
+		if device := sources[r.prefix].Device; device != nil {
+			info.Device = device(r.config)
+			devices[r.name] = info.Device
+		}
+		for name, value := range flagValues(r.config) {
+			if global[name] != value {
+				if info.Flags == nil {
+					info.Flags = make(map[string]string)
+				}
+				info.Flags[name] = value
+			}
+		}
+		effective.Requesters[r.name] = info
+	}
+
+	for _, spec := range s.messages {
+		effective.Messages = append(effective.Messages, messageInfo{
+			Requester: spec.message.GetRequester(),
+			Type:      spec.message.GetType(),
+			Interval:  Duration(spec.options.Interval),
+			Tags:      spec.options.Tags,
+			Priority:  spec.options.Priority,
+		})
+	}
+	sort.Slice(effective.Messages, func(i, j int) bool { return effective.Messages[i].Type < effective.Messages[j].Type })
+
+	for _, w := range s.writers {
+		if w.MQTT.Password != "" {
+			w.MQTT.Password = redacted
+		}
+		if w.Influx.Token != "" {
+			w.Influx.Token = redacted
+		}
+		effective.Writers = append(effective.Writers, yamlValue(w))
+	}
+
+	if s.upload != nil {
+		upload := *s.upload
+		upload.Headers = make(map[string]string, len(s.upload.Headers))
+		for name := range s.upload.Headers {
+			upload.Headers[name] = redacted
+		}
+		effective.Upload = yamlValue(upload)
+	}
+
+	return cellularlog.SessionHeader{
+		Version: loggerVersion(),
+		Host:    host,
+		Config:  effective,
+		Devices: devices,
+	}
+}
+
+// flagValues returns the value of every flag in config.
+func flagValues(config *Config) map[string]string {
+	values := make(map[string]string)
+
+	current := &Config{}
+	flags := newFlagSet(current)
+	*current = *config
+	flags.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if secretFlags[f.Name] && value != "" {
+			value = redacted
+		}
+		values[f.Name] = value
+	})
+
+	return values
+}
+
+// yamlValue converts v to the generic form of its YAML encoding, so that it is recorded with the keys
+// of the session file.
+func yamlValue(v interface{}) interface{} {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return nil
+	}
+
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil
+	}
+	return value
+}
@@ -2,30 +2,34 @@ package main
 
 import (
 	"context"
+	"crypto/ecdh"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
-	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/bluenviron/gomavlib/v3"
-	"github.com/bluenviron/gomavlib/v3/pkg/dialects/all"
-	"github.com/bluenviron/gomavlib/v3/pkg/dialects/ardupilotmega"
-	"github.com/bluenviron/gomavlib/v3/pkg/dialects/common"
-
 	"github.com/harshabose/cellular_localisation_logging"
-	"github.com/harshabose/cellular_localisation_logging/pkg/AT"
+	"github.com/harshabose/cellular_localisation_logging/pkg/chain"
+	"github.com/harshabose/cellular_localisation_logging/pkg/envelope"
+	"github.com/harshabose/cellular_localisation_logging/pkg/gpsd"
+	"github.com/harshabose/cellular_localisation_logging/pkg/influx"
 	"github.com/harshabose/cellular_localisation_logging/pkg/mavlink"
+	"github.com/harshabose/cellular_localisation_logging/pkg/mqtt"
+	"github.com/harshabose/cellular_localisation_logging/pkg/redact"
+	"github.com/harshabose/cellular_localisation_logging/pkg/upload"
 )
 
 type Config struct {
+	ConfigFile      string
 	Messages        string
 	OutputFormat    string
 	OutputFile      string
 	BufferSize      int
+	MaxBuffered     int
 	PollingInterval time.Duration
 	WriterInterval  time.Duration
 
@@ -33,165 +37,205 @@ type Config struct {
 	MAVDevice  string
 	MAVBaud    int
 	MAVTimeout time.Duration
+	// MAVLink 2 signing, enabled by a key from MAVSigningKey or $MAVLINK_SIGNING_KEY
+	MAVSigningKey string
+	MAVSigning    string
 
 	// AT specific
 	ATDevice  string
 	ATBaud    int
 	ATTimeout time.Duration
+	ATGNSS    bool
+
+	// NMEA specific
+	NMEADevice  string
+	NMEABaud    int
+	NMEATimeout time.Duration
+
+	// gpsd specific
+	GPSDAddress string
+	GPSDTimeout time.Duration
+
+	// probe specific
+	ProbeAddress     string
+	ProbeInterface   string
+	ProbeTimeout     time.Duration
+	ProbeUDPCount    int
+	ProbeUDPInterval time.Duration
+	ProbeUDPSize     int
+	ProbeBurstSize   int64
+
+	// mqtt writer specific
+	MQTTBroker  string
+	MQTTVehicle string
+	MQTTTopic   string
+	MQTTQoS     int
+	MQTTBatch   bool
+	MQTTQueue   string
+
+	// influx writer specific
+	InfluxURL     string
+	InfluxToken   string
+	InfluxVehicle string
+
+	// Source (message prefix) whose position is joined to the other entries, e.g. gpsd or nmea
+	PositionReference string
+
+	// Address of the control API, host:port or unix:/path/to.sock
+	ControlAddress string
+
+	// Address of the Prometheus /metrics endpoint, host:port
+	MetricsAddress string
+
+	// Write-ahead log of the buffer, disabled without a directory
+	WALDir          string
+	WALSync         string
+	WALSyncInterval time.Duration
+
+	HistoryEntries int
+	HistoryAge     time.Duration
+	StatsInterval  time.Duration
+
+	// Public key the output files are encrypted for, see pkg/envelope
+	EncryptKey string
+	// Private key the manifests of the output files are signed with, see pkg/chain
+	SignKey string
+
+	// Redaction of identifiers and positions before the writers, see pkg/redact
+	Redact        string
+	RedactMode    string
+	RedactGrid    float64
+	RedactKeyFile string
+
+	// Forwarding of closed segments, see pkg/upload
+	UploadURL   string
+	UploadRate  int64
+	UploadState string
+
+	// Show a live dashboard instead of the plain console output
+	TUI bool
 
 	// Utility flags
 	ListMessages bool
 }
 
-var mavlinkRegistry = map[string]func(context.Context) cellularlog.Message{
-	// IMU Messages (for inertial navigation)
-	"SCALED_IMU": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*ardupilotmega.MessageScaledImu](ctx)
-	},
-	"SCALED_IMU2": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*ardupilotmega.MessageScaledImu2](ctx)
-	},
-	"SCALED_IMU3": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*ardupilotmega.MessageScaledImu3](ctx)
-	},
-	"RAW_IMU": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*ardupilotmega.MessageRawImu](ctx)
-	},
-
-	// GPS Messages (for satellite-based positioning)
-	"GPS_RAW_INT": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*common.MessageGpsRawInt](ctx)
-	},
-	"GPS2_RAW": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*ardupilotmega.MessageGps2Raw](ctx)
-	},
-	"GPS_STATUS": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*common.MessageGpsStatus](ctx)
-	},
-
-	// Position Messages (for fused position estimates)
-	"GLOBAL_POSITION_INT": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*common.MessageGlobalPositionInt](ctx)
-	},
-	"LOCAL_POSITION_NED": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*common.MessageLocalPositionNed](ctx)
-	},
-
-	// Attitude and Orientation (for complete pose estimation)
-	"ATTITUDE": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*common.MessageAttitude](ctx)
-	},
-	"ATTITUDE_QUATERNION": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*common.MessageAttitudeQuaternion](ctx)
-	},
-
-	// Magnetometer Messages (for compass/heading data)
-	"SCALED_PRESSURE": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*common.MessageScaledPressure](ctx)
-	},
-	"MAG_CAL_REPORT": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*ardupilotmega.MessageMagCalReport](ctx)
-	},
-
-	// Navigation and Control Messages
-	"NAV_CONTROLLER_OUTPUT": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*common.MessageNavControllerOutput](ctx)
-	},
-	"POSITION_TARGET_GLOBAL_INT": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*common.MessagePositionTargetGlobalInt](ctx)
-	},
-	"POSITION_TARGET_LOCAL_NED": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*common.MessagePositionTargetLocalNed](ctx)
-	},
-
-	// System Status (for understanding system state)
-	"SYS_STATUS": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*common.MessageSysStatus](ctx)
-	},
-	"HEARTBEAT": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*common.MessageHeartbeat](ctx)
-	},
-
-	// EKF/Filter Status (for understanding fusion quality)
-	"EKF_STATUS_REPORT": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*ardupilotmega.MessageEkfStatusReport](ctx)
-	},
-	"AHRS": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*ardupilotmega.MessageAhrs](ctx)
-	},
-	"AHRS2": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*ardupilotmega.MessageAhrs2](ctx)
-	},
-
-	// High-rate position data
-	"HIGH_LATENCY": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*common.MessageHighLatency](ctx)
-	},
-	"HIGH_LATENCY2": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*common.MessageHighLatency2](ctx)
-	},
-
-	// Optical Flow (if available - for visual positioning)
-	"OPTICAL_FLOW": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*common.MessageOpticalFlow](ctx)
-	},
-	"OPTICAL_FLOW_RAD": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*common.MessageOpticalFlowRad](ctx)
-	},
-
-	// Velocity and acceleration
-	"LOCAL_POSITION_NED_SYSTEM_GLOBAL_OFFSET": func(ctx context.Context) cellularlog.Message {
-		return mavlink.NewMessage[*common.MessageLocalPositionNedSystemGlobalOffset](ctx)
-	},
-}
-
 func main() {
-	config := parseFlags()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	if config.ListMessages {
+	session, err := loadSession(ctx, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if session.config.ListMessages {
 		listAvailableMessages()
 		return
 	}
 
-	if config.Messages == "" {
-		fmt.Printf("Error: --messages flag is required\n")
+	if len(session.messages) == 0 {
+		fmt.Printf("Error: --messages flag or a --config file with messages is required\n")
 		fmt.Printf("Example: --messages=\"mavlink:SCALED_IMU2,at:I\"\n")
 		os.Exit(1)
 	}
 
-	if err := run(config); err != nil {
+	if err := run(ctx, session); err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-func parseFlags() *Config {
-	config := &Config{}
+// newFlagSet defines the flags on config, setting every field to its default.
+func newFlagSet(config *Config) *flag.FlagSet {
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	flags.StringVar(&config.ConfigFile, "config", "", "YAML session file; flags given alongside override its values")
 
 	// Main flags
-	flag.StringVar(&config.Messages, "messages", "", "Comma-separated list of messages (e.g., mavlink:SCALED_IMU2,at:I)")
-	flag.StringVar(&config.OutputFormat, "output", "json", "Output format: json, csv, binary, or multiple (csv,json)")
-	flag.StringVar(&config.OutputFile, "file", generateTimestampedFilename("cellular_logger"), "Output file prefix (extension added automatically)")
-	flag.IntVar(&config.BufferSize, "buffer", 100, "Log buffer size for batching")
-	flag.DurationVar(&config.PollingInterval, "polling-interval", 1*time.Second, "Polling interval")
-	flag.DurationVar(&config.WriterInterval, "writer-interval", 30*time.Second, "Polling interval")
+	flags.StringVar(&config.Messages, "messages", "", "Comma-separated list of messages (e.g., mavlink:SCALED_IMU2,at:I)")
+	flags.StringVar(&config.OutputFormat, "output", "json", "Output format: json, csv, binary, influx, mqtt, or multiple (csv,json)")
+	flags.StringVar(&config.OutputFile, "file", generateTimestampedFilename("cellular_logger"), "Output file prefix (extension added automatically)")
+	flags.IntVar(&config.BufferSize, "buffer", 100, "Log buffer size for batching")
+	flags.IntVar(&config.MaxBuffered, "max-buffered", 10000, "Entries kept for the next flush while the writer fails, lowest priority dropped first; 0 drops failed batches")
+	flags.DurationVar(&config.PollingInterval, "polling-interval", 1*time.Second, "Polling interval")
+	flags.DurationVar(&config.WriterInterval, "writer-interval", 30*time.Second, "Polling interval")
 
 	// MAVLink flags
-	flag.StringVar(&config.MAVDevice, "mav-device", "/dev/ttyUSB0", "MAVLink serial device")
-	flag.IntVar(&config.MAVBaud, "mav-baud", 57600, "MAVLink baud rate")
-	flag.DurationVar(&config.MAVTimeout, "mav-timeout", 5*time.Second, "MAVLink request timeout")
+	flags.StringVar(&config.MAVDevice, "mav-device", "/dev/ttyUSB0", "MAVLink serial device")
+	flags.IntVar(&config.MAVBaud, "mav-baud", 57600, "MAVLink baud rate")
+	flags.DurationVar(&config.MAVTimeout, "mav-timeout", 5*time.Second, "MAVLink request timeout")
+	flags.StringVar(&config.MAVSigningKey, "mav-signing-key", "", "File with the MAVLink 2 signing key, 64 hex digits or a passphrase (default: $"+mavSigningKeyEnv+")")
+	flags.StringVar(&config.MAVSigning, "mav-signing", string(mavlink.SigningVerify), "With a signing key, what to do with frames not validly signed: verify (log them with the result) or require (ignore them)")
 
 	// AT flags
-	flag.StringVar(&config.ATDevice, "at-device", "/dev/ttyUSB1", "AT command serial device")
-	flag.IntVar(&config.ATBaud, "at-baud", 115200, "AT command baud rate")
-	flag.DurationVar(&config.ATTimeout, "at-timeout", 5*time.Second, "AT command timeout")
+	flags.StringVar(&config.ATDevice, "at-device", "/dev/ttyUSB1", "AT command serial device")
+	flags.IntVar(&config.ATBaud, "at-baud", 115200, "AT command baud rate")
+	flags.DurationVar(&config.ATTimeout, "at-timeout", 5*time.Second, "AT command timeout")
+	flags.BoolVar(&config.ATGNSS, "at-gnss", false, "Enable the modem's internal GNSS engine (Quectel AT+QGPS) for the session")
+
+	// NMEA flags
+	flags.StringVar(&config.NMEADevice, "nmea-device", "/dev/ttyUSB1", "NMEA GNSS receiver serial device")
+	flags.IntVar(&config.NMEABaud, "nmea-baud", 9600, "NMEA GNSS receiver baud rate")
+	flags.DurationVar(&config.NMEATimeout, "nmea-timeout", 2*time.Second, "Time to wait for a fresh NMEA sentence")
+
+	// gpsd flags
+	flags.StringVar(&config.GPSDAddress, "gpsd-address", gpsd.DefaultAddress, "gpsd address")
+	flags.DurationVar(&config.GPSDTimeout, "gpsd-timeout", 2*time.Second, "Time to wait for a fresh gpsd report")
+
+	// probe flags
+	flags.StringVar(&config.ProbeAddress, "probe-address", "", "Probe server address (host:port, see cmd/probe-server)")
+	flags.StringVar(&config.ProbeInterface, "probe-interface", "", "Bind probe sockets to this interface, e.g. wwan0 (linux, needs CAP_NET_RAW)")
+	flags.DurationVar(&config.ProbeTimeout, "probe-timeout", 5*time.Second, "Probe timeout")
+	flags.IntVar(&config.ProbeUDPCount, "probe-udp-count", 10, "Datagrams per UDP echo probe")
+	flags.DurationVar(&config.ProbeUDPInterval, "probe-udp-interval", 20*time.Millisecond, "Interval between UDP echo datagrams")
+	flags.IntVar(&config.ProbeUDPSize, "probe-udp-size", 64, "UDP echo datagram payload size in bytes")
+	flags.Int64Var(&config.ProbeBurstSize, "probe-burst-size", 1<<20, "Bytes per direction of a throughput burst")
+
+	// mqtt writer flags
+	flags.StringVar(&config.MQTTBroker, "mqtt-broker", "", "MQTT broker for --output=mqtt, e.g. tcp://ground:1883")
+	flags.StringVar(&config.MQTTVehicle, "mqtt-vehicle", "vehicle", "Vehicle ID, the second level of MQTT topics")
+	flags.StringVar(&config.MQTTTopic, "mqtt-topic", mqtt.DefaultTopicPrefix, "First level of MQTT topics")
+	flags.IntVar(&config.MQTTQoS, "mqtt-qos", 1, "MQTT QoS: 0, 1 or 2")
+	flags.BoolVar(&config.MQTTBatch, "mqtt-batch", false, "Publish each flush as one JSON array instead of one message per entry")
+	flags.StringVar(&config.MQTTQueue, "mqtt-queue", "mqtt-queue", "Directory queueing MQTT entries while the broker is unreachable, none to disable")
+
+	// influx writer flags
+	flags.StringVar(&config.InfluxURL, "influx-url", "", "InfluxDB write endpoint for --output=influx, e.g. http://influx:8086/api/v2/write?org=team&bucket=flights; without it line protocol is written to a .lp file")
+	flags.StringVar(&config.InfluxToken, "influx-token", "", "InfluxDB API token")
+	flags.StringVar(&config.InfluxVehicle, "influx-vehicle", "vehicle", "Vehicle tag of InfluxDB lines")
+
+	flags.StringVar(&config.PositionReference, "position-ref", "", "Source whose position is added to every other entry (e.g. gpsd, nmea)")
+
+	flags.StringVar(&config.ControlAddress, "control", "", "Serve the control API on host:port or unix:/path/to.sock (e.g. 127.0.0.1:8090)")
+
+	flags.StringVar(&config.MetricsAddress, "metrics", "", "Serve Prometheus metrics on host:port/metrics (e.g. :9108)")
+	flags.StringVar(&config.WALDir, "wal", "", "Directory of a write-ahead log keeping buffered entries across crashes (e.g. wal)")
+	flags.StringVar(&config.WALSync, "wal-sync", string(cellularlog.SyncInterval), "When the write-ahead log is fsynced: always, interval or never")
+	flags.DurationVar(&config.WALSyncInterval, "wal-sync-interval", time.Second, "Time between fsyncs with --wal-sync=interval")
+	flags.IntVar(&config.HistoryEntries, "history", cellularlog.DefaultHistoryCapacity, "Entries kept in memory per message for the TUI and the control API")
+	flags.DurationVar(&config.HistoryAge, "history-age", 0, "Forget in-memory entries older than this (0 keeps them until --history is reached)")
+	flags.DurationVar(&config.StatsInterval, "stats-interval", time.Minute, "Log per-message and per-requester statistics as \"stats\" entries this often (0 to disable)")
+
+	flags.StringVar(&config.EncryptKey, "encrypt-key", "", "Encrypt the output files for this X25519 public key (PEM); read them with cmd/decrypt")
+	flags.StringVar(&config.SignKey, "sign-key", "", "Chain the entries of the output files and sign a manifest of each with this Ed25519 private key (PEM); check them with cmd/verify")
+	flags.StringVar(&config.Redact, "redact", "", "Comma-separated identifiers to redact before writing: imei, imsi, iccid, phone")
+	flags.StringVar(&config.RedactMode, "redact-mode", string(redact.ModeHash), "How identifiers are redacted: hash (keyed HMAC) or mask (last four digits kept)")
+	flags.Float64Var(&config.RedactGrid, "redact-grid", 0, "Coarsen coordinates to the centre of a grid of this many degrees, e.g. 0.01 (0 keeps them)")
+	flags.StringVar(&config.RedactKeyFile, "redact-key-file", "", "File with the key of redaction hashes, so that they match across sessions (default: random per session)")
+
+	flags.StringVar(&config.UploadURL, "upload-url", "", "Upload closed segments to this tus endpoint (e.g. https://ground/files/)")
+	flags.Int64Var(&config.UploadRate, "upload-rate", 128<<10, "Upload rate limit in bytes per second, 0 for none")
+	flags.StringVar(&config.UploadState, "upload-state", "upload-state.json", "File keeping the upload progress across sessions")
+	flags.BoolVar(&config.TUI, "tui", false, "Show a live dashboard of messages, links, serving cell, GNSS fix and writer state")
 
 	// Utility flags
-	flag.BoolVar(&config.ListMessages, "list", false, "List available messages and exit")
+	flags.BoolVar(&config.ListMessages, "list", false, "List available messages and exit")
 
-	flag.Parse()
-
-	return config
+	return flags
 }
 
 // generateTimestampedFilename creates a filename with timestamp
@@ -202,40 +246,36 @@ func generateTimestampedFilename(prefix string) string {
 
 func listAvailableMessages() {
 	fmt.Println("Available Messages:")
-	fmt.Println("\nMAVLink Messages:")
-	for name := range mavlinkRegistry {
-		fmt.Printf("  mavlink:%s\n", name)
-	}
+	for _, prefix := range sourcePrefixes() {
+		source := sources[prefix]
 
-	fmt.Println("\nAT Commands:")
-	fmt.Println("  at:I")
-	fmt.Println("  at:+GCAP")
-	fmt.Println("  at:+CNMI=?")
-	fmt.Println("  at:+CREG?")
-	fmt.Println("  at:+CSQ")
-	fmt.Println("  at:+CPIN?")
-	fmt.Println("  (Any valid AT command)")
+		fmt.Printf("\n%s:\n", source.Description)
+		for _, example := range source.Examples {
+			fmt.Printf("  %s:%s\n", prefix, example)
+		}
+		if source.Hint != "" {
+			fmt.Printf("  (%s)\n", source.Hint)
+		}
+	}
 
 	fmt.Println("\nExample usage:")
 	fmt.Println("  ./logger --messages=\"mavlink:SCALED_IMU2,mavlink:ATTITUDE,at:I,at:+CSQ\"")
 }
 
-func run(config *Config) error {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+func run(ctx context.Context, session *session) error {
+	config := session.config
 
-	messages, err := parseMessages(config.Messages, ctx)
+	writer, err := createWriter(ctx, session.writers)
 	if err != nil {
-		return fmt.Errorf("failed to parse messages: %w", err)
+		return fmt.Errorf("failed to create writer: %w", err)
 	}
-
-	if len(messages) == 0 {
-		return fmt.Errorf("no valid messages specified")
+	if session.redactor != nil {
+		writer = redact.NewWriter(writer, session.redactor)
 	}
 
-	writer, err := createWriter(config)
-	if err != nil {
-		return fmt.Errorf("failed to create writer: %w", err)
+	messages := make([]cellularlog.Message, 0, len(session.messages))
+	for _, spec := range session.messages {
+		messages = append(messages, spec.message)
 	}
 
 	processor := cellularlog.NewProcessor(
@@ -247,86 +287,162 @@ func run(config *Config) error {
 		messages...,
 	)
 
-	if err := initializeRequesters(processor, config); err != nil {
-		return fmt.Errorf("failed to initialize requesters: %w", err)
+	for _, spec := range session.messages {
+		processor.SetMessageOptions(spec.message, spec.options)
+	}
+	processor.SetOutput(console)
+	processor.SetBackpressure(config.MaxBuffered)
+	processor.SetHistoryLimits(config.HistoryEntries, config.HistoryAge)
+	processor.SetStatsInterval(config.StatsInterval)
+	if err := processor.SetRules(session.rules); err != nil {
+		if e := processor.Close(); e != nil {
+			fmt.Printf("error closing processor: %v\n", e)
+		}
+		return fmt.Errorf("failed to set rules: %w", err)
 	}
 
-	processor.Start()
+	if err := initializeRequesters(ctx, processor, session.requesters); err != nil {
+		if e := processor.Close(); e != nil {
+			fmt.Printf("error closing processor: %v\n", e)
+		}
+		return fmt.Errorf("failed to initialize requesters: %w", err)
+	}
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	if err := setPositionReference(processor, config.PositionReference); err != nil {
+		if e := processor.Close(); e != nil {
+			fmt.Printf("error closing processor: %v\n", e)
+		}
+		return err
+	}
 
-	<-sigChan
+	// Not fatal: writers that failed the header may still take the entries.
+	if err := processor.WriteSessionHeader(sessionHeader(session)); err != nil {
+		fmt.Printf("warning: %v\n", err)
+	}
 
-	// Graceful shutdown
-	return processor.Close()
-}
+	// Entries of an earlier session are replayed after this session's header.
+	if config.WALDir != "" {
+		replayed, err := processor.EnableWAL(cellularlog.WALConfig{
+			Dir:          config.WALDir,
+			Sync:         cellularlog.SyncPolicy(config.WALSync),
+			SyncInterval: config.WALSyncInterval,
+		})
+		if replayed > 0 {
+			fmt.Printf("replayed %d entries from the write-ahead log\n", replayed)
+		}
+		if err != nil {
+			if e := processor.Close(); e != nil {
+				fmt.Printf("error closing processor: %v\n", e)
+			}
+			return err
+		}
+	}
 
-func parseMessages(messageStr string, ctx context.Context) ([]cellularlog.Message, error) {
-	if messageStr == "" {
-		return nil, fmt.Errorf("empty message string")
+	var metrics *metrics
+	if config.MetricsAddress != "" {
+		if metrics, err = startMetrics(config.MetricsAddress, processor); err != nil {
+			if e := processor.Close(); e != nil {
+				fmt.Printf("error closing processor: %v\n", e)
+			}
+			return fmt.Errorf("failed to start metrics endpoint: %w", err)
+		}
+		fmt.Printf("metrics available on %s/metrics\n", config.MetricsAddress)
 	}
 
-	parts := strings.Split(messageStr, ",")
-	messages := make([]cellularlog.Message, 0, len(parts))
+	processor.Start()
 
-	for _, part := range parts {
-		part = strings.TrimSpace(part)
-		if part == "" {
-			continue
+	var control *control
+	if config.ControlAddress != "" {
+		if control, err = startControl(ctx, config.ControlAddress, processor, session.requesters); err != nil {
+			if e := processor.Close(); e != nil {
+				fmt.Printf("error closing processor: %v\n", e)
+			}
+			return fmt.Errorf("failed to start control API: %w", err)
 		}
+		fmt.Printf("control API listening on %s\n", config.ControlAddress)
+	}
 
-		colonIndex := strings.Index(part, ":")
-		if colonIndex == -1 {
-			return nil, fmt.Errorf("invalid message format: %s (expected type:name)", part)
+	var uploader *upload.Uploader
+	if session.upload != nil {
+		if uploader, err = startUploader(ctx, session.upload, processor); err != nil {
+			if e := processor.Close(); e != nil {
+				fmt.Printf("error closing processor: %v\n", e)
+			}
+			return fmt.Errorf("failed to start uploader: %w", err)
 		}
+		fmt.Printf("uploading closed segments to %s\n", session.upload.URL)
+	}
 
-		messageType := strings.TrimSpace(part[:colonIndex])
-		messageName := strings.TrimSpace(part[colonIndex+1:])
+	var dashboard *dashboard
+	if config.TUI {
+		dashboard = newDashboard(processor, session.requesters, console)
+		dashboard.Start()
+	}
 
-		switch messageType {
-		case "mavlink":
-			msg, err := createMAVLinkMessage(messageName, ctx)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create MAVLink message %s: %w", messageName, err)
-			}
-			messages = append(messages, msg)
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-		case "at":
-			msg := AT.NewMessage(messageName)
-			messages = append(messages, msg)
+	<-sigChan
 
-		default:
-			return nil, fmt.Errorf("unknown message type: %s (supported: mavlink, at)", messageType)
-		}
+	if dashboard != nil {
+		dashboard.Stop()
 	}
 
-	return messages, nil
-}
+	if control != nil {
+		if err := control.Close(); err != nil {
+			fmt.Printf("error closing control API: %v\n", err)
+		}
+	}
 
-func createMAVLinkMessage(name string, ctx context.Context) (cellularlog.Message, error) {
-	if factory, exists := mavlinkRegistry[name]; exists {
-		return factory(ctx), nil
+	if metrics != nil {
+		if err := metrics.Close(); err != nil {
+			fmt.Printf("error closing metrics endpoint: %v\n", err)
+		}
 	}
 
-	if id, err := strconv.Atoi(name); err == nil {
-		return mavlink.CreateMAVLinkMessageByID(uint32(id), ctx)
+	// Segments closed by the processor are uploaded by the next session.
+	if uploader != nil {
+		if err := uploader.Close(); err != nil {
+			fmt.Printf("error closing uploader: %v\n", err)
+		}
 	}
 
-	return nil, fmt.Errorf("unknown MAVLink message: %s", name)
+	// Graceful shutdown
+	return processor.Close()
 }
 
-func createWriter(config *Config) (cellularlog.Writer, error) {
-	formats := strings.Split(config.OutputFormat, ",")
+// startUploader uploads the segments matching the patterns in the background, skipping the files
+// the writers still have open.
+func startUploader(ctx context.Context, config *UploadConfig, processor *cellularlog.Processor) (*upload.Uploader, error) {
+	uploader, err := upload.NewUploader(ctx, upload.Config{
+		URL:       config.URL,
+		Headers:   config.Headers,
+		Patterns:  config.Patterns,
+		StateFile: config.State,
+		ChunkSize: int64(config.ChunkSize),
+		Rate:      int64(config.Rate),
+		Interval:  time.Duration(config.Interval),
+		MinAge:    time.Duration(config.MinAge),
+		Delete:    config.Delete,
+		Active:    func() []string { return processor.GetWriterStats().Files },
+		Output:    console,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	if len(formats) == 1 {
-		return createSingleWriter(formats[0], config.OutputFile)
+	uploader.Start()
+	return uploader, nil
+}
+
+func createWriter(ctx context.Context, configs []WriterConfig) (cellularlog.Writer, error) {
+	if len(configs) == 1 {
+		return createSingleWriter(ctx, configs[0])
 	}
 
-	writers := make([]cellularlog.Writer, 0, len(formats))
-	for _, format := range formats {
-		format = strings.TrimSpace(format)
-		writer, err := createSingleWriter(format, config.OutputFile)
+	writers := make([]cellularlog.Writer, 0, len(configs))
+	for _, config := range configs {
+		writer, err := createSingleWriter(ctx, config)
 		if err != nil {
 			for _, w := range writers {
 				if err := w.Close(); err != nil {
@@ -341,55 +457,141 @@ func createWriter(config *Config) (cellularlog.Writer, error) {
 	return cellularlog.NewMultiWriter(writers...), nil
 }
 
-func createSingleWriter(format, filePrefix string) (cellularlog.Writer, error) {
-	switch format {
-	case "json":
-		filename := filePrefix + ".json"
-		return cellularlog.NewJSONWriter(filename)
-	case "csv":
-		filename := filePrefix + ".csv"
-		return cellularlog.NewCSVWriter(filename)
-	case "binary":
-		filename := filePrefix + ".bin"
-		return cellularlog.NewBinaryWriter(filename)
-	default:
-		return nil, fmt.Errorf("unsupported output format: %s", format)
+// createSingleWriter creates the writer of config, wrapped in its failure policy if it has one.
+func createSingleWriter(ctx context.Context, config WriterConfig) (cellularlog.Writer, error) {
+	writer, err := openWriter(ctx, config)
+	if err != nil || config.OnError == (FailureConfig{}) {
+		return writer, err
 	}
+
+	policy, err := cellularlog.NewPolicyWriter(writer, cellularlog.FailurePolicy{
+		Retries:      config.OnError.Retries,
+		MinBackoff:   time.Duration(config.OnError.Backoff),
+		MaxBackoff:   time.Duration(config.OnError.MaxBackoff),
+		SpillDir:     config.OnError.Spill,
+		SpillMaxSize: int64(config.OnError.SpillMaxSize),
+	})
+	if err != nil {
+		if e := writer.Close(); e != nil {
+			fmt.Printf("error closing writer: %v\n", e)
+		}
+		return nil, err
+	}
+	return policy, nil
 }
 
-func initializeRequesters(processor *cellularlog.Processor, config *Config) error {
-	if needsMAVLink(config.Messages) {
-		mav, err := mavlink.NewMavlink(
-			config.MAVDevice,
-			config.MAVBaud,
-			config.MAVTimeout,
-			all.Dialect,
-			gomavlib.V2,
-		)
+func openWriter(ctx context.Context, config WriterConfig) (cellularlog.Writer, error) {
+	if config.Format == "mqtt" {
+		return createMQTTWriter(ctx, config.MQTT)
+	}
+	if config.Format == "influx" && config.Influx.URL != "" {
+		return influx.NewHTTPWriter(ctx, influx.HTTPConfig{
+			URL:       config.Influx.URL,
+			Token:     config.Influx.Token,
+			Vehicle:   config.Influx.Vehicle,
+			BatchSize: config.Influx.BatchSize,
+			Timeout:   time.Duration(config.Influx.Timeout),
+		})
+	}
+
+	prefix := strings.ReplaceAll(config.Path, "{time}", time.Now().Format("2006-01-02_15-04-05"))
+	ext := writerExtensions[config.Format]
+
+	policy := cellularlog.RotationPolicy{
+		MaxSize:  int64(config.Rotation.MaxSize),
+		MaxAge:   time.Duration(config.Rotation.MaxAge),
+		Compress: config.Compression == "gzip",
+	}
+
+	open := func(filename string) (cellularlog.Writer, error) {
+		file, err := createFile(filename, config.recipient)
 		if err != nil {
-			return fmt.Errorf("failed to initialize MAVLink: %w", err)
+			return nil, err
 		}
 
-		processor.Mavlink = mav
+		switch config.Format {
+		case "json":
+			return cellularlog.NewJSONWriterTo(file), nil
+		case "csv":
+			return cellularlog.NewCSVWriterTo(file), nil
+		case "binary":
+			return cellularlog.NewBinaryWriterTo(file), nil
+		case "influx":
+			return influx.NewFileWriterTo(file, config.Influx.Vehicle), nil
+		default:
+			_ = file.Close()
+			return nil, fmt.Errorf("unsupported output format: %s", config.Format)
+		}
 	}
 
-	// Check if we need AT
-	if needsAT(config.Messages) {
-		at, err := AT.NewAT(config.ATDevice, config.ATBaud, config.ATTimeout)
-		if err != nil {
-			return fmt.Errorf("failed to initialize AT: %w", err)
+	if config.signer == nil {
+		if policy == (cellularlog.RotationPolicy{}) {
+			return open(prefix + ext)
 		}
+		return cellularlog.NewRotatingWriter(prefix, ext, policy, open)
+	}
 
-		processor.AT = at
+	// Every file is a segment of the chain; closing the outermost writer marks the last one final.
+	links, err := chain.New(config.signer)
+	if err != nil {
+		return nil, err
+	}
+	openSegment := func(filename string) (cellularlog.Writer, error) {
+		writer, err := open(filename)
+		if err != nil {
+			return nil, err
+		}
+		segment, err := links.Segment(writer)
+		if err != nil {
+			_ = writer.Close()
+			return nil, err
+		}
+		return segment, nil
 	}
 
-	return nil
+	var writer cellularlog.Writer
+	if policy == (cellularlog.RotationPolicy{}) {
+		writer, err = openSegment(prefix + ext)
+	} else {
+		writer, err = cellularlog.NewRotatingWriter(prefix, ext, policy, openSegment)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return links.Wrap(writer), nil
 }
 
-func needsMAVLink(messages string) bool {
-	return strings.Contains(messages, "mavlink:")
+// createFile creates an output file, encrypted with the .enc extension if there is a recipient.
+func createFile(filename string, recipient *ecdh.PublicKey) (cellularlog.OutputFile, error) {
+	if recipient != nil {
+		return envelope.Create(filename+envelope.Ext, recipient)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
 }
 
-func needsAT(messages string) bool {
-	return strings.Contains(messages, "at:")
+func createMQTTWriter(ctx context.Context, config MQTTConfig) (cellularlog.Writer, error) {
+	queue := config.Queue
+	if queue == "none" {
+		queue = ""
+	}
+
+	return mqtt.NewWriter(ctx, mqtt.Config{
+		Broker:       config.Broker,
+		ClientID:     config.ClientID,
+		Username:     config.Username,
+		Password:     config.Password,
+		Vehicle:      config.Vehicle,
+		TopicPrefix:  config.Topic,
+		QoS:          byte(*config.QoS),
+		Batch:        config.Batch,
+		Timeout:      time.Duration(config.Timeout),
+		QueueDir:     queue,
+		QueueMaxSize: int64(config.QueueMaxSize),
+		Output:       console,
+	})
 }
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/AT"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the response and flush duration histograms.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metrics serves the session's health in the Prometheus text format on /metrics. Requests and flushes
+// are counted as the processor reports them; buffer, writer, reconnect and cellular gauges are read
+// at scrape time.
+type metrics struct {
+	processor *cellularlog.Processor
+
+	messages map[messageKey]*messageMetrics
+	flushes  histogram
+	failed   uint64                 // flushes
+	cells    map[string]cellMetrics // by requester
+	mux      sync.Mutex
+
+	server *http.Server
+}
+
+type messageKey struct {
+	requester, messageType string
+}
+
+type messageMetrics struct {
+	requests, successes uint64
+	errors              map[string]uint64 // by cellularlog.ErrorClass
+	durations           histogram
+	lastSuccess         time.Time
+}
+
+// cellMetrics are the latest values parsed from a modem.
+type cellMetrics struct {
+	serving *AT.ServingCell
+	signal  *AT.SignalQuality
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative; the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(durationBuckets)+1)
+	}
+
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(durationBuckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+}
+
+func newMetrics(processor *cellularlog.Processor) *metrics {
+	return &metrics{
+		processor: processor,
+		messages:  make(map[messageKey]*messageMetrics),
+		cells:     make(map[string]cellMetrics),
+	}
+}
+
+// startMetrics serves /metrics on address and sets the observer of processor.
+func startMetrics(address string, processor *cellularlog.Processor) (*metrics, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	m := newMetrics(processor)
+	processor.SetObserver(m)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", m)
+	m.server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		if err := m.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(console, "metrics endpoint stopped: %v\n", err)
+		}
+	}()
+
+	return m, nil
+}
+
+func (m *metrics) Close() error {
+	if m.server == nil {
+		return nil
+	}
+	return m.server.Close()
+}
+
+// ObserveEntry implements cellularlog.Observer.
+func (m *metrics) ObserveEntry(message cellularlog.Message, entry cellularlog.LogEntry) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	key := messageKey{requester: message.GetRequester(), messageType: message.GetType()}
+	mm, ok := m.messages[key]
+	if !ok {
+		mm = &messageMetrics{errors: make(map[string]uint64)}
+		m.messages[key] = mm
+	}
+
+	mm.requests++
+	if !entry.Success {
+		mm.errors[cellularlog.ErrorClass(entry)]++
+		return
+	}
+
+	mm.successes++
+	mm.durations.observe(entry.Duration)
+	mm.lastSuccess = entry.RequestTime
+
+	cell := m.cells[key.requester]
+	switch data := entry.Data.(type) {
+	case *AT.ServingCell:
+		cell.serving = data
+	case *AT.SignalQuality:
+		cell.signal = data
+	default:
+		return
+	}
+	m.cells[key.requester] = cell
+}
+
+// ObserveFlush implements cellularlog.Observer.
+func (m *metrics) ObserveFlush(_ int, duration time.Duration, err error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.flushes.observe(duration)
+	if err != nil {
+		m.failed++
+	}
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	buf := bufio.NewWriter(w)
+	m.write(buf)
+	_ = buf.Flush()
+}
+
+func (m *metrics) write(w io.Writer) {
+	stats := m.processor.GetWriterStats()
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	keys := make([]messageKey, 0, len(m.messages))
+	for key := range m.messages {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].requester != keys[j].requester {
+			return keys[i].requester < keys[j].requester
+		}
+		return keys[i].messageType < keys[j].messageType
+	})
+
+	labels := func(key messageKey, extra ...string) string {
+		return formatLabels(append([]string{"requester", key.requester, "message_type", key.messageType}, extra...)...)
+	}
+
+	header(w, "requests_total", "counter", "Requests made, by requester and message type.")
+	for _, key := range keys {
+		sample(w, "requests_total", labels(key), float64(m.messages[key].requests))
+	}
+
+	header(w, "successes_total", "counter", "Successful responses, by requester and message type.")
+	for _, key := range keys {
+		sample(w, "successes_total", labels(key), float64(m.messages[key].successes))
+	}
+
+	header(w, "errors_total", "counter", "Failed requests, by requester, message type and class (timeout, cancelled, mismatch, cme, io, parse, other).")
+	for _, key := range keys {
+		errs := m.messages[key].errors
+		classes := make([]string, 0, len(errs))
+		for class := range errs {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+
+		for _, class := range classes {
+			sample(w, "errors_total", labels(key, "class", class), float64(errs[class]))
+		}
+	}
+
+	header(w, "last_success_timestamp_seconds", "gauge", "Unix time of the last successful request, by requester and message type.")
+	for _, key := range keys {
+		if last := m.messages[key].lastSuccess; !last.IsZero() {
+			sample(w, "last_success_timestamp_seconds", labels(key), float64(last.UnixNano())/1e9)
+		}
+	}
+
+	header(w, "response_duration_seconds", "histogram", "Response time of successful requests, by requester and message type.")
+	for _, key := range keys {
+		writeHistogram(w, "response_duration_seconds", []string{"requester", key.requester, "message_type", key.messageType}, m.messages[key].durations)
+	}
+
+	header(w, "buffer_entries", "gauge", "Entries waiting in the log buffer.")
+	sample(w, "buffer_entries", "", float64(stats.Buffered))
+	header(w, "buffer_capacity_entries", "gauge", "Entries buffered before a flush is forced.")
+	sample(w, "buffer_capacity_entries", "", float64(stats.Capacity))
+
+	header(w, "written_entries_total", "counter", "Entries taken by the writer.")
+	sample(w, "written_entries_total", "", float64(stats.Written))
+	header(w, "dropped_entries_total", "counter", "Entries lost after failed flushes.")
+	sample(w, "dropped_entries_total", "", float64(stats.Dropped))
+	header(w, "backpressure", "gauge", "1 while entries of failed flushes are kept for the next flush.")
+	sample(w, "backpressure", "", boolValue(stats.Backpressure))
+	header(w, "written_bytes_total", "counter", "Bytes written to output files, before compression.")
+	sample(w, "written_bytes_total", "", float64(stats.Bytes))
+	header(w, "flush_errors_total", "counter", "Flushes the writer failed.")
+	sample(w, "flush_errors_total", "", float64(m.failed))
+	header(w, "flush_duration_seconds", "histogram", "Time taken to write a batch of entries.")
+	writeHistogram(w, "flush_duration_seconds", nil, m.flushes)
+
+	header(w, "requester_reconnects_total", "counter", "Times a requester re-established its link.")
+	for _, name := range m.processor.GetRequesterNames() {
+		requester, _ := m.processor.GetRequester(name)
+		if reconnector, ok := requester.(cellularlog.Reconnector); ok {
+			sample(w, "requester_reconnects_total", formatLabels("requester", name), float64(reconnector.Reconnects()))
+		}
+	}
+
+	m.writeCells(w)
+}
+
+func (m *metrics) writeCells(w io.Writer) {
+	requesters := make([]string, 0, len(m.cells))
+	for name := range m.cells {
+		requesters = append(requesters, name)
+	}
+	sort.Strings(requesters)
+
+	gauges := []struct {
+		name, help string
+		value      func(cellMetrics) *int
+	}{
+		{"cell_rsrp_dbm", "RSRP of the serving cell (+QENG).", func(c cellMetrics) *int { return servingValue(c, func(s *AT.ServingCell) *int { return s.RSRP }) }},
+		{"cell_rsrq_db", "RSRQ of the serving cell (+QENG).", func(c cellMetrics) *int { return servingValue(c, func(s *AT.ServingCell) *int { return s.RSRQ }) }},
+		{"cell_sinr", "SINR of the serving cell as reported by the modem (+QENG).", func(c cellMetrics) *int { return servingValue(c, func(s *AT.ServingCell) *int { return s.SINR }) }},
+		{"cell_rssi_dbm", "RSSI reported by +CSQ.", func(c cellMetrics) *int {
+			if c.signal == nil {
+				return nil
+			}
+			return c.signal.RSSI
+		}},
+	}
+
+	for _, gauge := range gauges {
+		header(w, gauge.name, "gauge", gauge.help)
+		for _, name := range requesters {
+			if v := gauge.value(m.cells[name]); v != nil {
+				sample(w, gauge.name, formatLabels("requester", name), float64(*v))
+			}
+		}
+	}
+
+	header(w, "serving_cell_info", "gauge", "The serving cell, always 1; the cell is identified by the labels.")
+	for _, name := range requesters {
+		s := m.cells[name].serving
+		if s == nil {
+			continue
+		}
+
+		sample(w, "serving_cell_info", formatLabels(
+			"requester", name,
+			"state", s.State,
+			"rat", s.RAT,
+			"mcc", strconv.Itoa(s.MCC),
+			"mnc", strconv.Itoa(s.MNC),
+			"cell_id", s.CellID,
+			"pci", strconv.Itoa(s.PCI),
+			"earfcn", strconv.Itoa(s.EARFCN),
+			"band", strconv.Itoa(s.Band),
+			"tac", s.TAC,
+		), 1)
+	}
+}
+
+func servingValue(c cellMetrics, field func(*AT.ServingCell) *int) *int {
+	if c.serving == nil {
+		return nil
+	}
+	return field(c.serving)
+}
+
+const metricPrefix = "cellular_logger_"
+
+func header(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricPrefix, name, help, metricPrefix, name, kind)
+}
+
+func sample(w io.Writer, name, labels string, value float64) {
+	fmt.Fprintf(w, "%s%s%s %s\n", metricPrefix, name, labels, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func writeHistogram(w io.Writer, name string, labels []string, h histogram) {
+	var cumulative uint64
+	for i, bound := range durationBuckets {
+		if h.counts != nil {
+			cumulative += h.counts[i]
+		}
+		sample(w, name+"_bucket", formatLabels(append(labels, "le", strconv.FormatFloat(bound, 'g', -1, 64))...), float64(cumulative))
+	}
+	sample(w, name+"_bucket", formatLabels(append(labels, "le", "+Inf")...), float64(h.count))
+	sample(w, name+"_sum", formatLabels(labels...), h.sum)
+	sample(w, name+"_count", formatLabels(labels...), float64(h.count))
+}
+
+// formatLabels renders name, value pairs as {name="value",...}.
+func formatLabels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, pairs[i], escaper.Replace(pairs[i+1]))
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
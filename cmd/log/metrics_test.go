@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/AT"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, err := loadSession(ctx, []string{"--messages=sys:lo,sys:missing0", "--polling-interval=10ms", "--writer-interval=20ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer, err := createSingleWriter(ctx, WriterConfig{Format: "json", Path: filepath.Join(t.TempDir(), "session")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	processor := cellularlog.NewProcessor(ctx, s.config.PollingInterval, s.config.WriterInterval, writer, 1000, s.messages[0].message, s.messages[1].message)
+	defer processor.Close()
+	if err := initializeRequesters(ctx, processor, s.requesters); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMetrics(processor)
+	processor.SetObserver(m)
+	processor.Start()
+
+	rsrp := -101
+	m.ObserveEntry(&recorded{requester: "modem", messageType: "at-+QENG"}, cellularlog.LogEntry{
+		Success: true,
+		Data:    &AT.ServingCell{State: "NOCONN", RAT: "LTE", MCC: 262, MNC: 2, CellID: "ABC", PCI: 7, RSRP: &rsrp},
+	})
+
+	time.Sleep(200 * time.Millisecond)
+
+	server := httptest.NewServer(m)
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	text := string(body)
+
+	for _, want := range []string{
+		`cellular_logger_requests_total{requester="sys",message_type="sys-lo"} `,
+		`cellular_logger_errors_total{requester="sys",message_type="sys-missing0",class="other"} `,
+		`cellular_logger_response_duration_seconds_bucket{requester="sys",message_type="sys-lo",le="+Inf"} `,
+		`cellular_logger_buffer_capacity_entries 1000`,
+		`cellular_logger_flush_duration_seconds_count `,
+		`cellular_logger_cell_rsrp_dbm{requester="modem"} -101`,
+		`cellular_logger_serving_cell_info{requester="modem",state="NOCONN",rat="LTE",mcc="262",mnc="2",cell_id="ABC",pci="7",earfcn="0",band="0",tac=""} 1`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("metrics are missing %q", want)
+		}
+	}
+	if strings.Contains(text, "cellular_logger_written_bytes_total 0\n") {
+		t.Errorf("no bytes counted as written:\n%s", text)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRuleValidationErrorsNameTheKey(t *testing.T) {
+	path := writeConfig(t, `
+messages:
+  - {source: sys, name: wwan0}
+rules:
+  - {name: a, when: {message: "sys:wwan0", field: rx_bytes}, then: {messages: ["sys:wwan0"], interval: 1s}}
+  - {name: b, when: {message: "sys:eth0", field: rx_bytes, above: 1}, then: {messages: ["sys:wwan0"], interval: 1s}}
+  - {name: c, when: {message: "sys:wwan0", field: rx_bytes, changed: true}, then: {messages: ["sys:wwan0"], interval: 1s}}
+  - {name: d, when: {message: "sys:wwan0", field: rx_bytes, above: 1}, then: {requester: modem, pause: true}}
+  - {name: e, when: {message: "sys:wwan0", field: rx_bytes, above: 1, below: 2}, then: {messages: ["sys:wwan0"]}}
+`)
+
+	_, err := loadSession(context.Background(), []string{"--config", path})
+	for _, key := range []string{"rules[0].when:", "rules[1].when.message", "rules[2].for", "rules[3].then.requester", "rules[4].when: only one"} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("expected an error for %s, got: %v", key, err)
+		}
+	}
+}
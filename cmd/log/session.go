@@ -0,0 +1,625 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/internal/multierr"
+	"github.com/harshabose/cellular_localisation_logging/pkg/chain"
+	"github.com/harshabose/cellular_localisation_logging/pkg/envelope"
+	"github.com/harshabose/cellular_localisation_logging/pkg/redact"
+)
+
+// session is what run starts: the settings merged from the config file and the flags, and the
+// requesters, messages and writers to create.
+type session struct {
+	config     *Config
+	requesters []requesterSpec
+	messages   []messageSpec
+	rules      []cellularlog.Rule
+	writers    []WriterConfig
+	upload     *UploadConfig    // nil unless segments are uploaded
+	redactor   *redact.Redactor // nil unless entries are redacted
+}
+
+type requesterSpec struct {
+	name   string // what messages and --position-ref refer to
+	prefix string // source type
+	config *Config
+}
+
+type messageSpec struct {
+	message cellularlog.Message
+	options cellularlog.MessageOptions
+}
+
+// loadSession parses args and, with --config, the config file. Every value is resolved in the order
+// flag default, config file, flag given in args. Validation errors of the file name the offending key.
+func loadSession(ctx context.Context, args []string) (*session, error) {
+	config := &Config{}
+	flags := newFlagSet(config)
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	file := &FileConfig{}
+	if config.ConfigFile != "" {
+		var err error
+		if file, err = loadFileConfig(config.ConfigFile); err != nil {
+			return nil, err
+		}
+
+		// Start over from the flag defaults so that only flags given in args override the file.
+		configFile := config.ConfigFile
+		config = &Config{}
+		flags := silence(newFlagSet(config))
+		file.applyGlobals(config)
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		config.ConfigFile = configFile
+	}
+
+	s := &session{config: config}
+	if config.ListMessages {
+		return s, nil
+	}
+
+	messages := file.Messages
+	if config.Messages != "" {
+		var err error
+		if messages, err = parseMessages(config.Messages); err != nil {
+			return nil, fmt.Errorf("failed to parse messages: %w", err)
+		}
+	}
+
+	writers := file.Writers
+	if len(writers) == 0 || set["output"] || set["file"] {
+		writers = writersFromFlags(config)
+	}
+
+	var err error
+	s.requesters, err = buildRequesters(file.Requesters, messages, config.PositionReference, args)
+	if err == nil {
+		s.messages, err = buildMessages(ctx, messages, s.requesters)
+	}
+	if err == nil {
+		s.rules, err = buildRules(file.Rules, messages, s.messages, s.requesters)
+	}
+	if err == nil {
+		s.writers, err = validateWriters(writers, config)
+	}
+	if err == nil && config.WALDir != "" {
+		switch cellularlog.SyncPolicy(config.WALSync) {
+		case cellularlog.SyncAlways, cellularlog.SyncInterval, cellularlog.SyncNever:
+		default:
+			err = fmt.Errorf("wal.sync: unsupported policy %s (supported: always, interval, never)", config.WALSync)
+		}
+	}
+	if err == nil && config.UploadURL != "" {
+		s.upload, err = resolveUpload(file.Upload, config, s.writers)
+	}
+	if err == nil && (config.Redact != "" || config.RedactGrid != 0) {
+		s.redactor, err = newRedactor(config)
+	}
+	if err != nil {
+		if config.ConfigFile != "" {
+			return nil, fmt.Errorf("%s: %w", config.ConfigFile, err)
+		}
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// parseMessages turns a "prefix:name,prefix:name" list into message configs.
+func parseMessages(messageStr string) ([]MessageConfig, error) {
+	var messages []MessageConfig
+
+	for _, part := range strings.Split(messageStr, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		colonIndex := strings.Index(part, ":")
+		if colonIndex == -1 {
+			return nil, fmt.Errorf("invalid message format: %s (expected type:name)", part)
+		}
+
+		messages = append(messages, MessageConfig{
+			Source: strings.TrimSpace(part[:colonIndex]),
+			Name:   strings.TrimSpace(part[colonIndex+1:]),
+		})
+	}
+
+	return messages, nil
+}
+
+func writersFromFlags(config *Config) []WriterConfig {
+	var writers []WriterConfig
+	for _, format := range strings.Split(config.OutputFormat, ",") {
+		writers = append(writers, WriterConfig{Format: strings.TrimSpace(format), Path: config.OutputFile})
+	}
+
+	return writers
+}
+
+// buildRequesters resolves the declared requesters, plus one per undeclared source prefix used by a
+// message or as the position reference. Each gets its own Config: flag defaults, then its entry in
+// the file, then the flags given in args.
+func buildRequesters(declared map[string]RequesterConfig, messages []MessageConfig, positionRef string, args []string) ([]requesterSpec, error) {
+	entries := make(map[string]RequesterConfig, len(declared))
+	for name, entry := range declared {
+		entries[name] = entry
+	}
+
+	var err error
+	for i, message := range messages {
+		if _, ok := entries[message.Source]; ok {
+			continue
+		}
+		if _, ok := sources[message.Source]; !ok {
+			err = multierr.Append(err, fmt.Errorf("messages[%d].source: unknown requester or message type '%s' (supported: %s)", i, message.Source, strings.Join(sourcePrefixes(), ", ")))
+			continue
+		}
+		entries[message.Source] = RequesterConfig{}
+	}
+	if _, ok := entries[positionRef]; !ok && positionRef != "" {
+		if _, ok := sources[positionRef]; ok {
+			entries[positionRef] = RequesterConfig{}
+		} else {
+			err = multierr.Append(err, fmt.Errorf("fusion.position_reference: unknown requester '%s'", positionRef))
+		}
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	specs := make([]requesterSpec, 0, len(names))
+	for _, name := range names {
+		entry := entries[name]
+
+		prefix := entry.Type
+		if prefix == "" {
+			prefix = name
+		}
+
+		source, ok := sources[prefix]
+		if !ok {
+			key := "type"
+			if entry.Type == "" {
+				key = "" // the name doubles as the type
+			}
+			err = multierr.Append(err, fmt.Errorf("%s: unknown source type '%s' (supported: %s)", requesterKey(name, key), prefix, strings.Join(sourcePrefixes(), ", ")))
+			continue
+		}
+
+		var unsupported bool
+		for _, key := range entry.keys() {
+			if !slices.Contains(source.RequesterKeys, key) {
+				err = multierr.Append(err, fmt.Errorf("%s: not supported by %s requesters", requesterKey(name, key), prefix))
+				unsupported = true
+			}
+		}
+		if unsupported {
+			continue
+		}
+
+		config := &Config{}
+		flags := silence(newFlagSet(config))
+		if source.Configure != nil {
+			source.Configure(config, entry)
+		}
+		if e := flags.Parse(args); e != nil {
+			return nil, e
+		}
+
+		specs = append(specs, requesterSpec{name: name, prefix: prefix, config: config})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return specs, nil
+}
+
+func requesterKey(name, key string) string {
+	if key == "" {
+		return fmt.Sprintf("requesters.%s", name)
+	}
+	return fmt.Sprintf("requesters.%s.%s", name, key)
+}
+
+func buildMessages(ctx context.Context, configs []MessageConfig, requesters []requesterSpec) ([]messageSpec, error) {
+	prefixes := make(map[string]string, len(requesters))
+	for _, r := range requesters {
+		prefixes[r.name] = r.prefix
+	}
+
+	var err error
+	messages := make([]messageSpec, 0, len(configs))
+	for i, c := range configs {
+		message, e := newMessage(ctx, c, prefixes[c.Source])
+		if e != nil {
+			err = multierr.Append(err, fmt.Errorf("messages[%d].%w", i, e))
+			continue
+		}
+
+		messages = append(messages, messageSpec{
+			message: message,
+			options: cellularlog.MessageOptions{
+				Interval: time.Duration(c.Interval),
+				Tags:     c.Tags,
+				Priority: c.Priority,
+			},
+		})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// buildRules resolves the messages and requesters the rules refer to. configs and specs are the
+// messages of the session, in the same order.
+func buildRules(rules []RuleConfig, configs []MessageConfig, specs []messageSpec, requesters []requesterSpec) ([]cellularlog.Rule, error) {
+	messages := make(map[string]cellularlog.Message, len(configs))
+	for i, c := range configs {
+		messages[c.Source+":"+c.Name] = specs[i].message
+	}
+	resolve := func(ref string) (cellularlog.Message, error) {
+		message, ok := messages[ref]
+		if !ok {
+			return nil, fmt.Errorf("no message '%s' in the session (expected source:name)", ref)
+		}
+		return message, nil
+	}
+
+	var err error
+	names := make(map[string]bool, len(rules))
+	built := make([]cellularlog.Rule, 0, len(rules))
+	for i, c := range rules {
+		rule, e := newRule(c, resolve, requesters)
+		if e == nil && names[rule.Name] {
+			e = fmt.Errorf("name: '%s' is used by an earlier rule", rule.Name)
+		}
+		if e != nil {
+			err = multierr.Append(err, fmt.Errorf("rules[%d].%w", i, e))
+			continue
+		}
+		names[rule.Name] = true
+		built = append(built, rule)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return built, nil
+}
+
+// newRule creates the rule described by c. Errors start with the offending key.
+func newRule(c RuleConfig, resolve func(string) (cellularlog.Message, error), requesters []requesterSpec) (cellularlog.Rule, error) {
+	rule := cellularlog.Rule{Name: c.Name, For: time.Duration(c.For)}
+	if c.Name == "" {
+		return rule, fmt.Errorf("name: required")
+	}
+	if c.For < 0 {
+		return rule, fmt.Errorf("for: must not be negative")
+	}
+
+	var err error
+	if rule.When.Message, err = resolve(c.When.Message); err != nil {
+		return rule, fmt.Errorf("when.message: %w", err)
+	}
+	rule.When.Field = c.When.Field
+	if c.When.Field == "" {
+		return rule, fmt.Errorf("when.field: required")
+	}
+
+	var ops []string
+	set := func(op string, value interface{}) {
+		ops = append(ops, op)
+		rule.When.Op, rule.When.Value = op, value
+	}
+	if c.When.Below != nil {
+		set(cellularlog.RuleBelow, *c.When.Below)
+	}
+	if c.When.Above != nil {
+		set(cellularlog.RuleAbove, *c.When.Above)
+	}
+	if c.When.Equals != nil {
+		set(cellularlog.RuleEquals, c.When.Equals)
+	}
+	if c.When.NotEquals != nil {
+		set(cellularlog.RuleNotEquals, c.When.NotEquals)
+	}
+	if c.When.Contains != "" {
+		set(cellularlog.RuleContains, c.When.Contains)
+	}
+	if c.When.Excludes != "" {
+		set(cellularlog.RuleExcludes, c.When.Excludes)
+	}
+	if c.When.Changed {
+		set(cellularlog.RuleChanged, nil)
+	}
+	switch {
+	case len(ops) == 0:
+		return rule, fmt.Errorf("when: one of below, above, equals, not_equals, contains, excludes or changed is required")
+	case len(ops) > 1:
+		return rule, fmt.Errorf("when: only one comparison is allowed, got %s", strings.Join(ops, ", "))
+	case rule.When.Op == cellularlog.RuleChanged && rule.For == 0:
+		return rule, fmt.Errorf("for: required with changed, which holds for a single entry")
+	}
+
+	for j, ref := range c.Then.Messages {
+		message, err := resolve(ref)
+		if err != nil {
+			return rule, fmt.Errorf("then.messages[%d]: %w", j, err)
+		}
+		rule.Then.Messages = append(rule.Then.Messages, message)
+	}
+	rule.Then.Requester = c.Then.Requester
+	if c.Then.Requester != "" {
+		if !slices.ContainsFunc(requesters, func(r requesterSpec) bool { return r.name == c.Then.Requester }) {
+			return rule, fmt.Errorf("then.requester: unknown requester '%s'", c.Then.Requester)
+		}
+	}
+	if len(rule.Then.Messages) == 0 && rule.Then.Requester == "" {
+		return rule, fmt.Errorf("then: messages or requester required")
+	}
+
+	rule.Then.Interval, rule.Then.Pause = time.Duration(c.Then.Interval), c.Then.Pause
+	switch {
+	case c.Then.Interval < 0:
+		return rule, fmt.Errorf("then.interval: must not be negative")
+	case c.Then.Interval == 0 && !c.Then.Pause:
+		return rule, fmt.Errorf("then: interval or pause required")
+	case c.Then.Interval > 0 && c.Then.Pause:
+		return rule, fmt.Errorf("then: interval and pause are exclusive")
+	}
+
+	return rule, nil
+}
+
+// newMessage creates the message described by c for a requester of the given source prefix. Errors
+// start with the offending key.
+func newMessage(ctx context.Context, c MessageConfig, prefix string) (cellularlog.Message, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("name: required")
+	}
+	if c.Interval < 0 {
+		return nil, fmt.Errorf("interval: must not be negative")
+	}
+
+	message, err := sources[prefix].NewMessage(ctx, c.Name)
+	if err != nil {
+		return nil, fmt.Errorf("name: failed to create %s message %s: %w", prefix, c.Name, err)
+	}
+
+	if c.Source != prefix {
+		setter, ok := message.(interface{ SetRequester(string) })
+		if !ok {
+			return nil, fmt.Errorf("source: %s messages cannot use a requester named '%s'", prefix, c.Source)
+		}
+		setter.SetRequester(c.Source)
+	}
+
+	if c.Parser != "" {
+		parsed, ok := message.(interface{ SetParser(string) error })
+		if !ok {
+			return nil, fmt.Errorf("parser: %s messages have no parsers", prefix)
+		}
+		if err := parsed.SetParser(c.Parser); err != nil {
+			return nil, fmt.Errorf("parser: %w", err)
+		}
+	}
+
+	return message, nil
+}
+
+// resolveUpload fills in the upload settings the file left to the flags. Without patterns, every
+// segment of the file writers is uploaded, including rotated and compressed ones.
+func resolveUpload(upload UploadConfig, config *Config, writers []WriterConfig) (*UploadConfig, error) {
+	upload.URL = config.UploadURL
+	upload.State = config.UploadState
+	upload.Rate = ByteSize(config.UploadRate)
+
+	if len(upload.Patterns) == 0 {
+		for _, w := range writers {
+			if ext, ok := writerExtensions[w.Format]; ok && w.Influx.URL == "" {
+				prefix := strings.ReplaceAll(w.Path, "{time}", "*")
+				upload.Patterns = append(upload.Patterns, prefix+"*"+ext+"*")
+			}
+		}
+	}
+
+	var err error
+	if _, e := url.ParseRequestURI(upload.URL); e != nil {
+		err = multierr.Append(err, fmt.Errorf("upload.url: invalid URL %q", upload.URL))
+	}
+	if len(upload.Patterns) == 0 {
+		err = multierr.Append(err, errors.New("upload.patterns: required without file writers"))
+	}
+	for _, pattern := range upload.Patterns {
+		if _, e := filepath.Match(pattern, ""); e != nil {
+			err = multierr.Append(err, fmt.Errorf("upload.patterns: invalid pattern %s", pattern))
+		}
+	}
+	if upload.Rate < 0 || upload.ChunkSize < 0 {
+		err = multierr.Append(err, errors.New("upload: rate and chunk_size must not be negative"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &upload, nil
+}
+
+var writerExtensions = map[string]string{
+	"json":   ".json",
+	"csv":    ".csv",
+	"binary": ".bin",
+	"influx": ".lp",
+}
+
+// newRedactor creates the redactor of the --redact flags.
+func newRedactor(config *Config) (*redact.Redactor, error) {
+	policy := redact.Policy{Mode: redact.Mode(config.RedactMode), Grid: config.RedactGrid}
+	for _, identifier := range strings.Split(config.Redact, ",") {
+		if identifier = strings.TrimSpace(identifier); identifier != "" {
+			policy.Identifiers = append(policy.Identifiers, identifier)
+		}
+	}
+
+	if config.RedactKeyFile != "" {
+		key, err := os.ReadFile(config.RedactKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("redact.key_file: %w", err)
+		}
+		policy.Key = bytes.TrimSpace(key)
+	}
+
+	redactor, err := redact.NewRedactor(policy)
+	if err != nil {
+		return nil, fmt.Errorf("redact: %w", err)
+	}
+	return redactor, nil
+}
+
+// validateWriters checks writers and fills in the mqtt and influx settings the file left to the flags.
+func validateWriters(writers []WriterConfig, config *Config) ([]WriterConfig, error) {
+	var err error
+	for i, w := range writers {
+		if w.OnError.Retries < 0 || w.OnError.Backoff < 0 || w.OnError.MaxBackoff < 0 || w.OnError.SpillMaxSize < 0 {
+			err = multierr.Append(err, fmt.Errorf("writers[%d].on_error: values must not be negative", i))
+		}
+
+		if w.Format == "mqtt" {
+			writers[i].MQTT = w.MQTT.withFlags(config)
+			err = multierr.Append(err, validateMQTT(i, writers[i]))
+			continue
+		}
+		if w.Format == "influx" {
+			writers[i].Influx = w.Influx.withFlags(config)
+			if writers[i].Influx.URL != "" {
+				err = multierr.Append(err, validateInfluxHTTP(i, writers[i]))
+				continue
+			}
+		}
+
+		if _, ok := writerExtensions[w.Format]; !ok {
+			err = multierr.Append(err, fmt.Errorf("writers[%d].format: unsupported output format: %s (supported: binary, csv, influx, json, mqtt)", i, w.Format))
+		}
+		if w.Path == "" {
+			err = multierr.Append(err, fmt.Errorf("writers[%d].path: required", i))
+		}
+		switch w.Compression {
+		case "", "none", "gzip":
+		default:
+			err = multierr.Append(err, fmt.Errorf("writers[%d].compression: unsupported compression: %s (supported: none, gzip)", i, w.Compression))
+		}
+		if w.Rotation.MaxSize < 0 || w.Rotation.MaxAge < 0 {
+			err = multierr.Append(err, fmt.Errorf("writers[%d].rotation: limits must not be negative", i))
+		}
+
+		setString(&writers[i].Encryption.PublicKey, config.EncryptKey)
+		if key := writers[i].Encryption.PublicKey; key != "" {
+			recipient, e := envelope.LoadPublicKey(key)
+			if e != nil {
+				err = multierr.Append(err, fmt.Errorf("writers[%d].encryption.public_key: %w", i, e))
+			}
+			writers[i].recipient = recipient
+			if w.Compression == "gzip" {
+				err = multierr.Append(err, fmt.Errorf("writers[%d]: compression does not apply to encrypted files", i))
+			}
+		}
+
+		setString(&writers[i].Signing.Key, config.SignKey)
+		if key := writers[i].Signing.Key; key != "" {
+			signer, e := chain.LoadPrivateKey(key)
+			if e != nil {
+				err = multierr.Append(err, fmt.Errorf("writers[%d].signing.key: %w", i, e))
+			}
+			writers[i].signer = signer
+		}
+	}
+
+	return writers, err
+}
+
+func validateMQTT(i int, w WriterConfig) error {
+	var err error
+	if w.MQTT.Broker == "" {
+		err = multierr.Append(err, fmt.Errorf("writers[%d].mqtt.broker: required (or --mqtt-broker)", i))
+	}
+	if qos := *w.MQTT.QoS; qos < 0 || qos > 2 {
+		err = multierr.Append(err, fmt.Errorf("writers[%d].mqtt.qos: must be 0, 1 or 2", i))
+	}
+	if w.MQTT.Timeout < 0 || w.MQTT.QueueMaxSize < 0 {
+		err = multierr.Append(err, fmt.Errorf("writers[%d].mqtt: timeout and queue_max_size must not be negative", i))
+	}
+	if w.Rotation != (RotationConfig{}) || (w.Compression != "" && w.Compression != "none") || w.Encryption != (EncryptionConfig{}) || w.Signing != (SigningConfig{}) {
+		err = multierr.Append(err, fmt.Errorf("writers[%d]: rotation, compression, encryption and signing do not apply to mqtt", i))
+	}
+
+	return err
+}
+
+func validateInfluxHTTP(i int, w WriterConfig) error {
+	var err error
+	if _, e := url.ParseRequestURI(w.Influx.URL); e != nil {
+		err = multierr.Append(err, fmt.Errorf("writers[%d].influx.url: invalid URL %q", i, w.Influx.URL))
+	}
+	if w.Influx.BatchSize < 0 || w.Influx.Timeout < 0 {
+		err = multierr.Append(err, fmt.Errorf("writers[%d].influx: batch_size and timeout must not be negative", i))
+	}
+	if w.Rotation != (RotationConfig{}) || (w.Compression != "" && w.Compression != "none") || w.Encryption != (EncryptionConfig{}) || w.Signing != (SigningConfig{}) {
+		err = multierr.Append(err, fmt.Errorf("writers[%d]: rotation, compression, encryption and signing do not apply to influx writers with a url", i))
+	}
+
+	return err
+}
+
+func initializeRequesters(ctx context.Context, processor *cellularlog.Processor, requesters []requesterSpec) error {
+	for _, spec := range requesters {
+		requester, err := sources[spec.prefix].NewRequester(ctx, spec.config)
+		if err != nil {
+			return fmt.Errorf("failed to initialize %s: %w", spec.name, err)
+		}
+
+		if err := processor.RegisterRequester(spec.name, requester); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// silence keeps re-parses of args from printing usage a second time.
+func silence(flags *flag.FlagSet) *flag.FlagSet {
+	flags.SetOutput(io.Discard)
+	return flags
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/AT"
+)
+
+func init() {
+	registerSource(AT.RequesterName, &Source{
+		Description:  "AT Commands",
+		Examples:     []string{"I", "+GCAP", "+CNMI=?", "+CREG?", "+CSQ", "+CPIN?"},
+		Hint:         "Any valid AT command; parsers for the config file: csq, creg, cops, qeng, cclk",
+		NewRequester: newATRequester,
+		NewMessage: func(_ context.Context, name string) (cellularlog.Message, error) {
+			return AT.NewMessage(name), nil
+		},
+
+		RequesterKeys: []string{"device", "baud", "timeout", "gnss"},
+		Configure: func(config *Config, r RequesterConfig) {
+			setString(&config.ATDevice, r.Device)
+			setInt(&config.ATBaud, r.Baud)
+			setDuration(&config.ATTimeout, r.Timeout)
+			if r.GNSS != nil {
+				config.ATGNSS = *r.GNSS
+			}
+		},
+		Device: func(config *Config) string { return config.ATDevice },
+	})
+}
+
+func newATRequester(_ context.Context, config *Config) (cellularlog.Requester, error) {
+	at, err := AT.NewAT(config.ATDevice, config.ATBaud, config.ATTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ATGNSS {
+		// Not fatal: the engine may already be running, which some modems report as an error.
+		if err := at.EnableGNSS(AT.QuectelGNSSEnable, AT.QuectelGNSSDisable); err != nil {
+			fmt.Printf("warning: %v\n", err)
+		}
+	}
+
+	return at, nil
+}
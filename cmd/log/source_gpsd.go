@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/gpsd"
+)
+
+func init() {
+	registerSource(gpsd.RequesterName, &Source{
+		Description: "gpsd Reports",
+		Examples:    gpsd.SupportedReports,
+		NewRequester: func(ctx context.Context, config *Config) (cellularlog.Requester, error) {
+			return gpsd.NewGPSD(ctx, config.GPSDAddress, config.GPSDTimeout)
+		},
+		NewMessage: func(_ context.Context, name string) (cellularlog.Message, error) {
+			return gpsd.NewMessage(name)
+		},
+
+		RequesterKeys: []string{"address", "timeout"},
+		Configure: func(config *Config, r RequesterConfig) {
+			setString(&config.GPSDAddress, r.Address)
+			setDuration(&config.GPSDTimeout, r.Timeout)
+		},
+		Device: func(config *Config) string { return config.GPSDAddress },
+	})
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/bluenviron/gomavlib/v3"
+	"github.com/bluenviron/gomavlib/v3/pkg/dialects/all"
+	"github.com/bluenviron/gomavlib/v3/pkg/dialects/ardupilotmega"
+	"github.com/bluenviron/gomavlib/v3/pkg/dialects/common"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/mavlink"
+)
+
+func init() {
+	names := make([]string, 0, len(mavlinkRegistry))
+	for name := range mavlinkRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	registerSource(mavlink.RequesterName, &Source{
+		Description:  "MAVLink Messages",
+		Examples:     names,
+		NewRequester: newMAVLinkRequester,
+		NewMessage:   createMAVLinkMessage,
+
+		RequesterKeys: []string{"device", "baud", "timeout", "signing_key", "signing"},
+		Configure: func(config *Config, r RequesterConfig) {
+			setString(&config.MAVDevice, r.Device)
+			setInt(&config.MAVBaud, r.Baud)
+			setDuration(&config.MAVTimeout, r.Timeout)
+			setString(&config.MAVSigningKey, r.SigningKey)
+			setString(&config.MAVSigning, r.Signing)
+		},
+		Device: func(config *Config) string { return config.MAVDevice },
+	})
+}
+
+var mavlinkRegistry = map[string]func(context.Context) cellularlog.Message{
+	// IMU Messages (for inertial navigation)
+	"SCALED_IMU": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*ardupilotmega.MessageScaledImu](ctx)
+	},
+	"SCALED_IMU2": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*ardupilotmega.MessageScaledImu2](ctx)
+	},
+	"SCALED_IMU3": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*ardupilotmega.MessageScaledImu3](ctx)
+	},
+	"RAW_IMU": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*ardupilotmega.MessageRawImu](ctx)
+	},
+
+	// GPS Messages (for satellite-based positioning)
+	"GPS_RAW_INT": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*common.MessageGpsRawInt](ctx)
+	},
+	"GPS2_RAW": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*ardupilotmega.MessageGps2Raw](ctx)
+	},
+	"GPS_STATUS": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*common.MessageGpsStatus](ctx)
+	},
+
+	// Position Messages (for fused position estimates)
+	"GLOBAL_POSITION_INT": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*common.MessageGlobalPositionInt](ctx)
+	},
+	"LOCAL_POSITION_NED": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*common.MessageLocalPositionNed](ctx)
+	},
+
+	// Attitude and Orientation (for complete pose estimation)
+	"ATTITUDE": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*common.MessageAttitude](ctx)
+	},
+	"ATTITUDE_QUATERNION": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*common.MessageAttitudeQuaternion](ctx)
+	},
+
+	// Magnetometer Messages (for compass/heading data)
+	"SCALED_PRESSURE": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*common.MessageScaledPressure](ctx)
+	},
+	"MAG_CAL_REPORT": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*ardupilotmega.MessageMagCalReport](ctx)
+	},
+
+	// Navigation and Control Messages
+	"NAV_CONTROLLER_OUTPUT": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*common.MessageNavControllerOutput](ctx)
+	},
+	"POSITION_TARGET_GLOBAL_INT": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*common.MessagePositionTargetGlobalInt](ctx)
+	},
+	"POSITION_TARGET_LOCAL_NED": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*common.MessagePositionTargetLocalNed](ctx)
+	},
+
+	// System Status (for understanding system state)
+	"SYS_STATUS": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*common.MessageSysStatus](ctx)
+	},
+	"HEARTBEAT": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*common.MessageHeartbeat](ctx)
+	},
+
+	// EKF/Filter Status (for understanding fusion quality)
+	"EKF_STATUS_REPORT": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*ardupilotmega.MessageEkfStatusReport](ctx)
+	},
+	"AHRS": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*ardupilotmega.MessageAhrs](ctx)
+	},
+	"AHRS2": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*ardupilotmega.MessageAhrs2](ctx)
+	},
+
+	// High-rate position data
+	"HIGH_LATENCY": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*common.MessageHighLatency](ctx)
+	},
+	"HIGH_LATENCY2": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*common.MessageHighLatency2](ctx)
+	},
+
+	// Optical Flow (if available - for visual positioning)
+	"OPTICAL_FLOW": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*common.MessageOpticalFlow](ctx)
+	},
+	"OPTICAL_FLOW_RAD": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*common.MessageOpticalFlowRad](ctx)
+	},
+
+	// Velocity and acceleration
+	"LOCAL_POSITION_NED_SYSTEM_GLOBAL_OFFSET": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*common.MessageLocalPositionNedSystemGlobalOffset](ctx)
+	},
+
+	// Clock synchronisation (for the sample time of every other message)
+	"TIMESYNC": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewTimeSync(ctx)
+	},
+	"SYSTEM_TIME": func(ctx context.Context) cellularlog.Message {
+		return mavlink.NewMessage[*common.MessageSystemTime](ctx)
+	},
+}
+
+// mavSigningKeyEnv holds the signing key itself when --mav-signing-key is not given, so that it
+// need not be stored on disk.
+const mavSigningKeyEnv = "MAVLINK_SIGNING_KEY"
+
+func newMAVLinkRequester(_ context.Context, config *Config) (cellularlog.Requester, error) {
+	key, err := mavSigningKey(config)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		if config.MAVSigning == string(mavlink.SigningRequire) {
+			return nil, fmt.Errorf("--mav-signing=require needs a key (--mav-signing-key or $%s)", mavSigningKeyEnv)
+		}
+
+		return mavlink.NewMavlink(
+			config.MAVDevice,
+			config.MAVBaud,
+			config.MAVTimeout,
+			all.Dialect,
+			gomavlib.V2,
+		)
+	}
+
+	return mavlink.NewSignedMavlink(
+		[]gomavlib.EndpointConf{gomavlib.EndpointSerial{Device: config.MAVDevice, Baud: config.MAVBaud}},
+		config.MAVTimeout,
+		all.Dialect,
+		mavlink.Signing{Key: key, Mode: mavlink.SigningMode(config.MAVSigning)},
+	)
+}
+
+// mavSigningKey returns the key of --mav-signing-key, or of the environment, or nil.
+func mavSigningKey(config *Config) ([]byte, error) {
+	if config.MAVSigningKey != "" {
+		return mavlink.LoadSigningKey(config.MAVSigningKey)
+	}
+	if env := os.Getenv(mavSigningKeyEnv); env != "" {
+		key, err := mavlink.ParseSigningKey(env)
+		if err != nil {
+			return nil, fmt.Errorf("$%s: %w", mavSigningKeyEnv, err)
+		}
+		return key, nil
+	}
+	return nil, nil
+}
+
+func createMAVLinkMessage(ctx context.Context, name string) (cellularlog.Message, error) {
+	if factory, exists := mavlinkRegistry[name]; exists {
+		return factory(ctx), nil
+	}
+
+	if id, err := strconv.Atoi(name); err == nil {
+		return mavlink.CreateMAVLinkMessageByID(uint32(id), ctx)
+	}
+
+	return nil, fmt.Errorf("unknown MAVLink message: %s", name)
+}
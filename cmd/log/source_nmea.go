@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/nmea"
+)
+
+func init() {
+	registerSource(nmea.RequesterName, &Source{
+		Description: "NMEA GNSS Sentences",
+		Examples:    nmea.SupportedSentences,
+		Hint:        "Any talker (GP, GL, GA, GB, GN) is accepted",
+		NewRequester: func(_ context.Context, config *Config) (cellularlog.Requester, error) {
+			return nmea.NewNMEA(config.NMEADevice, config.NMEABaud, config.NMEATimeout)
+		},
+		NewMessage: func(_ context.Context, name string) (cellularlog.Message, error) {
+			return nmea.NewMessage(name)
+		},
+
+		RequesterKeys: []string{"device", "baud", "timeout"},
+		Configure: func(config *Config, r RequesterConfig) {
+			setString(&config.NMEADevice, r.Device)
+			setInt(&config.NMEABaud, r.Baud)
+			setDuration(&config.NMEATimeout, r.Timeout)
+		},
+		Device: func(config *Config) string { return config.NMEADevice },
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/probe"
+)
+
+func init() {
+	registerSource(probe.RequesterName, &Source{
+		Description: "Active Network Probes",
+		Examples:    probe.SupportedProbes,
+		Hint:        "Needs --probe-address; throughput runs at most once a minute unless given an interval",
+		NewRequester: func(_ context.Context, config *Config) (cellularlog.Requester, error) {
+			return probe.NewProbe(probe.Config{
+				Address:     config.ProbeAddress,
+				Interface:   config.ProbeInterface,
+				Timeout:     config.ProbeTimeout,
+				UDPCount:    config.ProbeUDPCount,
+				UDPInterval: config.ProbeUDPInterval,
+				UDPSize:     config.ProbeUDPSize,
+				BurstSize:   config.ProbeBurstSize,
+			})
+		},
+		NewMessage: func(ctx context.Context, name string) (cellularlog.Message, error) {
+			return probe.NewMessage(ctx, name, 0)
+		},
+
+		RequesterKeys: []string{"address", "interface", "timeout", "udp_count", "udp_interval", "udp_size", "burst_size"},
+		Configure: func(config *Config, r RequesterConfig) {
+			setString(&config.ProbeAddress, r.Address)
+			setString(&config.ProbeInterface, r.Interface)
+			setDuration(&config.ProbeTimeout, r.Timeout)
+			setInt(&config.ProbeUDPCount, r.UDPCount)
+			setDuration(&config.ProbeUDPInterval, r.UDPInterval)
+			setInt(&config.ProbeUDPSize, r.UDPSize)
+			if r.BurstSize != 0 {
+				config.ProbeBurstSize = int64(r.BurstSize)
+			}
+		},
+		Device: func(config *Config) string { return config.ProbeAddress },
+	})
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/netif"
+)
+
+func init() {
+	registerSource(netif.RequesterName, &Source{
+		Description: "Network Interface Statistics",
+		Examples:    []string{"wwan0", "usb0"},
+		Hint:        "Any interface in /sys/class/net or /proc/net/dev",
+		NewRequester: func(_ context.Context, _ *Config) (cellularlog.Requester, error) {
+			return netif.NewNetIf(), nil
+		},
+		NewMessage: func(_ context.Context, name string) (cellularlog.Message, error) {
+			return netif.NewMessage(name), nil
+		},
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/harshabose/cellular_localisation_logging"
+)
+
+// Source is a data-source plugin selected by its prefix in --messages (e.g. "at:+CSQ"). Each
+// source lives in its own source_*.go file and registers itself from init, so adding one does not
+// require changes to the processor or to the other sources.
+type Source struct {
+	// Description is printed by --list.
+	Description string
+	// Examples are message names printed by --list, without the prefix.
+	Examples []string
+	// Hint is an optional note printed by --list after the examples.
+	Hint string
+
+	// NewRequester creates the requester serving this source's messages. It is only called when
+	// at least one message with the source's prefix is requested.
+	NewRequester func(ctx context.Context, config *Config) (cellularlog.Requester, error)
+	// NewMessage creates a message from the part of the --messages entry after the prefix.
+	NewMessage func(ctx context.Context, name string) (cellularlog.Message, error)
+
+	// RequesterKeys are the keys of a RequesterConfig this source reads, see Configure.
+	RequesterKeys []string
+	// Configure copies the settings of a requester declared in the config file over the flag
+	// defaults in config. Zero values in requester are left alone.
+	Configure func(config *Config, requester RequesterConfig)
+	// Device returns the serial device or address the requester connects to, for the session
+	// header. Sources without one leave it nil.
+	Device func(config *Config) string
+}
+
+var sources = map[string]*Source{}
+
+// registerSource makes a source available under prefix. The prefix is also the name its requester is
+// registered under on the processor, so messages created by NewMessage must target it.
+func registerSource(prefix string, source *Source) {
+	if _, exists := sources[prefix]; exists {
+		panic(fmt.Sprintf("source '%s' registered twice", prefix))
+	}
+
+	sources[prefix] = source
+}
+
+func sourcePrefixes() []string {
+	prefixes := make([]string, 0, len(sources))
+	for prefix := range sources {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	return prefixes
+}
+
+func setPositionReference(processor *cellularlog.Processor, name string) error {
+	if name == "" {
+		return nil
+	}
+
+	requester, exists := processor.GetRequester(name)
+	if !exists {
+		return fmt.Errorf("position reference '%s' is not in use (add one of its messages)", name)
+	}
+
+	if _, ok := requester.(cellularlog.PositionProvider); !ok {
+		return fmt.Errorf("source '%s' cannot provide positions", name)
+	}
+
+	processor.SetPositionReference(name)
+	return nil
+}
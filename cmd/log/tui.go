@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v3/pkg/dialects/common"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/AT"
+	"github.com/harshabose/cellular_localisation_logging/pkg/gpsd"
+	"github.com/harshabose/cellular_localisation_logging/pkg/nmea"
+)
+
+const (
+	dashboardRefresh = time.Second
+	recentEntries    = 50 // newest entries of a message read for its last value, cell, GNSS and link
+	consoleLines     = 5
+)
+
+// console is where the session prints while it runs: the processor, the background writers and
+// servers, and run. It is stdout until the dashboard takes it over.
+var console = &switchWriter{w: os.Stdout}
+
+// switchWriter is a writer whose destination can be changed while others write to it.
+type switchWriter struct {
+	w   io.Writer
+	mux sync.Mutex
+}
+
+func (s *switchWriter) Write(p []byte) (int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.w.Write(p)
+}
+
+// set makes w the destination and returns the previous one.
+func (s *switchWriter) set(w io.Writer) io.Writer {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	previous := s.w
+	s.w = w
+	return previous
+}
+
+// dashboard redraws a live summary of the session on the terminal for --tui. It only reads from the
+// processor, on its own goroutine, so logging carries on at the same pace while it runs. Output the
+// session prints to the console while the dashboard is up is shown in its console section instead.
+type dashboard struct {
+	processor  *cellularlog.Processor
+	requesters map[string]string // name to source prefix
+	started    time.Time
+	output     *switchWriter
+
+	terminal *os.File
+	previous io.Writer // of the output
+	console  []string
+	partial  []byte // of a line not yet ended
+	mux      sync.Mutex
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newDashboard(processor *cellularlog.Processor, requesters []requesterSpec, output *switchWriter) *dashboard {
+	d := &dashboard{
+		processor:  processor,
+		requesters: make(map[string]string, len(requesters)),
+		started:    time.Now(),
+		output:     output,
+		done:       make(chan struct{}),
+	}
+	for _, r := range requesters {
+		d.requesters[r.name] = r.prefix
+	}
+
+	return d
+}
+
+// Start takes over the terminal and the output of the session.
+func (d *dashboard) Start() {
+	d.terminal = os.Stdout
+	d.previous = d.output.set(d)
+
+	d.wg.Add(1)
+	go d.loop()
+}
+
+// Write adds what the session prints to the console section, a line at a time.
+func (d *dashboard) Write(p []byte) (int, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	d.partial = append(d.partial, p...)
+	for {
+		i := bytes.IndexByte(d.partial, '\n')
+		if i < 0 {
+			break
+		}
+		d.console = append(d.console, string(d.partial[:i]))
+		d.partial = d.partial[i+1:]
+	}
+	if len(d.console) > consoleLines {
+		d.console = d.console[len(d.console)-consoleLines:]
+	}
+
+	return len(p), nil
+}
+
+func (d *dashboard) loop() {
+	defer d.wg.Done()
+
+	fmt.Fprint(d.terminal, "\x1b[?1049h\x1b[?25l") // alternate screen, hide cursor
+	defer fmt.Fprint(d.terminal, "\x1b[?25h\x1b[?1049l")
+
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+
+	for {
+		width, height := terminalSize(d.terminal)
+
+		d.mux.Lock()
+		console := append([]string(nil), d.console...)
+		d.mux.Unlock()
+
+		lines := renderDashboard(takeSnapshot(d.processor, d.requesters, d.started, time.Now()), console, width)
+		if len(lines) > height {
+			lines = lines[:height]
+		}
+		fmt.Fprint(d.terminal, "\x1b[H"+strings.Join(lines, "\x1b[K\n")+"\x1b[K\x1b[J")
+
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop restores the terminal and the output. Lines still in the console are printed so they are not
+// lost.
+func (d *dashboard) Stop() {
+	close(d.done)
+	d.wg.Wait()
+	d.output.set(d.previous)
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	for _, line := range d.console {
+		fmt.Fprintln(d.terminal, line)
+	}
+	if len(d.partial) > 0 {
+		fmt.Fprintln(d.terminal, string(d.partial))
+	}
+}
+
+// snapshot is what one dashboard frame shows.
+type snapshot struct {
+	now      time.Time
+	uptime   time.Duration
+	messages []messageRow
+	links    []linkRow
+	cell     string
+	gnss     string
+	writer   cellularlog.WriterStats
+}
+
+type messageRow struct {
+	Type, Requester string
+	Age             time.Duration // since the last entry
+	Entries, Errors uint64
+	SuccessRate     float64
+	P50, P90, P99   time.Duration // -1 without successful entries
+	Last            string
+}
+
+type linkRow struct {
+	Name, Type, Status string
+}
+
+// latestData is the newest successful entry of a kind of data, with when it was taken.
+type latestData struct {
+	data interface{}
+	time time.Time
+}
+
+func (l *latestData) offer(data interface{}, t time.Time) {
+	if t.After(l.time) {
+		l.data, l.time = data, t
+	}
+}
+
+func takeSnapshot(processor *cellularlog.Processor, requesters map[string]string, started, now time.Time) snapshot {
+	s := snapshot{now: now, uptime: now.Sub(started), writer: processor.GetWriterStats()}
+
+	var cell, signal, gnss latestData
+	lastByRequester := make(map[string]cellularlog.LogEntry)
+	failingByRequester := make(map[string]int)
+
+	for _, message := range processor.GetMessages() {
+		row := messageRow{Type: message.GetType(), Requester: message.GetRequester(), P50: -1, P90: -1, P99: -1}
+		if stats, ok := processor.Stats().Message(row.Type); ok {
+			row.Entries, row.Errors, row.SuccessRate = stats.Requests, stats.Failures, stats.SuccessRate
+			row.Age = now.Sub(stats.LastEntry)
+			if stats.LatencyMax > 0 {
+				row.P50, row.P90, row.P99 = stats.LatencyP50, stats.LatencyP90, stats.LatencyP99
+			}
+		}
+
+		entries := recent(message, recentEntries)
+		for i := len(entries) - 1; i >= 0; i-- {
+			entry := entries[i]
+			if !entry.Success {
+				continue
+			}
+
+			switch entry.Data.(type) {
+			case *AT.ServingCell:
+				cell.offer(entry.Data, entry.RequestTime)
+			case *AT.SignalQuality:
+				signal.offer(entry.Data, entry.RequestTime)
+			case *nmea.GGA, *gpsd.TPV, *common.MessageGpsRawInt:
+				gnss.offer(entry.Data, entry.RequestTime)
+			}
+		}
+
+		if len(entries) > 0 {
+			last := entries[len(entries)-1]
+			row.Last = summarise(last)
+
+			if prev, ok := lastByRequester[row.Requester]; !ok || last.RequestTime.After(prev.RequestTime) {
+				lastByRequester[row.Requester] = last
+			}
+			for i := len(entries) - 1; i >= 0 && !entries[i].Success; i-- {
+				failingByRequester[row.Requester]++
+			}
+		}
+
+		s.messages = append(s.messages, row)
+	}
+
+	sort.Slice(s.messages, func(i, j int) bool {
+		if s.messages[i].Requester != s.messages[j].Requester {
+			return s.messages[i].Requester < s.messages[j].Requester
+		}
+		return s.messages[i].Type < s.messages[j].Type
+	})
+
+	for _, name := range processor.GetRequesterNames() {
+		row := linkRow{Name: name, Type: requesters[name]}
+
+		requester, _ := processor.GetRequester(name)
+		last, seen := lastByRequester[name]
+		switch {
+		case isStatusReporter(requester):
+			row.Status = requester.(cellularlog.StatusReporter).Status()
+		case !seen:
+			row.Status = "no responses yet"
+		case last.Success:
+			row.Status = fmt.Sprintf("ok, last response %s ago", formatAge(now.Sub(last.RequestTime)))
+		default:
+			row.Status = fmt.Sprintf("failing (%d in a row): %s", failingByRequester[name], last.Error)
+		}
+
+		s.links = append(s.links, row)
+	}
+
+	s.cell = describeCell(cell, signal, now)
+	s.gnss = describeGNSS(gnss, processor, now)
+
+	return s
+}
+
+// recent returns the newest n entries of message, without copying the rest of its history.
+func recent(message cellularlog.Message, n int) []cellularlog.LogEntry {
+	if provider, ok := message.(cellularlog.HistoryProvider); ok {
+		return provider.History().Last(n)
+	}
+	entries := message.GetAllEntries()
+	return entries[max(len(entries)-n, 0):]
+}
+
+func isStatusReporter(r cellularlog.Requester) bool {
+	_, ok := r.(cellularlog.StatusReporter)
+	return ok
+}
+
+func describeCell(cell, signal latestData, now time.Time) string {
+	var parts []string
+
+	if c, ok := cell.data.(*AT.ServingCell); ok {
+		parts = append(parts, c.State)
+		if c.RAT != "" {
+			parts = append(parts, c.RAT)
+		}
+		if c.RAT == "LTE" {
+			parts = append(parts, fmt.Sprintf("%03d-%02d cell %s PCI %d band %d TAC %s", c.MCC, c.MNC, c.CellID, c.PCI, c.Band, c.TAC))
+			parts = append(parts, "RSRP "+optional(c.RSRP, " dBm"), "RSRQ "+optional(c.RSRQ, " dB"), "SINR "+optional(c.SINR, ""))
+		}
+		parts = append(parts, fmt.Sprintf("(%s ago)", formatAge(now.Sub(cell.time))))
+	}
+
+	if q, ok := signal.data.(*AT.SignalQuality); ok {
+		parts = append(parts, fmt.Sprintf("CSQ RSSI %s (%s ago)", optional(q.RSSI, " dBm"), formatAge(now.Sub(signal.time))))
+	}
+
+	if len(parts) == 0 {
+		return "no serving cell data (log at:+QENG=\"servingcell\" with parser qeng, or at:+CSQ with parser csq)"
+	}
+	return strings.Join(parts, "  ")
+}
+
+func describeGNSS(gnss latestData, processor *cellularlog.Processor, now time.Time) string {
+	var description string
+
+	switch g := gnss.data.(type) {
+	case *nmea.GGA:
+		quality := map[int]string{0: "no fix", 1: "GPS fix", 2: "DGPS fix", 4: "RTK fixed", 5: "RTK float", 6: "estimated"}[g.FixQuality]
+		description = fmt.Sprintf("%s, %d satellites, HDOP %.1f (nmea)", quality, g.Satellites, g.HDOP)
+	case *gpsd.TPV:
+		mode := map[int]string{0: "unknown", 1: "no fix", 2: "2D fix", 3: "3D fix"}[g.Mode]
+		description = fmt.Sprintf("%s, eph %.1f m (gpsd)", mode, g.Eph)
+	case *common.MessageGpsRawInt:
+		description = fmt.Sprintf("%s, %d satellites, eph %.2f (mavlink)", strings.TrimPrefix(g.FixType.String(), "GPS_FIX_TYPE_"), g.SatellitesVisible, float64(g.Eph)/100)
+	default:
+		description = "no GNSS data"
+	}
+	if gnss.data != nil {
+		description += fmt.Sprintf(" %s ago", formatAge(now.Sub(gnss.time)))
+	}
+
+	if position, ok := processor.GetPosition(); ok {
+		description += fmt.Sprintf("  position %.6f, %.6f (%s, %s ago)", position.Latitude, position.Longitude, position.Source, formatAge(now.Sub(position.Time)))
+	}
+
+	return description
+}
+
+func renderDashboard(s snapshot, console []string, width int) []string {
+	lines := []string{
+		fmt.Sprintf("\x1b[1mcellular logger\x1b[0m  %s  up %s", s.now.Format("15:04:05"), s.uptime.Round(time.Second)),
+		"",
+		fmt.Sprintf("\x1b[1m%-28s %-10s %7s %7s %6s %8s %8s %8s  %s\x1b[0m", "MESSAGE", "REQUESTER", "AGE", "OK%", "ERR", "P50", "P90", "P99", "LAST"),
+	}
+
+	for _, m := range s.messages {
+		age, ok := "-", "-"
+		if m.Entries > 0 {
+			age, ok = formatAge(m.Age), fmt.Sprintf("%.1f", m.SuccessRate)
+		}
+
+		row := fmt.Sprintf("%-28s %-10s %7s %7s %6d %8s %8s %8s  ", truncate(m.Type, 28), truncate(m.Requester, 10), age,
+			ok, m.Errors, formatLatency(m.P50), formatLatency(m.P90), formatLatency(m.P99))
+		lines = append(lines, row+truncate(m.Last, width-len(row)))
+	}
+
+	lines = append(lines, "", "\x1b[1mLINKS\x1b[0m")
+	for _, l := range s.links {
+		lines = append(lines, fmt.Sprintf("  %-12s %-8s %s", truncate(l.Name, 12), l.Type, l.Status))
+	}
+
+	lines = append(lines, "", "\x1b[1mCELL\x1b[0m   "+s.cell, "\x1b[1mGNSS\x1b[0m   "+s.gnss, "", "\x1b[1mWRITER\x1b[0m "+describeWriter(s.writer, s.now))
+
+	if len(console) > 0 {
+		lines = append(lines, "", "\x1b[1mCONSOLE\x1b[0m")
+		for _, line := range console {
+			lines = append(lines, "  "+line)
+		}
+	}
+
+	for i, line := range lines {
+		lines[i] = truncate(line, width+ansiLength(line))
+	}
+
+	return lines
+}
+
+func describeWriter(stats cellularlog.WriterStats, now time.Time) string {
+	var parts []string
+
+	for _, file := range stats.Files {
+		size := "-"
+		if info, err := os.Stat(file); err == nil {
+			size = formatBytes(info.Size())
+		}
+		parts = append(parts, fmt.Sprintf("%s %s", file, size))
+	}
+
+	flush := "no flush yet"
+	if !stats.LastFlush.IsZero() {
+		flush = fmt.Sprintf("last flush %s ago", formatAge(now.Sub(stats.LastFlush)))
+	}
+	parts = append(parts, flush, fmt.Sprintf("%d written, %d buffered", stats.Written, stats.Buffered))
+	if stats.Dropped > 0 {
+		parts = append(parts, fmt.Sprintf("%d dropped", stats.Dropped))
+	}
+	if stats.Backpressure {
+		parts = append(parts, "backpressure")
+	}
+
+	if stats.LastError != "" {
+		parts = append(parts, "error: "+stats.LastError)
+	}
+
+	return strings.Join(parts, "  ")
+}
+
+// summarise renders an entry's data on one line.
+func summarise(entry cellularlog.LogEntry) string {
+	if !entry.Success {
+		return "error: " + entry.Error
+	}
+
+	switch data := entry.Data.(type) {
+	case []string:
+		return strings.Join(data, " | ")
+	case string:
+		return data
+	}
+
+	data, err := json.Marshal(entry.Data)
+	if err != nil {
+		return fmt.Sprintf("%v", entry.Data)
+	}
+	return string(data)
+}
+
+func optional(v *int, unit string) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d%s", *v, unit)
+}
+
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	case d < time.Hour:
+		return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+	default:
+		return d.Round(time.Minute).String()
+	}
+}
+
+func formatLatency(d time.Duration) string {
+	if d < 0 {
+		return "-"
+	}
+	if d < time.Second {
+		return fmt.Sprintf("%.1fms", float64(d.Microseconds())/1000)
+	}
+	return fmt.Sprintf("%.2fs", d.Seconds())
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// truncate shortens s to n bytes, marking the cut.
+func truncate(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if len(s) <= n {
+		return s
+	}
+	if n == 1 {
+		return s[:1]
+	}
+	return s[:n-1] + "~"
+}
+
+// ansiLength is the number of bytes of s taken by SGR escape sequences, which use no columns.
+func ansiLength(s string) int {
+	var n int
+	for {
+		start := strings.Index(s, "\x1b[")
+		if start < 0 {
+			return n
+		}
+		end := strings.IndexByte(s[start:], 'm')
+		if end < 0 {
+			return n
+		}
+		n += end + 1
+		s = s[start+end+1:]
+	}
+}
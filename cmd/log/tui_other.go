@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+func terminalSize(*os.File) (int, int) {
+	return 80, 24
+}
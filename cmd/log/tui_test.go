@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/AT"
+	"github.com/harshabose/cellular_localisation_logging/pkg/nmea"
+)
+
+// recorded is a message replaying fixed entries.
+type recorded struct {
+	requester, messageType string
+	entries                []cellularlog.LogEntry
+}
+
+func (m *recorded) Process(cellularlog.Requester) (cellularlog.LogEntry, error) {
+	return cellularlog.LogEntry{}, nil
+}
+func (m *recorded) GetRequester() string                  { return m.requester }
+func (m *recorded) GetType() string                       { return m.messageType }
+func (m *recorded) GetAllEntries() []cellularlog.LogEntry { return m.entries }
+
+func TestDashboardSnapshot(t *testing.T) {
+	now := time.Now()
+	rsrp, sinr := -97, 12
+
+	qeng := &recorded{requester: "at", messageType: "at-+QENG=\"servingcell\""}
+	for i := 0; i < 10; i++ {
+		qeng.entries = append(qeng.entries, cellularlog.LogEntry{
+			Success:     i != 3,
+			Error:       "timeout",
+			RequestTime: now.Add(time.Duration(i-10) * time.Second),
+			Duration:    time.Duration(i+1) * 10 * time.Millisecond,
+			Data:        &AT.ServingCell{State: "NOCONN", RAT: "LTE", MCC: 262, MNC: 1, CellID: "1A2B3C", PCI: 101, Band: 3, RSRP: &rsrp, SINR: &sinr},
+		})
+	}
+	gga := &recorded{requester: "nmea", messageType: "nmea-GGA", entries: []cellularlog.LogEntry{
+		{Success: true, RequestTime: now.Add(-time.Second), Data: &nmea.GGA{FixQuality: 1, Satellites: 9, HDOP: 0.8}},
+	}}
+
+	processor := cellularlog.NewProcessor(context.Background(), time.Hour, time.Hour, nil, 10, qeng, gga)
+	for _, entry := range qeng.entries {
+		entry.MessageType = qeng.messageType
+		processor.Stats().Observe(qeng.requester, entry)
+	}
+	s := takeSnapshot(processor, nil, now.Add(-time.Minute), now)
+
+	if len(s.messages) != 2 || s.messages[0].Type != qeng.messageType {
+		t.Fatalf("unexpected rows: %+v", s.messages)
+	}
+	row := s.messages[0]
+	if row.Entries != 10 || row.Errors != 1 || row.SuccessRate != 90 || !near(row.P50, 60*time.Millisecond) || !near(row.P99, 90*time.Millisecond) {
+		t.Errorf("unexpected statistics: %+v", row)
+	}
+	if s.messages[1].P50 != -1 {
+		t.Errorf("expected no latency for a message without statistics, got %v", s.messages[1].P50)
+	}
+
+	screen := strings.Join(renderDashboard(s, []string{"console line"}, 200), "\n")
+	for _, want := range []string{"RSRP -97 dBm", "SINR 12", "PCI 101", "GPS fix, 9 satellites, HDOP 0.8", "console line"} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("dashboard is missing %q:\n%s", want, screen)
+		}
+	}
+}
+
+// near reports whether d is within the 1% accuracy of the latency sketch of want.
+func near(d, want time.Duration) bool {
+	return d >= want*99/100 && d <= want*101/100
+}
+
+func TestDashboardConsole(t *testing.T) {
+	output := &switchWriter{w: io.Discard}
+	d := newDashboard(nil, nil, output)
+	d.previous = output.set(d)
+
+	fmt.Fprint(output, "first\nsec")
+	fmt.Fprintf(output, "ond %d\n", 2)
+	for i := 0; i < consoleLines; i++ {
+		fmt.Fprintln(output, "line", i)
+	}
+
+	if len(d.console) != consoleLines || d.console[0] != "line 0" {
+		t.Errorf("expected the last %d lines, got %q", consoleLines, d.console)
+	}
+	if output.set(d.previous) != d {
+		t.Error("expected the output to go to the dashboard")
+	}
+
+	d.console = nil
+	fmt.Fprint(d, "first\nsec")
+	fmt.Fprint(d, "ond\n")
+	if strings.Join(d.console, ",") != "first,second" {
+		t.Errorf("expected lines split across writes to be joined, got %q", d.console)
+	}
+}
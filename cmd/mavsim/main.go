@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v3"
+
+	"github.com/harshabose/cellular_localisation_logging/pkg/mavlink"
+	"github.com/harshabose/cellular_localisation_logging/pkg/mavlink/sim"
+)
+
+type Config struct {
+	UDPAddress string
+	PTY        bool
+	Messages   string
+
+	HeartbeatPeriod time.Duration
+	LossRate        float64
+	Latency         time.Duration
+	Jitter          time.Duration
+	Disarmed        bool
+	SigningKey      string
+	NoGPSTime       bool
+}
+
+func main() {
+	config := parseFlags()
+
+	if err := run(config); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func parseFlags() *Config {
+	config := &Config{}
+
+	flag.StringVar(&config.UDPAddress, "udp", "127.0.0.1:14550", "UDP address to listen on (empty to disable)")
+	flag.BoolVar(&config.PTY, "pty", false, "Also expose the vehicle on a pseudo-terminal (linux only)")
+	flag.StringVar(&config.Messages, "messages", "", "Comma-separated message IDs to answer for (default: all supported)")
+
+	flag.DurationVar(&config.HeartbeatPeriod, "heartbeat", time.Second, "HEARTBEAT period")
+	flag.Float64Var(&config.LossRate, "loss", 0, "Packet loss probability in [0, 1]")
+	flag.DurationVar(&config.Latency, "latency", 0, "Added latency on outgoing frames")
+	flag.DurationVar(&config.Jitter, "jitter", 0, "Random extra latency in [0, jitter)")
+	flag.BoolVar(&config.Disarmed, "disarmed", false, "Start disarmed")
+	flag.BoolVar(&config.NoGPSTime, "no-gps-time", false, "Send SYSTEM_TIME without a UNIX time, like before a GPS fix")
+	flag.StringVar(&config.SigningKey, "signing-key", "", "Require MAVLink 2 signing with this key: 64 hex digits or a passphrase")
+
+	flag.Parse()
+
+	return config
+}
+
+func run(config *Config) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages, err := parseMessageIDs(config.Messages)
+	if err != nil {
+		return fmt.Errorf("failed to parse messages: %w", err)
+	}
+
+	var signingKey []byte
+	if config.SigningKey != "" {
+		if signingKey, err = mavlink.ParseSigningKey(config.SigningKey); err != nil {
+			return err
+		}
+	}
+
+	var endpoints []gomavlib.EndpointConf
+	if config.UDPAddress != "" {
+		endpoints = append(endpoints, gomavlib.EndpointUDPServer{Address: config.UDPAddress})
+		fmt.Printf("listening on udp://%s\n", config.UDPAddress)
+	}
+
+	if config.PTY {
+		pty, err := sim.OpenPTY()
+		if err != nil {
+			return fmt.Errorf("failed to open pty: %w", err)
+		}
+		defer pty.Close()
+
+		endpoints = append(endpoints, gomavlib.EndpointCustom{ReadWriteCloser: pty})
+		fmt.Printf("listening on %s (use --mav-device=%s)\n", pty.Name(), pty.Name())
+	}
+
+	vehicle, err := sim.NewVehicle(ctx, sim.Config{
+		Endpoints:       endpoints,
+		Messages:        messages,
+		HeartbeatPeriod: config.HeartbeatPeriod,
+		Disarmed:        config.Disarmed,
+		NoGPSTime:       config.NoGPSTime,
+		LossRate:        config.LossRate,
+		Latency:         config.Latency,
+		Jitter:          config.Jitter,
+		SigningKey:      signingKey,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create vehicle: %w", err)
+	}
+
+	vehicle.Start()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	<-sigChan
+
+	return vehicle.Close()
+}
+
+func parseMessageIDs(messageStr string) ([]uint32, error) {
+	if messageStr == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(messageStr, ",")
+	ids := make([]uint32, 0, len(parts))
+
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		id, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message ID: %s", part)
+		}
+
+		ids = append(ids, uint32(id))
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/harshabose/cellular_localisation_logging/pkg/probe"
+)
+
+func main() {
+	address := flag.String("listen", fmt.Sprintf(":%d", probe.DefaultPort), "Address to serve UDP echo and TCP probes on")
+	flag.Parse()
+
+	if err := run(*address); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(address string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server, err := probe.NewServer(ctx, address)
+	if err != nil {
+		return fmt.Errorf("failed to start probe server: %w", err)
+	}
+
+	server.Start()
+	fmt.Printf("serving probes on %s (udp and tcp)\n", server.Addr())
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	fmt.Println("shutting down...")
+	return server.Close()
+}
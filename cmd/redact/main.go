@@ -0,0 +1,128 @@
+// Command redact removes identifiers and precise positions from existing logs, like the logger's
+// --redact flags do while logging. Each JSON or binary log, optionally gzipped, is written to the
+// output directory under its name without .gz.
+//
+//	redact -identifiers imei,imsi,iccid,phone -grid 0.01 -key-file redact.key -out shared logs/*.json.gz
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/redact"
+)
+
+func main() {
+	identifiers := flag.String("identifiers", strings.Join(redact.AllIdentifiers, ","), "Comma-separated identifiers to redact: imei, imsi, iccid, phone")
+	mode := flag.String("mode", string(redact.ModeHash), "How identifiers are redacted: hash (keyed HMAC) or mask (last four digits kept)")
+	grid := flag.Float64("grid", 0, "Coarsen coordinates to the centre of a grid of this many degrees, e.g. 0.01 (0 keeps them)")
+	keyFile := flag.String("key-file", "", "File with the key of the hashes, so that they match across runs (default: random)")
+	out := flag.String("out", "redacted", "Directory the redacted logs are written to")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Println("Usage: redact [flags] log.json[.gz]|log.bin[.gz]...")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	policy := redact.Policy{Mode: redact.Mode(*mode), Grid: *grid}
+	for _, identifier := range strings.Split(*identifiers, ",") {
+		if identifier = strings.TrimSpace(identifier); identifier != "" {
+			policy.Identifiers = append(policy.Identifiers, identifier)
+		}
+	}
+
+	if err := run(policy, *keyFile, *out, flag.Args()); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(policy redact.Policy, keyFile, out string, inputs []string) error {
+	if keyFile != "" {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return err
+		}
+		policy.Key = bytes.TrimSpace(key)
+	}
+
+	redactor, err := redact.NewRedactor(policy)
+	if err != nil {
+		return err
+	}
+	if policy.Mode != redact.ModeMask && keyFile == "" {
+		fmt.Println("warning: hashing with a random key, the hashes will not match those of other runs")
+	}
+
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		return err
+	}
+
+	for _, input := range inputs {
+		output := filepath.Join(out, strings.TrimSuffix(filepath.Base(input), ".gz"))
+		if filepath.Clean(output) == filepath.Clean(input) {
+			return fmt.Errorf("%s: would overwrite the input, choose another -out", input)
+		}
+
+		n, err := redactFile(redactor, input, output)
+		if err != nil {
+			return fmt.Errorf("%s: %w", input, err)
+		}
+		fmt.Printf("%s: %d entries written to %s\n", input, n, output)
+	}
+
+	return nil
+}
+
+// redactFile writes the redacted entries of input to output, in the same format.
+func redactFile(redactor *redact.Redactor, input, output string) (int, error) {
+	reader, closer, err := cellularlog.OpenLog(input)
+	if err != nil {
+		return 0, err
+	}
+	defer closer.Close()
+
+	var writer cellularlog.Writer
+	if format, _, _ := cellularlog.LogFormat(input); format == "binary" {
+		writer, err = cellularlog.NewBinaryWriter(output)
+	} else {
+		writer, err = cellularlog.NewJSONWriter(output)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for {
+		entry, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			_ = writer.Close()
+			return n, fmt.Errorf("entry %d: %w", n+1, err)
+		}
+
+		if entry.MessageType == cellularlog.SessionMessageType {
+			entry = redactor.Header(entry)
+		} else {
+			entry = redactor.Entry(entry)
+		}
+		if err := writer.Write([]cellularlog.LogEntry{entry}); err != nil {
+			_ = writer.Close()
+			return n, err
+		}
+		n++
+	}
+
+	return n, writer.Close()
+}
@@ -0,0 +1,184 @@
+// Command retime corrects the wall times of logs whose host clock was wrong for part of the session,
+// from the session offsets of their entries and the trusted times the logs hold: GNSS fixes, the
+// modem's network time (+CCLK?) and the host clock while NTP kept it synchronised. The files of a
+// session are read together, so that a fix in a later file corrects the earlier ones. Each JSON or
+// binary log, optionally gzipped, is written to the output directory under its name without .gz.
+//
+//	retime -out retimed logs/session_*.json.gz
+//	retime -sources gnss logs/*.json
+//
+// Rewritten copies no longer match the manifests of --sign-key; verify the originals.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/retime"
+)
+
+func main() {
+	sources := flag.String("sources", strings.Join(retime.DefaultSources, ","), "Comma-separated trusted time sources, preferred first: gnss, ntp, nitz")
+	out := flag.String("out", "retimed", "Directory the corrected logs are written to")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Println("Usage: retime [flags] log.json[.gz]|log.bin[.gz]...")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	var list []string
+	for _, source := range strings.Split(*sources, ",") {
+		switch source = strings.TrimSpace(source); source {
+		case "":
+		case retime.SourceGNSS, retime.SourceNTP, retime.SourceNITZ:
+			list = append(list, source)
+		default:
+			fmt.Printf("Error: unknown source %q (supported: gnss, ntp, nitz)\n", source)
+			os.Exit(2)
+		}
+	}
+
+	if err := run(list, *out, flag.Args()); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(sources []string, out string, inputs []string) error {
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		return err
+	}
+
+	// The first pass finds the session of every file and the references of every session.
+	timelines := make(map[string]*retime.Timeline)
+	sessions := make(map[string]string, len(inputs))
+	for _, input := range inputs {
+		session, err := scanFile(input, func(session string) *retime.Timeline {
+			if timelines[session] == nil {
+				timelines[session] = retime.NewTimeline(sources...)
+			}
+			return timelines[session]
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", input, err)
+		}
+		sessions[input] = session
+	}
+
+	names := make([]string, 0, len(timelines))
+	for session := range timelines {
+		names = append(names, session)
+	}
+	sort.Strings(names)
+	for _, session := range names {
+		timeline := timelines[session]
+		if timeline.Source() == "" {
+			fmt.Printf("session %s: no trusted time, its files are copied unchanged\n", session)
+			continue
+		}
+		fmt.Printf("session %s: corrected from %s (references: %v)\n", session, timeline.Source(), timeline.References())
+	}
+
+	for _, input := range inputs {
+		output := filepath.Join(out, strings.TrimSuffix(filepath.Base(input), ".gz"))
+		if filepath.Clean(output) == filepath.Clean(input) {
+			return fmt.Errorf("%s: would overwrite the input, choose another -out", input)
+		}
+
+		n, corrected, err := retimeFile(timelines[sessions[input]], input, output)
+		if err != nil {
+			return fmt.Errorf("%s: %w", input, err)
+		}
+		fmt.Printf("%s: %d of %d entries corrected, written to %s\n", input, corrected, n, output)
+	}
+
+	return nil
+}
+
+// scanFile adds the references of input to the timeline of its session, which the session header
+// identifies, and returns the session.
+func scanFile(input string, timeline func(session string) *retime.Timeline) (string, error) {
+	reader, closer, err := cellularlog.OpenLog(input)
+	if err != nil {
+		return "", err
+	}
+	defer closer.Close()
+
+	session := ""
+	for n := 1; ; n++ {
+		entry, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return session, nil
+		}
+		if err != nil {
+			return session, fmt.Errorf("entry %d: %w", n, err)
+		}
+
+		if entry.MessageType == cellularlog.SessionMessageType {
+			session = sessionOf(entry)
+		}
+		timeline(session).Observe(entry)
+	}
+}
+
+// sessionOf names the session of a header by its host and start, as logged.
+func sessionOf(header cellularlog.LogEntry) string {
+	data, _ := header.Data.(map[string]interface{})
+	host, _ := data["host"].(string)
+	started, _ := data["started"].(string)
+	return host + "@" + started
+}
+
+// retimeFile writes the corrected entries of input to output, in the same format.
+func retimeFile(timeline *retime.Timeline, input, output string) (int, int, error) {
+	reader, closer, err := cellularlog.OpenLog(input)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer closer.Close()
+
+	var writer cellularlog.Writer
+	if format, _, _ := cellularlog.LogFormat(input); format == "binary" {
+		writer, err = cellularlog.NewBinaryWriter(output)
+	} else {
+		writer, err = cellularlog.NewJSONWriter(output)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	n, corrected := 0, 0
+	for {
+		entry, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			_ = writer.Close()
+			return n, corrected, fmt.Errorf("entry %d: %w", n+1, err)
+		}
+
+		if timeline != nil {
+			var ok bool
+			if entry, ok = timeline.Rewrite(entry); ok {
+				corrected++
+			}
+		}
+		if err := writer.Write([]cellularlog.LogEntry{entry}); err != nil {
+			_ = writer.Close()
+			return n, corrected, err
+		}
+		n++
+	}
+
+	return n, corrected, writer.Close()
+}
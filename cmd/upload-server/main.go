@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging/pkg/upload"
+)
+
+func main() {
+	address := flag.String("listen", ":8080", "Address to accept uploads on")
+	dir := flag.String("dir", "uploads", "Directory completed uploads are stored in")
+	flag.Parse()
+
+	if err := run(*address, *dir); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(address, dir string) error {
+	server, err := upload.NewServer(dir)
+	if err != nil {
+		return fmt.Errorf("failed to create upload server: %w", err)
+	}
+	defer server.Close()
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/files/", server)
+	httpServer := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("upload server stopped: %v\n", err)
+		}
+	}()
+	fmt.Printf("accepting uploads on http://%s/files/, storing them in %s\n", listener.Addr(), dir)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	fmt.Println("shutting down...")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return httpServer.Shutdown(ctx)
+}
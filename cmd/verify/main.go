@@ -0,0 +1,264 @@
+// Command verify checks logs written with the logger's --sign-key: that every file matches its
+// signed manifest, that the hash chain of its entries is unbroken, and that the files of each session
+// follow one another up to the final one. Missing, reordered or altered entries and missing files are
+// reported, and the command fails if there are any. It also generates device keys.
+//
+//	verify -generate vehicle1                       # writes vehicle1.key (for --sign-key) and vehicle1.pub
+//	verify -pub vehicle1.pub logs/*
+//	verify -pub vehicle1.pub -key analyst.key logs/*.enc
+//
+// The entries of JSON and binary logs are checked, decrypting them with -key if they are encrypted;
+// CSV and line protocol files only against their manifests.
+package main
+
+import (
+	"compress/gzip"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/chain"
+	"github.com/harshabose/cellular_localisation_logging/pkg/envelope"
+)
+
+func main() {
+	generate := flag.String("generate", "", "Generate a device key, writing NAME.key (for --sign-key) and NAME.pub (for -pub)")
+	pubFile := flag.String("pub", "", "Public key of the device (default: the key in each manifest, which proves little)")
+	keyFile := flag.String("key", "", "Private key to decrypt encrypted logs with, see cmd/decrypt")
+	flag.Parse()
+
+	var err error
+	switch {
+	case *generate != "":
+		err = generateKey(*generate)
+	case flag.NArg() > 0:
+		err = run(*pubFile, *keyFile, flag.Args())
+	default:
+		fmt.Println("Usage: verify -generate NAME | verify [-pub NAME.pub] [-key ANALYST.key] FILE...")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func generateKey(name string) error {
+	key, err := chain.GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	private, err := chain.MarshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	public, err := chain.MarshalPublicKey(key.Public().(ed25519.PublicKey))
+	if err != nil {
+		return err
+	}
+
+	// O_EXCL, so that the key of a device's past logs is never overwritten.
+	file, err := os.OpenFile(name+".key", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(private); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(name+".pub", public, 0o644); err != nil {
+		return err
+	}
+
+	fmt.Printf("wrote %s.key and %s.pub (key ID %s); give %s.key to the logger with --sign-key and keep %s.pub to verify its logs\n",
+		name, name, chain.KeyID(key.Public().(ed25519.PublicKey)), name, name)
+	return nil
+}
+
+type verifier struct {
+	trusted  ed25519.PublicKey
+	key      *ecdh.PrivateKey
+	sessions map[string][]*chain.Manifest
+	problems int
+}
+
+func run(pubFile, keyFile string, inputs []string) error {
+	v := &verifier{sessions: make(map[string][]*chain.Manifest)}
+
+	var err error
+	if pubFile != "" {
+		if v.trusted, err = chain.LoadPublicKey(pubFile); err != nil {
+			return err
+		}
+	} else {
+		fmt.Println("warning: no -pub, manifests are only checked against the keys they carry")
+	}
+	if keyFile != "" {
+		if v.key, err = envelope.LoadPrivateKey(keyFile); err != nil {
+			return err
+		}
+	}
+
+	for _, input := range logFiles(inputs) {
+		v.verifyFile(input)
+	}
+
+	for session, manifests := range v.sessions {
+		if err := chain.CheckSession(manifests); err != nil {
+			v.report("session "+session, err)
+		}
+	}
+
+	if v.problems > 0 {
+		return fmt.Errorf("%d problems found", v.problems)
+	}
+	return nil
+}
+
+// logFiles returns the log files of inputs, taking a manifest for the file it describes so that
+// "verify logs/*" checks each file once.
+func logFiles(inputs []string) []string {
+	var files []string
+	seen := make(map[string]bool)
+	for _, input := range inputs {
+		if name, ok := strings.CutSuffix(input, chain.ManifestExt); ok {
+			input = name
+			if _, err := os.Stat(name); err != nil {
+				input = name + ".gz"
+			}
+		}
+		if !seen[input] {
+			seen[input] = true
+			files = append(files, input)
+		}
+	}
+	return files
+}
+
+// report prints each of the problems err combines.
+func (v *verifier) report(name string, err error) {
+	if multi, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range multi.Unwrap() {
+			v.report(name, err)
+		}
+		return
+	}
+
+	fmt.Printf("%s: %v\n", name, err)
+	v.problems++
+}
+
+// verifyFile checks a log file against its manifest, written next to it before compression.
+func (v *verifier) verifyFile(filename string) {
+	name, compressed := strings.CutSuffix(filename, ".gz")
+	problems := v.problems
+
+	manifest, err := chain.ReadManifest(name+chain.ManifestExt, v.trusted)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		v.report(filename, errors.New("no manifest, the file was not closed by the logger or the manifest was removed"))
+	case err != nil:
+		v.report(filename, err)
+	default:
+		v.sessions[manifest.Session] = append(v.sessions[manifest.Session], manifest)
+	}
+
+	if _, err := os.Stat(filename); err != nil {
+		v.report(filename, fmt.Errorf("missing: %w", err))
+		return
+	}
+
+	if manifest != nil {
+		err := withFile(filename, compressed, func(r io.Reader) error { return manifest.CheckFile(r) })
+		if err != nil {
+			v.report(filename, err)
+		}
+	}
+
+	plain, encrypted := strings.CutSuffix(name, envelope.Ext)
+	format, _, err := cellularlog.LogFormat(plain)
+	if err != nil {
+		// CSV and line protocol files carry the links, but not the entries as they were hashed.
+		v.summary(filename, manifest, problems, "manifest only")
+		return
+	}
+	if encrypted && v.key == nil {
+		v.summary(filename, manifest, problems, "manifest only, -key is needed to check the entries")
+		return
+	}
+
+	var segment chain.Segment
+	err = withFile(filename, compressed, func(r io.Reader) error {
+		if encrypted {
+			decrypted, err := envelope.NewReader(r, v.key)
+			if err != nil {
+				return err
+			}
+			r = decrypted
+		}
+
+		start := ""
+		if manifest != nil {
+			start = manifest.Start
+		}
+
+		var err error
+		segment, err = chain.CheckEntries(cellularlog.NewLogReader(r, format).(cellularlog.RawEntryReader), start)
+		if err == nil && manifest != nil {
+			err = manifest.Matches(segment)
+		}
+		return err
+	})
+	if err != nil {
+		v.report(filename, err)
+		return
+	}
+
+	v.summary(filename, manifest, problems, fmt.Sprintf("%d entries", segment.Entries))
+}
+
+// summary prints what was checked of a file, unless problems were reported for it.
+func (v *verifier) summary(filename string, manifest *chain.Manifest, problems int, checked string) {
+	if manifest == nil || v.problems > problems {
+		return
+	}
+
+	final := ""
+	if manifest.Final {
+		final = ", final"
+	}
+	fmt.Printf("%s: ok, session %s segment %d%s, %s\n", filename, manifest.Session, manifest.Segment, final, checked)
+}
+
+// withFile calls f with the content of filename, decompressed if it is.
+func withFile(filename string, compressed bool, f func(io.Reader) error) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if compressed {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	return f(r)
+}
@@ -1,20 +1,24 @@
 module github.com/harshabose/cellular_localisation_logging
 
-go 1.24
+go 1.24.0
 
 require (
 	github.com/bluenviron/gomavlib/v3 v3.2.1
+	github.com/eclipse/paho.mqtt.golang v1.5.1
 	github.com/emirpasic/gods/v2 v2.0.0-alpha
 	github.com/warthog618/modem v0.4.0
+	golang.org/x/sys v0.36.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/creack/goselect v0.1.2 // indirect
+	github.com/gorilla/websocket v1.5.3 // indirect
 	github.com/pion/logging v0.2.2 // indirect
 	github.com/pion/transport/v2 v2.2.10 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/tarm/serial v0.0.0-20180830185346-98f6abe2eb07 // indirect
 	go.bug.st/serial v1.6.2 // indirect
-	golang.org/x/net v0.41.0 // indirect
-	golang.org/x/sys v0.33.0 // indirect
+	golang.org/x/net v0.44.0 // indirect
+	golang.org/x/sync v0.17.0 // indirect
 )
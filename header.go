@@ -0,0 +1,110 @@
+package cellularlog
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SessionMessageType is the MessageType of the session header entry.
+const SessionMessageType = "session"
+
+// SessionHeader describes how a session was set up, so that a log can be traced back to the
+// hardware and configuration that produced it.
+type SessionHeader struct {
+	Version string      `json:"version"` // of the logger
+	Host    string      `json:"host"`
+	Started time.Time   `json:"started"`          // wall time at session offset zero, see LogEntry.SessionOffset
+	Config  interface{} `json:"config,omitempty"` // the effective configuration
+
+	// ClockSynchronized is whether the kernel considered the wall clock synchronised when the session
+	// started, nil where it does not tell. Later changes are logged as ClockMessageType entries.
+	ClockSynchronized *bool `json:"clock_synchronized,omitempty"`
+
+	// Devices are the serial devices or addresses of the requesters, by requester name.
+	Devices map[string]string `json:"devices,omitempty"`
+	// Identities are filled in by WriteSessionHeader from the requesters that are Identifiers.
+	Identities     map[string]interface{} `json:"identities,omitempty"`
+	IdentityErrors map[string]string      `json:"identity_errors,omitempty"`
+
+	// Redaction is the policy applied to the log before it was written, if any; see pkg/redact.
+	Redaction interface{} `json:"redaction,omitempty"`
+}
+
+// Identifier is implemented by requesters that can describe the device they talk to, e.g. a modem's
+// IMEI or an autopilot's firmware version. What they could not find out is returned as an error
+// alongside the rest.
+type Identifier interface {
+	Identify() (interface{}, error)
+}
+
+// HeaderWriter is implemented by writers that record the session header in a way of their own
+// instead of as an ordinary first entry, e.g. repeated at the start of every file they open.
+type HeaderWriter interface {
+	WriteHeader(header LogEntry) error
+}
+
+// writeHeader writes header with w's WriteHeader, or as an entry if w is not a HeaderWriter.
+func writeHeader(w Writer, header LogEntry) error {
+	if hw, ok := w.(HeaderWriter); ok {
+		return hw.WriteHeader(header)
+	}
+	return w.Write([]LogEntry{header})
+}
+
+// WriteSessionHeader identifies the registered requesters that are Identifiers and writes header to
+// the writer ahead of every entry. Call it after registering the requesters and before Start.
+func (p *Processor) WriteSessionHeader(header SessionHeader) error {
+	p.mux.RLock()
+	names := make([]string, 0, len(p.requesters))
+	for name := range p.requesters {
+		names = append(names, name)
+	}
+	p.mux.RUnlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		requester, _ := p.GetRequester(name)
+		identifier, ok := requester.(Identifier)
+		if !ok {
+			continue
+		}
+
+		identity, err := identifier.Identify()
+		if identity != nil {
+			if header.Identities == nil {
+				header.Identities = make(map[string]interface{})
+			}
+			header.Identities[name] = identity
+		}
+		if err != nil {
+			if header.IdentityErrors == nil {
+				header.IdentityErrors = make(map[string]string)
+			}
+			header.IdentityErrors[name] = err.Error()
+		}
+	}
+
+	if header.Started.IsZero() {
+		header.Started = p.started
+	}
+	if synchronized, known := clockSynchronized(); known {
+		header.ClockSynchronized = &synchronized
+	}
+	entry := LogEntry{
+		MessageType:   SessionMessageType,
+		Success:       true,
+		Data:          header,
+		RequestTime:   header.Started,
+		ResponseTime:  time.Now(),
+		SessionOffset: p.sessionOffset(header.Started),
+	}
+
+	p.logMux.Lock()
+	defer p.logMux.Unlock()
+
+	if err := writeHeader(p.writer, entry); err != nil {
+		return fmt.Errorf("error writing session header: %w", err)
+	}
+	return nil
+}
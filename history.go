@@ -0,0 +1,168 @@
+package cellularlog
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultHistoryCapacity is how many entries a message keeps until SetHistoryLimits says otherwise.
+const DefaultHistoryCapacity = 1000
+
+// History is the bounded record of a message's latest entries. It keeps at most capacity entries,
+// and none older than maxAge by request time if maxAge is set. It is safe for concurrent use and
+// every query returns a copy, oldest first.
+type History struct {
+	entries  []LogEntry // ring of len up to capacity, oldest at start
+	start    int
+	capacity int
+	maxAge   time.Duration
+	total    uint64
+	mux      sync.RWMutex
+}
+
+func NewHistory(capacity int, maxAge time.Duration) *History {
+	if capacity <= 0 {
+		capacity = DefaultHistoryCapacity
+	}
+
+	return &History{capacity: capacity, maxAge: maxAge}
+}
+
+// HistoryProvider is implemented by messages that keep their entries in a History.
+type HistoryProvider interface {
+	History() *History
+}
+
+// HistoryQuery selects entries of a History. Zero values do not filter.
+type HistoryQuery struct {
+	SinceIndex uint64    // entries with an Index of at least this
+	From, To   time.Time // entries requested at or after From and before To
+	Last       int       // at most this many of the newest matching entries
+}
+
+func (h *History) Add(entry LogEntry) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.total++
+	if len(h.entries) < h.capacity {
+		h.entries = append(h.entries, entry)
+	} else {
+		h.entries[h.start] = entry
+		h.start = (h.start + 1) % len(h.entries)
+	}
+}
+
+// SetLimits changes the capacity and maximum age, forgetting the oldest entries that no longer fit.
+func (h *History) SetLimits(capacity int, maxAge time.Duration) {
+	if capacity <= 0 {
+		capacity = DefaultHistoryCapacity
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	entries := h.ordered()
+	if len(entries) > capacity {
+		entries = entries[len(entries)-capacity:]
+	}
+
+	h.entries = append(make([]LogEntry, 0, min(capacity, max(len(entries), 16))), entries...)
+	h.start = 0
+	h.capacity = capacity
+	h.maxAge = maxAge
+}
+
+// ordered returns the ring oldest first, without entries older than maxAge. It does not copy if the
+// ring has not wrapped and no entry is too old.
+func (h *History) ordered() []LogEntry {
+	entries := h.entries
+	if h.start > 0 {
+		entries = append(append(make([]LogEntry, 0, len(h.entries)), h.entries[h.start:]...), h.entries[:h.start]...)
+	}
+
+	// Each entry is checked: after the clock was stepped, older entries may have later times.
+	if expired := h.expired(time.Now()); slices.ContainsFunc(entries, expired) {
+		entries = slices.DeleteFunc(slices.Clone(entries), expired)
+	}
+
+	return entries
+}
+
+// expired returns whether an entry is older than maxAge at now.
+func (h *History) expired(now time.Time) func(LogEntry) bool {
+	cutoff := now.Add(-h.maxAge)
+	return func(entry LogEntry) bool {
+		return h.maxAge > 0 && entry.RequestTime.Before(cutoff)
+	}
+}
+
+// Query returns a copy of the entries selected by q. It walks the ring from the newest entry, so
+// that a query with Last only looks at as many entries as it needs.
+func (h *History) Query(q HistoryQuery) []LogEntry {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
+	expired := h.expired(time.Now())
+
+	var result []LogEntry
+	for i := len(h.entries) - 1; i >= 0 && (q.Last <= 0 || len(result) < q.Last); i-- {
+		entry := h.entries[(h.start+i)%len(h.entries)]
+		if expired(entry) {
+			continue // older entries may not be, if the clock was stepped back
+		}
+		if entry.Index < q.SinceIndex {
+			continue
+		}
+		if !q.From.IsZero() && entry.RequestTime.Before(q.From) {
+			continue
+		}
+		if !q.To.IsZero() && !entry.RequestTime.Before(q.To) {
+			continue
+		}
+		result = append(result, entry)
+	}
+
+	slices.Reverse(result)
+	return result
+}
+
+// All returns a copy of every entry kept.
+func (h *History) All() []LogEntry {
+	return h.Query(HistoryQuery{})
+}
+
+// Last returns a copy of the newest n entries.
+func (h *History) Last(n int) []LogEntry {
+	if n <= 0 {
+		return nil
+	}
+	return h.Query(HistoryQuery{Last: n})
+}
+
+// Since returns a copy of the entries with an Index of at least index.
+func (h *History) Since(index uint64) []LogEntry {
+	return h.Query(HistoryQuery{SinceIndex: index})
+}
+
+// Between returns a copy of the entries requested at or after from and before to.
+func (h *History) Between(from, to time.Time) []LogEntry {
+	return h.Query(HistoryQuery{From: from, To: to})
+}
+
+// Len returns the number of entries kept.
+func (h *History) Len() int {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
+	return len(h.ordered())
+}
+
+// Total returns the number of entries ever added, including those forgotten.
+func (h *History) Total() uint64 {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+
+	return h.total
+}
@@ -0,0 +1,149 @@
+package cellularlog
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newFullHistory returns a history of capacity holding entries 1 to n, requested a second apart
+// starting at t0.
+func newFullHistory(capacity int, n uint64, t0 time.Time) *History {
+	h := NewHistory(capacity, 0)
+	for i := uint64(1); i <= n; i++ {
+		h.Add(LogEntry{Index: i, RequestTime: t0.Add(time.Duration(i) * time.Second)})
+	}
+	return h
+}
+
+func TestHistoryWraps(t *testing.T) {
+	h := newFullHistory(3, 5, time.Now())
+
+	if got := indexes(h.All()); !slices.Equal(got, []uint64{3, 4, 5}) {
+		t.Errorf("expected the newest 3 entries oldest first, got %v", got)
+	}
+	if h.Len() != 3 || h.Total() != 5 {
+		t.Errorf("expected 3 entries kept of 5 added, got %d of %d", h.Len(), h.Total())
+	}
+	if got := indexes(h.Last(2)); !slices.Equal(got, []uint64{4, 5}) {
+		t.Errorf("expected the newest 2 entries, got %v", got)
+	}
+	if got := h.Last(0); got != nil {
+		t.Errorf("expected no entries, got %v", indexes(got))
+	}
+}
+
+func TestHistorySetLimitsShrinks(t *testing.T) {
+	h := newFullHistory(4, 6, time.Now())
+
+	h.SetLimits(2, 0)
+	if got := indexes(h.All()); !slices.Equal(got, []uint64{5, 6}) {
+		t.Fatalf("expected the newest entries that fit, got %v", got)
+	}
+	h.Add(LogEntry{Index: 7})
+	if got := indexes(h.All()); !slices.Equal(got, []uint64{6, 7}) {
+		t.Errorf("expected the shrunk ring to keep wrapping, got %v", got)
+	}
+
+	h.SetLimits(0, 0)
+	h.Add(LogEntry{Index: 8})
+	if got := indexes(h.All()); !slices.Equal(got, []uint64{6, 7, 8}) {
+		t.Errorf("expected the default capacity to keep every entry, got %v", got)
+	}
+}
+
+func TestHistoryMaxAge(t *testing.T) {
+	now := time.Now()
+	h := NewHistory(10, time.Minute)
+	for i, age := range []time.Duration{3 * time.Minute, 2 * time.Minute, 30 * time.Second, time.Second} {
+		h.Add(LogEntry{Index: uint64(i + 1), RequestTime: now.Add(-age)})
+	}
+
+	if got := indexes(h.All()); !slices.Equal(got, []uint64{3, 4}) {
+		t.Errorf("expected only entries younger than a minute, got %v", got)
+	}
+	if h.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", h.Len())
+	}
+	if got := indexes(h.Last(5)); !slices.Equal(got, []uint64{3, 4}) {
+		t.Errorf("expected Last not to return expired entries, got %v", got)
+	}
+}
+
+func TestHistoryAfterClockStepBack(t *testing.T) {
+	now := time.Now()
+	h := NewHistory(10, time.Minute)
+	// Entries 1 and 2 were requested before the clock was stepped back by an hour.
+	for i, at := range []time.Time{now.Add(time.Hour - 2*time.Second), now.Add(time.Hour - time.Second), now.Add(-2 * time.Minute), now.Add(-time.Second)} {
+		h.Add(LogEntry{Index: uint64(i + 1), RequestTime: at})
+	}
+
+	if got := indexes(h.All()); !slices.Equal(got, []uint64{1, 2, 4}) {
+		t.Errorf("expected every entry younger than a minute, got %v", got)
+	}
+	if h.Len() != 3 {
+		t.Errorf("expected 3 entries, got %d", h.Len())
+	}
+	if got := indexes(h.Query(HistoryQuery{From: now.Add(-5 * time.Minute), Last: 3})); !slices.Equal(got, []uint64{1, 2, 4}) {
+		t.Errorf("expected the entries from before the step too, got %v", got)
+	}
+	if got := indexes(h.Between(now.Add(-3*time.Minute), now)); !slices.Equal(got, []uint64{4}) {
+		t.Errorf("expected only the entry in range, got %v", got)
+	}
+}
+
+func TestHistoryQuery(t *testing.T) {
+	t0 := time.Now()
+	h := newFullHistory(6, 8, t0) // keeps 3 to 8, requested at t0+3s to t0+8s
+	at := func(s int) time.Time { return t0.Add(time.Duration(s) * time.Second) }
+
+	for _, tc := range []struct {
+		name  string
+		query HistoryQuery
+		want  []uint64
+	}{
+		{"none", HistoryQuery{}, []uint64{3, 4, 5, 6, 7, 8}},
+		{"since", HistoryQuery{SinceIndex: 6}, []uint64{6, 7, 8}},
+		{"since forgotten", HistoryQuery{SinceIndex: 1}, []uint64{3, 4, 5, 6, 7, 8}},
+		{"from", HistoryQuery{From: at(7)}, []uint64{7, 8}},
+		{"to", HistoryQuery{To: at(5)}, []uint64{3, 4}},
+		{"between", HistoryQuery{From: at(4), To: at(7)}, []uint64{4, 5, 6}},
+		{"last", HistoryQuery{Last: 2}, []uint64{7, 8}},
+		{"last of more than kept", HistoryQuery{Last: 20}, []uint64{3, 4, 5, 6, 7, 8}},
+		{"last before", HistoryQuery{To: at(6), Last: 2}, []uint64{4, 5}},
+		{"since and to", HistoryQuery{SinceIndex: 4, To: at(6)}, []uint64{4, 5}},
+		{"all filters", HistoryQuery{SinceIndex: 5, From: at(4), To: at(8), Last: 2}, []uint64{6, 7}},
+		{"empty", HistoryQuery{SinceIndex: 9}, nil},
+	} {
+		if got := indexes(h.Query(tc.query)); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestSuccessRateCountsForgottenEntries(t *testing.T) {
+	p := NewProcessor(context.Background(), time.Hour, time.Hour, &memoryWriter{}, 100)
+	p.SetHistoryLimits(2, 0)
+
+	now := time.Now()
+	for i, success := range []bool{false, false, true, true} {
+		p.statistics.Observe("modem", LogEntry{
+			Index:       uint64(i),
+			MessageType: "at",
+			Success:     success,
+			RequestTime: now,
+			Duration:    time.Duration(i) * 10 * time.Millisecond,
+		})
+	}
+
+	if rate := p.GetSuccessRate("at"); rate != 50 {
+		t.Errorf("expected a success rate of 50%% over every entry, got %v", rate)
+	}
+	if mean := p.GetAverageResponseTime("at"); mean < 24*time.Millisecond || mean > 26*time.Millisecond {
+		t.Errorf("expected a mean latency of about 25ms, got %v", mean)
+	}
+	if rate := p.GetSuccessRate("gps"); rate != 0 {
+		t.Errorf("expected no success rate for an unknown type, got %v", rate)
+	}
+}
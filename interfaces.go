@@ -2,9 +2,13 @@ package cellularlog
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/emirpasic/gods/v2/sets/hashset"
@@ -17,25 +21,135 @@ type Requester interface {
 }
 
 type Message interface {
-	Request(*Processor) (LogEntry, error)
 	Process(Requester) (LogEntry, error)
+	// GetRequester returns the name of the registered requester the message is sent through.
+	GetRequester() string
 	GetType() string
+	// GetAllEntries returns a copy of the entries the message keeps, oldest first.
 	GetAllEntries() []LogEntry
 }
 
+// Scheduled is implemented by messages that must be requested less often than every polling tick,
+// such as active probes that load the link being measured.
+type Scheduled interface {
+	GetInterval() time.Duration
+}
+
+// MessageOptions are per-message settings applied by the processor, independent of the message
+// implementation.
+type MessageOptions struct {
+	// Interval is the minimum time between requests of the message. It overrides the message's own
+	// Scheduled interval; zero keeps that interval, or requests the message on every poll.
+	Interval time.Duration
+	// Tags are added to every entry of the message as Metadata["tags"].
+	Tags map[string]string
+	// Priority decides what gives way under backpressure: entries of lower priorities are dropped
+	// first, and messages with a negative priority are not requested at all.
+	Priority int
+}
+
+// StatusReporter is implemented by requesters that can describe the state of their link, e.g.
+// "connected" or "reconnecting", for operators watching a session.
+type StatusReporter interface {
+	Status() string
+}
+
+// Reconnector is implemented by requesters that re-establish a lost link on their own.
+type Reconnector interface {
+	Reconnects() uint64
+}
+
+// Observer is told about every entry the processor logs and every flush, e.g. to export metrics. It
+// is called from the processor's goroutines and must not block.
+type Observer interface {
+	ObserveEntry(message Message, entry LogEntry)
+	ObserveFlush(entries int, duration time.Duration, err error)
+}
+
+// Position is a geodetic fix from a requester acting as the session's position reference.
+type Position struct {
+	Time      time.Time `json:"time"` // when the host received the fix
+	Latitude  float64   `json:"lat"`
+	Longitude float64   `json:"lon"`
+	Altitude  float64   `json:"alt"`
+	Source    string    `json:"source"`
+}
+
+// PositionProvider is implemented by requesters that can serve as the position reference, so that
+// entries from other requesters can be joined with where they were taken.
+type PositionProvider interface {
+	LatestPosition() (Position, bool)
+}
+
 type Writer interface {
 	Write(entries []LogEntry) error
 	io.Closer
 }
 
-type Processor struct {
-	Mavlink Requester
-	AT      Requester
+// Rotator is implemented by writers that can start a new output file on demand.
+type Rotator interface {
+	Rotate() error
+}
+
+// FileWriter is implemented by writers that write local files.
+type FileWriter interface {
+	// Files returns the files currently being written.
+	Files() []string
+}
+
+// ByteCounter is implemented by writers that know how many bytes they have written in total.
+type ByteCounter interface {
+	BytesWritten() uint64
+}
+
+// OutputFile is what the file based writers write to: an *os.File, or a wrapper of one such as an
+// encrypted file (see pkg/envelope).
+type OutputFile interface {
+	io.WriteCloser
+	Name() string
+}
 
+// Flusher is implemented by OutputFiles that buffer what is written to them. The file based writers
+// flush them after every batch.
+type Flusher interface {
+	Flush() error
+}
+
+// WriterStats describes the processor's output.
+type WriterStats struct {
+	Buffered      int           // entries waiting for the next flush
+	Capacity      int           // entries buffered before a flush is forced
+	Written       uint64        // entries taken by the writer
+	Bytes         uint64        // if the writer is a ByteCounter
+	LastFlush     time.Time     // zero before the first flush
+	FlushDuration time.Duration // of the last flush
+	LastError     string        // of the last flush, empty if it succeeded
+	Files         []string      // if the writer is a FileWriter
+	Dropped       uint64        // entries lost after failed flushes
+	Backpressure  bool          // entries of failed flushes are being kept for the next one
+}
+
+type Processor struct {
+	requesters      map[string]Requester
+	positionRef     string
+	observer        Observer
+	output          io.Writer // where errors are printed
 	messages        *hashset.Set[Message]
+	options         map[Message]MessageOptions
+	lastRequest     map[Message]time.Time // loop goroutine only
 	pollingInterval time.Duration
 	writerInterval  time.Duration
 	writer          Writer
+	historyCapacity int // zero keeps the messages' own limits
+	historyMaxAge   time.Duration
+	statistics      *Stats
+	statsInterval   time.Duration // between summary entries, zero for none
+	statsIndex      uint64        // loop goroutine only
+	started         time.Time     // session offsets are relative to it
+	clock           clockMonitor  // loop goroutine only
+	clockIndex      uint64        // loop goroutine only
+	rules           []*ruleState  // loop goroutine only, set before Start
+	ruleIndex       uint64        // loop goroutine only
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -43,40 +157,208 @@ type Processor struct {
 	wg     sync.WaitGroup
 	mux    sync.RWMutex
 
-	logBatchSize int
-	logBuffer    []LogEntry
-	logMux       sync.Mutex
+	logBatchSize  int
+	logBuffer     []LogEntry
+	logPriorities []int // of the entries of logBuffer, from their message's options
+	flushAt       int   // buffer length that triggers a flush, raised while the writer fails
+	maxBuffered   int   // entries kept across failed flushes, zero to drop them
+	wal           *wal  // nil unless EnableWAL was called
+	logMux        sync.Mutex
+	pressure      atomic.Bool
+
+	stats    WriterStats // Buffered, Capacity, Bytes and Files are filled in by GetWriterStats
+	statsMux sync.Mutex
 }
 
 func NewProcessor(ctx context.Context, pollingInterval time.Duration, writerInterval time.Duration, writer Writer, buffsize int, messages ...Message) *Processor {
 	ctx2, cancel := context.WithCancel(ctx)
+	started := time.Now()
 
 	p := &Processor{
+		requesters:      make(map[string]Requester),
+		lastRequest:     make(map[Message]time.Time),
+		options:         make(map[Message]MessageOptions),
 		messages:        hashset.New(messages...),
 		writer:          writer,
 		pollingInterval: pollingInterval,
 		writerInterval:  writerInterval,
+		statistics:      NewStats(),
+		output:          os.Stdout,
+		started:         started,
+		clock:           newClockMonitor(started),
 		ctx:             ctx2,
 		cancel:          cancel,
 		logBatchSize:    buffsize,
+		flushAt:         buffsize,
 		logBuffer:       make([]LogEntry, 0, buffsize),
 	}
 
 	return p
 }
 
+// RegisterRequester makes requester available to every message whose GetRequester returns name.
+func (p *Processor) RegisterRequester(name string, requester Requester) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if _, exists := p.requesters[name]; exists {
+		return fmt.Errorf("requester '%s' already registered", name)
+	}
+
+	p.requesters[name] = requester
+	return nil
+}
+
+// GetRequesterNames returns the names of the registered requesters, sorted.
+func (p *Processor) GetRequesterNames() []string {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+
+	names := make([]string, 0, len(p.requesters))
+	for name := range p.requesters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (p *Processor) GetRequester(name string) (Requester, bool) {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+
+	requester, exists := p.requesters[name]
+	return requester, exists
+}
+
+// SetPositionReference selects the registered requester whose latest position, if it implements
+// PositionProvider, is joined to every entry from the other requesters as Metadata["position"].
+func (p *Processor) SetPositionReference(name string) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.positionRef = name
+}
+
+// SetBackpressure keeps the entries of failed flushes in the buffer, to be written by the next
+// flush, instead of dropping them. While the writer fails, messages with a negative priority are
+// not requested and, beyond limit entries, entries of the lowest priority are dropped first, oldest
+// first. Zero drops the entries of a failed flush. Set it before Start.
+func (p *Processor) SetBackpressure(limit int) {
+	p.logMux.Lock()
+	defer p.logMux.Unlock()
+
+	p.maxBuffered = limit
+}
+
+// SetStatsInterval makes the processor log the statistics of every message type and requester as
+// "stats" entries every interval. Set it before Start.
+func (p *Processor) SetStatsInterval(interval time.Duration) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.statsInterval = interval
+}
+
+// Stats returns the rolling statistics of the entries the processor has logged.
+func (p *Processor) Stats() *Stats {
+	return p.statistics
+}
+
+// SetOutput sets where the processor prints the errors it carries on after, by default stdout. Set
+// it before Start.
+func (p *Processor) SetOutput(w io.Writer) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.output = w
+}
+
+// printf prints to the output of the processor.
+func (p *Processor) printf(format string, args ...interface{}) {
+	p.mux.RLock()
+	w := p.output
+	p.mux.RUnlock()
+
+	_, _ = fmt.Fprintf(w, format, args...)
+}
+
+// SetObserver sets the observer told about every entry and flush. Set it before Start.
+func (p *Processor) SetObserver(observer Observer) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.observer = observer
+}
+
+// GetPosition returns the latest position of the position reference.
+func (p *Processor) GetPosition() (Position, bool) {
+	p.mux.RLock()
+	requester, exists := p.requesters[p.positionRef]
+	p.mux.RUnlock()
+
+	if !exists {
+		return Position{}, false
+	}
+
+	provider, ok := requester.(PositionProvider)
+	if !ok {
+		return Position{}, false
+	}
+
+	return provider.LatestPosition()
+}
+
 func (p *Processor) AddMessage(m Message) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
 
+	if provider, ok := m.(HistoryProvider); ok && p.historyCapacity > 0 {
+		provider.History().SetLimits(p.historyCapacity, p.historyMaxAge)
+	}
 	p.messages.Add(m)
 }
 
+// SetHistoryLimits bounds the history of every message, current and added later, to capacity
+// entries and, if maxAge is set, to entries requested within maxAge.
+func (p *Processor) SetHistoryLimits(capacity int, maxAge time.Duration) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.historyCapacity, p.historyMaxAge = capacity, maxAge
+	for _, m := range p.messages.Values() {
+		if provider, ok := m.(HistoryProvider); ok {
+			provider.History().SetLimits(capacity, maxAge)
+		}
+	}
+}
+
 func (p *Processor) RemoveMessage(m Message) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
 
 	p.messages.Remove(m)
+	delete(p.options, m)
+}
+
+// SetMessageOptions replaces the options of m. It may be called before or after m is added.
+func (p *Processor) SetMessageOptions(m Message, options MessageOptions) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.options[m] = options
+}
+
+func (p *Processor) GetMessageOptions(m Message) MessageOptions {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+
+	return p.options[m]
+}
+
+// GetMessages returns the messages currently being requested, in no particular order.
+func (p *Processor) GetMessages() []Message {
+	return p.getMessages()
 }
 
 func (p *Processor) Start() {
@@ -94,38 +376,182 @@ func (p *Processor) loop() {
 	logTicker := time.NewTicker(p.writerInterval)
 	defer logTicker.Stop()
 
+	var statsTick <-chan time.Time
+	p.mux.RLock()
+	if p.statsInterval > 0 {
+		statsTicker := time.NewTicker(p.statsInterval)
+		defer statsTicker.Stop()
+		statsTick = statsTicker.C
+	}
+	p.mux.RUnlock()
+
 	for {
 		select {
 		case <-p.ctx.Done():
-			p.flushLogs()
+			if err := p.Flush(); err != nil {
+				p.printf("error writing logs: %v\n", err)
+			}
 			return
 		case <-ticker.C:
+			p.checkClock()
 			if err := p.request(); err != nil {
-				fmt.Printf("error processing: %v. Continuing...\n", err)
+				p.printf("error processing: %v. Continuing...\n", err)
 				continue
 			}
 		case <-logTicker.C:
-			p.flushLogs()
+			if err := p.Flush(); err != nil {
+				p.printf("error writing logs: %v\n", err)
+			}
+		case <-statsTick:
+			p.logStats()
 		}
 	}
 }
 
 func (p *Processor) request() error {
 	messages := p.getMessages()
+	p.expireRules()
 
 	var err error
 	for _, message := range messages {
-		log, e := message.Request(p)
+		if !p.due(message) {
+			continue
+		}
+
+		requester, exists := p.GetRequester(message.GetRequester())
+		if !exists {
+			err = multierr.Append(err, fmt.Errorf("no requester '%s' registered for %s", message.GetRequester(), message.GetType()))
+			continue
+		}
+
+		log, e := requester.Process(message)
 		if e != nil {
 			err = multierr.Append(err, e)
 		}
 
-		p.addLogEntry(log)
+		log = p.joinTags(message, p.joinPosition(message, log))
+		p.bufferEntry(log, p.GetMessageOptions(message).Priority)
+		p.statistics.Observe(message.GetRequester(), log)
+		p.applyRules(message, log)
+
+		if observer := p.getObserver(); observer != nil {
+			observer.ObserveEntry(message, log)
+		}
+	}
+
+	// Forget removed messages.
+	if len(p.lastRequest) > len(messages) {
+		current := hashset.New(messages...)
+		for message := range p.lastRequest {
+			if !current.Contains(message) {
+				delete(p.lastRequest, message)
+			}
+		}
 	}
 
 	return err
 }
 
+// due reports whether the message's interval, from an active rule, its options or its Scheduled
+// implementation, has elapsed since it was last requested, and records the request if so. Half a
+// polling interval of slack absorbs ticker jitter.
+func (p *Processor) due(message Message) bool {
+	now := time.Now()
+
+	options := p.GetMessageOptions(message)
+	if options.Priority < 0 && p.pressure.Load() {
+		return false
+	}
+
+	paused, interval := p.ruleInterval(message)
+	if paused {
+		return false
+	}
+	if interval == 0 {
+		interval = options.Interval
+	}
+	if scheduled, ok := message.(Scheduled); ok && interval == 0 {
+		interval = scheduled.GetInterval()
+	}
+
+	if last, requested := p.lastRequest[message]; requested && now.Sub(last)+p.pollingInterval/2 < interval {
+		return false
+	}
+
+	p.lastRequest[message] = now
+	return true
+}
+
+func (p *Processor) joinPosition(message Message, log LogEntry) LogEntry {
+	p.mux.RLock()
+	ref := p.positionRef
+	p.mux.RUnlock()
+
+	if ref == "" || message.GetRequester() == ref {
+		return log
+	}
+
+	position, ok := p.GetPosition()
+	if !ok {
+		return log
+	}
+
+	// Copy so the entry kept in the message's history is not modified.
+	metadata := make(map[string]interface{}, len(log.Metadata)+1)
+	for k, v := range log.Metadata {
+		metadata[k] = v
+	}
+	metadata["position"] = position
+	log.Metadata = metadata
+
+	return log
+}
+
+func (p *Processor) joinTags(message Message, log LogEntry) LogEntry {
+	tags := p.GetMessageOptions(message).Tags
+	if len(tags) == 0 {
+		return log
+	}
+
+	metadata := make(map[string]interface{}, len(log.Metadata)+1)
+	for k, v := range log.Metadata {
+		metadata[k] = v
+	}
+	metadata["tags"] = tags
+	log.Metadata = metadata
+
+	return log
+}
+
+// logStats adds a "stats" entry for every message type and requester, tagged with its scope and name.
+func (p *Processor) logStats() {
+	now := time.Now()
+	for _, scope := range []struct {
+		name      string
+		snapshots []StatsSnapshot
+	}{{"message", p.statistics.Messages()}, {"requester", p.statistics.Requesters()}} {
+		for _, snapshot := range scope.snapshots {
+			p.addLogEntry(LogEntry{
+				Index:        p.statsIndex,
+				MessageType:  "stats",
+				Success:      true,
+				Data:         snapshot,
+				Metadata:     map[string]interface{}{"tags": map[string]string{"scope": scope.name, "name": snapshot.Name}},
+				RequestTime:  now,
+				ResponseTime: now,
+			})
+			p.statsIndex++
+		}
+	}
+}
+
+func (p *Processor) getObserver() Observer {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+
+	return p.observer
+}
+
 func (p *Processor) getMessages() []Message {
 	p.mux.RLock()
 	defer p.mux.RUnlock()
@@ -133,33 +559,194 @@ func (p *Processor) getMessages() []Message {
 	return p.messages.Values()
 }
 
+// addLogEntry adds an entry of the processor's own, e.g. a rule event, at the default priority.
 func (p *Processor) addLogEntry(entry LogEntry) {
+	p.bufferEntry(entry, 0)
+}
+
+// bufferEntry adds an entry to the buffer, recording it in the write-ahead log, and flushes the
+// buffer if it is full. priority decides when it is dropped under backpressure.
+func (p *Processor) bufferEntry(entry LogEntry, priority int) {
+	if entry.SessionOffset == 0 {
+		entry.SessionOffset = p.sessionOffset(entry.RequestTime)
+	}
+
 	p.logMux.Lock()
 	defer p.logMux.Unlock()
 
 	p.logBuffer = append(p.logBuffer, entry)
+	p.logPriorities = append(p.logPriorities, priority)
 
-	if len(p.logBuffer) >= p.logBatchSize {
-		p.flushLogsUnsafe()
+	if p.wal != nil {
+		if err := p.wal.append(entry); err != nil {
+			p.printf("error writing to write-ahead log: %v\n", err)
+		}
+	}
+
+	if len(p.logBuffer) >= p.flushAt {
+		if err := p.flushLogsUnsafe(); err != nil {
+			p.printf("error writing logs: %v\n", err)
+		}
 	}
 }
 
-func (p *Processor) flushLogs() {
+// Flush writes the buffered entries now instead of waiting for the writer interval or a full buffer.
+func (p *Processor) Flush() error {
 	p.logMux.Lock()
 	defer p.logMux.Unlock()
-	p.flushLogsUnsafe()
+
+	return p.flushLogsUnsafe()
 }
 
-func (p *Processor) flushLogsUnsafe() {
+// Rotate flushes the buffered entries and starts a new output file, if the writer is a Rotator.
+func (p *Processor) Rotate() error {
+	p.logMux.Lock()
+	defer p.logMux.Unlock()
+
+	rotator, ok := p.writer.(Rotator)
+	if !ok {
+		return fmt.Errorf("writer does not support rotation")
+	}
+
+	if err := p.flushLogsUnsafe(); err != nil {
+		return err
+	}
+
+	return rotator.Rotate()
+}
+
+// GetWriterStats returns the state of the output. It does not wait for a flush in progress.
+func (p *Processor) GetWriterStats() WriterStats {
+	p.statsMux.Lock()
+	stats := p.stats
+	p.statsMux.Unlock()
+
+	stats.Capacity = p.logBatchSize
+	stats.Backpressure = p.pressure.Load()
+	if p.logMux.TryLock() {
+		stats.Buffered = len(p.logBuffer)
+		p.logMux.Unlock()
+	}
+
+	if files, ok := p.writer.(FileWriter); ok {
+		stats.Files = files.Files()
+	}
+	if counter, ok := p.writer.(ByteCounter); ok {
+		stats.Bytes = counter.BytesWritten()
+	}
+
+	return stats
+}
+
+func (p *Processor) flushLogsUnsafe() error {
 	if len(p.logBuffer) == 0 {
-		return
+		return nil
+	}
+
+	start := time.Now()
+	err := p.writer.Write(p.logBuffer)
+	duration := time.Since(start)
+
+	if observer := p.getObserver(); observer != nil {
+		observer.ObserveFlush(len(p.logBuffer), duration, err)
+	}
+
+	p.statsMux.Lock()
+	if delivered(err) {
+		p.stats.Written += uint64(len(p.logBuffer))
+	}
+	p.stats.LastFlush = start
+	p.stats.FlushDuration = duration
+	p.stats.LastError = ""
+	if err != nil {
+		p.stats.LastError = err.Error()
+	}
+	p.statsMux.Unlock()
+
+	if delivered(err) {
+		if p.wal != nil {
+			// A writer missed the batch; the next session replays it to every writer.
+			if errors.Is(err, ErrPartialWrite) {
+				if e := p.wal.keep(p.logBuffer); e != nil {
+					p.printf("error keeping partially written entries in write-ahead log: %v\n", e)
+				}
+			}
+			if e := p.wal.clear(); e != nil {
+				p.printf("error truncating write-ahead log: %v\n", e)
+			}
+		}
+
+		p.logBuffer = make([]LogEntry, 0, p.logBatchSize) // Reset buffer
+		p.logPriorities = p.logPriorities[:0]
+		p.flushAt = p.logBatchSize
+		p.pressure.Store(false)
+		return err
 	}
 
-	if err := p.writer.Write(p.logBuffer); err != nil {
-		fmt.Printf("error writing logs: %v\n", err)
+	var dropped []LogEntry
+	if p.maxBuffered > 0 {
+		// Keep the entries for the next flush; until then, only a full batch more triggers one.
+		dropped = p.trimBufferUnsafe()
+		p.flushAt = len(p.logBuffer) + p.logBatchSize
+		p.pressure.Store(true)
+	} else {
+		dropped = p.logBuffer
+		p.logBuffer = make([]LogEntry, 0, p.logBatchSize)
+		p.logPriorities = p.logPriorities[:0]
 	}
 
-	p.logBuffer = make([]LogEntry, 0, p.logBatchSize) // Reset buffer
+	// The next successful flush truncates the write-ahead log, which must not lose these.
+	if p.wal != nil && len(dropped) > 0 {
+		if e := p.wal.keep(dropped); e != nil {
+			p.printf("error keeping dropped entries in write-ahead log: %v\n", e)
+		}
+	}
+
+	p.statsMux.Lock()
+	p.stats.Dropped += uint64(len(dropped))
+	p.statsMux.Unlock()
+
+	if len(dropped) > 0 {
+		return fmt.Errorf("%w; %d entries dropped", err, len(dropped))
+	}
+	return err
+}
+
+// trimBufferUnsafe drops the entries beyond maxBuffered, those of the lowest priority first and the
+// oldest first among equals, and returns them.
+func (p *Processor) trimBufferUnsafe() []LogEntry {
+	excess := len(p.logBuffer) - p.maxBuffered
+	if excess <= 0 {
+		return nil
+	}
+
+	order := make([]int, len(p.logBuffer))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return p.logPriorities[order[i]] < p.logPriorities[order[j]]
+	})
+
+	drop := make(map[int]bool, excess)
+	for _, i := range order[:excess] {
+		drop[i] = true
+	}
+
+	kept := make([]LogEntry, 0, p.maxBuffered)
+	priorities := make([]int, 0, p.maxBuffered)
+	dropped := make([]LogEntry, 0, excess)
+	for i, entry := range p.logBuffer {
+		if drop[i] {
+			dropped = append(dropped, entry)
+		} else {
+			kept = append(kept, entry)
+			priorities = append(priorities, p.logPriorities[i])
+		}
+	}
+	p.logBuffer, p.logPriorities = kept, priorities
+
+	return dropped
 }
 
 func (p *Processor) Close() error {
@@ -172,11 +759,25 @@ func (p *Processor) Close() error {
 
 		p.wg.Wait()
 
+		for name, requester := range p.requesters {
+			if closer, ok := requester.(io.Closer); ok {
+				if e := closer.Close(); e != nil {
+					err = multierr.Append(err, fmt.Errorf("error closing requester '%s': %w", name, e))
+				}
+			}
+		}
+
 		if p.writer != nil {
 			if e := p.writer.Close(); e != nil {
 				err = multierr.Append(err, fmt.Errorf("error closing writer: %w", e))
 			}
 		}
+
+		if p.wal != nil {
+			if e := p.wal.close(); e != nil {
+				err = multierr.Append(err, fmt.Errorf("error closing write-ahead log: %w", e))
+			}
+		}
 	})
 
 	if err != nil {
@@ -190,48 +791,15 @@ func (p *Processor) Close() error {
 // HELPER FUNCTIONS
 // ========================
 
+// GetSuccessRate returns the percentage of successful entries of a message type in this session.
 func (p *Processor) GetSuccessRate(messageType string) float64 {
-	messages := p.getMessages()
-
-	var total, successful int
-	for _, message := range messages {
-		if message.GetType() == messageType {
-			entries := message.GetAllEntries()
-			total += len(entries)
-			for _, entry := range entries {
-				if entry.Success {
-					successful++
-				}
-			}
-		}
-	}
-
-	if total == 0 {
-		return 0
-	}
-	return float64(successful) / float64(total) * 100
+	stats, _ := p.statistics.Message(messageType)
+	return stats.SuccessRate
 }
 
+// GetAverageResponseTime returns the mean latency of successful entries of a message type in this
+// session.
 func (p *Processor) GetAverageResponseTime(messageType string) time.Duration {
-	messages := p.getMessages()
-
-	var totalDuration time.Duration
-	var count int
-
-	for _, message := range messages {
-		if message.GetType() == messageType {
-			entries := message.GetAllEntries()
-			for _, entry := range entries {
-				if entry.Success && entry.Duration > 0 {
-					totalDuration += entry.Duration
-					count++
-				}
-			}
-		}
-	}
-
-	if count == 0 {
-		return 0
-	}
-	return totalDuration / time.Duration(count)
+	stats, _ := p.statistics.Message(messageType)
+	return stats.LatencyMean
 }
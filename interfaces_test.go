@@ -0,0 +1,147 @@
+package cellularlog
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echo answers every message with a successful entry of its type and index.
+type echo struct{ index uint64 }
+
+func (r *echo) Process(message Message) (LogEntry, error) {
+	return LogEntry{Index: r.index, MessageType: message.GetType(), Success: true, RequestTime: time.Now()}, nil
+}
+
+func TestRegisterRequester(t *testing.T) {
+	p := NewProcessor(context.Background(), time.Hour, time.Hour, &memoryWriter{}, 100)
+	first := &echo{index: 1}
+	for _, name := range []string{"nmea", "modem", "mavlink"} {
+		if err := p.RegisterRequester(name, first); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := p.RegisterRequester("modem", &echo{index: 2}); err == nil || !strings.Contains(err.Error(), "'modem' already registered") {
+		t.Errorf("expected registering a name twice to fail, got %v", err)
+	}
+	if requester, _ := p.GetRequester("modem"); requester != first {
+		t.Error("expected the first requester to stay registered")
+	}
+	if names := p.GetRequesterNames(); !slices.Equal(names, []string{"mavlink", "modem", "nmea"}) {
+		t.Errorf("expected the names sorted, got %v", names)
+	}
+}
+
+func TestRequestWithoutRequester(t *testing.T) {
+	orphan := &stub{requester: "gpsd", messageType: "gpsd-TPV"}
+	csq := &stub{requester: "modem", messageType: "at-+CSQ"}
+	p := NewProcessor(context.Background(), time.Hour, time.Hour, &memoryWriter{}, 100, orphan, csq)
+	if err := p.RegisterRequester("modem", &echo{index: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := p.request()
+	if err == nil || !strings.Contains(err.Error(), "no requester 'gpsd' registered for gpsd-TPV") {
+		t.Errorf("expected an error for the unregistered requester, got %v", err)
+	}
+	if got := p.logBuffer; len(got) != 1 || got[0].MessageType != csq.messageType {
+		t.Errorf("expected only the registered requester's entry, got %+v", got)
+	}
+}
+
+func TestTrimBuffer(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		priorities []int // of entries 1, 2, ...
+		limit      int
+		kept       []uint64
+		dropped    []uint64
+	}{
+		{"under the limit", []int{0, 0}, 2, []uint64{1, 2}, nil},
+		{"oldest first", []int{0, 0, 0, 0}, 2, []uint64{3, 4}, []uint64{1, 2}},
+		{"lowest priority first", []int{1, 0, 2, 0}, 2, []uint64{1, 3}, []uint64{2, 4}},
+		{"negative priorities", []int{0, -1, 0}, 2, []uint64{1, 3}, []uint64{2}},
+		{"oldest of a priority", []int{1, 0, 1, 1}, 2, []uint64{3, 4}, []uint64{1, 2}},
+	} {
+		p := &Processor{maxBuffered: tc.limit}
+		for i, priority := range tc.priorities {
+			p.logBuffer = append(p.logBuffer, LogEntry{Index: uint64(i + 1), MessageType: "at"})
+			p.logPriorities = append(p.logPriorities, priority)
+		}
+
+		dropped := p.trimBufferUnsafe()
+		if got := indexes(p.logBuffer); !slices.Equal(got, tc.kept) || !slices.Equal(indexes(dropped), tc.dropped) {
+			t.Errorf("%s: expected %v kept and %v dropped, got %v and %v", tc.name, tc.kept, tc.dropped, got, indexes(dropped))
+		}
+		if len(p.logPriorities) != len(p.logBuffer) {
+			t.Errorf("%s: %d priorities left for %d entries", tc.name, len(p.logPriorities), len(p.logBuffer))
+		}
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		limit    int
+		buffered int // after a failed flush of 4 entries
+		dropped  uint64
+		pressure bool
+	}{
+		{"without backpressure", 0, 0, 4, false},
+		{"within the limit", 10, 4, 0, true},
+		{"beyond the limit", 3, 3, 1, true},
+	} {
+		writer := &memoryWriter{fail: true}
+		p := NewProcessor(context.Background(), time.Hour, time.Hour, writer, 100)
+		p.SetBackpressure(tc.limit)
+		addEntries(p, 1, 2, 3, 4)
+
+		if err := p.Flush(); err == nil {
+			t.Fatalf("%s: expected the flush to fail", tc.name)
+		}
+		stats := p.GetWriterStats()
+		if stats.Buffered != tc.buffered || stats.Dropped != tc.dropped || stats.Backpressure != tc.pressure {
+			t.Errorf("%s: expected %d buffered and %d dropped, backpressure %v, got %+v", tc.name, tc.buffered, tc.dropped, tc.pressure, stats)
+		}
+		if tc.pressure && p.flushAt != tc.buffered+100 {
+			t.Errorf("%s: expected the next flush after a further batch, at %d, got %d", tc.name, tc.buffered+100, p.flushAt)
+		}
+
+		writer.fail = false
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if len(writer.entries) != tc.buffered || p.GetWriterStats().Backpressure {
+			t.Errorf("%s: expected the kept entries written and the backpressure released, got %v", tc.name, indexes(writer.entries))
+		}
+	}
+}
+
+func TestBackpressureByMessagePriority(t *testing.T) {
+	// Two requesters answer the same message type, at different priorities.
+	modem := &stub{requester: "modem", messageType: "at-+CSQ"}
+	backup := &stub{requester: "backup", messageType: "at-+CSQ"}
+
+	p := NewProcessor(context.Background(), time.Hour, time.Hour, &memoryWriter{fail: true}, 100, modem, backup)
+	if err := p.RegisterRequester("modem", &echo{index: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.RegisterRequester("backup", &echo{index: 2}); err != nil {
+		t.Fatal(err)
+	}
+	p.SetMessageOptions(modem, MessageOptions{Priority: 1})
+	p.SetBackpressure(1)
+
+	if err := p.request(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err == nil {
+		t.Fatal("expected the flush to fail")
+	}
+	if got := indexes(p.logBuffer); !slices.Equal(got, []uint64{1}) {
+		t.Errorf("expected the entry of the higher priority message to be kept, got %v", got)
+	}
+}
@@ -0,0 +1,264 @@
+// Package diskqueue is a persistent FIFO of byte records kept in a directory, for data that has to
+// survive an outage or a restart until it can be delivered.
+//
+// Records are appended to a data file, each framed by its length and CRC32; the position of the
+// oldest undelivered record is kept in a separate offset file. A record torn by a crash is dropped
+// when the queue is opened again. The data file is truncated whenever the queue drains.
+package diskqueue
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/harshabose/cellular_localisation_logging/internal/multierr"
+)
+
+const (
+	dataFile   = "queue.dat"
+	offsetFile = "queue.off"
+	headerSize = 8 // length, crc32
+)
+
+// ErrFull is returned by Push when the records do not fit within the size limit.
+var ErrFull = errors.New("queue is full")
+
+type Queue struct {
+	data   *os.File
+	offset *os.File
+	read   int64 // position of the oldest record
+	size   int64 // end of the last record
+	max    int64
+	mux    sync.Mutex
+}
+
+// Open opens or creates the queue in dir. maxSize limits the data file in bytes, zero for no limit.
+func Open(dir string, maxSize int64) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	data, err := os.OpenFile(filepath.Join(dir, dataFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	offset, err := os.OpenFile(filepath.Join(dir, offsetFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		_ = data.Close()
+		return nil, err
+	}
+
+	q := &Queue{data: data, offset: offset, max: maxSize}
+	if err := q.recover(); err != nil {
+		_ = q.Close()
+		return nil, fmt.Errorf("error recovering queue in %s: %w", dir, err)
+	}
+
+	return q, nil
+}
+
+// recover finds the read position and the end of the last intact record.
+func (q *Queue) recover() error {
+	var buf [8]byte
+	if _, err := q.offset.ReadAt(buf[:], 0); err == nil {
+		q.read = int64(binary.BigEndian.Uint64(buf[:]))
+	} else if !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	info, err := q.data.Stat()
+	if err != nil {
+		return err
+	}
+	if q.read > info.Size() {
+		q.read = 0
+	}
+
+	q.size = q.read
+	for q.size < info.Size() {
+		record, err := q.readAt(q.size, info.Size())
+		if err != nil {
+			break // torn or corrupt tail
+		}
+		q.size += headerSize + int64(len(record))
+	}
+
+	if q.size < info.Size() {
+		if err := q.data.Truncate(q.size); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readAt reads the record at position, which must end before end.
+func (q *Queue) readAt(position, end int64) ([]byte, error) {
+	var header [headerSize]byte
+	if _, err := q.data.ReadAt(header[:], position); err != nil {
+		return nil, err
+	}
+
+	length := int64(binary.BigEndian.Uint32(header[:4]))
+	if position+headerSize+length > end {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	record := make([]byte, length)
+	if _, err := q.data.ReadAt(record, position+headerSize); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(record) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, errors.New("checksum mismatch")
+	}
+
+	return record, nil
+}
+
+// Push appends records, all of them or, if they do not fit, none.
+func (q *Queue) Push(records ...[]byte) error {
+	var length int64
+	for _, record := range records {
+		length += headerSize + int64(len(record))
+	}
+
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if q.max > 0 && q.size+length > q.max {
+		return ErrFull
+	}
+
+	buf := make([]byte, 0, length)
+	for _, record := range records {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(record)))
+		buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(record))
+		buf = append(buf, record...)
+	}
+
+	if _, err := q.data.WriteAt(buf, q.size); err != nil {
+		return err
+	}
+	q.size += length
+
+	return nil
+}
+
+// Peek returns the oldest record without removing it; false if the queue is empty.
+func (q *Queue) Peek() ([]byte, bool, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if q.read >= q.size {
+		return nil, false, nil
+	}
+
+	record, err := q.readAt(q.read, q.size)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return record, true, nil
+}
+
+// Pop removes the oldest record, the one Peek returns.
+func (q *Queue) Pop() error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if q.read >= q.size {
+		return nil
+	}
+
+	var header [headerSize]byte
+	if _, err := q.data.ReadAt(header[:], q.read); err != nil {
+		return err
+	}
+	q.read += headerSize + int64(binary.BigEndian.Uint32(header[:4]))
+
+	if q.read >= q.size {
+		if err := q.data.Truncate(0); err != nil {
+			return err
+		}
+		q.read, q.size = 0, 0
+	}
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(q.read))
+	_, err := q.offset.WriteAt(buf[:], 0)
+
+	return err
+}
+
+// Records returns every undelivered record, oldest first, without removing them.
+func (q *Queue) Records() ([][]byte, error) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	var records [][]byte
+	for position := q.read; position < q.size; {
+		record, err := q.readAt(position, q.size)
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+		position += headerSize + int64(len(record))
+	}
+
+	return records, nil
+}
+
+// Clear removes every record.
+func (q *Queue) Clear() error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	if q.size == 0 {
+		return nil
+	}
+	if err := q.data.Truncate(0); err != nil {
+		return err
+	}
+	q.read, q.size = 0, 0
+
+	var buf [8]byte
+	_, err := q.offset.WriteAt(buf[:], 0)
+	return err
+}
+
+// Sync commits the queue to stable storage.
+func (q *Queue) Sync() error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	return multierr.Combine(q.data.Sync(), q.offset.Sync())
+}
+
+// Empty reports whether there is no record to deliver.
+func (q *Queue) Empty() bool {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	return q.read >= q.size
+}
+
+// Size returns the bytes taken by undelivered records.
+func (q *Queue) Size() int64 {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	return q.size - q.read
+}
+
+func (q *Queue) Close() error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	return multierr.Combine(q.data.Close(), q.offset.Close())
+}
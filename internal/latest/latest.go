@@ -0,0 +1,121 @@
+// Package latest keeps the most recent value of each kind received from a streaming source, such as
+// a GNSS receiver, and lets pollers wait for a value they have not seen yet.
+package latest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrStopped is returned by Next once the source has stopped and no unseen value is left.
+var ErrStopped = errors.New("source stopped")
+
+// Value is a stored value with its sequence number, which increases with every Put across all
+// kinds, and the time it was stored.
+type Value struct {
+	Data interface{}
+	Seq  uint64
+	Time time.Time
+}
+
+type Store struct {
+	values  map[string]Value
+	seq     uint64
+	last    time.Time
+	updated chan struct{} // closed and replaced on every Put
+	done    chan struct{}
+	err     error
+	once    sync.Once
+	mux     sync.Mutex
+}
+
+func New() *Store {
+	return &Store{
+		values:  make(map[string]Value),
+		updated: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (s *Store) Put(kind string, data interface{}) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.seq++
+	s.last = time.Now()
+	s.values[kind] = Value{Data: data, Seq: s.seq, Time: s.last}
+
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+// Updated returns when the newest value of any kind was stored, zero if none has been.
+func (s *Store) Updated() time.Time {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.last
+}
+
+// Get returns the latest value of kind, seen or not.
+func (s *Store) Get(kind string) (Value, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	v, ok := s.values[kind]
+	return v, ok
+}
+
+// Next returns the latest value of kind if its sequence number is greater than after, waiting for
+// one until ctx is done or the source stops.
+func (s *Store) Next(ctx context.Context, kind string, after uint64) (Value, error) {
+	for {
+		s.mux.Lock()
+		v, ok := s.values[kind]
+		updated := s.updated
+		s.mux.Unlock()
+
+		if ok && v.Seq > after {
+			return v, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return Value{}, ctx.Err()
+		case <-s.done:
+			return Value{}, fmt.Errorf("%w: %w", ErrStopped, s.Err())
+		case <-updated:
+		}
+	}
+}
+
+// Stop marks the source as ended with err (io.EOF if nil). Only the first call has an effect.
+func (s *Store) Stop(err error) {
+	s.once.Do(func() {
+		if err == nil {
+			err = io.EOF
+		}
+
+		s.mux.Lock()
+		s.err = err
+		s.mux.Unlock()
+
+		close(s.done)
+	})
+}
+
+// Done is closed once Stop has been called.
+func (s *Store) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Store) Err() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.err
+}
@@ -0,0 +1,93 @@
+// Package sketch estimates quantiles of a stream of non-negative values in constant memory, with a
+// bounded relative error, in the manner of DDSketch: values are counted in buckets whose bounds grow
+// geometrically, so every estimate is within the relative accuracy of a value of the stream.
+package sketch
+
+import (
+	"math"
+	"sort"
+)
+
+// Sketch is not safe for concurrent use.
+type Sketch struct {
+	gamma    float64
+	logGamma float64
+	buckets  map[int]uint64
+	zeros    uint64 // values of zero, which have no bucket
+	count    uint64
+	sum      float64
+	max      float64
+}
+
+// New returns a sketch whose quantiles are within relativeAccuracy, e.g. 0.01 for 1%, of the true
+// value. Values outside (0, 1) select 1%.
+func New(relativeAccuracy float64) *Sketch {
+	if relativeAccuracy <= 0 || relativeAccuracy >= 1 {
+		relativeAccuracy = 0.01
+	}
+
+	gamma := (1 + relativeAccuracy) / (1 - relativeAccuracy)
+	return &Sketch{gamma: gamma, logGamma: math.Log(gamma), buckets: make(map[int]uint64)}
+}
+
+// Add counts v. Negative values and NaN are ignored.
+func (s *Sketch) Add(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) || v < 0 {
+		return
+	}
+
+	s.count++
+	s.sum += v
+	s.max = math.Max(s.max, v)
+
+	if v == 0 {
+		s.zeros++
+		return
+	}
+	s.buckets[int(math.Ceil(math.Log(v)/s.logGamma))]++
+}
+
+// Quantile returns an estimate of the q-quantile, q between 0 and 1, or 0 if nothing was added.
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Max(0, math.Min(1, q)) * float64(s.count-1))
+	if rank < s.zeros {
+		return 0
+	}
+
+	keys := make([]int, 0, len(s.buckets))
+	for k := range s.buckets {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	seen := s.zeros
+	for _, k := range keys {
+		seen += s.buckets[k]
+		if seen > rank {
+			// The middle of the bucket (gamma^(k-1), gamma^k] in relative terms.
+			return math.Min(2*math.Pow(s.gamma, float64(k))/(s.gamma+1), s.max)
+		}
+	}
+	return s.max
+}
+
+func (s *Sketch) Count() uint64 {
+	return s.count
+}
+
+// Mean returns the exact mean of the values added, or 0 if nothing was added.
+func (s *Sketch) Mean() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return s.sum / float64(s.count)
+}
+
+// Max returns the exact largest value added.
+func (s *Sketch) Max() float64 {
+	return s.max
+}
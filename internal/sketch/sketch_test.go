@@ -0,0 +1,59 @@
+package sketch_test
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/harshabose/cellular_localisation_logging/internal/sketch"
+)
+
+func TestQuantileRelativeError(t *testing.T) {
+	const accuracy = 0.01
+	s := sketch.New(accuracy)
+	r := rand.New(rand.NewSource(1))
+
+	values := make([]float64, 10000)
+	for i := range values {
+		values[i] = math.Exp(r.NormFloat64()*2 + 15) // latencies in nanoseconds, over several decades
+		s.Add(values[i])
+	}
+	sort.Float64s(values)
+
+	for _, q := range []float64{0, 0.1, 0.5, 0.9, 0.99, 0.999, 1} {
+		want := values[int(q*float64(len(values)-1))]
+		if got := s.Quantile(q); math.Abs(got-want) > accuracy*want {
+			t.Errorf("q%v: expected within 1%% of %v, got %v", q, want, got)
+		}
+	}
+	if s.Count() != uint64(len(values)) {
+		t.Errorf("expected %d values, got %d", len(values), s.Count())
+	}
+}
+
+func TestQuantileZerosAndIgnoredValues(t *testing.T) {
+	s := sketch.New(0.01)
+	if s.Quantile(0.5) != 0 || s.Mean() != 0 {
+		t.Error("expected zero for an empty sketch")
+	}
+
+	for _, v := range []float64{0, 0, 0, 100, 200, -5, math.NaN(), math.Inf(1)} {
+		s.Add(v)
+	}
+	if s.Count() != 5 {
+		t.Errorf("expected negative, NaN and infinite values to be ignored, got %d values", s.Count())
+	}
+	if s.Quantile(0.5) != 0 {
+		t.Errorf("expected the median to be zero, got %v", s.Quantile(0.5))
+	}
+	if got := s.Quantile(0.75); math.Abs(got-100) > 1 {
+		t.Errorf("expected q0.75 within 1%% of 100, got %v", got)
+	}
+	if got := s.Quantile(2); got > 200 || got < 198 {
+		t.Errorf("expected the top quantile within 1%% of the maximum and not above it, got %v", got)
+	}
+	if s.Mean() != 60 || s.Max() != 200 {
+		t.Errorf("expected an exact mean of 60 and maximum of 200, got %v and %v", s.Mean(), s.Max())
+	}
+}
@@ -4,10 +4,13 @@ import (
 	"encoding/binary"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"reflect"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -25,10 +28,64 @@ type LogEntry struct {
 	RequestTime  time.Time              `json:"request_time,omitempty"`
 	ResponseTime time.Time              `json:"response_time,omitempty"`
 	Duration     time.Duration          `json:"duration,omitempty"`
+
+	// SessionOffset is when the entry was requested, as the time since the session started on the
+	// monotonic clock. Unlike RequestTime, it is not thrown off when the wall clock is stepped.
+	SessionOffset time.Duration `json:"session_offset,omitempty"`
+}
+
+// ErrorClass groups a failed entry by its cause: "timeout", "cancelled", "mismatch" (the message was
+// given to the wrong requester), "cme" (the modem answered +CME or +CMS ERROR), "parse", "io" (the
+// device or connection failed) or "other". It is empty for successful entries.
+func ErrorClass(entry LogEntry) string {
+	if entry.Success {
+		return ""
+	}
+
+	err := strings.ToLower(entry.Error)
+	switch {
+	case strings.Contains(err, "timeout") || strings.Contains(err, "deadline exceeded"):
+		return "timeout"
+	case strings.Contains(err, "context cancelled") || strings.Contains(err, "context canceled"):
+		return "cancelled"
+	case strings.Contains(err, "interface mismatch"):
+		return "mismatch"
+	case strings.Contains(err, "cme error") || strings.Contains(err, "cms error"):
+		return "cme"
+	case strings.Contains(err, "pars"):
+		return "parse"
+	case strings.Contains(err, "i/o") || strings.Contains(err, "input/output") || strings.Contains(err, "eof") ||
+		strings.Contains(err, "broken pipe") || strings.Contains(err, "connection") || strings.Contains(err, "closed"):
+		return "io"
+	default:
+		return "other"
+	}
+}
+
+// fileOffset is the number of bytes written to a file created by the writer: its offset, or what a
+// wrapping OutputFile counted before it was encoded.
+func fileOffset(file OutputFile) uint64 {
+	if counter, ok := file.(ByteCounter); ok {
+		return counter.BytesWritten()
+	}
+	if seeker, ok := file.(io.Seeker); ok {
+		if offset, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			return uint64(offset)
+		}
+	}
+	return 0
+}
+
+// FlushFile flushes file if it is a Flusher, at the end of a writer's batch.
+func FlushFile(file OutputFile) error {
+	if flusher, ok := file.(Flusher); ok {
+		return flusher.Flush()
+	}
+	return nil
 }
 
 type JSONWriter struct {
-	file    *os.File
+	file    OutputFile
 	encoder *json.Encoder
 	mu      sync.Mutex
 }
@@ -39,10 +96,15 @@ func NewJSONWriter(filename string) (*JSONWriter, error) {
 		return nil, err
 	}
 
+	return NewJSONWriterTo(file), nil
+}
+
+// NewJSONWriterTo writes to an open file, e.g. an encrypted one.
+func NewJSONWriterTo(file OutputFile) *JSONWriter {
 	return &JSONWriter{
 		file:    file,
 		encoder: json.NewEncoder(file),
-	}, nil
+	}
 }
 
 func (w *JSONWriter) Write(entries []LogEntry) error {
@@ -55,7 +117,18 @@ func (w *JSONWriter) Write(entries []LogEntry) error {
 		}
 	}
 
-	return nil
+	return FlushFile(w.file)
+}
+
+func (w *JSONWriter) Files() []string {
+	return []string{w.file.Name()}
+}
+
+func (w *JSONWriter) BytesWritten() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return fileOffset(w.file)
 }
 
 func (w *JSONWriter) Close() error {
@@ -63,7 +136,7 @@ func (w *JSONWriter) Close() error {
 }
 
 type CSVWriter struct {
-	file   *os.File
+	file   OutputFile
 	writer *csv.Writer
 	mu     sync.Mutex
 	header bool
@@ -75,69 +148,239 @@ func NewCSVWriter(filename string) (*CSVWriter, error) {
 		return nil, err
 	}
 
+	return NewCSVWriterTo(file), nil
+}
+
+// NewCSVWriterTo writes to an open file, e.g. an encrypted one.
+func NewCSVWriterTo(file OutputFile) *CSVWriter {
 	return &CSVWriter{
 		file:   file,
 		writer: csv.NewWriter(file),
-	}, nil
+	}
 }
 
 func (w *CSVWriter) Write(entries []LogEntry) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	if !w.header {
-		header := []string{
-			"index", "message_type", "message_id", "timestamp",
-			"success", "data", "error", "request_time",
-			"response_time", "duration_ms",
-		}
-		if err := w.writer.Write(header); err != nil {
-			return err
-		}
-		w.header = true
+	if err := w.writeColumns(); err != nil {
+		return err
 	}
 
 	for _, entry := range entries {
-		record := []string{
-			fmt.Sprintf("%d", entry.Index),
-			entry.MessageType,
-			fmt.Sprintf("%v", entry.MessageID),
-			fmt.Sprintf("%t", entry.Success),
-			fmt.Sprintf("%v", entry.Data),
-			entry.Error,
-			entry.RequestTime.Format(time.RFC3339Nano),
-			entry.ResponseTime.Format(time.RFC3339Nano),
-			fmt.Sprintf("%.2f", float64(entry.Duration.Nanoseconds())/1e6),
-		}
-		if err := w.writer.Write(record); err != nil {
+		if err := w.writer.Write(csvRecord(entry, fmt.Sprintf("%v", entry.Data))); err != nil {
 			return err
 		}
 	}
 
+	return w.flush()
+}
+
+// WriteHeader writes the session header as the first row, with its data as JSON.
+func (w *CSVWriter) WriteHeader(header LogEntry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data, err := json.Marshal(header.Data)
+	if err != nil {
+		return fmt.Errorf("failed to encode session header: %w", err)
+	}
+
+	if err := w.writeColumns(); err != nil {
+		return err
+	}
+	if err := w.writer.Write(csvRecord(header, string(data))); err != nil {
+		return err
+	}
+
+	return w.flush()
+}
+
+func (w *CSVWriter) flush() error {
 	w.writer.Flush()
-	return w.writer.Error()
+	if err := w.writer.Error(); err != nil {
+		return err
+	}
+	return FlushFile(w.file)
+}
+
+func (w *CSVWriter) writeColumns() error {
+	if w.header {
+		return nil
+	}
+
+	header := []string{
+		"index", "message_type", "message_id", "timestamp",
+		"success", "data", "error", "request_time",
+		"response_time", "duration_ms", "session_offset_ms",
+	}
+	if err := w.writer.Write(header); err != nil {
+		return err
+	}
+	w.header = true
+
+	return nil
+}
+
+func csvRecord(entry LogEntry, data string) []string {
+	return []string{
+		fmt.Sprintf("%d", entry.Index),
+		entry.MessageType,
+		fmt.Sprintf("%v", entry.MessageID),
+		fmt.Sprintf("%t", entry.Success),
+		data,
+		entry.Error,
+		entry.RequestTime.Format(time.RFC3339Nano),
+		entry.ResponseTime.Format(time.RFC3339Nano),
+		fmt.Sprintf("%.2f", float64(entry.Duration.Nanoseconds())/1e6),
+		fmt.Sprintf("%.2f", float64(entry.SessionOffset.Nanoseconds())/1e6),
+	}
+}
+
+func (w *CSVWriter) Files() []string {
+	return []string{w.file.Name()}
+}
+
+// BytesWritten does not include records still buffered by a failed Write.
+func (w *CSVWriter) BytesWritten() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return fileOffset(w.file)
 }
 
 func (w *CSVWriter) Close() error {
 	w.writer.Flush()
-	return w.file.Close()
+	return multierr.Combine(w.writer.Error(), w.file.Close())
 }
 
+// MultiWriter writes every batch to each of its writers. A writer that fails is marked unhealthy
+// and skipped, while the others continue, until its backoff (1s, doubling up to 1m) has passed.
 type MultiWriter struct {
 	writers []Writer
+	health  []WriterHealth
+	mux     sync.Mutex
 }
 
+// WriterHealth is the state of one writer of a MultiWriter.
+type WriterHealth struct {
+	Healthy   bool
+	Failures  int       // consecutive
+	LastError string    // of the last failure
+	RetryAt   time.Time // when an unhealthy writer is tried again
+}
+
+const (
+	unhealthyBackoff    = time.Second
+	unhealthyMaxBackoff = time.Minute
+)
+
 func NewMultiWriter(writers ...Writer) *MultiWriter {
-	return &MultiWriter{writers: writers}
+	health := make([]WriterHealth, len(writers))
+	for i := range health {
+		health[i].Healthy = true
+	}
+
+	return &MultiWriter{writers: writers, health: health}
 }
 
+// Write returns an error wrapping ErrPartialWrite if some writers took the batch and others did
+// not, and the combined errors if none did.
 func (w *MultiWriter) Write(entries []LogEntry) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	var (
+		err  error
+		took int
+		now  = time.Now()
+	)
+	for i, writer := range w.writers {
+		health := &w.health[i]
+		if !health.Healthy && now.Before(health.RetryAt) {
+			err = multierr.Append(err, fmt.Errorf("writer %d unhealthy until %s: %s", i, health.RetryAt.Format(time.TimeOnly), health.LastError))
+			continue
+		}
+
+		e := writer.Write(entries)
+		if e == nil || errors.Is(e, ErrSpilled) {
+			took++
+			*health = WriterHealth{Healthy: true}
+		} else {
+			health.Healthy = false
+			health.Failures++
+			health.LastError = e.Error()
+			health.RetryAt = now.Add(backoffDelay(unhealthyBackoff, unhealthyMaxBackoff, health.Failures))
+		}
+		err = multierr.Append(err, e)
+	}
+
+	if took > 0 && took < len(w.writers) {
+		return fmt.Errorf("%w: %w", ErrPartialWrite, err)
+	}
+	return err
+}
+
+// WriteHeader writes the session header to every writer, healthy or not, in its own way.
+func (w *MultiWriter) WriteHeader(header LogEntry) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
 	var err error
+	for i, writer := range w.writers {
+		if e := writeHeader(writer, header); e != nil {
+			err = multierr.Append(err, fmt.Errorf("writer %d: %w", i, e))
+		}
+	}
+	return err
+}
+
+// Health returns the state of each writer, in the order they were given.
+func (w *MultiWriter) Health() []WriterHealth {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	return append([]WriterHealth(nil), w.health...)
+}
+
+func (w *MultiWriter) Files() []string {
+	var files []string
 	for _, writer := range w.writers {
-		if e := writer.Write(entries); e != nil {
-			err = multierr.Append(err, e)
+		if f, ok := writer.(FileWriter); ok {
+			files = append(files, f.Files()...)
 		}
 	}
+	return files
+}
+
+func (w *MultiWriter) BytesWritten() uint64 {
+	var n uint64
+	for _, writer := range w.writers {
+		if counter, ok := writer.(ByteCounter); ok {
+			n += counter.BytesWritten()
+		}
+	}
+	return n
+}
+
+// Rotate rotates every writer that is a Rotator.
+func (w *MultiWriter) Rotate() error {
+	var (
+		err     error
+		rotated bool
+	)
+	for _, writer := range w.writers {
+		if rotator, ok := writer.(Rotator); ok {
+			rotated = true
+			if e := rotator.Rotate(); e != nil {
+				err = multierr.Append(err, e)
+			}
+		}
+	}
+
+	if !rotated {
+		return fmt.Errorf("no writer supports rotation")
+	}
 	return err
 }
 
@@ -152,7 +395,7 @@ func (w *MultiWriter) Close() error {
 }
 
 type BinaryWriter struct {
-	file *os.File
+	file OutputFile
 	mu   sync.Mutex
 }
 
@@ -162,7 +405,12 @@ func NewBinaryWriter(filename string) (*BinaryWriter, error) {
 		return nil, err
 	}
 
-	return &BinaryWriter{file: file}, nil
+	return NewBinaryWriterTo(file), nil
+}
+
+// NewBinaryWriterTo writes to an open file, e.g. an encrypted one.
+func NewBinaryWriterTo(file OutputFile) *BinaryWriter {
+	return &BinaryWriter{file: file}
 }
 
 func (w *BinaryWriter) Write(entries []LogEntry) error {
@@ -184,7 +432,18 @@ func (w *BinaryWriter) Write(entries []LogEntry) error {
 		}
 	}
 
-	return nil
+	return FlushFile(w.file)
+}
+
+func (w *BinaryWriter) Files() []string {
+	return []string{w.file.Name()}
+}
+
+func (w *BinaryWriter) BytesWritten() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return fileOffset(w.file)
 }
 
 func (w *BinaryWriter) Close() error {
@@ -193,69 +452,70 @@ func (w *BinaryWriter) Close() error {
 
 func FlattenStruct(v interface{}) map[string]string {
 	result := make(map[string]string)
-	flattenValue(reflect.ValueOf(v), "", result)
+	walkValue(reflect.ValueOf(v), "", true, func(name string, value reflect.Value) {
+		result[name] = formatValue(value)
+	})
 	return result
 }
 
-func flattenValue(v reflect.Value, prefix string, result map[string]string) {
-	if v.Kind() == reflect.Ptr {
+// WalkFields calls fn with the name and value of every leaf of v, e.g. Cell_RSRP or Bands_0. Leaves
+// are scalars, time.Time values and nil pointers and interfaces. Unlike FlattenStruct, it looks into
+// interface values, so data read back from a log is walked like the struct it was, and it names the
+// elements of a top-level slice or map 0 rather than _0.
+func WalkFields(v interface{}, fn func(name string, value reflect.Value)) {
+	walkValue(reflect.ValueOf(v), "", false, fn)
+}
+
+// walkValue walks v for WalkFields, or for FlattenStruct with flatten set, which keeps interface
+// values as leaves and always prefixes elements with an underscore, as its CSV columns always have.
+func walkValue(v reflect.Value, prefix string, flatten bool, fn func(string, reflect.Value)) {
+	if v.Kind() == reflect.Ptr || (v.Kind() == reflect.Interface && !flatten) {
 		if v.IsNil() {
-			result[prefix] = ""
+			fn(prefix, v)
 			return
 		}
 		v = v.Elem()
 	}
 
+	element := joinName
+	if flatten {
+		element = func(prefix, name string) string { return prefix + "_" + name }
+	}
+
 	switch v.Kind() {
 	case reflect.Struct:
 		if v.Type() == reflect.TypeOf(time.Time{}) {
-			if t, ok := v.Interface().(time.Time); ok {
-				result[prefix] = t.Format(time.RFC3339Nano)
-			}
-			return
-		}
-
-		if v.Type() == reflect.TypeOf(time.Duration(0)) {
-			if d, ok := v.Interface().(time.Duration); ok {
-				result[prefix] = fmt.Sprintf("%.2f", float64(d.Nanoseconds())/1e6) // milliseconds
-			}
+			fn(prefix, v)
 			return
 		}
 
 		t := v.Type()
 		for i := 0; i < v.NumField(); i++ {
-			field := t.Field(i)
-			fieldValue := v.Field(i)
-
-			if !fieldValue.CanInterface() {
-				continue
-			}
-
-			fieldName := field.Name
-			fullName := fieldName
-			if prefix != "" {
-				fullName = prefix + "_" + fieldName
+			if fieldValue := v.Field(i); fieldValue.CanInterface() {
+				walkValue(fieldValue, joinName(prefix, t.Field(i).Name), flatten, fn)
 			}
-
-			flattenValue(fieldValue, fullName, result)
 		}
 
 	case reflect.Slice, reflect.Array:
 		for i := 0; i < v.Len(); i++ {
-			indexName := fmt.Sprintf("%s_%d", prefix, i)
-			flattenValue(v.Index(i), indexName, result)
+			walkValue(v.Index(i), element(prefix, strconv.Itoa(i)), flatten, fn)
 		}
 
 	case reflect.Map:
 		for _, key := range v.MapKeys() {
-			keyStr := fmt.Sprintf("%v", key.Interface())
-			mapName := fmt.Sprintf("%s_%s", prefix, keyStr)
-			flattenValue(v.MapIndex(key), mapName, result)
+			walkValue(v.MapIndex(key), element(prefix, fmt.Sprintf("%v", key.Interface())), flatten, fn)
 		}
 
 	default:
-		result[prefix] = formatValue(v)
+		fn(prefix, v)
+	}
+}
+
+func joinName(prefix, name string) string {
+	if prefix == "" {
+		return name
 	}
+	return prefix + "_" + name
 }
 
 func formatValue(v reflect.Value) string {
@@ -264,6 +524,13 @@ func formatValue(v reflect.Value) string {
 	}
 
 	switch v.Kind() {
+	case reflect.Ptr:
+		return "" // nil
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			return t.Format(time.RFC3339Nano)
+		}
+		return fmt.Sprintf("%v", v.Interface())
 	case reflect.Bool:
 		return strconv.FormatBool(v.Bool())
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
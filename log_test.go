@@ -0,0 +1,101 @@
+package cellularlog
+
+import (
+	"errors"
+	"maps"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type flattened struct {
+	Cell struct {
+		RSRP *int
+		SINR *int
+	}
+	Bands  []int
+	Extra  interface{}
+	Missed interface{}
+	At     time.Time
+}
+
+func TestFlattenStructKeepsItsColumnNames(t *testing.T) {
+	rsrp := -97
+	v := flattened{Bands: []int{3, 20}, Extra: map[string]int{"a": 1}, At: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	v.Cell.RSRP = &rsrp
+
+	want := map[string]string{
+		"Cell_RSRP": "-97",
+		"Cell_SINR": "",
+		"Bands_0":   "3",
+		"Bands_1":   "20",
+		"Extra":     "map[a:1]",
+		"Missed":    "<nil>",
+		"At":        "2026-01-01T00:00:00Z",
+	}
+	if got := FlattenStruct(&v); !maps.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	if got := FlattenStruct([]int{7}); !maps.Equal(got, map[string]string{"_0": "7"}) {
+		t.Errorf("expected elements of a top-level slice to be named _0, got %v", got)
+	}
+}
+
+func TestWalkFieldsLooksIntoInterfaces(t *testing.T) {
+	got := make(map[string]interface{})
+	WalkFields([]interface{}{map[string]interface{}{"rsrp": -97.0}, nil}, func(name string, value reflect.Value) {
+		if value.Kind() == reflect.Interface {
+			got[name] = nil
+			return
+		}
+		got[name] = value.Interface()
+	})
+
+	if want := map[string]interface{}{"0_rsrp": -97.0, "1": nil}; !maps.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestMultiWriterHealth(t *testing.T) {
+	a, b := &countingWriter{}, &countingWriter{}
+	multi := NewMultiWriter(a, b)
+
+	for _, step := range []struct {
+		name            string
+		failA, failB    bool
+		backoffPassed   bool
+		partial, failed bool // the error wraps ErrPartialWrite, or is not delivered
+		writesB         int
+		healthyB        bool
+		failuresB       int
+		backoffB        time.Duration // until b is tried again, if unhealthy
+	}{
+		{name: "both take it", writesB: 1, healthyB: true},
+		{name: "b fails", failB: true, partial: true, writesB: 2, failuresB: 1, backoffB: time.Second},
+		{name: "b skipped while backing off", partial: true, writesB: 2, failuresB: 1, backoffB: time.Second},
+		{name: "b fails again", failB: true, backoffPassed: true, partial: true, writesB: 3, failuresB: 2, backoffB: 2 * time.Second},
+		{name: "b recovers", backoffPassed: true, writesB: 4, healthyB: true},
+		{name: "both fail", failA: true, failB: true, failed: true, writesB: 5, failuresB: 1, backoffB: time.Second},
+	} {
+		a.fail, b.fail = step.failA, step.failB
+		if step.backoffPassed {
+			multi.health[1].RetryAt = time.Time{}
+		}
+
+		now := time.Now()
+		err := multi.Write([]LogEntry{{Index: 1}})
+		if errors.Is(err, ErrPartialWrite) != step.partial || delivered(err) == step.failed {
+			t.Errorf("%s: unexpected error %v", step.name, err)
+		}
+
+		health := multi.Health()[1]
+		if b.writes != step.writesB || health.Healthy != step.healthyB || health.Failures != step.failuresB {
+			t.Errorf("%s: expected b tried %d times, healthy %v after %d failures, got %d times and %+v",
+				step.name, step.writesB, step.healthyB, step.failuresB, b.writes, health)
+		}
+		if backoff := health.RetryAt.Sub(now); !health.Healthy && (backoff < step.backoffB-time.Second/2 || backoff > step.backoffB+time.Second/2) {
+			t.Errorf("%s: expected a backoff of %v, got %v", step.name, step.backoffB, backoff)
+		}
+	}
+}
@@ -3,18 +3,31 @@ package AT
 import (
 	"errors"
 	"fmt"
-	"sync"
+	"io"
+	"strings"
 	"time"
 
 	"github.com/warthog618/modem/at"
 	"github.com/warthog618/modem/serial"
 
 	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/internal/multierr"
+)
+
+// RequesterName is the name messages from this package target by default.
+const RequesterName = "at"
+
+// Commands controlling the internal GNSS engine of Quectel modems. Once enabled, NMEA sentences are
+// streamed on the modem's NMEA port (/dev/ttyUSB1 on most Quectel modules).
+const (
+	QuectelGNSSEnable  = "+QGPS=1"
+	QuectelGNSSDisable = "+QGPSEND"
 )
 
 type AT struct {
-	s    *serial.Port
-	node *at.AT
+	s           io.ReadWriteCloser
+	node        *at.AT
+	gnssDisable string
 }
 
 func NewAT(device string, baud int, timeout time.Duration) (*AT, error) {
@@ -25,10 +38,12 @@ func NewAT(device string, baud int, timeout time.Duration) (*AT, error) {
 
 	node := at.New(s, at.WithTimeout(timeout))
 	if err := node.Init(); err != nil {
+		_ = s.Close()
 		return nil, err
 	}
 
 	return &AT{
+		s:    s,
 		node: node,
 	}, nil
 }
@@ -37,11 +52,92 @@ func (r *AT) Process(messages cellularlog.Message) (cellularlog.LogEntry, error)
 	return messages.Process(r)
 }
 
+// EnableGNSS turns on the modem's internal GNSS engine by sending enable, and remembers disable so
+// that Close turns the engine off again at the end of the session.
+func (r *AT) EnableGNSS(enable, disable string) error {
+	if _, err := r.node.Command(enable); err != nil {
+		return fmt.Errorf("error while enabling GNSS: %w", err)
+	}
+
+	r.gnssDisable = disable
+	return nil
+}
+
+// ModemIdentity is a modem's answer to the identification commands.
+type ModemIdentity struct {
+	Info     string `json:"info"`     // ATI, manufacturer, model and revision
+	Firmware string `json:"firmware"` // +CGMR
+	IMEI     string `json:"imei"`     // +CGSN
+	ICCID    string `json:"iccid"`    // +CCID, of the SIM
+	IMSI     string `json:"imsi"`     // +CIMI
+}
+
+// Identify asks the modem and its SIM who they are, for the session header. Fields whose command
+// failed, e.g. without a SIM, are left empty.
+func (r *AT) Identify() (interface{}, error) {
+	var (
+		identity ModemIdentity
+		err      error
+	)
+
+	for _, c := range []struct {
+		cmd   string
+		value *string
+	}{
+		{"I", &identity.Info},
+		{"+CGMR", &identity.Firmware},
+		{"+CGSN", &identity.IMEI},
+		{"+CCID", &identity.ICCID},
+		{"+CIMI", &identity.IMSI},
+	} {
+		lines, e := r.node.Command(c.cmd)
+		if e != nil {
+			err = multierr.Append(err, fmt.Errorf("AT%s: %w", c.cmd, e))
+			continue
+		}
+		*c.value = identityValue(c.cmd, lines)
+	}
+
+	return identity, err
+}
+
+// identityValue joins the response lines without the command's prefix, e.g. "+CCID: ", and quotes.
+func identityValue(cmd string, lines []string) string {
+	values := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if rest, ok := strings.CutPrefix(line, cmd+":"); ok {
+			line = strings.TrimSpace(rest)
+		}
+		if line = strings.Trim(line, `"`); line != "" {
+			values = append(values, line)
+		}
+	}
+	return strings.Join(values, " ")
+}
+
+func (r *AT) Close() error {
+	var err error
+
+	if r.gnssDisable != "" {
+		if _, e := r.node.Command(r.gnssDisable); e != nil {
+			err = multierr.Append(err, fmt.Errorf("error while disabling GNSS: %w", e))
+		}
+	}
+
+	if e := r.s.Close(); e != nil {
+		err = multierr.Append(err, e)
+	}
+
+	return err
+}
+
 type Message struct {
-	index    uint64
-	messages []cellularlog.LogEntry
-	cmd      string
-	mux      sync.RWMutex
+	index     uint64
+	history   *cellularlog.History
+	cmd       string
+	requester string
+	parser    Parser
 }
 
 // NewMessage example
@@ -51,13 +147,32 @@ type Message struct {
 //	NewMessage("+CNMI=?")
 func NewMessage(cmd string) *Message {
 	return &Message{
-		messages: make([]cellularlog.LogEntry, 0),
-		cmd:      cmd,
+		history:   cellularlog.NewHistory(cellularlog.DefaultHistoryCapacity, 0),
+		cmd:       cmd,
+		requester: RequesterName,
 	}
 }
 
-func (m *Message) Request(processor *cellularlog.Processor) (cellularlog.LogEntry, error) {
-	return processor.AT.Process(m)
+// SetRequester points the message at a requester registered under a name other than RequesterName,
+// e.g. when logging from two modems.
+func (m *Message) SetRequester(name string) {
+	m.requester = name
+}
+
+// SetParser selects one of Parsers to turn the response into structured data. The raw response
+// lines are kept in Metadata["response"].
+func (m *Message) SetParser(name string) error {
+	parser, ok := Parsers[name]
+	if !ok {
+		return fmt.Errorf("unknown parser '%s' (supported: %s)", name, strings.Join(ParserNames(), ", "))
+	}
+
+	m.parser = parser
+	return nil
+}
+
+func (m *Message) GetRequester() string {
+	return m.requester
 }
 
 func (m *Message) Process(requester cellularlog.Requester) (cellularlog.LogEntry, error) {
@@ -87,21 +202,37 @@ func (m *Message) Process(requester cellularlog.Requester) (cellularlog.LogEntry
 		return log, fmt.Errorf("error while sending AT commands: %w", err)
 	}
 
-	log.Success = true
-	log.Data = data
 	log.ResponseTime = time.Now()
 	log.Duration = log.ResponseTime.Sub(log.RequestTime)
 
+	if m.parser != nil {
+		parsed, err := m.parser(data)
+		if err != nil {
+			log.Data = data
+			log.Error = fmt.Errorf("error parsing response: %w", err).Error()
+
+			m.add(log)
+			return log, fmt.Errorf("error parsing response: %w", err)
+		}
+
+		log.Metadata = map[string]interface{}{"response": data}
+		log.Success = true
+		log.Data = parsed
+
+		m.add(log)
+		return log, nil
+	}
+
+	log.Success = true
+	log.Data = data
+
 	m.add(log)
 
 	return log, nil
 }
 
 func (m *Message) add(log cellularlog.LogEntry) {
-	m.mux.Lock()
-	defer m.mux.Unlock()
-
-	m.messages = append(m.messages, log)
+	m.history.Add(log)
 }
 
 func (m *Message) GetType() string {
@@ -109,8 +240,10 @@ func (m *Message) GetType() string {
 }
 
 func (m *Message) GetAllEntries() []cellularlog.LogEntry {
-	m.mux.RLock()
-	defer m.mux.RUnlock()
+	return m.history.All()
+}
 
-	return m.messages
+// History returns the bounded record of the message's entries.
+func (m *Message) History() *cellularlog.History {
+	return m.history
 }
@@ -0,0 +1,301 @@
+package AT
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parser turns the information lines of an AT response into structured data.
+type Parser func(lines []string) (interface{}, error)
+
+// Parsers are the response parsers selectable per message with SetParser.
+var Parsers = map[string]Parser{
+	"csq":  ParseCSQ,
+	"creg": ParseRegistration,
+	"qeng": ParseQENG,
+	"cops": ParseCOPS,
+	"cclk": ParseCCLK,
+}
+
+// ParserNames returns the names of Parsers, sorted.
+func ParserNames() []string {
+	names := make([]string, 0, len(Parsers))
+	for name := range Parsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// ErrNoResponse is returned by parsers when the response has no line they recognise.
+var ErrNoResponse = errors.New("no matching response line")
+
+// SignalQuality is the +CSQ response. RSSI is nil when the modem reports 99 (unknown).
+type SignalQuality struct {
+	RSSI *int // dBm
+	BER  *int // 0-7, nil when unknown
+}
+
+// ParseCSQ parses "+CSQ: <rssi>,<ber>".
+func ParseCSQ(lines []string) (interface{}, error) {
+	fields, err := responseFields(lines, "+CSQ:")
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("unexpected +CSQ response %q", fields)
+	}
+
+	var result SignalQuality
+
+	rssi, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid +CSQ rssi: %w", err)
+	}
+	if rssi != 99 {
+		dbm := -113 + 2*rssi
+		result.RSSI = &dbm
+	}
+
+	ber, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid +CSQ ber: %w", err)
+	}
+	if ber != 99 {
+		result.BER = &ber
+	}
+
+	return &result, nil
+}
+
+// Registration is a +CREG, +CGREG, +CEREG or +C5GREG response.
+type Registration struct {
+	Stat             int
+	Registered       bool // home network (1) or roaming (5)
+	AreaCode         string
+	CellID           string
+	AccessTechnology *int
+}
+
+// ParseRegistration parses "+CREG: [<n>,]<stat>[,<lac>,<ci>[,<AcT>]]" and the GPRS, EPS and 5GS
+// variants. Unsolicited (no <n>) and solicited forms are told apart by where the quoted area code is.
+func ParseRegistration(lines []string) (interface{}, error) {
+	var fields []string
+	var err error
+	for _, prefix := range []string{"+CREG:", "+CGREG:", "+CEREG:", "+C5GREG:"} {
+		if fields, err = responseFields(lines, prefix); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop <n> when present: the solicited form has either exactly two fields or an unquoted second one.
+	if len(fields) == 2 || len(fields) >= 3 && !isQuoted(fields[1]) {
+		fields = fields[1:]
+	}
+
+	stat, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid registration status: %w", err)
+	}
+
+	result := &Registration{Stat: stat, Registered: stat == 1 || stat == 5}
+	if len(fields) >= 3 {
+		result.AreaCode = unquote(fields[1])
+		result.CellID = unquote(fields[2])
+	}
+	if len(fields) >= 4 {
+		if act, err := strconv.Atoi(fields[3]); err == nil {
+			result.AccessTechnology = &act
+		}
+	}
+
+	return result, nil
+}
+
+// Operator is the +COPS? response.
+type Operator struct {
+	Mode             int
+	Format           *int
+	Name             string
+	AccessTechnology *int
+}
+
+// ParseCOPS parses "+COPS: <mode>[,<format>,<oper>[,<AcT>]]".
+func ParseCOPS(lines []string) (interface{}, error) {
+	fields, err := responseFields(lines, "+COPS:")
+	if err != nil {
+		return nil, err
+	}
+
+	mode, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid +COPS mode: %w", err)
+	}
+
+	result := &Operator{Mode: mode}
+	if len(fields) >= 3 {
+		if format, err := strconv.Atoi(fields[1]); err == nil {
+			result.Format = &format
+		}
+		result.Name = unquote(fields[2])
+	}
+	if len(fields) >= 4 {
+		if act, err := strconv.Atoi(fields[3]); err == nil {
+			result.AccessTechnology = &act
+		}
+	}
+
+	return result, nil
+}
+
+// ServingCell is the Quectel AT+QENG="servingcell" response. The LTE fields are filled for LTE
+// cells; other access technologies keep their values in Fields only.
+type ServingCell struct {
+	State  string // SEARCH, LIMSRV, NOCONN or CONNECT
+	RAT    string // e.g. LTE, NR5G-SA, WCDMA, GSM
+	Fields []string
+
+	Duplex  string // FDD or TDD
+	MCC     int
+	MNC     int
+	CellID  string // hexadecimal
+	PCI     int
+	EARFCN  int
+	Band    int
+	TAC     string // hexadecimal
+	RSRP    *int   // dBm
+	RSRQ    *int   // dB
+	RSSI    *int   // dBm
+	SINR    *int   // as reported; the scale depends on the module
+	CQI     *int
+	TxPower *int
+}
+
+// ParseQENG parses the first "+QENG: "servingcell",..." line, e.g.
+//
+//	+QENG: "servingcell","NOCONN","LTE","FDD",262,01,1A2D001,123,1300,3,5,5,1A2B,-95,-9,-65,12,30,-,40
+func ParseQENG(lines []string) (interface{}, error) {
+	fields, err := responseFields(lines, "+QENG:")
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) < 2 || unquote(fields[0]) != "servingcell" {
+		return nil, fmt.Errorf("not a servingcell response: %q", fields)
+	}
+
+	result := &ServingCell{State: unquote(fields[1])}
+	if len(fields) < 3 {
+		return result, nil // SEARCH and LIMSRV report no cell
+	}
+
+	result.RAT = unquote(fields[2])
+	result.Fields = fields[3:]
+	for i := range result.Fields {
+		result.Fields[i] = unquote(result.Fields[i])
+	}
+
+	if result.RAT != "LTE" || len(fields) < 19 {
+		return result, nil
+	}
+
+	lte := fields[3:]
+	result.Duplex = unquote(lte[0])
+	result.MCC = atoi(lte[1])
+	result.MNC = atoi(lte[2])
+	result.CellID = lte[3]
+	result.PCI = atoi(lte[4])
+	result.EARFCN = atoi(lte[5])
+	result.Band = atoi(lte[6])
+	result.TAC = lte[9]
+	result.RSRP = optionalInt(lte[10])
+	result.RSRQ = optionalInt(lte[11])
+	result.RSSI = optionalInt(lte[12])
+	result.SINR = optionalInt(lte[13])
+	result.CQI = optionalInt(lte[14])
+	result.TxPower = optionalInt(lte[15])
+
+	return result, nil
+}
+
+// NetworkTime is the +CCLK? response: the modem's real-time clock, which follows the network time
+// (NITZ) where the network sends it and automatic time zone update (AT+CTZU=1) is on.
+type NetworkTime struct {
+	Time time.Time // in the reported time zone
+}
+
+// ParseCCLK parses "+CCLK: "<yy/MM/dd,hh:mm:ss>[±zz]"", where zz is the time zone in quarter hours.
+func ParseCCLK(lines []string) (interface{}, error) {
+	fields, err := responseFields(lines, "+CCLK:")
+	if err != nil {
+		return nil, err
+	}
+
+	value := unquote(strings.Join(fields, ","))
+	zone := 0
+	if i := strings.LastIndexAny(value, "+-"); i >= 0 && i >= len(value)-3 {
+		quarters, err := strconv.Atoi(value[i:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid +CCLK time zone: %w", err)
+		}
+		value, zone = value[:i], quarters*15*60
+	}
+
+	layout := "06/01/02,15:04:05"
+	if strings.IndexByte(value, '/') == 4 {
+		layout = "2006/01/02,15:04:05"
+	}
+	t, err := time.ParseInLocation(layout, value, time.FixedZone("", zone))
+	if err != nil {
+		return nil, fmt.Errorf("invalid +CCLK time: %w", err)
+	}
+
+	return &NetworkTime{Time: t}, nil
+}
+
+// responseFields returns the comma separated fields of the first line starting with prefix.
+func responseFields(lines []string, prefix string) ([]string, error) {
+	for _, line := range lines {
+		if rest, ok := strings.CutPrefix(strings.TrimSpace(line), prefix); ok {
+			fields := strings.Split(strings.TrimSpace(rest), ",")
+			for i := range fields {
+				fields[i] = strings.TrimSpace(fields[i])
+			}
+			return fields, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w for %s", ErrNoResponse, prefix)
+}
+
+func isQuoted(s string) bool {
+	return len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"'
+}
+
+func unquote(s string) string {
+	if isQuoted(s) {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+func atoi(s string) int {
+	v, _ := strconv.Atoi(unquote(s))
+	return v
+}
+
+// optionalInt is nil for "-" and other values the module uses when a measurement is unavailable.
+func optionalInt(s string) *int {
+	v, err := strconv.Atoi(unquote(s))
+	if err != nil {
+		return nil
+	}
+	return &v
+}
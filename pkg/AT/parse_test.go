@@ -0,0 +1,76 @@
+package AT_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging/pkg/AT"
+)
+
+func TestParseQENG(t *testing.T) {
+	lines := []string{`+QENG: "servingcell","NOCONN","LTE","FDD",262,01,1A2D001,123,1300,3,5,5,1A2B,-95,-9,-65,12,30,-,40`}
+
+	data, err := AT.ParseQENG(lines)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cell := data.(*AT.ServingCell)
+	if cell.State != "NOCONN" || cell.RAT != "LTE" || cell.MCC != 262 || cell.MNC != 1 || cell.CellID != "1A2D001" || cell.PCI != 123 || cell.Band != 3 || cell.TAC != "1A2B" {
+		t.Errorf("unexpected cell %+v", cell)
+	}
+	if *cell.RSRP != -95 || *cell.RSRQ != -9 || *cell.SINR != 12 || cell.TxPower != nil {
+		t.Errorf("unexpected measurements %+v", cell)
+	}
+
+	data, err = AT.ParseQENG([]string{`+QENG: "servingcell","SEARCH"`})
+	if err != nil || data.(*AT.ServingCell).RAT != "" {
+		t.Errorf("expected a searching modem without a cell, got %+v (%v)", data, err)
+	}
+}
+
+func TestParseCSQAndRegistration(t *testing.T) {
+	data, err := AT.ParseCSQ([]string{"+CSQ: 20,99"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := data.(*AT.SignalQuality); *q.RSSI != -73 || q.BER != nil {
+		t.Errorf("unexpected signal quality %+v", q)
+	}
+
+	for _, line := range []string{`+CEREG: 2,5,"1A2B","1A2D001",7`, `+CEREG: 5,"1A2B","1A2D001",7`} {
+		data, err := AT.ParseRegistration([]string{line})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := data.(*AT.Registration); r.Stat != 5 || !r.Registered || r.AreaCode != "1A2B" || r.CellID != "1A2D001" || *r.AccessTechnology != 7 {
+			t.Errorf("%s: unexpected registration %+v", line, r)
+		}
+	}
+
+	if _, err := AT.ParseCSQ([]string{"OK"}); err == nil {
+		t.Error("expected an error without a +CSQ line")
+	}
+}
+
+func TestParseCCLK(t *testing.T) {
+	for line, want := range map[string]string{
+		`+CCLK: "24/05/01,12:34:56+08"`:  "2024-05-01T10:34:56Z",
+		`+CCLK: "24/05/01,12:34:56-14"`:  "2024-05-01T16:04:56Z",
+		`+CCLK: "2024/05/01,12:34:56"`:   "2024-05-01T12:34:56Z",
+		`+CCLK: "24/05/01,12:34:56+00" `: "2024-05-01T12:34:56Z",
+	} {
+		data, err := AT.ParseCCLK([]string{line})
+		if err != nil {
+			t.Errorf("%s: %v", line, err)
+			continue
+		}
+		if got := data.(*AT.NetworkTime).Time.UTC().Format(time.RFC3339); got != want {
+			t.Errorf("%s: got %s, want %s", line, got, want)
+		}
+	}
+
+	if _, err := AT.ParseCCLK([]string{`+CCLK: "not a time"`}); err == nil {
+		t.Error("expected an error for an invalid time")
+	}
+}
@@ -0,0 +1,461 @@
+// Package chain makes logs tamper-evident. Every batch written to a file is followed by a link entry
+// holding a SHA-256 hash over the previous link's hash and the batch's entries as written, so that
+// each link commits to everything before it. When a file (a segment of a rotating writer) is closed,
+// a manifest with the file's digest and the chain hashes at its start and end is written next to it
+// and signed with the device's Ed25519 key. Verify checks a session's files against both.
+//
+// Entries are hashed as their JSON encoding, which is what the JSON and binary writers write, so the
+// chain of those logs can be verified entry by entry. For other formats only the manifests are.
+package chain
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/internal/multierr"
+)
+
+// LinkMessageType is the MessageType of link entries.
+const LinkMessageType = "chain"
+
+// ManifestExt is appended to a file's name for the name of its manifest.
+const ManifestExt = ".manifest"
+
+// Link is the data of a link entry.
+type Link struct {
+	Session string `json:"session"` // random, shared by every segment of an output
+	Seq     uint64 `json:"seq"`     // of the link in the session, from 0
+	Entries int    `json:"entries"` // covered by the link: those written right before it
+	Prev    string `json:"prev"`    // hash of the previous link, hex, zeros for the first
+	Hash    string `json:"hash"`    // SHA-256 of Prev and each covered entry's JSON, hex
+}
+
+// Manifest describes a closed file. It is signed over its JSON encoding with an empty Signature.
+type Manifest struct {
+	Session   string    `json:"session"`
+	Segment   int       `json:"segment"` // of the file in the session, from 0
+	File      string    `json:"file"`    // base name, as written before any compression
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`               // of the file, hex
+	Entries   int       `json:"entries"`              // covered by links
+	FirstLink *uint64   `json:"first_link,omitempty"` // nil for a file without links
+	LastLink  *uint64   `json:"last_link,omitempty"`  //
+	Start     string    `json:"start"`                // chain hash before the file's first link
+	End       string    `json:"end"`                  // hash of its last link
+	Opened    time.Time `json:"opened"`               //
+	Closed    time.Time `json:"closed"`               //
+	Final     bool      `json:"final,omitempty"`      // the session ended with this file
+	KeyID     string    `json:"key_id"`               // first bytes of the SHA-256 of the public key, hex
+	PublicKey string    `json:"public_key"`           // base64, for convenience; verify against a trusted key
+	Signature string    `json:"signature,omitempty"`  // base64 Ed25519
+}
+
+// Chain links the batches of one output across its segments. It is safe for concurrent use.
+type Chain struct {
+	key     ed25519.PrivateKey
+	session string
+
+	seq      uint64
+	head     [sha256.Size]byte
+	segments int
+	last     *Manifest // of the last closed segment
+	lastPath string
+
+	mux sync.Mutex
+}
+
+// New starts the chain of an output, signing its manifests with key.
+func New(key ed25519.PrivateKey) (*Chain, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid Ed25519 private key")
+	}
+
+	session := make([]byte, 16)
+	if _, err := rand.Read(session); err != nil {
+		return nil, err
+	}
+
+	return &Chain{key: key, session: hex.EncodeToString(session)}, nil
+}
+
+// ID returns the chain's session ID.
+func (c *Chain) ID() string {
+	return c.session
+}
+
+// Segment wraps the writer of a new file, which must be a cellularlog.FileWriter of a single file:
+// its batches are linked, and its manifest is written when it is closed.
+func (c *Chain) Segment(w cellularlog.Writer) (*SegmentWriter, error) {
+	f, ok := w.(cellularlog.FileWriter)
+	if !ok || len(f.Files()) != 1 {
+		return nil, errors.New("chained writers must write a single file")
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	s := &SegmentWriter{
+		chain:    c,
+		writer:   w,
+		filename: f.Files()[0],
+		manifest: Manifest{
+			Session: c.session,
+			Segment: c.segments,
+			Start:   hex.EncodeToString(c.head[:]),
+			Opened:  time.Now(),
+		},
+	}
+	c.segments++
+
+	return s, nil
+}
+
+// link returns the link entry of a batch, without advancing the chain.
+func (c *Chain) link(entries []cellularlog.LogEntry) (cellularlog.LogEntry, Link, [sha256.Size]byte, error) {
+	c.mux.Lock()
+	prev, seq := c.head, c.seq
+	c.mux.Unlock()
+
+	h := sha256.New()
+	h.Write(prev[:])
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return cellularlog.LogEntry{}, Link{}, prev, fmt.Errorf("failed to hash entry: %w", err)
+		}
+		h.Write(data)
+	}
+
+	var hash [sha256.Size]byte
+	h.Sum(hash[:0])
+
+	link := Link{
+		Session: c.session,
+		Seq:     seq,
+		Entries: len(entries),
+		Prev:    hex.EncodeToString(prev[:]),
+		Hash:    hex.EncodeToString(hash[:]),
+	}
+	now := time.Now()
+
+	return cellularlog.LogEntry{
+		MessageType:  LinkMessageType,
+		Success:      true,
+		Data:         link,
+		RequestTime:  now,
+		ResponseTime: now,
+	}, link, hash, nil
+}
+
+// advance moves the head of the chain to a written link.
+func (c *Chain) advance(hash [sha256.Size]byte) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.head = hash
+	c.seq++
+}
+
+// Close marks the manifest of the last closed segment as the end of the session. Close the writers
+// first.
+func (c *Chain) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.last == nil {
+		return nil
+	}
+
+	c.last.Final = true
+	return c.writeManifest(c.lastPath, c.last)
+}
+
+func (c *Chain) closed(path string, manifest *Manifest) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.last, c.lastPath = manifest, path
+	return c.writeManifest(path, manifest)
+}
+
+func (c *Chain) writeManifest(path string, manifest *Manifest) error {
+	public := c.key.Public().(ed25519.PublicKey)
+	manifest.KeyID = KeyID(public)
+	manifest.PublicKey = base64.StdEncoding.EncodeToString(public)
+	manifest.Signature = ""
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	manifest.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(c.key, data))
+
+	if data, err = json.MarshalIndent(manifest, "", "  "); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+	return nil
+}
+
+// SegmentWriter links the batches of one file, see Chain.Segment.
+type SegmentWriter struct {
+	chain    *Chain
+	writer   cellularlog.Writer
+	filename string
+	manifest Manifest
+	mux      sync.Mutex
+}
+
+func (w *SegmentWriter) Write(entries []cellularlog.LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	return w.write(entries, func(batch []cellularlog.LogEntry) error { return w.writer.Write(batch) })
+}
+
+// WriteHeader links the session header like a batch of its own.
+func (w *SegmentWriter) WriteHeader(header cellularlog.LogEntry) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	return w.write([]cellularlog.LogEntry{header}, func(batch []cellularlog.LogEntry) error {
+		hw, ok := w.writer.(cellularlog.HeaderWriter)
+		if !ok {
+			return w.writer.Write(batch)
+		}
+		if err := hw.WriteHeader(batch[0]); err != nil {
+			return err
+		}
+		return w.writer.Write(batch[1:])
+	})
+}
+
+// write writes entries and their link with write. The chain only advances if both were written, so
+// that a batch retried after a failure is linked again.
+func (w *SegmentWriter) write(entries []cellularlog.LogEntry, write func([]cellularlog.LogEntry) error) error {
+	entry, link, hash, err := w.chain.link(entries)
+	if err != nil {
+		return err
+	}
+
+	batch := make([]cellularlog.LogEntry, 0, len(entries)+1)
+	batch = append(append(batch, entries...), entry)
+	if err := write(batch); err != nil {
+		return err
+	}
+
+	w.chain.advance(hash)
+	if w.manifest.FirstLink == nil {
+		seq := link.Seq
+		w.manifest.FirstLink = &seq
+		w.manifest.Start = link.Prev
+	}
+	seq := link.Seq
+	w.manifest.LastLink = &seq
+	w.manifest.End = link.Hash
+	w.manifest.Entries += link.Entries
+
+	return nil
+}
+
+func (w *SegmentWriter) Files() []string {
+	return []string{w.filename}
+}
+
+func (w *SegmentWriter) BytesWritten() uint64 {
+	if counter, ok := w.writer.(cellularlog.ByteCounter); ok {
+		return counter.BytesWritten()
+	}
+	return 0
+}
+
+// Close closes the file and writes its signed manifest.
+func (w *SegmentWriter) Close() error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if err := w.writer.Close(); err != nil {
+		return err
+	}
+
+	if w.manifest.End == "" {
+		w.manifest.End = w.manifest.Start
+	}
+	w.manifest.Closed = time.Now()
+	w.manifest.File = baseName(w.filename)
+
+	size, sum, err := digest(w.filename)
+	if err != nil {
+		return fmt.Errorf("error hashing %s: %w", w.filename, err)
+	}
+	w.manifest.Size, w.manifest.SHA256 = size, sum
+
+	manifest := w.manifest
+	return w.chain.closed(w.filename+ManifestExt, &manifest)
+}
+
+// SessionWriter closes the chain after the writer, see Chain.Close.
+type SessionWriter struct {
+	cellularlog.Writer
+	chain *Chain
+}
+
+// Wrap wraps the outermost writer of the chain's output, so that closing it marks the end of the
+// session.
+func (c *Chain) Wrap(w cellularlog.Writer) *SessionWriter {
+	return &SessionWriter{Writer: w, chain: c}
+}
+
+func (w *SessionWriter) WriteHeader(header cellularlog.LogEntry) error {
+	if hw, ok := w.Writer.(cellularlog.HeaderWriter); ok {
+		return hw.WriteHeader(header)
+	}
+	return w.Writer.Write([]cellularlog.LogEntry{header})
+}
+
+func (w *SessionWriter) Files() []string {
+	if f, ok := w.Writer.(cellularlog.FileWriter); ok {
+		return f.Files()
+	}
+	return nil
+}
+
+func (w *SessionWriter) BytesWritten() uint64 {
+	if counter, ok := w.Writer.(cellularlog.ByteCounter); ok {
+		return counter.BytesWritten()
+	}
+	return 0
+}
+
+func (w *SessionWriter) Rotate() error {
+	rotator, ok := w.Writer.(cellularlog.Rotator)
+	if !ok {
+		return fmt.Errorf("writer does not support rotation")
+	}
+	return rotator.Rotate()
+}
+
+func (w *SessionWriter) Close() error {
+	return multierr.Combine(w.Writer.Close(), w.chain.Close())
+}
+
+func digest(filename string) (int64, string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, file)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func baseName(filename string) string {
+	for i := len(filename) - 1; i >= 0; i-- {
+		if os.IsPathSeparator(filename[i]) {
+			return filename[i+1:]
+		}
+	}
+	return filename
+}
+
+// KeyID identifies a public key: the first bytes of its SHA-256, in hex.
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// GenerateKey returns a new Ed25519 device key.
+func GenerateKey() (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	return key, err
+}
+
+// MarshalPrivateKey encodes key as a PKCS #8 PEM block.
+func MarshalPrivateKey(key ed25519.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// MarshalPublicKey encodes key as a PKIX PEM block.
+func MarshalPublicKey(key ed25519.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// LoadPrivateKey reads an Ed25519 private key written by MarshalPrivateKey or openssl genpkey
+// -algorithm ED25519.
+func LoadPrivateKey(filename string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(filename, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if k, ok := key.(ed25519.PrivateKey); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%s: not an Ed25519 private key", filename)
+}
+
+// LoadPublicKey reads an Ed25519 public key written by MarshalPublicKey or openssl.
+func LoadPublicKey(filename string) (ed25519.PublicKey, error) {
+	block, err := readPEM(filename, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if k, ok := key.(ed25519.PublicKey); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%s: not an Ed25519 public key", filename)
+}
+
+func readPEM(filename, blockType string) (*pem.Block, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s: no %s PEM block", filename, blockType)
+	}
+	return block, nil
+}
@@ -0,0 +1,128 @@
+package chain_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/chain"
+)
+
+func TestChainedRotatingWriter(t *testing.T) {
+	key, err := chain.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := chain.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := filepath.Join(t.TempDir(), "session")
+	rotating, err := cellularlog.NewRotatingWriter(prefix, ".json", cellularlog.RotationPolicy{MaxSize: 600},
+		func(filename string) (cellularlog.Writer, error) {
+			writer, err := cellularlog.NewJSONWriter(filename)
+			if err != nil {
+				return nil, err
+			}
+			return links.Segment(writer)
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer := links.Wrap(rotating)
+	if err := writer.WriteHeader(cellularlog.LogEntry{MessageType: cellularlog.SessionMessageType, Data: "header"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		batch := []cellularlog.LogEntry{
+			{Index: uint64(2 * i), MessageType: "at-+CSQ", Success: true, Data: "+CSQ: 20,99"},
+			{Index: uint64(2*i + 1), MessageType: "at-+QENG", Success: true, Data: map[string]interface{}{"rsrp": -95}},
+		}
+		if err := writer.Write(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(prefix + "_*.json")
+	if len(files) < 3 {
+		t.Fatalf("expected several segments, got %v", files)
+	}
+
+	public := key.Public().(ed25519.PublicKey)
+	manifests := make([]*chain.Manifest, 0, len(files))
+	for i, file := range files {
+		manifest, err := chain.ReadManifest(file+chain.ManifestExt, public)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verify(file, manifest); err != nil {
+			t.Errorf("%s: %v", file, err)
+		}
+		if manifest.Final != (i == len(files)-1) {
+			t.Errorf("%s: final is %v", file, manifest.Final)
+		}
+		manifests = append(manifests, manifest)
+	}
+	if err := chain.CheckSession(manifests); err != nil {
+		t.Errorf("unexpected session error: %v", err)
+	}
+
+	// A missing segment breaks the session, and the final one being missing is noticed too.
+	if err := chain.CheckSession(append(manifests[:1:1], manifests[2:]...)); err == nil {
+		t.Error("expected a missing segment")
+	}
+	if err := chain.CheckSession(manifests[:len(manifests)-1]); err == nil {
+		t.Error("expected a missing final segment")
+	}
+
+	// So does dropping or swapping entries, even with the file's digest in the manifest fixed up.
+	data, _ := os.ReadFile(files[1])
+	lines := strings.SplitAfter(string(data), "\n")
+	for name, altered := range map[string]string{
+		"dropped": strings.Join(append(lines[:1:1], lines[2:]...), ""),
+		"swapped": lines[1] + lines[0] + strings.Join(lines[2:], ""),
+		"edited":  strings.Replace(string(data), "-95", "-85", 1),
+	} {
+		if err := os.WriteFile(files[1], []byte(altered), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := verify(files[1], manifests[1]); !errors.Is(err, chain.ErrModified) {
+			t.Errorf("%s: expected ErrModified, got %v", name, err)
+		}
+		if _, err := chain.CheckEntries(cellularlog.NewJSONReader(strings.NewReader(altered)).(cellularlog.RawEntryReader), manifests[1].Start); err == nil {
+			t.Errorf("%s: expected a broken chain", name)
+		}
+	}
+
+	// Manifests only verify against the device key.
+	other, _ := chain.GenerateKey()
+	if _, err := chain.ReadManifest(files[0]+chain.ManifestExt, other.Public().(ed25519.PublicKey)); !errors.Is(err, chain.ErrSignature) {
+		t.Errorf("expected ErrSignature, got %v", err)
+	}
+}
+
+func verify(filename string, manifest *chain.Manifest) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := manifest.CheckFile(bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	segment, err := chain.CheckEntries(cellularlog.NewJSONReader(bytes.NewReader(data)).(cellularlog.RawEntryReader), manifest.Start)
+	if err != nil {
+		return err
+	}
+	return manifest.Matches(segment)
+}
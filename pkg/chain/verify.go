@@ -0,0 +1,264 @@
+package chain
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/internal/multierr"
+)
+
+var (
+	// ErrSignature is returned for a manifest that was not signed by the expected key, or was altered.
+	ErrSignature = errors.New("invalid manifest signature")
+	// ErrModified is returned for a file that does not match its manifest.
+	ErrModified = errors.New("file does not match its manifest")
+)
+
+// ReadManifest reads the manifest at filename and checks its signature against key, or against the
+// key embedded in it if key is nil, which only shows that the manifest was not altered by someone
+// without the device key.
+func ReadManifest(filename string, key ed25519.PublicKey) (*Manifest, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%s: invalid manifest: %w", filename, err)
+	}
+
+	if key == nil {
+		embedded, err := base64.StdEncoding.DecodeString(manifest.PublicKey)
+		if err != nil || len(embedded) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%s: %w: no public key", filename, ErrSignature)
+		}
+		key = embedded
+	} else if manifest.KeyID != KeyID(key) {
+		return nil, fmt.Errorf("%s: %w: signed by key %s, not %s", filename, ErrSignature, manifest.KeyID, KeyID(key))
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(manifest.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, ErrSignature)
+	}
+
+	signed := manifest
+	signed.Signature = ""
+	if data, err = json.Marshal(signed); err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(key, data, signature) {
+		return nil, fmt.Errorf("%s: %w", filename, ErrSignature)
+	}
+
+	return &manifest, nil
+}
+
+// CheckFile checks the size and digest of the file the manifest describes, read from r.
+func (m *Manifest) CheckFile(r io.Reader) error {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+
+	if n != m.Size || hex.EncodeToString(h.Sum(nil)) != m.SHA256 {
+		return fmt.Errorf("%w: %d bytes with SHA-256 %x, expected %d bytes with %s", ErrModified, n, h.Sum(nil), m.Size, m.SHA256)
+	}
+	return nil
+}
+
+// Segment is what CheckEntries found in a file.
+type Segment struct {
+	Session   string
+	Entries   int     // covered by links
+	Unlinked  int     // after the last link
+	FirstLink *uint64 // nil without links
+	LastLink  *uint64
+	Start     string // chain hash before the first link
+	End       string // hash of the last link
+}
+
+// CheckEntries follows the links of the entries read from r, which start after the chain hash start,
+// or after whatever the first link says if start is empty. It reports every link that does not
+// follow the previous one or does not match the entries before it, which is what missing, reordered
+// or altered entries cause, along with entries after the last link.
+func CheckEntries(r cellularlog.RawEntryReader, start string) (Segment, error) {
+	segment := Segment{Start: start, End: start}
+
+	var (
+		errs    error
+		pending [][]byte
+		index   int
+	)
+	for {
+		raw, err := r.ReadRaw()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return segment, multierr.Append(errs, fmt.Errorf("entry %d: %w", index+1, err))
+		}
+		index++
+
+		var entry struct {
+			MessageType string          `json:"message_type"`
+			Data        json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return segment, multierr.Append(errs, fmt.Errorf("entry %d: %w", index, err))
+		}
+		if entry.MessageType != LinkMessageType {
+			pending = append(pending, raw)
+			continue
+		}
+
+		var link Link
+		if err := json.Unmarshal(entry.Data, &link); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("entry %d: invalid link: %w", index, err))
+			continue
+		}
+		errs = multierr.Append(errs, segment.follow(index, link, pending))
+		pending = pending[:0]
+	}
+
+	if len(pending) > 0 {
+		segment.Unlinked = len(pending)
+		errs = multierr.Append(errs, fmt.Errorf("%d entries after the last link", len(pending)))
+	}
+
+	return segment, errs
+}
+
+// follow checks the link at entry index and moves the segment's end to it, so that a broken link is
+// reported once rather than for every link after it.
+func (s *Segment) follow(index int, link Link, entries [][]byte) error {
+	var errs error
+
+	if s.FirstLink == nil {
+		seq := link.Seq
+		s.FirstLink, s.Session = &seq, link.Session
+		if s.Start == "" {
+			s.Start, s.End = link.Prev, link.Prev
+		}
+	} else if link.Seq != *s.LastLink+1 {
+		errs = multierr.Append(errs, fmt.Errorf("entry %d: link %d follows link %d", index, link.Seq, *s.LastLink))
+	}
+
+	if link.Session != s.Session {
+		errs = multierr.Append(errs, fmt.Errorf("entry %d: link %d is of session %s, not %s", index, link.Seq, link.Session, s.Session))
+	}
+	if link.Prev != s.End {
+		errs = multierr.Append(errs, fmt.Errorf("entry %d: link %d does not follow the previous link (prev %s, expected %s)", index, link.Seq, short(link.Prev), short(s.End)))
+	}
+	if link.Entries != len(entries) {
+		errs = multierr.Append(errs, fmt.Errorf("entry %d: link %d covers %d entries, found %d", index, link.Seq, link.Entries, len(entries)))
+	}
+
+	prev, err := hex.DecodeString(link.Prev)
+	if err != nil {
+		errs = multierr.Append(errs, fmt.Errorf("entry %d: link %d: invalid prev hash", index, link.Seq))
+	}
+	h := sha256.New()
+	h.Write(prev)
+	for _, entry := range entries {
+		h.Write(entry)
+	}
+	if hash := hex.EncodeToString(h.Sum(nil)); hash != link.Hash {
+		errs = multierr.Append(errs, fmt.Errorf("entry %d: the %d entries before link %d were altered", index, len(entries), link.Seq))
+	}
+
+	seq := link.Seq
+	s.LastLink, s.End = &seq, link.Hash
+	s.Entries += len(entries)
+
+	return errs
+}
+
+// Matches checks that a segment found in a file is the one the manifest describes.
+func (m *Manifest) Matches(s Segment) error {
+	var errs error
+	if s.FirstLink != nil && s.Session != m.Session {
+		errs = multierr.Append(errs, fmt.Errorf("links are of session %s, the manifest of %s", s.Session, m.Session))
+	}
+	if !equalSeq(s.FirstLink, m.FirstLink) || !equalSeq(s.LastLink, m.LastLink) {
+		errs = multierr.Append(errs, fmt.Errorf("links %s, the manifest lists %s", seqRange(s.FirstLink, s.LastLink), seqRange(m.FirstLink, m.LastLink)))
+	}
+	if s.Start != m.Start || s.End != m.End {
+		errs = multierr.Append(errs, fmt.Errorf("the chain runs from %s to %s, the manifest from %s to %s", short(s.Start), short(s.End), short(m.Start), short(m.End)))
+	}
+	if s.Entries != m.Entries {
+		errs = multierr.Append(errs, fmt.Errorf("%d entries are linked, the manifest lists %d", s.Entries, m.Entries))
+	}
+	return errs
+}
+
+// CheckSession checks that the manifests of a session's files form a complete chain: every segment
+// is present, each starts where the previous one ended, and the last one ends the session.
+func CheckSession(manifests []*Manifest) error {
+	if len(manifests) == 0 {
+		return nil
+	}
+
+	sorted := append([]*Manifest(nil), manifests...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Segment < sorted[j].Segment })
+
+	var errs error
+	if sorted[0].Segment != 0 {
+		errs = multierr.Append(errs, missing(0, sorted[0].Segment-1))
+	}
+	for i := 1; i < len(sorted); i++ {
+		prev, m := sorted[i-1], sorted[i]
+		switch {
+		case m.Segment == prev.Segment:
+			errs = multierr.Append(errs, fmt.Errorf("segment %d appears twice (%s, %s)", m.Segment, prev.File, m.File))
+		case m.Segment > prev.Segment+1:
+			errs = multierr.Append(errs, missing(prev.Segment+1, m.Segment-1))
+		case m.Start != prev.End:
+			errs = multierr.Append(errs, fmt.Errorf("%s does not continue the chain of %s", m.File, prev.File))
+		}
+		if prev.Final {
+			errs = multierr.Append(errs, fmt.Errorf("%s follows the final segment %s", m.File, prev.File))
+		}
+	}
+	if last := sorted[len(sorted)-1]; !last.Final {
+		errs = multierr.Append(errs, fmt.Errorf("no final segment after %s, later segments are missing or the logger did not stop cleanly", last.File))
+	}
+
+	return errs
+}
+
+func missing(first, last int) error {
+	if first == last {
+		return fmt.Errorf("segment %d is missing", first)
+	}
+	return fmt.Errorf("segments %d to %d are missing", first, last)
+}
+
+func equalSeq(a, b *uint64) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func seqRange(first, last *uint64) string {
+	if first == nil || last == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%d to %d", *first, *last)
+}
+
+func short(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
@@ -0,0 +1,429 @@
+// Package envelope encrypts log files for a recipient's X25519 public key, so that a logger holding
+// only the public key can write logs it cannot read back. Every file is encrypted with a random key
+// of its own, which is wrapped for the recipient through a key exchange with a fresh ephemeral key
+// (X25519, HKDF-SHA256 and AES-256-GCM, as in age or HPKE). The data follows in records sealed with
+// AES-256-GCM, one per flush, numbered so that dropped, reordered or truncated records are detected.
+//
+// An encrypted file is laid out as:
+//
+//	magic "CLENC" and version 1   6 bytes
+//	recipient key ID              8 bytes, see KeyID
+//	ephemeral public key         32 bytes
+//	wrapped file key             48 bytes
+//	records                      each a big endian uint32 length and the sealed data
+//
+// The nonce of a record is its number, with the last byte set to 1 for the final record written by
+// Close. Keys are PEM files as written by openssl genpkey -algorithm X25519.
+package envelope
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/harshabose/cellular_localisation_logging/internal/multierr"
+)
+
+// Ext is appended to the names of encrypted files.
+const Ext = ".enc"
+
+const (
+	magic      = "CLENC\x01"
+	keyIDSize  = 8
+	fileKey    = 32
+	wrappedKey = fileKey + 16 // with the GCM tag
+	// maxRecord is the most data sealed in one record; larger writes are split.
+	maxRecord = 1 << 20
+
+	wrapInfo = "cellular-log envelope v1 file key"
+)
+
+var (
+	// ErrTruncated is returned by a Reader at the end of a file that has no final record, because
+	// the logger did not close it or records were cut off. The data before it is intact.
+	ErrTruncated = errors.New("file ends without its final record")
+	// ErrWrongKey is returned by NewReader for a file encrypted for another key.
+	ErrWrongKey = errors.New("file is encrypted for another key")
+)
+
+// KeyID identifies a public key: the first bytes of its SHA-256.
+func KeyID(key *ecdh.PublicKey) []byte {
+	sum := sha256.Sum256(key.Bytes())
+	return sum[:keyIDSize]
+}
+
+// Writer encrypts what is written to it for a recipient. Data is buffered until Flush or Close, or
+// until a record is full.
+type Writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	written uint64
+	err     error // sticky, a failed record leaves the output unusable
+}
+
+// NewWriter writes the header of an encrypted file for recipient to w.
+func NewWriter(w io.Writer, recipient *ecdh.PublicKey) (*Writer, error) {
+	if recipient.Curve() != ecdh.X25519() {
+		return nil, errors.New("recipient must be an X25519 key")
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+	wrap, err := wrapAEAD(shared, ephemeral.PublicKey(), recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	key := make([]byte, fileKey)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(magic)+keyIDSize+32+wrappedKey)
+	header = append(header, magic...)
+	header = append(header, KeyID(recipient)...)
+	header = append(header, ephemeral.PublicKey().Bytes()...)
+	header = wrap.Seal(header, make([]byte, wrap.NonceSize()), key, nil) // the wrapping key is used once
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &Writer{w: w, aead: aead}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	w.buf = append(w.buf, p...)
+	w.written += uint64(len(p))
+	for len(w.buf) >= maxRecord {
+		if err := w.seal(w.buf[:maxRecord], false); err != nil {
+			return len(p), err
+		}
+		w.buf = w.buf[maxRecord:]
+	}
+
+	return len(p), nil
+}
+
+// Flush seals the buffered data into a record.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	err := w.seal(w.buf, false)
+	w.buf = w.buf[:0]
+	return err
+}
+
+// Close writes the final record. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	err := w.seal(w.buf, true)
+	w.buf = nil
+	if err == nil {
+		w.err = errors.New("writer is closed")
+	}
+	return err
+}
+
+// BytesWritten counts the bytes written to w, before encryption.
+func (w *Writer) BytesWritten() uint64 {
+	return w.written
+}
+
+func (w *Writer) seal(data []byte, last bool) error {
+	record := make([]byte, 4, 4+len(data)+w.aead.Overhead())
+	record = w.aead.Seal(record, nonce(w.counter, last), data, nil)
+	binary.BigEndian.PutUint32(record, uint32(len(record)-4))
+
+	w.counter++
+	if _, err := w.w.Write(record); err != nil {
+		w.err = fmt.Errorf("error writing encrypted record: %w", err)
+		return w.err
+	}
+	return nil
+}
+
+// File is an encrypted file, see Create. It is a cellularlog.OutputFile.
+type File struct {
+	*Writer
+	file *os.File
+}
+
+// Create creates an encrypted file for recipient. Callers add Ext to the name.
+func Create(filename string, recipient *ecdh.PublicKey) (*File, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := NewWriter(file, recipient)
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(filename)
+		return nil, fmt.Errorf("error encrypting %s: %w", filename, err)
+	}
+
+	return &File{Writer: w, file: file}, nil
+}
+
+func (f *File) Name() string {
+	return f.file.Name()
+}
+
+func (f *File) Close() error {
+	return multierr.Combine(f.Writer.Close(), f.file.Close())
+}
+
+// Reader decrypts a file written by a Writer.
+type Reader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	done    bool // the final record was read
+}
+
+// NewReader reads the header of an encrypted file and unwraps its key with key.
+func NewReader(r io.Reader, key *ecdh.PrivateKey) (*Reader, error) {
+	if key.Curve() != ecdh.X25519() {
+		return nil, errors.New("key must be an X25519 key")
+	}
+
+	header := make([]byte, len(magic)+keyIDSize+32+wrappedKey)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("not an encrypted log: %w", err)
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, errors.New("not an encrypted log")
+	}
+	header = header[len(magic):]
+
+	if !bytes.Equal(header[:keyIDSize], KeyID(key.PublicKey())) {
+		return nil, fmt.Errorf("%w (%x)", ErrWrongKey, header[:keyIDSize])
+	}
+	header = header[keyIDSize:]
+
+	ephemeral, err := ecdh.X25519().NewPublicKey(header[:32])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %w", err)
+	}
+	shared, err := key.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	wrap, err := wrapAEAD(shared, ephemeral, key.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	contentKey, err := wrap.Open(nil, make([]byte, wrap.NonceSize()), header[32:], nil)
+	if err != nil {
+		return nil, errors.New("failed to unwrap the file key, the header is damaged")
+	}
+
+	aead, err := newAEAD(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{r: r, aead: aead}, nil
+}
+
+// Read returns the decrypted data. It returns io.EOF after the final record, ErrTruncated if the
+// file ends before it, and an error for records that were altered, reordered or dropped.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *Reader) next() error {
+	var length uint32
+	if err := binary.Read(r.r, binary.BigEndian, &length); err != nil {
+		if r.done && errors.Is(err, io.EOF) {
+			return io.EOF
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		}
+		return err
+	}
+	if r.done {
+		return errors.New("data after the final record")
+	}
+
+	if length < uint32(r.aead.Overhead()) || length > maxRecord+uint32(r.aead.Overhead()) {
+		return fmt.Errorf("record %d: invalid length %d", r.counter, length)
+	}
+
+	record := make([]byte, length)
+	if _, err := io.ReadFull(r.r, record); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		}
+		return err
+	}
+
+	data, err := r.aead.Open(record[:0], nonce(r.counter, false), record, nil)
+	if err != nil {
+		if data, err = r.aead.Open(record[:0], nonce(r.counter, true), record, nil); err != nil {
+			return fmt.Errorf("record %d: authentication failed, it was altered, reordered or follows a dropped record", r.counter)
+		}
+		r.done = true
+	}
+
+	r.counter++
+	r.buf = data
+	return nil
+}
+
+func nonce(counter uint64, last bool) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n[3:11], counter)
+	if last {
+		n[11] = 1
+	}
+	return n
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrapAEAD derives the key the file key is wrapped with from the shared secret of the exchange,
+// bound to both public keys.
+func wrapAEAD(shared []byte, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	salt := append(ephemeral.Bytes(), recipient.Bytes()...)
+	key, err := hkdf.Key(sha256.New, shared, salt, wrapInfo, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(key)
+}
+
+// GenerateKey returns a new X25519 private key.
+func GenerateKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// MarshalPrivateKey encodes key as a PKCS #8 PEM block.
+func MarshalPrivateKey(key *ecdh.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// MarshalPublicKey encodes key as a PKIX PEM block.
+func MarshalPublicKey(key *ecdh.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// ParsePrivateKey decodes an X25519 private key written by MarshalPrivateKey or openssl.
+func ParsePrivateKey(data []byte) (*ecdh.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("no PRIVATE KEY PEM block")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if k, ok := key.(*ecdh.PrivateKey); ok && k.Curve() == ecdh.X25519() {
+		return k, nil
+	}
+	return nil, errors.New("not an X25519 private key")
+}
+
+// ParsePublicKey decodes an X25519 public key written by MarshalPublicKey or openssl.
+func ParsePublicKey(data []byte) (*ecdh.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("no PUBLIC KEY PEM block")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if k, ok := key.(*ecdh.PublicKey); ok && k.Curve() == ecdh.X25519() {
+		return k, nil
+	}
+	return nil, errors.New("not an X25519 public key")
+}
+
+// LoadPublicKey reads a public key file.
+func LoadPublicKey(filename string) (*ecdh.PublicKey, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ParsePublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return key, nil
+}
+
+// LoadPrivateKey reads a private key file.
+func LoadPrivateKey(filename string) (*ecdh.PrivateKey, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return key, nil
+}
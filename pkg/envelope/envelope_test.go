@@ -0,0 +1,89 @@
+package envelope_test
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/envelope"
+)
+
+func TestEncryptedJSONWriter(t *testing.T) {
+	key, err := envelope.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys survive PEM, as the logger only gets the public one from a file.
+	public, _ := envelope.MarshalPublicKey(key.PublicKey())
+	private, _ := envelope.MarshalPrivateKey(key)
+	recipient, err := envelope.ParsePublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, err = envelope.ParsePrivateKey(private); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "session.json"+envelope.Ext)
+	file, err := envelope.Create(filename, recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer := cellularlog.NewJSONWriterTo(file)
+	for i := 0; i < 3; i++ {
+		if err := writer.Write([]cellularlog.LogEntry{{Index: uint64(i), MessageType: "at-+CSQ", Data: "+CSQ: 20,99"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files := writer.Files(); files[0] != filename || writer.BytesWritten() == 0 {
+		t.Errorf("unexpected files %v or size %d", files, writer.BytesWritten())
+	}
+
+	// Every batch is sealed as it is written, so a crash loses nothing but the final record.
+	sealed, _ := os.ReadFile(filename)
+	if bytes.Contains(sealed, []byte("CSQ")) {
+		t.Fatal("the file is not encrypted")
+	}
+	data, err := decrypt(sealed, key)
+	if !errors.Is(err, envelope.ErrTruncated) || bytes.Count(data, []byte("+CSQ: 20,99")) != 3 {
+		t.Errorf("expected 3 entries and a truncated file before Close, got %q (%v)", data, err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sealed, _ = os.ReadFile(filename)
+	if data, err := decrypt(sealed, key); err != nil || bytes.Count(data, []byte("\n")) != 3 {
+		t.Errorf("expected 3 entries, got %q (%v)", data, err)
+	}
+
+	// Dropping the second record is detected.
+	records := sealed[6+8+32+48:]
+	first := 4 + int(binary.BigEndian.Uint32(records))
+	second := 4 + int(binary.BigEndian.Uint32(records[first:]))
+	dropped := append(append([]byte{}, sealed[:len(sealed)-len(records)+first]...), records[first+second:]...)
+	if _, err := decrypt(dropped, key); err == nil || errors.Is(err, envelope.ErrTruncated) {
+		t.Errorf("expected an authentication error, got %v", err)
+	}
+
+	other, _ := envelope.GenerateKey()
+	if _, err := envelope.NewReader(bytes.NewReader(sealed), other); !errors.Is(err, envelope.ErrWrongKey) {
+		t.Errorf("expected ErrWrongKey, got %v", err)
+	}
+}
+
+func decrypt(sealed []byte, key *ecdh.PrivateKey) ([]byte, error) {
+	r, err := envelope.NewReader(bytes.NewReader(sealed), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
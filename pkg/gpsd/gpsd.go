@@ -0,0 +1,349 @@
+package gpsd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/internal/latest"
+)
+
+// RequesterName is the name messages from this package target by default.
+const RequesterName = "gpsd"
+
+const (
+	DefaultAddress = "127.0.0.1:2947"
+
+	watch           = `?WATCH={"enable":true,"json":true};` + "\n"
+	maxReconnectGap = 10 * time.Second
+)
+
+// GPSD is a client for gpsd's JSON protocol. gpsd streams reports after ?WATCH, so the requester
+// keeps the latest report of each class and a request returns the newest one the message has not
+// logged yet. A dropped connection is redialled until Close.
+type GPSD struct {
+	address string
+	timeout time.Duration
+	store   *latest.Store
+
+	conn       net.Conn
+	connected  bool
+	reconnects uint64
+	connMux    sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+func NewGPSD(ctx context.Context, address string, timeout time.Duration) (*GPSD, error) {
+	ctx2, cancel := context.WithCancel(ctx)
+
+	g := &GPSD{
+		address: address,
+		timeout: timeout,
+		store:   latest.New(),
+		ctx:     ctx2,
+		cancel:  cancel,
+	}
+
+	conn, err := g.dial()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	g.wg.Add(1)
+	go g.loop(conn)
+
+	return g, nil
+}
+
+func (r *GPSD) Process(messages cellularlog.Message) (cellularlog.LogEntry, error) {
+	return messages.Process(r)
+}
+
+// LatestPosition implements cellularlog.PositionProvider using the newest TPV with a 2D or 3D fix.
+func (r *GPSD) LatestPosition() (cellularlog.Position, bool) {
+	v, ok := r.store.Get("TPV")
+	if !ok {
+		return cellularlog.Position{}, false
+	}
+
+	tpv := v.Data.(*TPV)
+	if tpv.Mode < 2 {
+		return cellularlog.Position{}, false
+	}
+
+	altitude := tpv.AltMSL
+	if altitude == 0 {
+		altitude = tpv.AltHAE
+	}
+
+	return cellularlog.Position{
+		Time:      v.Time,
+		Latitude:  tpv.Lat,
+		Longitude: tpv.Lon,
+		Altitude:  altitude,
+		Source:    RequesterName,
+	}, true
+}
+
+// Status implements cellularlog.StatusReporter.
+func (r *GPSD) Status() string {
+	r.connMux.Lock()
+	defer r.connMux.Unlock()
+
+	status := "reconnecting"
+	if r.connected {
+		status = "connected"
+	}
+	if r.reconnects > 0 {
+		status = fmt.Sprintf("%s (%d reconnects)", status, r.reconnects)
+	}
+
+	return status
+}
+
+// Reconnects returns how many times the connection to gpsd has been re-established.
+func (r *GPSD) Reconnects() uint64 {
+	r.connMux.Lock()
+	defer r.connMux.Unlock()
+
+	return r.reconnects
+}
+
+func (r *GPSD) dial() (net.Conn, error) {
+	dialer := net.Dialer{Timeout: r.timeout}
+
+	conn, err := dialer.DialContext(r.ctx, "tcp", r.address)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to gpsd at %s: %w", r.address, err)
+	}
+
+	if _, err := conn.Write([]byte(watch)); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("error enabling gpsd watch: %w", err)
+	}
+
+	r.connMux.Lock()
+	defer r.connMux.Unlock()
+
+	if r.ctx.Err() != nil {
+		_ = conn.Close()
+		return nil, r.ctx.Err()
+	}
+	r.conn = conn
+	r.connected = true
+
+	return conn, nil
+}
+
+func (r *GPSD) loop(conn net.Conn) {
+	defer r.wg.Done()
+
+	gap := time.Second
+	for {
+		r.read(conn)
+
+		r.connMux.Lock()
+		r.connected = false
+		r.connMux.Unlock()
+
+		for {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-time.After(gap):
+			}
+
+			c, err := r.dial()
+			if err == nil {
+				conn = c
+				gap = time.Second
+
+				r.connMux.Lock()
+				r.reconnects++
+				r.connMux.Unlock()
+				break
+			}
+
+			if gap *= 2; gap > maxReconnectGap {
+				gap = maxReconnectGap
+			}
+		}
+	}
+}
+
+// read consumes reports until the connection fails.
+func (r *GPSD) read(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024) // SKY reports with many satellites are long
+
+	for scanner.Scan() {
+		report, class, err := parse(scanner.Bytes())
+		if err != nil {
+			continue
+		}
+
+		r.store.Put(class, report)
+	}
+}
+
+// parse decodes a report of a supported class. Other classes (VERSION, DEVICES, WATCH, ...) are
+// returned as an error.
+func parse(line []byte) (interface{}, string, error) {
+	var header struct {
+		Class string `json:"class"`
+	}
+	if err := json.Unmarshal(line, &header); err != nil {
+		return nil, "", err
+	}
+
+	var report interface{}
+	switch header.Class {
+	case "TPV":
+		report = &TPV{}
+	case "SKY":
+		report = &SKY{}
+	default:
+		return nil, "", fmt.Errorf("unsupported report class: %s", header.Class)
+	}
+
+	if err := json.Unmarshal(line, report); err != nil {
+		return nil, "", err
+	}
+
+	return report, header.Class, nil
+}
+
+func (r *GPSD) Close() error {
+	r.once.Do(func() {
+		r.cancel()
+
+		r.connMux.Lock()
+		if r.conn != nil {
+			_ = r.conn.Close()
+		}
+		r.connMux.Unlock()
+
+		r.wg.Wait()
+		r.store.Stop(errors.New("gpsd client closed"))
+	})
+
+	return nil
+}
+
+type Message struct {
+	index     uint64
+	history   *cellularlog.History
+	class     string
+	seen      uint64
+	requester string
+}
+
+// NewMessage example
+//
+//	NewMessage("TPV")
+//	NewMessage("SKY")
+func NewMessage(class string) (*Message, error) {
+	class = strings.ToUpper(class)
+
+	for _, c := range SupportedReports {
+		if c == class {
+			return &Message{
+				history:   cellularlog.NewHistory(cellularlog.DefaultHistoryCapacity, 0),
+				class:     class,
+				requester: RequesterName,
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported report: %s (supported: %s)", class, strings.Join(SupportedReports, ", "))
+}
+
+// SetRequester points the message at a requester registered under a name other than RequesterName.
+func (m *Message) SetRequester(name string) {
+	m.requester = name
+}
+
+func (m *Message) GetRequester() string {
+	return m.requester
+}
+
+func (m *Message) Process(requester cellularlog.Requester) (cellularlog.LogEntry, error) {
+	defer func() { m.index++ }()
+
+	requestTime := time.Now()
+	log := cellularlog.LogEntry{
+		Index:       m.index,
+		MessageType: m.GetType(),
+		Success:     false,
+		RequestTime: requestTime,
+	}
+
+	r, ok := requester.(*GPSD)
+	if !ok {
+		log.Error = "errors interface mismatch"
+
+		m.add(log)
+		return log, errors.New("error interface mismatch")
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, r.timeout)
+	defer cancel()
+
+	v, err := r.store.Next(ctx, m.class, m.seen)
+	if err != nil {
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			log.Error = "request timeout"
+		case errors.Is(err, context.Canceled):
+			log.Error = "context cancelled"
+		default:
+			log.Error = err.Error()
+		}
+
+		m.add(log)
+		return log, err
+	}
+
+	m.seen = v.Seq
+
+	log.Success = true
+	log.Data = v.Data
+	log.Metadata = map[string]interface{}{"received_time": v.Time}
+	log.ResponseTime = time.Now()
+	log.Duration = log.ResponseTime.Sub(log.RequestTime)
+
+	m.add(log)
+
+	return log, nil
+}
+
+func (m *Message) add(log cellularlog.LogEntry) {
+	m.history.Add(log)
+}
+
+func (m *Message) GetType() string {
+	return fmt.Sprintf("gpsd-%s", m.class)
+}
+
+func (m *Message) GetAllEntries() []cellularlog.LogEntry {
+	return m.history.All()
+}
+
+// History returns the bounded record of the message's entries.
+func (m *Message) History() *cellularlog.History {
+	return m.history
+}
@@ -0,0 +1,121 @@
+package gpsd_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging/pkg/gpsd"
+)
+
+const (
+	version = `{"class":"VERSION","release":"3.25","rev":"3.25","proto_major":3,"proto_minor":15}`
+	tpv     = `{"class":"TPV","device":"/dev/ttyACM0","mode":3,"time":"2025-06-25T07:22:48.000Z","lat":12.971601,"lon":77.594603,"altHAE":912.3,"altMSL":920.1,"eph":1.9,"speed":0.4,"track":87.2}`
+	tpv2    = `{"class":"TPV","device":"/dev/ttyACM0","mode":3,"time":"2025-06-25T07:22:49.000Z","lat":12.971701,"lon":77.594703,"altMSL":920.5}`
+	sky     = `{"class":"SKY","device":"/dev/ttyACM0","hdop":0.8,"pdop":1.4,"nSat":3,"uSat":2,"satellites":[{"PRN":5,"el":61,"az":41,"ss":42,"used":true},{"PRN":13,"el":30,"az":270,"ss":35,"used":true},{"PRN":20,"el":8,"az":150,"ss":0,"used":false}]}`
+)
+
+// server is a stand-in for gpsd that answers each connection's ?WATCH with the given reports.
+func server(t *testing.T, sessions ...[]string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for i, reports := range sessions {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil || !strings.HasPrefix(line, "?WATCH=") {
+				t.Errorf("expected ?WATCH, got %q (%v)", line, err)
+			}
+
+			for _, report := range append([]string{version}, reports...) {
+				_, _ = conn.Write([]byte(report + "\r\n"))
+			}
+
+			if i < len(sessions)-1 {
+				_ = conn.Close()
+				continue
+			}
+			t.Cleanup(func() { _ = conn.Close() })
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestGPSD(t *testing.T) {
+	address := server(t, []string{tpv, sky})
+
+	requester, err := gpsd.NewGPSD(context.Background(), address, time.Second)
+	if err != nil {
+		t.Fatalf("error connecting: %v", err)
+	}
+	defer requester.Close()
+
+	tpvMessage, _ := gpsd.NewMessage("TPV")
+	skyMessage, _ := gpsd.NewMessage("sky")
+
+	log, err := requester.Process(tpvMessage)
+	if err != nil || !log.Success {
+		t.Fatalf("TPV: expected success, got %+v (%v)", log, err)
+	}
+	if report := log.Data.(*gpsd.TPV); report.Mode != 3 || report.Lat != 12.971601 || report.Time.IsZero() {
+		t.Errorf("TPV: unexpected %+v", report)
+	}
+
+	log, err = requester.Process(skyMessage)
+	if err != nil || !log.Success {
+		t.Fatalf("SKY: expected success, got %+v (%v)", log, err)
+	}
+	if report := log.Data.(*gpsd.SKY); len(report.Satellites) != 3 || !report.Satellites[0].Used {
+		t.Errorf("SKY: unexpected %+v", report)
+	}
+
+	position, ok := requester.LatestPosition()
+	if !ok || position.Altitude != 920.1 || position.Source != gpsd.RequesterName {
+		t.Errorf("unexpected position %+v", position)
+	}
+
+	// Nothing new has arrived, so the same TPV must not be logged again.
+	if _, err := requester.Process(tpvMessage); err == nil {
+		t.Error("expected a timeout waiting for a fresh TPV")
+	}
+}
+
+func TestGPSDReconnect(t *testing.T) {
+	address := server(t, []string{tpv}, []string{tpv2})
+
+	requester, err := gpsd.NewGPSD(context.Background(), address, 3*time.Second)
+	if err != nil {
+		t.Fatalf("error connecting: %v", err)
+	}
+	defer requester.Close()
+
+	message, _ := gpsd.NewMessage("TPV")
+
+	for _, want := range []float64{12.971601, 12.971701} {
+		log, err := requester.Process(message)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if lat := log.Data.(*gpsd.TPV).Lat; lat != want {
+			t.Errorf("expected lat %v, got %v", want, lat)
+		}
+	}
+
+	if n := requester.Reconnects(); n != 1 {
+		t.Errorf("expected 1 reconnect, got %d", n)
+	}
+}
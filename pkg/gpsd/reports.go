@@ -0,0 +1,56 @@
+package gpsd
+
+import "time"
+
+// TPV is gpsd's time-position-velocity report. Fields gpsd leaves out for the current fix are zero.
+type TPV struct {
+	Class  string    `json:"class"`
+	Device string    `json:"device,omitempty"`
+	Mode   int       `json:"mode"` // 0 unknown, 1 no fix, 2 2D, 3 3D
+	Status int       `json:"status,omitempty"`
+	Time   time.Time `json:"time,omitempty"`
+	Ept    float64   `json:"ept,omitempty"`
+	Lat    float64   `json:"lat,omitempty"`
+	Lon    float64   `json:"lon,omitempty"`
+	AltHAE float64   `json:"altHAE,omitempty"`
+	AltMSL float64   `json:"altMSL,omitempty"`
+	Epx    float64   `json:"epx,omitempty"`
+	Epy    float64   `json:"epy,omitempty"`
+	Epv    float64   `json:"epv,omitempty"`
+	Eph    float64   `json:"eph,omitempty"`
+	Track  float64   `json:"track,omitempty"`
+	Speed  float64   `json:"speed,omitempty"`
+	Climb  float64   `json:"climb,omitempty"`
+	Eps    float64   `json:"eps,omitempty"`
+	Epc    float64   `json:"epc,omitempty"`
+}
+
+// SKY is gpsd's sky view report.
+type SKY struct {
+	Class      string      `json:"class"`
+	Device     string      `json:"device,omitempty"`
+	Time       time.Time   `json:"time,omitempty"`
+	Xdop       float64     `json:"xdop,omitempty"`
+	Ydop       float64     `json:"ydop,omitempty"`
+	Vdop       float64     `json:"vdop,omitempty"`
+	Tdop       float64     `json:"tdop,omitempty"`
+	Hdop       float64     `json:"hdop,omitempty"`
+	Gdop       float64     `json:"gdop,omitempty"`
+	Pdop       float64     `json:"pdop,omitempty"`
+	NSat       int         `json:"nSat,omitempty"`
+	USat       int         `json:"uSat,omitempty"`
+	Satellites []Satellite `json:"satellites,omitempty"`
+}
+
+type Satellite struct {
+	PRN    int     `json:"PRN"`
+	El     float64 `json:"el"`
+	Az     float64 `json:"az"`
+	Ss     float64 `json:"ss"`
+	Used   bool    `json:"used"`
+	GnssID int     `json:"gnssid"`
+	SvID   int     `json:"svid"`
+}
+
+// SupportedReports lists the report classes that can be logged.
+var SupportedReports = []string{"TPV", "SKY"}
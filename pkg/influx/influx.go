@@ -0,0 +1,439 @@
+// Package influx writes entries as InfluxDB line protocol, to a file or to an HTTP write endpoint,
+// so that cellular metrics can be graphed against flight data, e.g. in Grafana.
+//
+// Every entry is one line: the measurement is the message type; the tags are the vehicle, the
+// source (the message type's prefix), the serving cell last reported by a +QENG="servingcell"
+// entry and the message's tags; the fields are success, duration_ms, error, the flattened data (see
+// cellularlog.WalkFields) and the joined position; the timestamp is the response time in
+// nanoseconds.
+//
+// Entries read back from a log, e.g. replayed from a write-ahead log or a spill queue, carry their
+// data as generic maps with json.Number values; they are written as the typed entries were, except
+// that a float field with a whole value is an integer unless the Encoder has seen it as a float.
+package influx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/AT"
+)
+
+// Encoder turns entries into lines. It remembers the serving cell, so entries from one session
+// should go through one Encoder in order.
+type Encoder struct {
+	vehicle string
+	cell    string
+	floats  map[string]bool // fields written as floats, by measurement and name
+}
+
+// NewEncoder tags every line with vehicle, unless it is empty.
+func NewEncoder(vehicle string) *Encoder {
+	return &Encoder{vehicle: vehicle, floats: make(map[string]bool)}
+}
+
+// Append appends the line of entry, with its trailing newline, to buf.
+func (e *Encoder) Append(buf []byte, entry cellularlog.LogEntry) []byte {
+	if cell := servingCell(entry); entry.Success && cell != "" {
+		e.cell = cell
+	}
+
+	tags := make(map[string]string)
+	if source, _, ok := strings.Cut(entry.MessageType, "-"); ok {
+		tags["source"] = source
+	}
+	if e.vehicle != "" {
+		tags["vehicle"] = e.vehicle
+	}
+	if e.cell != "" {
+		tags["cell_id"] = e.cell
+	}
+	switch messageTags := entry.Metadata["tags"].(type) {
+	case map[string]string:
+		for k, v := range messageTags {
+			tags[k] = v
+		}
+	case map[string]interface{}:
+		for k, v := range messageTags {
+			if v, ok := v.(string); ok {
+				tags[k] = v
+			}
+		}
+	}
+
+	buf = appendEscaped(buf, entry.MessageType, ", ")
+	for _, k := range sortedKeys(tags) {
+		if tags[k] == "" {
+			continue
+		}
+		buf = append(buf, ',')
+		buf = appendEscaped(buf, k, ",= ")
+		buf = append(buf, '=')
+		buf = appendEscaped(buf, tags[k], ",= ")
+	}
+
+	buf = append(buf, " success="...)
+	buf = strconv.AppendBool(buf, entry.Success)
+	buf = append(buf, ",duration_ms="...)
+	buf = strconv.AppendFloat(buf, float64(entry.Duration.Nanoseconds())/1e6, 'f', -1, 64)
+	if entry.Error != "" {
+		buf = append(buf, ",error="...)
+		buf = appendString(buf, entry.Error)
+	}
+
+	fields := make(map[string][]byte)
+	cellularlog.WalkFields(entry.Data, func(name string, value reflect.Value) {
+		if name == "" {
+			name = "value"
+		}
+		key := entry.MessageType + " " + name
+		if kind := value.Kind(); kind == reflect.Float32 || kind == reflect.Float64 {
+			e.floats[key] = true
+		}
+		if field, ok := fieldValue(value, e.floats[key]); ok {
+			fields[name] = field
+		}
+	})
+	if position, ok := position(entry.Metadata["position"]); ok {
+		fields["position_lat"] = strconv.AppendFloat(nil, position.Latitude, 'f', -1, 64)
+		fields["position_lon"] = strconv.AppendFloat(nil, position.Longitude, 'f', -1, 64)
+		fields["position_alt"] = strconv.AppendFloat(nil, position.Altitude, 'f', -1, 64)
+	}
+	for _, name := range sortedKeys(fields) {
+		switch name {
+		case "success", "duration_ms", "error":
+			continue // reserved for the entry itself
+		}
+		buf = append(buf, ',')
+		buf = appendEscaped(buf, name, ",= ")
+		buf = append(buf, '=')
+		buf = append(buf, fields[name]...)
+	}
+
+	timestamp := entry.ResponseTime
+	if timestamp.IsZero() {
+		timestamp = entry.RequestTime
+	}
+	if !timestamp.IsZero() {
+		buf = append(buf, ' ')
+		buf = strconv.AppendInt(buf, timestamp.UnixNano(), 10)
+	}
+
+	return append(buf, '\n')
+}
+
+// servingCell returns the cell ID of a serving cell entry, typed or read back from a log.
+func servingCell(entry cellularlog.LogEntry) string {
+	switch data := entry.Data.(type) {
+	case *AT.ServingCell:
+		return data.CellID
+	case map[string]interface{}:
+		if strings.HasPrefix(entry.MessageType, "at-+QENG") {
+			cell, _ := data["CellID"].(string)
+			return cell
+		}
+	}
+	return ""
+}
+
+// position returns the joined position of an entry, typed or read back from a log.
+func position(v interface{}) (cellularlog.Position, bool) {
+	switch v := v.(type) {
+	case cellularlog.Position:
+		return v, true
+	case map[string]interface{}:
+		var p cellularlog.Position
+		var ok bool
+		p.Latitude, ok = number(v["lat"])
+		if !ok {
+			return p, false
+		}
+		p.Longitude, _ = number(v["lon"])
+		p.Altitude, _ = number(v["alt"])
+		return p, true
+	}
+	return cellularlog.Position{}, false
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// fieldValue formats a leaf as a line protocol field value: integers get the i suffix, strings are
+// quoted. Numbers read back from a log are integers if they have no fraction, unless float is set.
+// Nil pointers and values that cannot be represented are left out.
+func fieldValue(v reflect.Value, float bool) ([]byte, bool) {
+	if !v.IsValid() {
+		return nil, false
+	}
+
+	if n, ok := v.Interface().(json.Number); ok {
+		if i, err := n.Int64(); err == nil && !float {
+			return append(strconv.AppendInt(nil, i, 10), 'i'), true
+		}
+		f, err := n.Float64()
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, false
+		}
+		return strconv.AppendFloat(nil, f, 'f', -1, 64), true
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.AppendBool(nil, v.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return append(strconv.AppendInt(nil, v.Int(), 10), 'i'), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, false
+		}
+		return append(strconv.AppendUint(nil, v.Uint(), 10), 'i'), true
+	case reflect.Float32, reflect.Float64:
+		if math.IsNaN(v.Float()) || math.IsInf(v.Float(), 0) {
+			return nil, false
+		}
+		return strconv.AppendFloat(nil, v.Float(), 'f', -1, 64), true
+	case reflect.String:
+		return appendString(nil, v.String()), true
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			return appendString(nil, t.Format(time.RFC3339Nano)), true
+		}
+	}
+
+	return nil, false
+}
+
+// appendEscaped escapes the given characters and backslashes, and replaces newlines, which cannot
+// be escaped, with spaces.
+func appendEscaped(buf []byte, s string, special string) []byte {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\n' || c == '\r':
+			c = ' '
+			if strings.IndexByte(special, ' ') >= 0 {
+				buf = append(buf, '\\')
+			}
+		case c == '\\' || strings.IndexByte(special, c) >= 0:
+			buf = append(buf, '\\')
+		}
+		buf = append(buf, c)
+	}
+	return buf
+}
+
+// appendString quotes a string field value; newlines would end the line, so they become spaces.
+func appendString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			buf = append(buf, '\\', c)
+		case '\n', '\r':
+			buf = append(buf, ' ')
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return append(buf, '"')
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// FileWriter writes line protocol to a file, e.g. for a later `influx write`.
+type FileWriter struct {
+	file    cellularlog.OutputFile
+	encoder *Encoder
+	written uint64
+	mu      sync.Mutex
+}
+
+func NewFileWriter(filename, vehicle string) (*FileWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFileWriterTo(file, vehicle), nil
+}
+
+// NewFileWriterTo writes to an open file, e.g. an encrypted one.
+func NewFileWriterTo(file cellularlog.OutputFile, vehicle string) *FileWriter {
+	return &FileWriter{file: file, encoder: NewEncoder(vehicle)}
+}
+
+func (w *FileWriter) Write(entries []cellularlog.LogEntry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var buf []byte
+	for _, entry := range entries {
+		buf = w.encoder.Append(buf, entry)
+	}
+
+	n, err := w.file.Write(buf)
+	w.written += uint64(n)
+	if err == nil {
+		err = cellularlog.FlushFile(w.file)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write line protocol: %w", err)
+	}
+	return nil
+}
+
+func (w *FileWriter) Files() []string {
+	return []string{w.file.Name()}
+}
+
+func (w *FileWriter) BytesWritten() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.written
+}
+
+func (w *FileWriter) Close() error {
+	return w.file.Close()
+}
+
+// HTTPConfig describes an InfluxDB write endpoint.
+type HTTPConfig struct {
+	// URL of the write endpoint including its query, e.g.
+	// http://influx:8086/api/v2/write?org=team&bucket=flights (InfluxDB 2) or
+	// http://influx:8086/write?db=flights (InfluxDB 1). The precision is set to nanoseconds.
+	URL       string
+	Token     string        // sent as "Authorization: Token <token>" if set
+	Vehicle   string        // tag on every line
+	BatchSize int           // lines per request, default 5000
+	Timeout   time.Duration // per request, default 10s
+}
+
+// HTTPWriter posts every flush of the processor to the write endpoint, split into requests of at
+// most BatchSize lines. Writes are at least once: if a request fails, the whole batch fails and is
+// posted again when it is retried, including the requests the server already accepted. InfluxDB
+// identifies a point by its measurement, tags and timestamp, so the same lines posted again
+// overwrite the points rather than duplicate them; a failed write leaves the encoder as it was, so
+// that a retry encodes the batch to the same lines.
+type HTTPWriter struct {
+	ctx     context.Context
+	config  HTTPConfig
+	target  string
+	client  *http.Client
+	encoder *Encoder
+	written uint64
+	mu      sync.Mutex
+}
+
+func NewHTTPWriter(ctx context.Context, config HTTPConfig) (*HTTPWriter, error) {
+	target, err := url.ParseRequestURI(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid InfluxDB URL: %w", err)
+	}
+	query := target.Query()
+	query.Set("precision", "ns")
+	target.RawQuery = query.Encode()
+
+	if config.BatchSize <= 0 {
+		config.BatchSize = 5000
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	return &HTTPWriter{
+		ctx:     ctx,
+		config:  config,
+		target:  target.String(),
+		client:  &http.Client{Timeout: config.Timeout},
+		encoder: NewEncoder(config.Vehicle),
+	}, nil
+}
+
+func (w *HTTPWriter) Write(entries []cellularlog.LogEntry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	cell := w.encoder.cell
+	for len(entries) > 0 {
+		n := min(len(entries), w.config.BatchSize)
+
+		var buf []byte
+		for _, entry := range entries[:n] {
+			buf = w.encoder.Append(buf, entry)
+		}
+		if err := w.post(buf); err != nil {
+			w.encoder.cell = cell
+			return err
+		}
+
+		w.written += uint64(len(buf))
+		entries = entries[n:]
+	}
+
+	return nil
+}
+
+func (w *HTTPWriter) post(body []byte) error {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, w.target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.config.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.config.Token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to write to InfluxDB: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("failed to write to InfluxDB: %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+// BytesWritten counts the line protocol accepted by the server.
+func (w *HTTPWriter) BytesWritten() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.written
+}
+
+func (w *HTTPWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,315 @@
+package influx_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/AT"
+	"github.com/harshabose/cellular_localisation_logging/pkg/influx"
+)
+
+func TestEncoder(t *testing.T) {
+	rsrp := -95
+	at := time.Unix(1700000000, 5)
+
+	encoder := influx.NewEncoder("car 1")
+	cell := encoder.Append(nil, cellularlog.LogEntry{
+		MessageType:  `at-+QENG="servingcell"`,
+		Success:      true,
+		Data:         &AT.ServingCell{State: "NOCONN", RAT: "LTE", MCC: 262, CellID: "1A2D001", RSRP: &rsrp},
+		Metadata:     map[string]interface{}{"tags": map[string]string{"antenna": "roof"}},
+		ResponseTime: at,
+		Duration:     1500 * time.Microsecond,
+	})
+
+	want := `at-+QENG="servingcell",antenna=roof,cell_id=1A2D001,source=at,vehicle=car\ 1 success=true,duration_ms=1.5,` +
+		`Band=0i,CellID="1A2D001",Duplex="",EARFCN=0i,MCC=262i,MNC=0i,PCI=0i,RAT="LTE",RSRP=-95i,State="NOCONN",TAC="" 1700000000000000005` + "\n"
+	if string(cell) != want {
+		t.Errorf("unexpected line\n got: %s\nwant: %s", cell, want)
+	}
+
+	// Later entries are tagged with the serving cell; errors are quoted.
+	failed := encoder.Append(nil, cellularlog.LogEntry{
+		MessageType: "mavlink-ATTITUDE",
+		Error:       "request timeout: \"ATTITUDE\"\nretrying",
+		Metadata:    map[string]interface{}{"position": cellularlog.Position{Latitude: 52.5, Longitude: 13.25}},
+		RequestTime: at,
+	})
+
+	want = `mavlink-ATTITUDE,cell_id=1A2D001,source=mavlink,vehicle=car\ 1 success=false,duration_ms=0,` +
+		`error="request timeout: \"ATTITUDE\" retrying",position_alt=0,position_lat=52.5,position_lon=13.25 1700000000000000005` + "\n"
+	if string(failed) != want {
+		t.Errorf("unexpected line\n got: %s\nwant: %s", failed, want)
+	}
+}
+
+// lineWriter encodes the entries it is given, or fails while fail is set.
+type lineWriter struct {
+	encoder *influx.Encoder
+	lines   []string
+	fail    bool
+}
+
+func (w *lineWriter) Write(entries []cellularlog.LogEntry) error {
+	if w.fail {
+		return errors.New("connection refused")
+	}
+	for _, entry := range entries {
+		w.lines = append(w.lines, string(w.encoder.Append(nil, entry)))
+	}
+	return nil
+}
+
+func (w *lineWriter) Close() error { return nil }
+
+// servingCellEntries returns a serving cell entry with tags and a position, and a later entry that
+// is tagged with its cell.
+func servingCellEntries() []cellularlog.LogEntry {
+	rsrp := -97
+	at := time.Unix(1700000000, 5)
+	return []cellularlog.LogEntry{{
+		MessageType:  `at-+QENG="servingcell"`,
+		Success:      true,
+		Data:         &AT.ServingCell{State: "NOCONN", RAT: "LTE", MCC: 262, CellID: "ABC", RSRP: &rsrp},
+		Metadata:     map[string]interface{}{"tags": map[string]string{"antenna": "roof"}, "position": cellularlog.Position{Latitude: 52.5, Longitude: 13.25, Altitude: 34.5}},
+		RequestTime:  at,
+		ResponseTime: at,
+		Duration:     1500 * time.Microsecond,
+	}, {
+		MessageType: "sys-wwan0",
+		Success:     true,
+		Data:        map[string]uint64{"rx_bytes": 1024},
+		RequestTime: at,
+	}}
+}
+
+func TestEncoderReplayedSpill(t *testing.T) {
+	entries := servingCellEntries()
+	var want []string
+	live := influx.NewEncoder("car 1")
+	for _, entry := range entries {
+		want = append(want, string(live.Append(nil, entry)))
+	}
+
+	writer := &lineWriter{encoder: influx.NewEncoder("car 1"), fail: true}
+	policy, err := cellularlog.NewPolicyWriter(writer, cellularlog.FailurePolicy{Retries: 1, SpillDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer policy.Close()
+
+	if err := policy.Write(entries); !errors.Is(err, cellularlog.ErrSpilled) {
+		t.Fatalf("expected the batch to be spilled, got %v", err)
+	}
+	writer.fail = false
+	if err := policy.Write(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(writer.lines, "") != strings.Join(want, "") {
+		t.Errorf("replayed lines differ from the live ones\n got: %s\nwant: %s", writer.lines, want)
+	}
+	if policy.Discarded() != 0 {
+		t.Errorf("expected no batch to be discarded, got %d", policy.Discarded())
+	}
+}
+
+// failingWriter keeps the entries it is given and fails.
+type failingWriter struct{ entries []cellularlog.LogEntry }
+
+func (w *failingWriter) Write(entries []cellularlog.LogEntry) error {
+	w.entries = append(w.entries, entries...)
+	return errors.New("disk full")
+}
+
+func (w *failingWriter) Close() error { return nil }
+
+// qeng is the serving cell message, answered by modem.
+type qeng struct{}
+
+func (qeng) Process(r cellularlog.Requester) (cellularlog.LogEntry, error) { return r.Process(qeng{}) }
+func (qeng) GetRequester() string                                          { return "modem" }
+func (qeng) GetType() string                                               { return `at-+QENG="servingcell"` }
+func (qeng) GetAllEntries() []cellularlog.LogEntry                         { return nil }
+
+// modem answers with the first of servingCellEntries; as the position reference, it is at a fixed
+// position.
+type modem struct{}
+
+func (modem) Process(message cellularlog.Message) (cellularlog.LogEntry, error) {
+	entry := servingCellEntries()[0]
+	entry.Metadata = nil
+	return entry, nil
+}
+
+func (modem) LatestPosition() (cellularlog.Position, bool) {
+	return cellularlog.Position{Latitude: 52.5, Longitude: 13.25, Altitude: 34.5}, true
+}
+
+func TestEncoderReplayedWAL(t *testing.T) {
+	dir := t.TempDir()
+
+	// A session whose writer never takes its entries leaves them in the write-ahead log.
+	failing := &failingWriter{}
+	p := cellularlog.NewProcessor(context.Background(), 5*time.Millisecond, time.Hour, failing, 100, qeng{})
+	for _, name := range []string{"modem", "gps"} {
+		if err := p.RegisterRequester(name, modem{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.SetPositionReference("gps")
+	p.SetMessageOptions(qeng{}, cellularlog.MessageOptions{Tags: map[string]string{"antenna": "roof"}})
+	p.SetBackpressure(100)
+	if _, err := p.EnableWAL(cellularlog.WALConfig{Dir: dir, Sync: cellularlog.SyncAlways}); err != nil {
+		t.Fatal(err)
+	}
+	p.Start()
+	for deadline := time.Now().Add(2 * time.Second); p.GetWriterStats().Buffered < 2 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	_ = p.Close()
+	if len(failing.entries) < 2 {
+		t.Fatalf("expected the session to buffer entries, got %d", len(failing.entries))
+	}
+
+	var want []string
+	live := influx.NewEncoder("car 1")
+	for _, entry := range failing.entries {
+		want = append(want, string(live.Append(nil, entry)))
+	}
+	if !strings.Contains(want[0], "RSRP=-97i") || !strings.Contains(want[0], "antenna=roof,cell_id=ABC") || !strings.Contains(want[0], "position_lat=52.5") {
+		t.Fatalf("unexpected live line: %s", want[0])
+	}
+
+	writer := &lineWriter{encoder: influx.NewEncoder("car 1")}
+	next := cellularlog.NewProcessor(context.Background(), time.Hour, time.Hour, writer, 100)
+	defer next.Close()
+	replayed, err := next.EnableWAL(cellularlog.WALConfig{Dir: dir, Sync: cellularlog.SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != len(want) || strings.Join(writer.lines, "") != strings.Join(want, "") {
+		t.Errorf("replayed lines differ from the live ones\n got: %s\nwant: %s", writer.lines, want)
+	}
+}
+
+func TestEncoderNumbersReadBack(t *testing.T) {
+	encoder := influx.NewEncoder("")
+	encoder.Append(nil, cellularlog.LogEntry{MessageType: "nmea-GGA", Data: map[string]float64{"HDOP": 0.8}})
+
+	line := encoder.Append(nil, cellularlog.LogEntry{
+		MessageType: "nmea-GGA",
+		Data:        map[string]interface{}{"HDOP": json.Number("1"), "Satellites": json.Number("9"), "Altitude": json.Number("1e2")},
+	})
+	if want := "nmea-GGA,source=nmea success=false,duration_ms=0,Altitude=100,HDOP=1,Satellites=9i\n"; string(line) != want {
+		t.Errorf("unexpected line\n got: %s\nwant: %s", line, want)
+	}
+}
+
+func TestHTTPWriterBatches(t *testing.T) {
+	var (
+		bodies []string
+		query  string
+		auth   string
+		mux    sync.Mutex
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mux.Lock()
+		bodies = append(bodies, string(body))
+		query, auth = r.URL.RawQuery, r.Header.Get("Authorization")
+		mux.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	writer, err := influx.NewHTTPWriter(context.Background(), influx.HTTPConfig{
+		URL:       server.URL + "/api/v2/write?org=team&bucket=flights",
+		Token:     "secret",
+		BatchSize: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	entries := make([]cellularlog.LogEntry, 5)
+	for i := range entries {
+		entries[i] = cellularlog.LogEntry{MessageType: "sys-wwan0", Success: true, Data: i}
+	}
+	if err := writer.Write(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	if len(bodies) != 3 || strings.Count(bodies[0], "\n") != 2 || !strings.Contains(bodies[2], "value=4i") {
+		t.Errorf("expected 3 requests of at most 2 lines, got %q", bodies)
+	}
+	if query != "bucket=flights&org=team&precision=ns" || auth != "Token secret" {
+		t.Errorf("unexpected query %q or authorization %q", query, auth)
+	}
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"unauthorized"}`, http.StatusUnauthorized)
+	})
+	if err := writer.Write(entries[:1]); err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Errorf("expected the server's error, got %v", err)
+	}
+}
+
+func TestHTTPWriterRetriesWholeBatch(t *testing.T) {
+	var (
+		bodies   []string
+		requests int
+		mux      sync.Mutex
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mux.Lock()
+		defer mux.Unlock()
+		if requests++; requests == 2 {
+			http.Error(w, "timeout", http.StatusServiceUnavailable)
+			return
+		}
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	writer, err := influx.NewHTTPWriter(context.Background(), influx.HTTPConfig{URL: server.URL + "/write?db=flights", BatchSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	// The second entry changes the serving cell of the lines after it.
+	entries := append(servingCellEntries()[1:], servingCellEntries()...)
+	if err := writer.Write(entries); err == nil {
+		t.Fatal("expected the failed request to fail the batch")
+	}
+	if err := writer.Write(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	if len(bodies) != 4 || bodies[0] != bodies[1] {
+		t.Errorf("expected the accepted request to be posted again with the same line, got %q", bodies)
+	}
+	if strings.Contains(bodies[1], "cell_id") || !strings.Contains(bodies[3], "cell_id=ABC") {
+		t.Errorf("expected only the lines after the serving cell to be tagged with it, got %q", bodies)
+	}
+}
@@ -0,0 +1,97 @@
+package mavlink
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/bluenviron/gomavlib/v3/pkg/dialects/common"
+
+	"github.com/harshabose/cellular_localisation_logging/internal/multierr"
+)
+
+// AutopilotIdentity is what an autopilot reports about itself in HEARTBEAT and AUTOPILOT_VERSION.
+type AutopilotIdentity struct {
+	Autopilot          string `json:"autopilot"`    // e.g. MAV_AUTOPILOT_ARDUPILOTMEGA
+	VehicleType        string `json:"vehicle_type"` // e.g. MAV_TYPE_QUADROTOR
+	MAVLinkVersion     uint8  `json:"mavlink_version"`
+	FlightSoftware     string `json:"flight_software,omitempty"` // e.g. "4.5.7 official (1a2b3c4d)"
+	MiddlewareSoftware string `json:"middleware_software,omitempty"`
+	OSSoftware         string `json:"os_software,omitempty"`
+	BoardVersion       uint32 `json:"board_version,omitempty"`
+	VendorID           uint16 `json:"vendor_id,omitempty"`
+	ProductID          uint16 `json:"product_id,omitempty"`
+	UID                string `json:"uid,omitempty"` // hex, of uid2 if the autopilot sets it
+	Capabilities       uint64 `json:"capabilities,omitempty"`
+}
+
+// Identify waits for a HEARTBEAT and requests AUTOPILOT_VERSION, for the session header. Fields of a
+// message that did not arrive are left empty.
+func (r *Mavlink) Identify() (interface{}, error) {
+	var (
+		identity AutopilotIdentity
+		err      error
+	)
+
+	if entry, e := NewMessage[*common.MessageHeartbeat](context.Background()).Process(r); entry.Success {
+		heartbeat := entry.Data.(*common.MessageHeartbeat)
+		identity.Autopilot = heartbeat.Autopilot.String()
+		identity.VehicleType = heartbeat.Type.String()
+		identity.MAVLinkVersion = heartbeat.MavlinkVersion
+	} else {
+		err = multierr.Append(err, fmt.Errorf("HEARTBEAT: %s", entryError(entry.Error, e)))
+	}
+
+	if entry, e := NewMessage[*common.MessageAutopilotVersion](context.Background()).Process(r); entry.Success {
+		version := entry.Data.(*common.MessageAutopilotVersion)
+		identity.FlightSoftware = softwareVersion(version.FlightSwVersion, version.FlightCustomVersion)
+		identity.MiddlewareSoftware = softwareVersion(version.MiddlewareSwVersion, version.MiddlewareCustomVersion)
+		identity.OSSoftware = softwareVersion(version.OsSwVersion, version.OsCustomVersion)
+		identity.BoardVersion = version.BoardVersion
+		identity.VendorID = version.VendorId
+		identity.ProductID = version.ProductId
+		identity.Capabilities = uint64(version.Capabilities)
+
+		if version.Uid2 != [18]uint8{} {
+			identity.UID = hex.EncodeToString(version.Uid2[:])
+		} else if version.Uid != 0 {
+			identity.UID = fmt.Sprintf("%016x", version.Uid)
+		}
+	} else {
+		err = multierr.Append(err, fmt.Errorf("AUTOPILOT_VERSION: %s", entryError(entry.Error, e)))
+	}
+
+	return identity, err
+}
+
+func entryError(message string, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return message
+}
+
+// softwareVersion formats a version as packed in AUTOPILOT_VERSION: major, minor and patch in the
+// upper three bytes and a FIRMWARE_VERSION_TYPE in the lowest, followed by the git hash, if any.
+func softwareVersion(packed uint32, custom [8]uint8) string {
+	if packed == 0 {
+		return ""
+	}
+
+	kind := strings.ToLower(strings.TrimPrefix(common.FIRMWARE_VERSION_TYPE(packed&0xff).String(), "FIRMWARE_VERSION_TYPE_"))
+	version := fmt.Sprintf("%d.%d.%d %s", packed>>24, packed>>16&0xff, packed>>8&0xff, kind)
+
+	hash := strings.TrimRight(string(custom[:]), "\x00")
+	if hash == "" {
+		return version
+	}
+	// ArduPilot sends the hash as text, PX4 as bytes.
+	for _, c := range []byte(hash) {
+		if c < 0x20 || c > 0x7e {
+			hash = hex.EncodeToString(custom[:])
+			break
+		}
+	}
+	return version + " (" + hash + ")"
+}
@@ -11,25 +11,39 @@ import (
 	"github.com/bluenviron/gomavlib/v3/pkg/dialect"
 	"github.com/bluenviron/gomavlib/v3/pkg/dialects/ardupilotmega"
 	"github.com/bluenviron/gomavlib/v3/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v3/pkg/frame"
 	"github.com/bluenviron/gomavlib/v3/pkg/message"
 
 	"github.com/harshabose/cellular_localisation_logging"
 )
 
+// RequesterName is the name messages from this package target by default.
+const RequesterName = "mavlink"
+
 type Mavlink struct {
-	node    *gomavlib.Node
-	timeout time.Duration
+	node     *gomavlib.Node
+	timeout  time.Duration
+	verifier *verifier // nil without signing
+	mode     SigningMode
+	clock    clock
+	once     sync.Once
 }
 
 func NewMavlink(device string, baud int, timeout time.Duration, dialect *dialect.Dialect, version gomavlib.Version) (*Mavlink, error) {
+	return NewMavlinkWithEndpoints([]gomavlib.EndpointConf{
+		gomavlib.EndpointSerial{
+			Device: device,
+			Baud:   baud,
+		},
+	}, timeout, dialect, version)
+}
+
+// NewMavlinkWithEndpoints is like NewMavlink but accepts any gomavlib endpoint, e.g. a UDP client
+// pointed at a simulated vehicle.
+func NewMavlinkWithEndpoints(endpoints []gomavlib.EndpointConf, timeout time.Duration, dialect *dialect.Dialect, version gomavlib.Version) (*Mavlink, error) {
 	r := &Mavlink{
 		node: &gomavlib.Node{
-			Endpoints: []gomavlib.EndpointConf{
-				gomavlib.EndpointSerial{
-					Device: device,
-					Baud:   baud,
-				},
-			},
+			Endpoints:   endpoints,
 			Dialect:     dialect,
 			OutVersion:  version,
 			OutSystemID: 10,
@@ -37,37 +51,112 @@ func NewMavlink(device string, baud int, timeout time.Duration, dialect *dialect
 		timeout: timeout,
 	}
 
-	if err := r.node.Initialize(); err != nil {
+	if err := r.initialize(); err != nil {
 		return nil, err
 	}
+	return r, nil
+}
+
+// NewSignedMavlink is like NewMavlinkWithEndpoints for autopilots that require MAVLink 2 signing: it
+// signs its requests, and records the signature check of each entry in its Metadata under
+// SignatureMetadataKey.
+func NewSignedMavlink(endpoints []gomavlib.EndpointConf, timeout time.Duration, dialect *dialect.Dialect, signing Signing) (*Mavlink, error) {
+	if err := signing.validate(); err != nil {
+		return nil, err
+	}
+
+	verifier, err := newVerifier(signing.Key, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Mavlink{
+		node: &gomavlib.Node{
+			Endpoints:   endpoints,
+			Dialect:     dialect,
+			OutVersion:  gomavlib.V2,
+			OutSystemID: 10,
+			OutKey:      frame.NewV2Key(signing.Key),
+		},
+		timeout:  timeout,
+		verifier: verifier,
+		mode:     signing.Mode,
+	}
 
+	if err := r.initialize(); err != nil {
+		return nil, err
+	}
 	return r, nil
 }
 
+func (r *Mavlink) initialize() error {
+	if err := r.node.Initialize(); err != nil {
+		return err
+	}
+
+	r.waitChannelOpen()
+
+	return nil
+}
+
+// waitChannelOpen blocks until the node opens its first channel or the timeout elapses. Messages
+// written before that are silently dropped by gomavlib, which would fail the first request.
+// Server endpoints only open a channel once a peer talks to them, so a timeout is not an error.
+func (r *Mavlink) waitChannelOpen() {
+	timer := time.NewTimer(r.timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			return
+		case event, ok := <-r.node.Events():
+			if !ok {
+				return
+			}
+			if _, ok := event.(*gomavlib.EventChannelOpen); ok {
+				return
+			}
+		}
+	}
+}
+
 func (r *Mavlink) Process(messages cellularlog.Message) (cellularlog.LogEntry, error) {
 	return messages.Process(r)
 }
 
+func (r *Mavlink) Close() error {
+	r.once.Do(r.node.Close)
+	return nil
+}
+
 type Message[T message.Message] struct {
-	index    uint64
-	messages []cellularlog.LogEntry
-	id       uint32
-	ctx      context.Context
-	mux      sync.RWMutex
+	index     uint64
+	history   *cellularlog.History
+	id        uint32
+	requester string
+	ctx       context.Context
 }
 
 func NewMessage[T message.Message](ctx context.Context) *Message[T] {
 	empty := new(T)
 
 	return &Message[T]{
-		messages: make([]cellularlog.LogEntry, 0),
-		id:       (*empty).GetID(),
-		ctx:      ctx,
+		history:   cellularlog.NewHistory(cellularlog.DefaultHistoryCapacity, 0),
+		id:        (*empty).GetID(),
+		requester: RequesterName,
+		ctx:       ctx,
 	}
 }
 
-func (m *Message[T]) Request(processor *cellularlog.Processor) (cellularlog.LogEntry, error) {
-	return processor.Mavlink.Process(m)
+// SetRequester points the message at a requester registered under a name other than RequesterName,
+// e.g. when logging from two autopilots.
+func (m *Message[T]) SetRequester(name string) {
+	m.requester = name
+}
+
+func (m *Message[T]) GetRequester() string {
+	return m.requester
 }
 
 func (m *Message[T]) Process(requester cellularlog.Requester) (cellularlog.LogEntry, error) {
@@ -125,30 +214,46 @@ func (m *Message[T]) Process(requester cellularlog.Requester) (cellularlog.LogEn
 				return log, errors.New("error interface mismatch")
 			}
 
-			if frm, ok := event.(*gomavlib.EventFrame); ok {
-				msg, ok := frm.Message().(T)
-				if !ok {
-					continue
-				}
+			frm, ok := event.(*gomavlib.EventFrame)
+			if !ok {
+				continue
+			}
 
-				log.Success = true
-				log.Data = msg
-				log.ResponseTime = time.Now()
-				log.Duration = log.ResponseTime.Sub(log.RequestTime)
+			r.observe(frm)
 
-				m.add(log)
+			msg, ok := frm.Message().(T)
+			if !ok {
+				continue
+			}
+			signature, ok := r.accept(frm)
+			if !ok {
+				continue
+			}
 
-				return log, nil
+			log.ResponseTime = time.Now()
+			if r.verifier != nil {
+				log.Metadata = map[string]interface{}{SignatureMetadataKey: signature}
+			}
+			if sample, ok := r.clock.sampleTime(msg, log.ResponseTime); ok {
+				if log.Metadata == nil {
+					log.Metadata = make(map[string]interface{})
+				}
+				log.Metadata[SampleTimeMetadataKey] = sample
 			}
+
+			log.Success = true
+			log.Data = msg
+			log.Duration = log.ResponseTime.Sub(log.RequestTime)
+
+			m.add(log)
+
+			return log, nil
 		}
 	}
 }
 
 func (m *Message[T]) add(log cellularlog.LogEntry) {
-	m.mux.Lock()
-	defer m.mux.Unlock()
-
-	m.messages = append(m.messages, log)
+	m.history.Add(log)
 }
 
 func (m *Message[T]) GetType() string {
@@ -156,8 +261,10 @@ func (m *Message[T]) GetType() string {
 }
 
 func (m *Message[T]) GetAllEntries() []cellularlog.LogEntry {
-	m.mux.RLock()
-	defer m.mux.RUnlock()
+	return m.history.All()
+}
 
-	return m.messages
+// History returns the bounded record of the message's entries.
+func (m *Message[T]) History() *cellularlog.History {
+	return m.history
 }
@@ -0,0 +1,357 @@
+package mavlink_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v3"
+	"github.com/bluenviron/gomavlib/v3/pkg/dialects/all"
+	"github.com/bluenviron/gomavlib/v3/pkg/dialects/common"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/mavlink"
+	"github.com/harshabose/cellular_localisation_logging/pkg/mavlink/sim"
+)
+
+func freeUDPAddress(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error reserving udp port: %v", err)
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().String()
+}
+
+// startVehicle starts a simulated vehicle on a free UDP port and returns the endpoints to reach it.
+func startVehicle(t *testing.T, conf sim.Config) []gomavlib.EndpointConf {
+	t.Helper()
+
+	address := freeUDPAddress(t)
+	conf.Endpoints = []gomavlib.EndpointConf{gomavlib.EndpointUDPServer{Address: address}}
+
+	vehicle, err := sim.NewVehicle(context.Background(), conf)
+	if err != nil {
+		t.Fatalf("error creating vehicle: %v", err)
+	}
+	vehicle.Start()
+	t.Cleanup(func() { _ = vehicle.Close() })
+
+	return []gomavlib.EndpointConf{gomavlib.EndpointUDPClient{Address: address}}
+}
+
+// setup starts a simulated vehicle and connects a Mavlink requester to it.
+func setup(t *testing.T, conf sim.Config, timeout time.Duration) *mavlink.Mavlink {
+	t.Helper()
+
+	requester, err := mavlink.NewMavlinkWithEndpoints(startVehicle(t, conf), timeout, all.Dialect, gomavlib.V2)
+	if err != nil {
+		t.Fatalf("error creating requester: %v", err)
+	}
+	t.Cleanup(func() { _ = requester.Close() })
+
+	return requester
+}
+
+func TestMessageProcessSuccess(t *testing.T) {
+	requester := setup(t, sim.Config{}, 2*time.Second)
+
+	message := mavlink.NewMessage[*all.MessageGlobalPositionInt](context.Background())
+
+	var previous *all.MessageGlobalPositionInt
+	for i := 0; i < 3; i++ {
+		log, err := requester.Process(message)
+		if err != nil {
+			t.Fatalf("request %d: unexpected error: %v", i, err)
+		}
+
+		if !log.Success || log.Error != "" {
+			t.Fatalf("request %d: expected success, got %+v", i, log)
+		}
+		if log.Index != uint64(i) {
+			t.Errorf("request %d: expected index %d, got %d", i, i, log.Index)
+		}
+		if log.MessageType != "mavlink-33" {
+			t.Errorf("request %d: unexpected message type %q", i, log.MessageType)
+		}
+		if log.Duration <= 0 || log.ResponseTime.Before(log.RequestTime) {
+			t.Errorf("request %d: inconsistent timing %+v", i, log)
+		}
+
+		data, ok := log.Data.(*all.MessageGlobalPositionInt)
+		if !ok {
+			t.Fatalf("request %d: unexpected data type %T", i, log.Data)
+		}
+		if data.Lat == 0 || data.Lon == 0 {
+			t.Errorf("request %d: expected a position, got %+v", i, data)
+		}
+		if previous != nil && data.TimeBootMs <= previous.TimeBootMs {
+			t.Errorf("request %d: time_boot_ms did not advance", i)
+		}
+		previous = data
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if n := len(message.GetAllEntries()); n != 3 {
+		t.Errorf("expected 3 stored entries, got %d", n)
+	}
+}
+
+func TestMessageProcessLatency(t *testing.T) {
+	latency := 150 * time.Millisecond
+	requester := setup(t, sim.Config{Latency: latency}, 2*time.Second)
+
+	log, err := requester.Process(mavlink.NewMessage[*all.MessageAttitude](context.Background()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if log.Duration < latency {
+		t.Errorf("expected duration of at least %v, got %v", latency, log.Duration)
+	}
+}
+
+func TestMessageProcessTimeout(t *testing.T) {
+	tests := map[string]sim.Config{
+		"unsupported message": {Messages: []uint32{(&common.MessageHeartbeat{}).GetID()}},
+		"total packet loss":   {LossRate: 1},
+	}
+
+	for name, conf := range tests {
+		t.Run(name, func(t *testing.T) {
+			timeout := 300 * time.Millisecond
+			requester := setup(t, conf, timeout)
+
+			message := mavlink.NewMessage[*all.MessageAttitude](context.Background())
+
+			start := time.Now()
+			log, err := requester.Process(message)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("expected deadline exceeded, got %v", err)
+			}
+
+			if elapsed := time.Since(start); elapsed < timeout {
+				t.Errorf("returned after %v, before the %v timeout", elapsed, timeout)
+			}
+			if log.Success || log.Error != "request timeout" {
+				t.Errorf("expected a failed timeout entry, got %+v", log)
+			}
+			if n := len(message.GetAllEntries()); n != 1 {
+				t.Errorf("expected the failure to be stored, got %d entries", n)
+			}
+		})
+	}
+}
+
+func TestMessageProcessCancelled(t *testing.T) {
+	requester := setup(t, sim.Config{Latency: 5 * time.Second}, 10*time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	message := mavlink.NewMessage[*all.MessageAttitude](ctx)
+
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	log, err := requester.Process(message)
+	if err != nil {
+		t.Fatalf("cancellation should not be reported as an error, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("cancellation took %v", elapsed)
+	}
+	if log.Success || log.Error != "context cancelled" {
+		t.Errorf("expected a cancelled entry, got %+v", log)
+	}
+}
+
+type otherRequester struct{}
+
+func (otherRequester) Process(m cellularlog.Message) (cellularlog.LogEntry, error) {
+	return m.Process(otherRequester{})
+}
+
+func TestMessageProcessInterfaceMismatch(t *testing.T) {
+	message := mavlink.NewMessage[*all.MessageAttitude](context.Background())
+
+	log, err := otherRequester{}.Process(message)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if log.Success {
+		t.Errorf("expected a failed entry, got %+v", log)
+	}
+}
+
+func TestMessageProcessPTY(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("pty is only supported on linux")
+	}
+
+	pty, err := sim.OpenPTY()
+	if err != nil {
+		t.Skipf("pty unavailable: %v", err)
+	}
+	defer pty.Close()
+
+	vehicle, err := sim.NewVehicle(context.Background(), sim.Config{
+		Endpoints: []gomavlib.EndpointConf{gomavlib.EndpointCustom{ReadWriteCloser: pty}},
+	})
+	if err != nil {
+		t.Fatalf("error creating vehicle: %v", err)
+	}
+	vehicle.Start()
+	defer vehicle.Close()
+
+	requester, err := mavlink.NewMavlink(pty.Name(), 57600, 2*time.Second, all.Dialect, gomavlib.V2)
+	if err != nil {
+		t.Fatalf("error creating requester: %v", err)
+	}
+	defer requester.Close()
+
+	log, err := requester.Process(mavlink.NewMessage[*all.MessageScaledImu2](context.Background()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := log.Data.(*all.MessageScaledImu2); !ok || !log.Success {
+		t.Errorf("expected SCALED_IMU2, got %+v", log)
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	requester := setup(t, sim.Config{}, 2*time.Second)
+
+	identity, err := requester.Identify()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	autopilot, ok := identity.(mavlink.AutopilotIdentity)
+	if !ok {
+		t.Fatalf("unexpected identity type %T", identity)
+	}
+	if autopilot.Autopilot != "MAV_AUTOPILOT_ARDUPILOTMEGA" || autopilot.VehicleType != "MAV_TYPE_QUADROTOR" {
+		t.Errorf("unexpected heartbeat identity %+v", autopilot)
+	}
+	if autopilot.FlightSoftware != "4.5.7 official" || autopilot.VendorID != 0x1209 || autopilot.UID == "" {
+		t.Errorf("unexpected version identity %+v", autopilot)
+	}
+}
+
+func TestSigning(t *testing.T) {
+	key, err := mavlink.ParseSigningKey("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		vehicle   []byte
+		signing   *mavlink.Signing
+		signature string // expected in the metadata, empty for a timeout
+	}{
+		"unsigned requests are ignored": {vehicle: key},
+		"signed":                        {vehicle: key, signing: &mavlink.Signing{Key: key, Mode: mavlink.SigningRequire}, signature: mavlink.SignatureValid},
+		"unsigned vehicle, verify":      {signing: &mavlink.Signing{Key: key, Mode: mavlink.SigningVerify}, signature: mavlink.SignatureUnsigned},
+		"unsigned vehicle, require":     {signing: &mavlink.Signing{Key: key, Mode: mavlink.SigningRequire}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			endpoints := startVehicle(t, sim.Config{SigningKey: test.vehicle})
+			timeout := 500 * time.Millisecond
+
+			var requester *mavlink.Mavlink
+			if test.signing != nil {
+				requester, err = mavlink.NewSignedMavlink(endpoints, timeout, all.Dialect, *test.signing)
+			} else {
+				requester, err = mavlink.NewMavlinkWithEndpoints(endpoints, timeout, all.Dialect, gomavlib.V2)
+			}
+			if err != nil {
+				t.Fatalf("error creating requester: %v", err)
+			}
+			defer requester.Close()
+
+			log, err := requester.Process(mavlink.NewMessage[*all.MessageAttitude](context.Background()))
+			if test.signature == "" {
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("expected a timeout, got %+v (%v)", log, err)
+				}
+				return
+			}
+
+			if err != nil || !log.Success {
+				t.Fatalf("expected success, got %+v (%v)", log, err)
+			}
+			if signature := log.Metadata[mavlink.SignatureMetadataKey]; signature != test.signature {
+				t.Errorf("expected signature %q, got %v", test.signature, signature)
+			}
+		})
+	}
+}
+
+func TestTimeSync(t *testing.T) {
+	const latency = 20 * time.Millisecond
+
+	for _, tc := range []struct {
+		name   string
+		gps    bool
+		source string
+	}{
+		{"timesync", false, mavlink.TimeSourceTimeSync},
+		{"gps", true, mavlink.TimeSourceGPS},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			requester := setup(t, sim.Config{NoGPSTime: !tc.gps, Latency: latency}, 2*time.Second)
+
+			attitude := mavlink.NewMessage[*all.MessageAttitude](context.Background())
+			log, err := requester.Process(attitude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := log.Metadata[mavlink.SampleTimeMetadataKey]; ok {
+				t.Fatalf("unexpected sample time before the clock is known: %+v", log.Metadata)
+			}
+
+			timeSync := mavlink.NewTimeSync(context.Background())
+			for i := 0; i < 4; i++ {
+				if _, err := requester.Process(timeSync); err != nil {
+					t.Fatal(err)
+				}
+			}
+			estimate, ok := timeSync.GetAllEntries()[3].Data.(mavlink.ClockEstimate)
+			if !ok || estimate.Samples != 4 || estimate.RTTMS < milliseconds(latency) || estimate.DriftPPM != 0 {
+				t.Fatalf("unexpected estimate: %+v", timeSync.GetAllEntries()[3].Data)
+			}
+			if _, err := requester.Process(mavlink.NewMessage[*all.MessageSystemTime](context.Background())); err != nil {
+				t.Fatal(err)
+			}
+
+			log, err = requester.Process(attitude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sample, ok := log.Metadata[mavlink.SampleTimeMetadataKey].(mavlink.SampleTime)
+			if !ok || sample.Source != tc.source {
+				t.Fatalf("expected a sample time from %s, got %+v", tc.source, log.Metadata)
+			}
+
+			// The vehicle samples ATTITUDE when asked, one latency before the response arrives.
+			sampled := log.ResponseTime.Add(-latency)
+			if diff := milliseconds(sample.Time.Sub(sampled)); diff > sample.UncertaintyMS+5 || diff < -sample.UncertaintyMS-5 {
+				t.Errorf("sample time off by %.1f ms, uncertainty %.1f ms", diff, sample.UncertaintyMS)
+			}
+		})
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,231 @@
+package sim
+
+import (
+	"math"
+	"sort"
+
+	"github.com/bluenviron/gomavlib/v3/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v3/pkg/message"
+)
+
+type generator func(v *Vehicle, s State) message.Message
+
+// generators holds every message the simulated vehicle knows how to synthesise, keyed by message ID.
+var generators = map[uint32]generator{
+	(&common.MessageHeartbeat{}).GetID():         heartbeat,
+	(&common.MessageSysStatus{}).GetID():         sysStatus,
+	(&common.MessageSystemTime{}).GetID():        systemTime,
+	(&common.MessageAttitude{}).GetID():          attitude,
+	(&common.MessageGlobalPositionInt{}).GetID(): globalPositionInt,
+	(&common.MessageLocalPositionNed{}).GetID():  localPositionNed,
+	(&common.MessageGpsRawInt{}).GetID():         gpsRawInt,
+	(&common.MessageVfrHud{}).GetID():            vfrHud,
+	(&common.MessageScaledImu{}).GetID():         scaledImu,
+	(&common.MessageScaledImu2{}).GetID():        scaledImu2,
+	(&common.MessageRawImu{}).GetID():            rawImu,
+	(&common.MessageScaledPressure{}).GetID():    scaledPressure,
+	(&common.MessageAutopilotVersion{}).GetID():  autopilotVersion,
+}
+
+// SupportedMessages returns the IDs of all messages the simulator can generate, in ascending order.
+func SupportedMessages() []uint32 {
+	ids := make([]uint32, 0, len(generators))
+	for id := range generators {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func heartbeat(v *Vehicle, _ State) message.Message {
+	mode := common.MAV_MODE_FLAG_CUSTOM_MODE_ENABLED | common.MAV_MODE_FLAG_GUIDED_ENABLED | common.MAV_MODE_FLAG_STABILIZE_ENABLED
+	status := common.MAV_STATE_STANDBY
+	if v.Armed() {
+		mode |= common.MAV_MODE_FLAG_SAFETY_ARMED
+		status = common.MAV_STATE_ACTIVE
+	}
+
+	return &common.MessageHeartbeat{
+		Type:           common.MAV_TYPE_QUADROTOR,
+		Autopilot:      common.MAV_AUTOPILOT_ARDUPILOTMEGA,
+		BaseMode:       mode,
+		CustomMode:     4, // GUIDED
+		SystemStatus:   status,
+		MavlinkVersion: 3,
+	}
+}
+
+func sysStatus(_ *Vehicle, s State) message.Message {
+	sensors := common.MAV_SYS_STATUS_SENSOR_3D_GYRO | common.MAV_SYS_STATUS_SENSOR_3D_ACCEL |
+		common.MAV_SYS_STATUS_SENSOR_3D_MAG | common.MAV_SYS_STATUS_SENSOR_ABSOLUTE_PRESSURE |
+		common.MAV_SYS_STATUS_SENSOR_GPS
+
+	remaining := 100 - int(s.Elapsed.Minutes())
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &common.MessageSysStatus{
+		OnboardControlSensorsPresent: sensors,
+		OnboardControlSensorsEnabled: sensors,
+		OnboardControlSensorsHealth:  sensors,
+		Load:                         250,
+		VoltageBattery:               uint16(16800 - 20*(100-remaining)),
+		CurrentBattery:               1250,
+		BatteryRemaining:             int8(remaining),
+	}
+}
+
+func systemTime(v *Vehicle, s State) message.Message {
+	msg := &common.MessageSystemTime{
+		TimeUnixUsec: uint64(v.start.Add(s.Elapsed).UnixMicro()),
+		TimeBootMs:   uint32(s.Elapsed.Milliseconds()),
+	}
+	if v.conf.NoGPSTime {
+		msg.TimeUnixUsec = 0
+	}
+	return msg
+}
+
+func attitude(_ *Vehicle, s State) message.Message {
+	return &common.MessageAttitude{
+		TimeBootMs: uint32(s.Elapsed.Milliseconds()),
+		Roll:       float32(s.Roll),
+		Pitch:      float32(s.Pitch),
+		Yaw:        float32(s.Yaw),
+		Yawspeed:   float32(s.YawRate),
+	}
+}
+
+func globalPositionInt(v *Vehicle, s State) message.Message {
+	return &common.MessageGlobalPositionInt{
+		TimeBootMs:  uint32(s.Elapsed.Milliseconds()),
+		Lat:         int32(s.Lat * 1e7),
+		Lon:         int32(s.Lon * 1e7),
+		Alt:         int32(s.Alt * 1000),
+		RelativeAlt: int32(-s.Down * 1000),
+		Vx:          int16(s.VNorth * 100),
+		Vy:          int16(s.VEast * 100),
+		Vz:          int16(s.VDown * 100),
+		Hdg:         uint16(s.Heading() * 100),
+	}
+}
+
+func localPositionNed(_ *Vehicle, s State) message.Message {
+	return &common.MessageLocalPositionNed{
+		TimeBootMs: uint32(s.Elapsed.Milliseconds()),
+		X:          float32(s.North),
+		Y:          float32(s.East),
+		Z:          float32(s.Down),
+		Vx:         float32(s.VNorth),
+		Vy:         float32(s.VEast),
+		Vz:         float32(s.VDown),
+	}
+}
+
+func gpsRawInt(v *Vehicle, s State) message.Message {
+	return &common.MessageGpsRawInt{
+		TimeUsec:          uint64(v.start.Add(s.Elapsed).UnixMicro()),
+		FixType:           common.GPS_FIX_TYPE_3D_FIX,
+		Lat:               int32(s.Lat * 1e7),
+		Lon:               int32(s.Lon * 1e7),
+		Alt:               int32(s.Alt * 1000),
+		Eph:               90,
+		Epv:               140,
+		Vel:               uint16(s.GroundSpeed() * 100),
+		Cog:               uint16(s.Heading() * 100),
+		SatellitesVisible: 14,
+	}
+}
+
+func vfrHud(_ *Vehicle, s State) message.Message {
+	return &common.MessageVfrHud{
+		Airspeed:    float32(s.GroundSpeed()),
+		Groundspeed: float32(s.GroundSpeed()),
+		Heading:     int16(s.Heading()),
+		Throttle:    45,
+		Alt:         float32(s.Alt),
+		Climb:       float32(-s.VDown),
+	}
+}
+
+// imu returns body-frame specific force (mg), angular rate (mrad/s) and magnetic field (mgauss) for
+// a coordinated level turn.
+func imu(s State) (acc, gyro, mag [3]int16) {
+	acc = [3]int16{0, 0, int16(-1000 / math.Cos(s.Roll))}
+	gyro = [3]int16{0, int16(1000 * s.YawRate * math.Sin(s.Roll)), int16(1000 * s.YawRate * math.Cos(s.Roll))}
+	mag = [3]int16{int16(300 * math.Cos(s.Yaw)), int16(-300 * math.Sin(s.Yaw)), 200}
+	return acc, gyro, mag
+}
+
+func scaledImu(_ *Vehicle, s State) message.Message {
+	acc, gyro, mag := imu(s)
+	return &common.MessageScaledImu{
+		TimeBootMs:  uint32(s.Elapsed.Milliseconds()),
+		Xacc:        acc[0],
+		Yacc:        acc[1],
+		Zacc:        acc[2],
+		Xgyro:       gyro[0],
+		Ygyro:       gyro[1],
+		Zgyro:       gyro[2],
+		Xmag:        mag[0],
+		Ymag:        mag[1],
+		Zmag:        mag[2],
+		Temperature: 4500,
+	}
+}
+
+func scaledImu2(_ *Vehicle, s State) message.Message {
+	acc, gyro, mag := imu(s)
+	return &common.MessageScaledImu2{
+		TimeBootMs:  uint32(s.Elapsed.Milliseconds()),
+		Xacc:        acc[0] + 2,
+		Yacc:        acc[1] - 3,
+		Zacc:        acc[2] + 4,
+		Xgyro:       gyro[0],
+		Ygyro:       gyro[1],
+		Zgyro:       gyro[2],
+		Xmag:        mag[0],
+		Ymag:        mag[1],
+		Zmag:        mag[2],
+		Temperature: 4620,
+	}
+}
+
+func rawImu(v *Vehicle, s State) message.Message {
+	acc, gyro, mag := imu(s)
+	return &common.MessageRawImu{
+		TimeUsec:    uint64(s.Elapsed.Microseconds()),
+		Xacc:        acc[0],
+		Yacc:        acc[1],
+		Zacc:        acc[2],
+		Xgyro:       gyro[0],
+		Ygyro:       gyro[1],
+		Zgyro:       gyro[2],
+		Xmag:        mag[0],
+		Ymag:        mag[1],
+		Zmag:        mag[2],
+		Temperature: 4500,
+	}
+}
+
+func scaledPressure(_ *Vehicle, s State) message.Message {
+	// International standard atmosphere, good enough below 11 km.
+	pressure := 1013.25 * math.Pow(1-2.25577e-5*s.Alt, 5.25588)
+	return &common.MessageScaledPressure{
+		TimeBootMs:  uint32(s.Elapsed.Milliseconds()),
+		PressAbs:    float32(pressure),
+		Temperature: 3100,
+	}
+}
+
+func autopilotVersion(v *Vehicle, _ State) message.Message {
+	return &common.MessageAutopilotVersion{
+		Capabilities:    common.MAV_PROTOCOL_CAPABILITY_MAVLINK2 | common.MAV_PROTOCOL_CAPABILITY_COMMAND_INT,
+		FlightSwVersion: 4<<24 | 5<<16 | 7<<8 | 255, // 4.5.7 official
+		BoardVersion:    140 << 16,
+		VendorId:        0x1209,
+		ProductId:       0x5741,
+		Uid:             uint64(v.conf.SystemID)<<56 | 0x53494d,
+	}
+}
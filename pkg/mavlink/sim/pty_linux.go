@@ -0,0 +1,91 @@
+//go:build linux
+
+package sim
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/harshabose/cellular_localisation_logging/internal/multierr"
+)
+
+// PTY is a pseudo-terminal pair. The vehicle talks on the master side through
+// gomavlib.EndpointCustom{ReadWriteCloser: pty} while the logger opens Name() as if it were a
+// serial device.
+type PTY struct {
+	master *os.File
+	slave  *os.File
+}
+
+func OpenPTY() (*PTY, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error opening /dev/ptmx: %w", err)
+	}
+
+	fd := int(master.Fd())
+
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		_ = master.Close()
+		return nil, fmt.Errorf("error unlocking pty: %w", err)
+	}
+
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		_ = master.Close()
+		return nil, fmt.Errorf("error getting pty number: %w", err)
+	}
+
+	// The slave is held open for the lifetime of the pair so that master reads don't fail with EIO
+	// while the logger is not connected, and is put in raw mode so the line discipline neither
+	// echoes nor rewrites binary frames before the logger configures it.
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		_ = master.Close()
+		return nil, fmt.Errorf("error opening pty slave: %w", err)
+	}
+
+	if err := makeRaw(int(slave.Fd())); err != nil {
+		_ = slave.Close()
+		_ = master.Close()
+		return nil, fmt.Errorf("error setting pty raw mode: %w", err)
+	}
+
+	return &PTY{master: master, slave: slave}, nil
+}
+
+func makeRaw(fd int) error {
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB
+	t.Cflag |= unix.CS8
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+
+	return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+}
+
+// Name returns the path of the slave device, e.g. /dev/pts/3.
+func (p *PTY) Name() string {
+	return p.slave.Name()
+}
+
+func (p *PTY) Read(b []byte) (int, error) {
+	return p.master.Read(b)
+}
+
+func (p *PTY) Write(b []byte) (int, error) {
+	return p.master.Write(b)
+}
+
+func (p *PTY) Close() error {
+	return multierr.Combine(p.slave.Close(), p.master.Close())
+}
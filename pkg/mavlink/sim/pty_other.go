@@ -0,0 +1,27 @@
+//go:build !linux
+
+package sim
+
+import "errors"
+
+type PTY struct{}
+
+func OpenPTY() (*PTY, error) {
+	return nil, errors.New("pty is only supported on linux")
+}
+
+func (p *PTY) Name() string {
+	return ""
+}
+
+func (p *PTY) Read([]byte) (int, error) {
+	return 0, errors.New("pty is only supported on linux")
+}
+
+func (p *PTY) Write([]byte) (int, error) {
+	return 0, errors.New("pty is only supported on linux")
+}
+
+func (p *PTY) Close() error {
+	return nil
+}
@@ -0,0 +1,320 @@
+// Package sim provides a lightweight emulated MAVLink autopilot. It emits HEARTBEAT, answers
+// MAV_CMD_REQUEST_MESSAGE and MAV_CMD_SET_MESSAGE_INTERVAL with synthetic data from a moving
+// trajectory, and can inject packet loss and latency, so the mavlink package can be exercised
+// without a flight controller.
+package sim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v3"
+	"github.com/bluenviron/gomavlib/v3/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v3/pkg/frame"
+	"github.com/bluenviron/gomavlib/v3/pkg/message"
+)
+
+type Config struct {
+	// Endpoints the vehicle listens on, e.g. gomavlib.EndpointUDPServer{Address: "127.0.0.1:14550"}.
+	Endpoints []gomavlib.EndpointConf
+
+	SystemID    byte // defaults to 1, which is what the logger targets
+	ComponentID byte // defaults to 1 (MAV_COMP_ID_AUTOPILOT1)
+
+	// Messages restricts which message IDs the vehicle answers for. Empty means every message in
+	// SupportedMessages. Requests for anything else are acknowledged with MAV_RESULT_UNSUPPORTED.
+	Messages []uint32
+
+	HeartbeatPeriod time.Duration // defaults to 1s
+	Trajectory      Trajectory    // defaults to DefaultTrajectory
+	Disarmed        bool          // start disarmed
+	NoGPSTime       bool          // send SYSTEM_TIME without a UNIX time, like before a GPS fix
+
+	// SigningKey enables MAVLink 2 signing like on an autopilot that requires it: the vehicle signs
+	// its frames and ignores those that are not signed with the key.
+	SigningKey []byte
+
+	// LossRate is the probability in [0, 1] that any frame, in either direction, is dropped.
+	LossRate float64
+	// Latency is added to every outgoing frame, plus a uniformly random extra in [0, Jitter).
+	Latency time.Duration
+	Jitter  time.Duration
+}
+
+type Vehicle struct {
+	conf     Config
+	node     *gomavlib.Node
+	start    time.Time
+	messages map[uint32]generator
+
+	armed   bool
+	streams map[uint32]context.CancelFunc
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+	wg     sync.WaitGroup
+	mux    sync.RWMutex
+}
+
+func NewVehicle(ctx context.Context, conf Config) (*Vehicle, error) {
+	if len(conf.Endpoints) == 0 {
+		return nil, errors.New("at least one endpoint is required")
+	}
+	if conf.SystemID == 0 {
+		conf.SystemID = 1
+	}
+	if conf.ComponentID == 0 {
+		conf.ComponentID = 1
+	}
+	if conf.HeartbeatPeriod <= 0 {
+		conf.HeartbeatPeriod = time.Second
+	}
+	if conf.Trajectory == (Trajectory{}) {
+		conf.Trajectory = DefaultTrajectory
+	}
+
+	messages := make(map[uint32]generator)
+	if len(conf.Messages) == 0 {
+		for id, g := range generators {
+			messages[id] = g
+		}
+	}
+	for _, id := range conf.Messages {
+		g, ok := generators[id]
+		if !ok {
+			return nil, fmt.Errorf("simulator cannot generate message %d", id)
+		}
+		messages[id] = g
+	}
+
+	node := &gomavlib.Node{
+		Endpoints:        conf.Endpoints,
+		Dialect:          common.Dialect,
+		OutVersion:       gomavlib.V2,
+		OutSystemID:      conf.SystemID,
+		OutComponentID:   conf.ComponentID,
+		HeartbeatDisable: true, // sent by the vehicle itself so that loss and latency apply
+	}
+	if conf.SigningKey != nil {
+		node.InKey = frame.NewV2Key(conf.SigningKey)
+		node.OutKey = node.InKey
+	}
+	if err := node.Initialize(); err != nil {
+		return nil, err
+	}
+
+	ctx2, cancel := context.WithCancel(ctx)
+
+	return &Vehicle{
+		conf:     conf,
+		node:     node,
+		start:    time.Now(),
+		messages: messages,
+		armed:    !conf.Disarmed,
+		streams:  make(map[uint32]context.CancelFunc),
+		ctx:      ctx2,
+		cancel:   cancel,
+	}, nil
+}
+
+func (v *Vehicle) Start() {
+	v.wg.Add(2)
+	go v.heartbeatLoop()
+	go v.eventLoop()
+}
+
+// Armed reports the arming state advertised in HEARTBEAT.
+func (v *Vehicle) Armed() bool {
+	v.mux.RLock()
+	defer v.mux.RUnlock()
+
+	return v.armed
+}
+
+func (v *Vehicle) SetArmed(armed bool) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	v.armed = armed
+}
+
+// State returns the current trajectory state.
+func (v *Vehicle) State() State {
+	return v.conf.Trajectory.At(time.Since(v.start))
+}
+
+func (v *Vehicle) heartbeatLoop() {
+	defer v.wg.Done()
+
+	ticker := time.NewTicker(v.conf.HeartbeatPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-v.ctx.Done():
+			return
+		case <-ticker.C:
+			v.send(heartbeat(v, v.State()))
+		}
+	}
+}
+
+func (v *Vehicle) eventLoop() {
+	defer v.wg.Done()
+
+	for {
+		select {
+		case <-v.ctx.Done():
+			return
+		case event, ok := <-v.node.Events():
+			if !ok {
+				return
+			}
+
+			frm, ok := event.(*gomavlib.EventFrame)
+			if !ok || v.drop() {
+				continue
+			}
+
+			switch msg := frm.Message().(type) {
+			case *common.MessageCommandLong:
+				v.handleCommand(msg)
+			case *common.MessageTimesync:
+				// Requests have tc1 zero; the answer carries the time since boot in nanoseconds.
+				if msg.Tc1 == 0 {
+					v.send(&common.MessageTimesync{Tc1: time.Since(v.start).Nanoseconds(), Ts1: msg.Ts1})
+				}
+			}
+		}
+	}
+}
+
+func (v *Vehicle) handleCommand(cmd *common.MessageCommandLong) {
+	if cmd.TargetSystem != 0 && cmd.TargetSystem != v.conf.SystemID {
+		return
+	}
+
+	switch cmd.Command {
+	case common.MAV_CMD_REQUEST_MESSAGE:
+		g, ok := v.messages[uint32(cmd.Param1)]
+		if !ok {
+			v.ack(cmd.Command, common.MAV_RESULT_UNSUPPORTED)
+			return
+		}
+
+		v.ack(cmd.Command, common.MAV_RESULT_ACCEPTED)
+		v.send(g(v, v.State()))
+
+	case common.MAV_CMD_SET_MESSAGE_INTERVAL:
+		id := uint32(cmd.Param1)
+		if _, ok := v.messages[id]; !ok {
+			v.ack(cmd.Command, common.MAV_RESULT_UNSUPPORTED)
+			return
+		}
+
+		v.setInterval(id, cmd.Param2)
+		v.ack(cmd.Command, common.MAV_RESULT_ACCEPTED)
+
+	case common.MAV_CMD_COMPONENT_ARM_DISARM:
+		v.SetArmed(cmd.Param1 == 1)
+		v.ack(cmd.Command, common.MAV_RESULT_ACCEPTED)
+
+	default:
+		v.ack(cmd.Command, common.MAV_RESULT_UNSUPPORTED)
+	}
+}
+
+// setInterval follows MAV_CMD_SET_MESSAGE_INTERVAL semantics: param2 is the interval in
+// microseconds, -1 disables the stream and 0 restores the default (here: disabled).
+func (v *Vehicle) setInterval(id uint32, param2 float32) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	if cancel, ok := v.streams[id]; ok {
+		cancel()
+		delete(v.streams, id)
+	}
+
+	if param2 <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(v.ctx)
+	v.streams[id] = cancel
+
+	v.wg.Add(1)
+	go v.stream(ctx, v.messages[id], time.Duration(param2)*time.Microsecond)
+}
+
+func (v *Vehicle) stream(ctx context.Context, g generator, interval time.Duration) {
+	defer v.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			v.send(g(v, v.State()))
+		}
+	}
+}
+
+func (v *Vehicle) ack(cmd common.MAV_CMD, result common.MAV_RESULT) {
+	v.send(&common.MessageCommandAck{
+		Command: cmd,
+		Result:  result,
+	})
+}
+
+func (v *Vehicle) drop() bool {
+	return v.conf.LossRate > 0 && rand.Float64() < v.conf.LossRate
+}
+
+// send writes msg to every connected channel, honouring the configured loss and latency.
+func (v *Vehicle) send(msg message.Message) {
+	if v.drop() {
+		return
+	}
+
+	delay := v.conf.Latency
+	if v.conf.Jitter > 0 {
+		delay += rand.N(v.conf.Jitter)
+	}
+
+	if delay <= 0 {
+		_ = v.node.WriteMessageAll(msg)
+		return
+	}
+
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-v.ctx.Done():
+		case <-timer.C:
+			_ = v.node.WriteMessageAll(msg)
+		}
+	}()
+}
+
+func (v *Vehicle) Close() error {
+	v.once.Do(func() {
+		v.cancel()
+		v.wg.Wait()
+		v.node.Close()
+	})
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package sim
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	earthRadius = 6378137.0
+	gravity     = 9.80665
+)
+
+// Trajectory describes a vehicle flying a horizontal circle around Home at a constant speed. It is
+// deliberately simple: the aim is data that moves and stays self-consistent across messages, not
+// a flight model.
+type Trajectory struct {
+	HomeLat float64 // degrees
+	HomeLon float64 // degrees
+	HomeAlt float64 // metres AMSL
+	Height  float64 // metres above home
+	Radius  float64 // metres
+	Speed   float64 // metres per second
+}
+
+// DefaultTrajectory is a 100 m circle at 10 m/s, 50 m above an arbitrary home.
+var DefaultTrajectory = Trajectory{
+	HomeLat: 12.9716,
+	HomeLon: 77.5946,
+	HomeAlt: 920,
+	Height:  50,
+	Radius:  100,
+	Speed:   10,
+}
+
+// State is the vehicle state at one instant of the trajectory.
+type State struct {
+	Elapsed time.Duration
+
+	Lat float64 // degrees
+	Lon float64 // degrees
+	Alt float64 // metres AMSL
+
+	North float64 // metres from home
+	East  float64
+	Down  float64
+
+	VNorth float64 // metres per second
+	VEast  float64
+	VDown  float64
+
+	Roll  float64 // radians
+	Pitch float64
+	Yaw   float64
+
+	YawRate float64 // radians per second
+}
+
+// At returns the state of the trajectory elapsed time after start.
+func (t Trajectory) At(elapsed time.Duration) State {
+	s := State{Elapsed: elapsed}
+
+	if t.Radius <= 0 {
+		s.Lat, s.Lon, s.Alt = t.HomeLat, t.HomeLon, t.HomeAlt+t.Height
+		s.Down = -t.Height
+		return s
+	}
+
+	omega := t.Speed / t.Radius
+	theta := omega * elapsed.Seconds()
+
+	s.North = t.Radius * math.Cos(theta)
+	s.East = t.Radius * math.Sin(theta)
+	s.Down = -t.Height
+
+	s.VNorth = -t.Speed * math.Sin(theta)
+	s.VEast = t.Speed * math.Cos(theta)
+
+	s.Lat = t.HomeLat + (s.North/earthRadius)*180/math.Pi
+	s.Lon = t.HomeLon + (s.East/(earthRadius*math.Cos(t.HomeLat*math.Pi/180)))*180/math.Pi
+	s.Alt = t.HomeAlt + t.Height
+
+	s.Roll = math.Atan(t.Speed * omega / gravity)
+	s.Yaw = wrapAngle(math.Atan2(s.VEast, s.VNorth))
+	s.YawRate = omega
+
+	return s
+}
+
+// Heading returns the yaw in degrees in [0, 360).
+func (s State) Heading() float64 {
+	h := s.Yaw * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+// GroundSpeed returns the horizontal speed in metres per second.
+func (s State) GroundSpeed() float64 {
+	return math.Hypot(s.VNorth, s.VEast)
+}
+
+func wrapAngle(a float64) float64 {
+	for a > math.Pi {
+		a -= 2 * math.Pi
+	}
+	for a <= -math.Pi {
+		a += 2 * math.Pi
+	}
+	return a
+}
@@ -0,0 +1,409 @@
+package mavlink
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v3"
+	"github.com/bluenviron/gomavlib/v3/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v3/pkg/message"
+
+	"github.com/harshabose/cellular_localisation_logging"
+)
+
+// SampleTimeMetadataKey is the Metadata key of the SampleTime of a MAVLink entry. It is set once the
+// autopilot's clock is known, from TIMESYNC (see TimeSync) or SYSTEM_TIME.
+const SampleTimeMetadataKey = "sample_time"
+
+const (
+	// TimeSourceGPS is the autopilot's own wall time from SYSTEM_TIME, referenced to GPS.
+	TimeSourceGPS = "gps"
+	// TimeSourceTimeSync is the host clock, through the offset measured with TIMESYNC.
+	TimeSourceTimeSync = "timesync"
+	// TimeSourcePayload is a UNIX timestamp the autopilot put in the message itself.
+	TimeSourcePayload = "payload"
+)
+
+// SampleTime is the wall time an entry's data was sampled at, from the autopilot timestamp in it
+// (time_boot_ms or time_usec) rather than from when the response arrived.
+type SampleTime struct {
+	Time          time.Time `json:"time"`
+	UncertaintyMS float64   `json:"uncertainty_ms,omitempty"` // bound of the error of Time
+	Source        string    `json:"source"`
+}
+
+// ClockEstimate is what is known of an autopilot's clock, see TimeSync.
+type ClockEstimate struct {
+	Boot          time.Time  `json:"boot"`                    // host time the autopilot's clock started at
+	DriftPPM      float64    `json:"drift_ppm"`               // of the autopilot's clock against the host's
+	RTTMS         float64    `json:"rtt_ms"`                  // of the last exchange
+	UncertaintyMS float64    `json:"uncertainty_ms"`          // of Boot
+	Samples       int        `json:"samples"`                 // exchanges the estimate is fitted to
+	GPSBoot       *time.Time `json:"gps_boot,omitempty"`      // GPS time the clock started at, from SYSTEM_TIME
+	GPSOffsetMS   *float64   `json:"gps_offset_ms,omitempty"` // of the host clock from GPS time
+}
+
+const (
+	clockSamples = 16               // exchanges the offset and drift are fitted to
+	clockMaxAge  = 10 * time.Minute // beyond which exchanges are forgotten
+	clockStep    = time.Second      // a jump of the offset by more is a reboot of the autopilot
+	driftSpan    = time.Minute      // exchanges must span this much for the drift to be fitted
+	gpsMaxAge    = 10 * time.Minute // beyond which SYSTEM_TIME is not trusted any more
+	unixUsec     = 1e15             // time_usec above this is a UNIX time, below it time since boot
+)
+
+type clockSample struct {
+	host time.Time     // midpoint of the exchange
+	boot time.Duration // autopilot time at the midpoint
+	rtt  time.Duration
+}
+
+// clock estimates an autopilot's clock against the host's as boot(t) = offset + drift*t, fitted to
+// TIMESYNC exchanges weighted by their round trip time, and keeps its GPS time from SYSTEM_TIME.
+type clock struct {
+	samples []clockSample
+
+	// fit, of the host time of the autopilot's boot against the host time of the exchange
+	origin      time.Time // host time drift is relative to
+	boot        time.Time // at origin
+	drift       float64   // seconds per second
+	uncertainty time.Duration
+
+	gpsBoot     time.Time // GPS time the autopilot booted at
+	gpsObserved time.Time
+
+	mux sync.Mutex
+}
+
+// observeTimeSync adds the exchange of a TIMESYNC request sent at sent, answered with the autopilot
+// time tc1 (ns) at received.
+func (c *clock) observeTimeSync(sent, received time.Time, tc1 int64) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	rtt := received.Sub(sent)
+	sample := clockSample{host: sent.Add(rtt / 2), boot: time.Duration(tc1), rtt: rtt}
+
+	if len(c.samples) > 0 {
+		if predicted := c.bootAt(sample.host); absDuration(sample.host.Add(-sample.boot).Sub(predicted)) > clockStep {
+			c.samples = c.samples[:0] // the autopilot rebooted
+		}
+	}
+
+	samples := c.samples[:0]
+	for _, s := range c.samples {
+		if sample.host.Sub(s.host) < clockMaxAge {
+			samples = append(samples, s)
+		}
+	}
+	c.samples = append(samples, sample)
+	if len(c.samples) > clockSamples {
+		c.samples = c.samples[len(c.samples)-clockSamples:]
+	}
+
+	c.fit()
+}
+
+// fit fits the host time of boot against the host time by least squares, each exchange weighted by
+// the inverse square of its round trip time, which bounds its error.
+func (c *clock) fit() {
+	c.origin = c.samples[len(c.samples)-1].host
+
+	var sw, sx, sy, sxx, sxy float64
+	minRTT := c.samples[0].rtt
+	for _, s := range c.samples {
+		half := math.Max(float64(s.rtt)/2, float64(time.Microsecond))
+		w := 1 / (half * half)
+		x := s.host.Sub(c.origin).Seconds()
+		y := float64(s.host.Add(-s.boot).Sub(c.origin)) // ns
+		sw, sx, sy, sxx, sxy = sw+w, sx+w*x, sy+w*y, sxx+w*x*x, sxy+w*x*y
+		if s.rtt < minRTT {
+			minRTT = s.rtt
+		}
+	}
+
+	// Over a short span the scatter of the round trip times swamps any drift.
+	slope := 0.0
+	if d := sw*sxx - sx*sx; c.origin.Sub(c.samples[0].host) >= driftSpan && d > 0 {
+		slope = (sw*sxy - sx*sy) / d // ns per s
+	}
+	intercept := (sy - slope*sx) / sw
+
+	// The best exchange bounds the error, and the scatter of the others around the fit adds to it.
+	var residuals float64
+	for _, s := range c.samples {
+		x := s.host.Sub(c.origin).Seconds()
+		r := float64(s.host.Add(-s.boot).Sub(c.origin)) - (intercept + slope*x)
+		residuals += r * r
+	}
+
+	c.boot = c.origin.Add(time.Duration(intercept))
+	c.drift = -slope / 1e9 // the boot time moves back as the autopilot's clock runs fast
+	c.uncertainty = minRTT/2 + time.Duration(math.Sqrt(residuals/float64(len(c.samples))))
+}
+
+// bootAt returns the host time of the autopilot's boot as estimated at host time t.
+func (c *clock) bootAt(t time.Time) time.Time {
+	return c.boot.Add(time.Duration(-c.drift * float64(t.Sub(c.origin))))
+}
+
+// observeSystemTime records the GPS time of a SYSTEM_TIME, if the autopilot has one.
+func (c *clock) observeSystemTime(msg *common.MessageSystemTime, received time.Time) {
+	if msg.TimeUnixUsec == 0 {
+		return
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.gpsBoot = time.UnixMicro(int64(msg.TimeUnixUsec)).Add(-time.Duration(msg.TimeBootMs) * time.Millisecond)
+	c.gpsObserved = received
+}
+
+// sampleTime returns the sample time of a message with a time_boot_ms or time_usec field.
+func (c *clock) sampleTime(msg message.Message, received time.Time) (SampleTime, bool) {
+	boot, resolution, unix, ok := timestamp(msg)
+	if !ok {
+		return SampleTime{}, false
+	}
+	if unix {
+		return SampleTime{Time: time.UnixMicro(int64(boot / time.Microsecond)), Source: TimeSourcePayload}, true
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	// SYSTEM_TIME carries time_boot_ms, so it is known to a millisecond.
+	if !c.gpsObserved.IsZero() && received.Sub(c.gpsObserved) < gpsMaxAge {
+		return SampleTime{
+			Time:          c.gpsBoot.Add(boot),
+			UncertaintyMS: milliseconds(time.Millisecond + resolution),
+			Source:        TimeSourceGPS,
+		}, true
+	}
+
+	if len(c.samples) == 0 {
+		return SampleTime{}, false
+	}
+	// The drift is applied at the time of the sample, whose own time is close enough to find it.
+	t := c.bootAt(received).Add(boot)
+	return SampleTime{
+		Time:          c.bootAt(t).Add(boot),
+		UncertaintyMS: milliseconds(c.uncertainty + resolution),
+		Source:        TimeSourceTimeSync,
+	}, true
+}
+
+func (c *clock) estimate(rtt time.Duration) ClockEstimate {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	estimate := ClockEstimate{
+		Boot:          c.boot,
+		DriftPPM:      c.drift * 1e6,
+		RTTMS:         milliseconds(rtt),
+		UncertaintyMS: milliseconds(c.uncertainty),
+		Samples:       len(c.samples),
+	}
+	if !c.gpsObserved.IsZero() {
+		gpsBoot := c.gpsBoot
+		estimate.GPSBoot = &gpsBoot
+		if len(c.samples) > 0 {
+			offset := milliseconds(c.bootAt(c.origin).Sub(gpsBoot))
+			estimate.GPSOffsetMS = &offset
+		}
+	}
+	return estimate
+}
+
+// timestamp returns the autopilot timestamp of a message, from time_boot_ms or time_usec, with its
+// resolution, and whether it is a UNIX time rather than a time since boot.
+func timestamp(msg message.Message) (time.Duration, time.Duration, bool, bool) {
+	v := reflect.ValueOf(msg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return 0, 0, false, false
+	}
+	v = v.Elem()
+
+	if f := v.FieldByName("TimeBootMs"); f.IsValid() && f.CanUint() && f.Uint() != 0 {
+		return time.Duration(f.Uint()) * time.Millisecond, time.Millisecond, false, true
+	}
+	if f := v.FieldByName("TimeUsec"); f.IsValid() && f.CanUint() && f.Uint() != 0 {
+		return time.Duration(f.Uint()) * time.Microsecond, time.Microsecond, f.Uint() > unixUsec, true
+	}
+	return 0, 0, false, false
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// TimeSyncMessageType is the MessageType of TimeSync entries.
+const TimeSyncMessageType = "mavlink-timesync"
+
+// DefaultTimeSyncInterval is how often a TimeSync runs its exchange unless the message interval is
+// set.
+const DefaultTimeSyncInterval = 10 * time.Second
+
+// TimeSync runs the TIMESYNC exchange with the autopilot each time it is requested, and asks for
+// SYSTEM_TIME, so that the requester can annotate every MAVLink entry with its SampleTime. Its
+// entries hold the resulting ClockEstimate.
+type TimeSync struct {
+	index     uint64
+	history   *cellularlog.History
+	requester string
+	ctx       context.Context
+}
+
+// NewTimeSync returns the TIMESYNC message. Without it, only autopilots that send SYSTEM_TIME with a
+// GPS time get their entries annotated, and only once SYSTEM_TIME is requested.
+func NewTimeSync(ctx context.Context) *TimeSync {
+	return &TimeSync{
+		history:   cellularlog.NewHistory(cellularlog.DefaultHistoryCapacity, 0),
+		requester: RequesterName,
+		ctx:       ctx,
+	}
+}
+
+func (m *TimeSync) Process(requester cellularlog.Requester) (cellularlog.LogEntry, error) {
+	defer func() { m.index++ }()
+
+	log := cellularlog.LogEntry{
+		Index:       m.index,
+		MessageType: m.GetType(),
+		RequestTime: time.Now(),
+	}
+
+	r, ok := requester.(*Mavlink)
+	if !ok {
+		log.Error = "errors interface mismatch"
+
+		m.history.Add(log)
+		return log, errors.New("error interface mismatch")
+	}
+
+	rtt, err := r.syncTime(m.ctx)
+	if err != nil {
+		log.Error = err.Error()
+
+		m.history.Add(log)
+		return log, err
+	}
+
+	log.Success = true
+	log.Data = r.clock.estimate(rtt)
+	log.ResponseTime = time.Now()
+	log.Duration = log.ResponseTime.Sub(log.RequestTime)
+
+	m.history.Add(log)
+	return log, nil
+}
+
+// syncTime runs one TIMESYNC exchange, returning its round trip time. SYSTEM_TIME is requested along
+// with it and recorded by observe when it arrives.
+func (r *Mavlink) syncTime(ctx context.Context) (time.Duration, error) {
+	if err := r.node.WriteMessageAll(&common.MessageCommandLong{
+		TargetSystem: 1,
+		Command:      common.MAV_CMD_REQUEST_MESSAGE,
+		Param1:       float32((&common.MessageSystemTime{}).GetID()),
+	}); err != nil {
+		return 0, err
+	}
+
+	sent := time.Now()
+	ts1 := sent.UnixNano()
+	if err := r.node.WriteMessageAll(&common.MessageTimesync{Tc1: 0, Ts1: ts1}); err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, errors.New("request timeout")
+		case event, ok := <-r.node.Events():
+			if !ok {
+				return 0, errors.New("error interface mismatch")
+			}
+
+			frm, ok := event.(*gomavlib.EventFrame)
+			if !ok {
+				continue
+			}
+			r.observe(frm)
+
+			// The autopilot's own requests have tc1 zero, and answers to others another ts1.
+			reply, ok := frm.Message().(*common.MessageTimesync)
+			if !ok || reply.Tc1 == 0 || reply.Ts1 != ts1 {
+				continue
+			}
+			if _, ok := r.accept(frm); ok {
+				received := time.Now()
+				r.clock.observeTimeSync(sent, received, reply.Tc1)
+				return received.Sub(sent), nil
+			}
+		}
+	}
+}
+
+// accept returns the signature check of a frame, and false if the frame is to be ignored for lack of
+// a valid signature.
+func (r *Mavlink) accept(frm *gomavlib.EventFrame) (string, bool) {
+	if r.verifier == nil {
+		return "", true
+	}
+
+	signature := r.verifier.check(frm.Frame)
+	return signature, r.mode != SigningRequire || signature == SignatureValid
+}
+
+// observe records the clock information of a frame, whichever request is waiting for it.
+func (r *Mavlink) observe(frm *gomavlib.EventFrame) {
+	msg, ok := frm.Message().(*common.MessageSystemTime)
+	if !ok {
+		return
+	}
+	if _, ok := r.accept(frm); ok {
+		r.clock.observeSystemTime(msg, time.Now())
+	}
+}
+
+// SetRequester points the message at a requester registered under a name other than RequesterName.
+func (m *TimeSync) SetRequester(name string) {
+	m.requester = name
+}
+
+func (m *TimeSync) GetRequester() string {
+	return m.requester
+}
+
+func (m *TimeSync) GetType() string {
+	return TimeSyncMessageType
+}
+
+// GetInterval implements cellularlog.Scheduled.
+func (m *TimeSync) GetInterval() time.Duration {
+	return DefaultTimeSyncInterval
+}
+
+func (m *TimeSync) GetAllEntries() []cellularlog.LogEntry {
+	return m.history.All()
+}
+
+// History returns the bounded record of the message's entries.
+func (m *TimeSync) History() *cellularlog.History {
+	return m.history
+}
@@ -0,0 +1,363 @@
+// Package mqtt publishes log entries to an MQTT broker, e.g. at a ground station, so that a session
+// can be watched while it runs. Entries that cannot be published while the uplink is down are kept
+// in an on-disk queue and resent, in order, once the client reconnects. Delivery is at least once.
+package mqtt
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/internal/diskqueue"
+)
+
+// DefaultTopicPrefix is the first level of every topic unless configured otherwise.
+const DefaultTopicPrefix = "cellular_logger"
+
+// Config describes the broker and how entries are published. Zero values are replaced by the
+// defaults noted below.
+type Config struct {
+	Broker   string // e.g. tcp://ground:1883, ssl://ground:8883 or ws://ground:9001/mqtt
+	ClientID string // default cellular_logger-<vehicle>
+	Username string
+	Password string
+
+	Vehicle     string // second topic level, default "vehicle"
+	TopicPrefix string // default DefaultTopicPrefix
+	QoS         byte   // 0, 1 or 2
+	Batch       bool   // publish each Write as one JSON array on <prefix>/<vehicle>/batch
+	Timeout     time.Duration
+
+	QueueDir     string // on-disk queue, empty to return errors instead while disconnected
+	QueueMaxSize int64  // bytes, zero for no limit
+
+	Output io.Writer // where errors of the background publishing are printed, default stdout
+}
+
+func (c Config) withDefaults() Config {
+	if c.Vehicle == "" {
+		c.Vehicle = "vehicle"
+	}
+	if c.TopicPrefix == "" {
+		c.TopicPrefix = DefaultTopicPrefix
+	}
+	if c.ClientID == "" {
+		c.ClientID = "cellular_logger-" + c.Vehicle
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+	if c.Output == nil {
+		c.Output = os.Stdout
+	}
+	return c
+}
+
+// Writer is a cellularlog.Writer publishing each entry as JSON on <prefix>/<vehicle>/<MessageType>.
+type Writer struct {
+	config Config
+	client paho.Client
+	queue  *diskqueue.Queue
+
+	mux sync.Mutex // serialises Write, so direct publishes never overtake each other
+
+	header    []byte // session header, published retained on every connect
+	headerMux sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wake   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewWriter connects to the broker in the background; entries written before the first connection
+// succeeds are queued.
+func NewWriter(ctx context.Context, config Config) (*Writer, error) {
+	config = config.withDefaults()
+
+	if config.Broker == "" {
+		return nil, errors.New("broker address is required")
+	}
+	if config.QoS > 2 {
+		return nil, fmt.Errorf("invalid QoS %d (supported: 0, 1, 2)", config.QoS)
+	}
+
+	ctx2, cancel := context.WithCancel(ctx)
+	w := &Writer{
+		config: config,
+		ctx:    ctx2,
+		cancel: cancel,
+		wake:   make(chan struct{}, 1),
+	}
+
+	if config.QueueDir != "" {
+		queue, err := diskqueue.Open(config.QueueDir, config.QueueMaxSize)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		w.queue = queue
+	}
+
+	options := paho.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetConnectTimeout(config.Timeout).
+		SetWriteTimeout(config.Timeout).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(time.Second).
+		SetMaxReconnectInterval(30 * time.Second).
+		SetOnConnectHandler(func(paho.Client) {
+			w.signal()
+			go w.publishHeader()
+		})
+
+	w.client = paho.NewClient(options)
+	w.client.Connect() // completes once connected; retried in the background until then
+
+	w.wg.Add(1)
+	go w.resend()
+
+	return w, nil
+}
+
+// Topic returns the topic entries of messageType are published on.
+func (w *Writer) Topic(messageType string) string {
+	return w.config.TopicPrefix + "/" + w.config.Vehicle + "/" + topicLevel(messageType)
+}
+
+// topicLevel escapes the characters MQTT reserves, e.g. the + of AT commands.
+func topicLevel(s string) string {
+	return strings.NewReplacer("%", "%25", "+", "%2B", "#", "%23", "/", "%2F").Replace(s)
+}
+
+func (w *Writer) Write(entries []cellularlog.LogEntry) error {
+	publications, err := w.encode(entries)
+	if err != nil {
+		return err
+	}
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	// While older entries wait in the queue, new ones join them to keep the order.
+	if w.queue == nil || w.queue.Empty() {
+		var sent int
+		for ; sent < len(publications); sent++ {
+			if err := w.publish(publications[sent]); err != nil {
+				if w.queue == nil {
+					return fmt.Errorf("error publishing to %s: %w", w.config.Broker, err)
+				}
+				break
+			}
+		}
+		publications = publications[sent:]
+	}
+
+	if len(publications) == 0 {
+		return nil
+	}
+
+	if err := w.enqueue(publications); err != nil {
+		return err
+	}
+	w.signal()
+
+	return nil
+}
+
+// WriteHeader publishes the session header retained on <prefix>/<vehicle>/session, so that a
+// subscriber joining later still learns the setup, and again after every reconnect. It is not
+// queued; if the client is not connected, it is published once it connects.
+func (w *Writer) WriteHeader(header cellularlog.LogEntry) error {
+	payload, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("failed to encode session header: %w", err)
+	}
+
+	w.headerMux.Lock()
+	w.header = payload
+	w.headerMux.Unlock()
+
+	if w.client.IsConnectionOpen() {
+		w.publishHeader()
+	}
+	return nil
+}
+
+func (w *Writer) publishHeader() {
+	w.headerMux.Lock()
+	defer w.headerMux.Unlock()
+
+	if w.header == nil {
+		return
+	}
+
+	token := w.client.Publish(w.Topic(cellularlog.SessionMessageType), w.config.QoS, true, w.header)
+	if !token.WaitTimeout(w.config.Timeout) {
+		fmt.Fprintf(w.config.Output, "error publishing session header to %s: publish timeout\n", w.config.Broker)
+	} else if err := token.Error(); err != nil {
+		fmt.Fprintf(w.config.Output, "error publishing session header to %s: %v\n", w.config.Broker, err)
+	}
+}
+
+type publication struct {
+	topic   string
+	payload []byte
+}
+
+func (w *Writer) encode(entries []cellularlog.LogEntry) ([]publication, error) {
+	if w.config.Batch {
+		payload, err := json.Marshal(entries)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode entries: %w", err)
+		}
+		return []publication{{topic: w.Topic("batch"), payload: payload}}, nil
+	}
+
+	publications := make([]publication, 0, len(entries))
+	for _, entry := range entries {
+		payload, err := json.Marshal(entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s entry: %w", entry.MessageType, err)
+		}
+		publications = append(publications, publication{topic: w.Topic(entry.MessageType), payload: payload})
+	}
+
+	return publications, nil
+}
+
+func (w *Writer) publish(p publication) error {
+	if !w.client.IsConnectionOpen() {
+		return errors.New("not connected")
+	}
+
+	token := w.client.Publish(p.topic, w.config.QoS, false, p.payload)
+	if !token.WaitTimeout(w.config.Timeout) {
+		return errors.New("publish timeout")
+	}
+	return token.Error()
+}
+
+// Records in the queue are the topic length, topic and payload.
+func (w *Writer) enqueue(publications []publication) error {
+	records := make([][]byte, 0, len(publications))
+	for _, p := range publications {
+		record := binary.BigEndian.AppendUint16(nil, uint16(len(p.topic)))
+		record = append(record, p.topic...)
+		records = append(records, append(record, p.payload...))
+	}
+
+	if err := w.queue.Push(records...); err != nil {
+		return fmt.Errorf("error queueing %d entries for %s: %w", len(publications), w.config.Broker, err)
+	}
+	return nil
+}
+
+func decode(record []byte) (publication, error) {
+	if len(record) < 2 || len(record) < 2+int(binary.BigEndian.Uint16(record)) {
+		return publication{}, errors.New("malformed queue record")
+	}
+
+	n := 2 + int(binary.BigEndian.Uint16(record))
+	return publication{topic: string(record[2:n]), payload: record[n:]}, nil
+}
+
+func (w *Writer) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// resend drains the queue whenever the client (re)connects or entries are queued, and periodically
+// in case a publish failed while the connection looked open.
+func (w *Writer) resend() {
+	defer w.wg.Done()
+
+	if w.queue == nil {
+		return
+	}
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-w.wake:
+		case <-ticker.C:
+		}
+
+		if err := w.drain(); err != nil {
+			fmt.Fprintf(w.config.Output, "error resending queued MQTT entries: %v\n", err)
+		}
+	}
+}
+
+func (w *Writer) drain() error {
+	for w.ctx.Err() == nil && w.client.IsConnectionOpen() {
+		record, ok, err := w.queue.Peek()
+		if err != nil || !ok {
+			return err
+		}
+
+		p, err := decode(record)
+		if err != nil {
+			// Unreadable records would block the queue forever.
+			if e := w.queue.Pop(); e != nil {
+				return e
+			}
+			return err
+		}
+
+		if err := w.publish(p); err != nil {
+			return nil // retried on the next reconnect or tick
+		}
+		if err := w.queue.Pop(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Queued returns the bytes waiting in the on-disk queue.
+func (w *Writer) Queued() int64 {
+	if w.queue == nil {
+		return 0
+	}
+	return w.queue.Size()
+}
+
+// Connected reports whether the client is currently connected to the broker.
+func (w *Writer) Connected() bool {
+	return w.client.IsConnectionOpen()
+}
+
+// Close stops resending and disconnects; queued entries stay on disk for the next session.
+func (w *Writer) Close() error {
+	w.cancel()
+	w.wg.Wait()
+
+	w.client.Disconnect(uint(w.config.Timeout.Milliseconds()))
+
+	if w.queue != nil {
+		return w.queue.Close()
+	}
+	return nil
+}
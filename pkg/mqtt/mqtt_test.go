@@ -0,0 +1,262 @@
+package mqtt_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/mqtt"
+)
+
+// broker is a minimal local MQTT 3.1.1 broker that accepts every client and records what is
+// published to it.
+type broker struct {
+	listener net.Listener
+	conns    []net.Conn
+
+	published []*packets.PublishPacket
+	mux       sync.Mutex
+}
+
+func startBroker(t *testing.T, address string) *broker {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+
+	b := &broker{listener: listener}
+	t.Cleanup(b.stop)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			b.mux.Lock()
+			b.conns = append(b.conns, conn)
+			b.mux.Unlock()
+
+			go b.serve(conn)
+		}
+	}()
+
+	return b
+}
+
+func (b *broker) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+
+		var reply packets.ControlPacket
+		switch p := packet.(type) {
+		case *packets.ConnectPacket:
+			reply = packets.NewControlPacket(packets.Connack)
+		case *packets.PublishPacket:
+			b.mux.Lock()
+			b.published = append(b.published, p)
+			b.mux.Unlock()
+
+			switch p.Qos {
+			case 1:
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				reply = ack
+			case 2:
+				rec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+				rec.MessageID = p.MessageID
+				reply = rec
+			}
+		case *packets.PubrelPacket:
+			comp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+			comp.MessageID = p.MessageID
+			reply = comp
+		case *packets.PingreqPacket:
+			reply = packets.NewControlPacket(packets.Pingresp)
+		case *packets.DisconnectPacket:
+			return
+		}
+
+		if reply != nil {
+			if err := reply.Write(conn); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (b *broker) stop() {
+	_ = b.listener.Close()
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	for _, conn := range b.conns {
+		_ = conn.Close()
+	}
+}
+
+// wait returns the first n publications, failing the test if they do not arrive in time.
+func (b *broker) wait(t *testing.T, n int) []*packets.PublishPacket {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		b.mux.Lock()
+		published := append([]*packets.PublishPacket(nil), b.published...)
+		b.mux.Unlock()
+
+		if len(published) >= n {
+			return published[:n]
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Fatalf("expected %d publications in time", n)
+	return nil
+}
+
+func entries(from, to int) []cellularlog.LogEntry {
+	var entries []cellularlog.LogEntry
+	for i := from; i < to; i++ {
+		entries = append(entries, cellularlog.LogEntry{Index: uint64(i), MessageType: "at-+CSQ", Success: true})
+	}
+	return entries
+}
+
+func index(t *testing.T, p *packets.PublishPacket) uint64 {
+	t.Helper()
+
+	var entry cellularlog.LogEntry
+	if err := json.Unmarshal(p.Payload, &entry); err != nil {
+		t.Fatalf("invalid payload %q: %v", p.Payload, err)
+	}
+	return entry.Index
+}
+
+func TestPublish(t *testing.T) {
+	b := startBroker(t, "127.0.0.1:0")
+
+	writer, err := mqtt.NewWriter(context.Background(), mqtt.Config{
+		Broker:   "tcp://" + b.listener.Addr().String(),
+		Vehicle:  "car1",
+		QoS:      1,
+		QueueDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	if err := writer.Write(entries(0, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, p := range b.wait(t, 3) {
+		if p.TopicName != "cellular_logger/car1/at-%2BCSQ" || p.Qos != 1 {
+			t.Errorf("unexpected topic %q or QoS %d", p.TopicName, p.Qos)
+		}
+		if n := index(t, p); n != uint64(i) {
+			t.Errorf("expected entry %d, got %d", i, n)
+		}
+	}
+}
+
+func TestQueueWhileDisconnected(t *testing.T) {
+	// Reserve an address nothing listens on until the broker is started.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	_ = listener.Close()
+
+	config := mqtt.Config{Broker: "tcp://" + address, Vehicle: "car1", QoS: 1, QueueDir: t.TempDir(), Timeout: time.Second}
+
+	// Entries queued by one session are resent by the next.
+	first, err := mqtt.NewWriter(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Write(entries(0, 5)); err != nil {
+		t.Fatalf("expected the entries to be queued, got %v", err)
+	}
+	if first.Queued() == 0 {
+		t.Error("expected queued entries")
+	}
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := mqtt.NewWriter(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if err := second.Write(entries(5, 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	b := startBroker(t, address)
+	for i, p := range b.wait(t, 10) {
+		if n := index(t, p); n != uint64(i) {
+			t.Fatalf("expected entry %d, got %d", i, n)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for second.Queued() != 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if second.Queued() != 0 {
+		t.Errorf("expected an empty queue, %d bytes left", second.Queued())
+	}
+
+	// Once drained, entries are published directly again.
+	if err := second.Write(entries(10, 11)); err != nil {
+		t.Fatal(err)
+	}
+	if n := index(t, b.wait(t, 11)[10]); n != 10 {
+		t.Errorf("expected entry 10, got %d", n)
+	}
+}
+
+func TestSessionHeaderRetained(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	_ = listener.Close()
+
+	writer, err := mqtt.NewWriter(context.Background(), mqtt.Config{Broker: "tcp://" + address, Vehicle: "car1", Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	// Written while disconnected, the header is published once the client connects.
+	header := cellularlog.LogEntry{MessageType: cellularlog.SessionMessageType, Success: true, Data: cellularlog.SessionHeader{Host: "car1"}}
+	if err := writer.WriteHeader(header); err != nil {
+		t.Fatal(err)
+	}
+
+	b := startBroker(t, address)
+	p := b.wait(t, 1)[0]
+	if p.TopicName != "cellular_logger/car1/session" || !p.Retain {
+		t.Errorf("expected a retained header on cellular_logger/car1/session, got %q (retain %t)", p.TopicName, p.Retain)
+	}
+}
@@ -0,0 +1,311 @@
+// Package netif samples Linux network interface statistics, typically of the modem's WWAN
+// interface, so that link throughput and errors can be correlated with signal metrics.
+package netif
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging"
+)
+
+// RequesterName is the name messages from this package target by default.
+const RequesterName = "sys"
+
+const (
+	DefaultSysfsRoot  = "/sys/class/net"
+	DefaultProcNetDev = "/proc/net/dev"
+)
+
+// Counters are the cumulative kernel counters of an interface.
+type Counters struct {
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	RxDropped uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+	TxDropped uint64
+}
+
+func (c Counters) sub(prev Counters) Counters {
+	return Counters{
+		RxBytes:   c.RxBytes - prev.RxBytes,
+		RxPackets: c.RxPackets - prev.RxPackets,
+		RxErrors:  c.RxErrors - prev.RxErrors,
+		RxDropped: c.RxDropped - prev.RxDropped,
+		TxBytes:   c.TxBytes - prev.TxBytes,
+		TxPackets: c.TxPackets - prev.TxPackets,
+		TxErrors:  c.TxErrors - prev.TxErrors,
+		TxDropped: c.TxDropped - prev.TxDropped,
+	}
+}
+
+// less reports whether any counter went backwards, which happens when the interface is recreated.
+func (c Counters) less(prev Counters) bool {
+	return c.RxBytes < prev.RxBytes || c.RxPackets < prev.RxPackets || c.RxErrors < prev.RxErrors ||
+		c.RxDropped < prev.RxDropped || c.TxBytes < prev.TxBytes || c.TxPackets < prev.TxPackets ||
+		c.TxErrors < prev.TxErrors || c.TxDropped < prev.TxDropped
+}
+
+// Stats is one sample of an interface with the change since the previous sample of the same
+// message. Deltas and rates are zero on the first sample and after a counter reset.
+type Stats struct {
+	Interface string
+	OperState string // from sysfs, empty when only /proc/net/dev is available
+	Counters  Counters
+
+	Interval     time.Duration // since the previous sample
+	Delta        Counters
+	CounterReset bool
+
+	RxBitsPerSecond    float64
+	TxBitsPerSecond    float64
+	RxPacketsPerSecond float64
+	TxPacketsPerSecond float64
+}
+
+// NetIf reads interface counters from sysfs, falling back to /proc/net/dev when the statistics
+// directory is not available (e.g. inside some containers).
+type NetIf struct {
+	sysfsRoot  string
+	procNetDev string
+}
+
+func NewNetIf() *NetIf {
+	return NewNetIfWithPaths(DefaultSysfsRoot, DefaultProcNetDev)
+}
+
+// NewNetIfWithPaths reads from alternative sysfs and procfs locations, e.g. a fixture directory.
+func NewNetIfWithPaths(sysfsRoot, procNetDev string) *NetIf {
+	return &NetIf{
+		sysfsRoot:  sysfsRoot,
+		procNetDev: procNetDev,
+	}
+}
+
+func (r *NetIf) Process(messages cellularlog.Message) (cellularlog.LogEntry, error) {
+	return messages.Process(r)
+}
+
+// Read returns the current counters of iface and where they were read from.
+func (r *NetIf) Read(iface string) (Counters, string, error) {
+	counters, err := r.readSysfs(iface)
+	if err == nil {
+		return counters, "sysfs", nil
+	}
+
+	counters, e := r.readProcNetDev(iface)
+	if e != nil {
+		return Counters{}, "", fmt.Errorf("error reading statistics of %s: %w", iface, errors.Join(err, e))
+	}
+
+	return counters, "procfs", nil
+}
+
+func (r *NetIf) readSysfs(iface string) (Counters, error) {
+	dir := filepath.Join(r.sysfsRoot, iface, "statistics")
+
+	var c Counters
+	for name, dst := range map[string]*uint64{
+		"rx_bytes":   &c.RxBytes,
+		"rx_packets": &c.RxPackets,
+		"rx_errors":  &c.RxErrors,
+		"rx_dropped": &c.RxDropped,
+		"tx_bytes":   &c.TxBytes,
+		"tx_packets": &c.TxPackets,
+		"tx_errors":  &c.TxErrors,
+		"tx_dropped": &c.TxDropped,
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return Counters{}, err
+		}
+
+		v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return Counters{}, fmt.Errorf("error parsing %s: %w", name, err)
+		}
+		*dst = v
+	}
+
+	return c, nil
+}
+
+// readProcNetDev parses the line of iface in /proc/net/dev:
+//
+//	wwan0: 1234 10 0 0 0 0 0 0 5678 12 0 0 0 0 0 0
+//
+// The receive columns are bytes packets errs drop fifo frame compressed multicast, followed by
+// the transmit columns bytes packets errs drop fifo colls carrier compressed.
+func (r *NetIf) readProcNetDev(iface string) (Counters, error) {
+	file, err := os.Open(r.procNetDev)
+	if err != nil {
+		return Counters{}, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.TrimSpace(name) != iface {
+			continue
+		}
+
+		fields := strings.Fields(rest)
+		if len(fields) < 16 {
+			return Counters{}, fmt.Errorf("unexpected /proc/net/dev format for %s", iface)
+		}
+
+		values := make([]uint64, 16)
+		for i := range values {
+			if values[i], err = strconv.ParseUint(fields[i], 10, 64); err != nil {
+				return Counters{}, fmt.Errorf("error parsing /proc/net/dev: %w", err)
+			}
+		}
+
+		return Counters{
+			RxBytes:   values[0],
+			RxPackets: values[1],
+			RxErrors:  values[2],
+			RxDropped: values[3],
+			TxBytes:   values[8],
+			TxPackets: values[9],
+			TxErrors:  values[10],
+			TxDropped: values[11],
+		}, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return Counters{}, err
+	}
+
+	return Counters{}, fmt.Errorf("interface %s not found in %s", iface, r.procNetDev)
+}
+
+func (r *NetIf) operState(iface string) string {
+	data, err := os.ReadFile(filepath.Join(r.sysfsRoot, iface, "operstate"))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+type Message struct {
+	index     uint64
+	history   *cellularlog.History
+	iface     string
+	requester string
+
+	previous     Counters
+	previousTime time.Time
+}
+
+// NewMessage example
+//
+//	NewMessage("wwan0")
+//	NewMessage("usb0")
+func NewMessage(iface string) *Message {
+	return &Message{
+		history:   cellularlog.NewHistory(cellularlog.DefaultHistoryCapacity, 0),
+		iface:     iface,
+		requester: RequesterName,
+	}
+}
+
+// SetRequester points the message at a requester registered under a name other than RequesterName.
+func (m *Message) SetRequester(name string) {
+	m.requester = name
+}
+
+func (m *Message) GetRequester() string {
+	return m.requester
+}
+
+func (m *Message) Process(requester cellularlog.Requester) (cellularlog.LogEntry, error) {
+	defer func() { m.index++ }()
+
+	requestTime := time.Now()
+	log := cellularlog.LogEntry{
+		Index:       m.index,
+		MessageType: m.GetType(),
+		Success:     false,
+		RequestTime: requestTime,
+	}
+
+	r, ok := requester.(*NetIf)
+	if !ok {
+		log.Error = "errors interface mismatch"
+
+		m.add(log)
+		return log, errors.New("error interface mismatch")
+	}
+
+	counters, source, err := r.Read(m.iface)
+	if err != nil {
+		log.Error = err.Error()
+
+		m.add(log)
+		return log, err
+	}
+
+	stats := &Stats{
+		Interface: m.iface,
+		OperState: r.operState(m.iface),
+		Counters:  counters,
+	}
+
+	if !m.previousTime.IsZero() {
+		if counters.less(m.previous) {
+			stats.CounterReset = true
+		} else {
+			stats.Interval = requestTime.Sub(m.previousTime)
+			stats.Delta = counters.sub(m.previous)
+
+			if seconds := stats.Interval.Seconds(); seconds > 0 {
+				stats.RxBitsPerSecond = float64(stats.Delta.RxBytes) * 8 / seconds
+				stats.TxBitsPerSecond = float64(stats.Delta.TxBytes) * 8 / seconds
+				stats.RxPacketsPerSecond = float64(stats.Delta.RxPackets) / seconds
+				stats.TxPacketsPerSecond = float64(stats.Delta.TxPackets) / seconds
+			}
+		}
+	}
+
+	m.previous = counters
+	m.previousTime = requestTime
+
+	log.Success = true
+	log.Data = stats
+	log.Metadata = map[string]interface{}{"source": source}
+	log.ResponseTime = time.Now()
+	log.Duration = log.ResponseTime.Sub(log.RequestTime)
+
+	m.add(log)
+
+	return log, nil
+}
+
+func (m *Message) add(log cellularlog.LogEntry) {
+	m.history.Add(log)
+}
+
+func (m *Message) GetType() string {
+	return fmt.Sprintf("sys-%s", m.iface)
+}
+
+func (m *Message) GetAllEntries() []cellularlog.LogEntry {
+	return m.history.All()
+}
+
+// History returns the bounded record of the message's entries.
+func (m *Message) History() *cellularlog.History {
+	return m.history
+}
@@ -0,0 +1,103 @@
+package netif_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging/pkg/netif"
+)
+
+func writeSysfs(t *testing.T, root string, rxBytes, txBytes uint64) {
+	t.Helper()
+
+	dir := filepath.Join(root, "wwan0", "statistics")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, v := range map[string]uint64{
+		"rx_bytes": rxBytes, "rx_packets": rxBytes / 1000, "rx_errors": 0, "rx_dropped": 1,
+		"tx_bytes": txBytes, "tx_packets": txBytes / 1000, "tx_errors": 0, "tx_dropped": 0,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(fmt.Sprintf("%d\n", v)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "wwan0", "operstate"), []byte("up\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSysfsDeltas(t *testing.T) {
+	root := t.TempDir()
+	requester := netif.NewNetIfWithPaths(root, filepath.Join(root, "missing"))
+	message := netif.NewMessage("wwan0")
+
+	writeSysfs(t, root, 100000, 20000)
+	log, err := requester.Process(message)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	first := log.Data.(*netif.Stats)
+	if first.OperState != "up" || first.Counters.RxBytes != 100000 || first.RxBitsPerSecond != 0 {
+		t.Errorf("unexpected first sample %+v", first)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	writeSysfs(t, root, 150000, 30000)
+
+	log, err = requester.Process(message)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second := log.Data.(*netif.Stats)
+	if second.Delta.RxBytes != 50000 || second.Delta.TxBytes != 10000 {
+		t.Errorf("unexpected deltas %+v", second.Delta)
+	}
+	if want := float64(50000*8) / second.Interval.Seconds(); second.RxBitsPerSecond != want {
+		t.Errorf("expected %v bit/s, got %v", want, second.RxBitsPerSecond)
+	}
+
+	writeSysfs(t, root, 10, 10)
+	log, _ = requester.Process(message)
+	if reset := log.Data.(*netif.Stats); !reset.CounterReset || reset.RxBitsPerSecond != 0 {
+		t.Errorf("expected a counter reset, got %+v", reset)
+	}
+}
+
+func TestProcNetDevFallback(t *testing.T) {
+	root := t.TempDir()
+	proc := filepath.Join(root, "dev")
+
+	content := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0
+ wwan0: 5242880    4096    2    3    0     0          0         0   524288     512    4    5    0     0       0          0
+`
+	if err := os.WriteFile(proc, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	requester := netif.NewNetIfWithPaths(filepath.Join(root, "sys"), proc)
+
+	counters, source, err := requester.Read("wwan0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if source != "procfs" {
+		t.Errorf("expected procfs source, got %s", source)
+	}
+
+	want := netif.Counters{RxBytes: 5242880, RxPackets: 4096, RxErrors: 2, RxDropped: 3, TxBytes: 524288, TxPackets: 512, TxErrors: 4, TxDropped: 5}
+	if counters != want {
+		t.Errorf("expected %+v, got %+v", want, counters)
+	}
+
+	if _, _, err := requester.Read("eth9"); err == nil {
+		t.Error("expected an error for an unknown interface")
+	}
+}
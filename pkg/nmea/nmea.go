@@ -0,0 +1,262 @@
+package nmea
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/warthog618/modem/serial"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/internal/latest"
+)
+
+// RequesterName is the name messages from this package target by default.
+const RequesterName = "nmea"
+
+// NMEA reads NMEA 0183 sentences from a GNSS receiver, either a standalone receiver or the NMEA
+// port of a cellular modem. The receiver streams on its own, so the requester keeps the latest
+// sentence of each type and a request returns the newest one the message has not logged yet.
+type NMEA struct {
+	r       io.ReadCloser
+	timeout time.Duration
+	store   *latest.Store
+	gsv     map[string]*GSV // partial multi-sentence GSV reports, keyed by talker; loop only
+	once    sync.Once
+}
+
+func NewNMEA(device string, baud int, timeout time.Duration) (*NMEA, error) {
+	s, err := serial.New(serial.WithPort(device), serial.WithBaud(baud))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewNMEAFromReader(s, timeout), nil
+}
+
+// NewNMEAFromReader reads sentences from any stream, e.g. a file replay or a pipe in tests.
+func NewNMEAFromReader(r io.ReadCloser, timeout time.Duration) *NMEA {
+	n := &NMEA{
+		r:       r,
+		timeout: timeout,
+		store:   latest.New(),
+		gsv:     make(map[string]*GSV),
+	}
+
+	go n.loop()
+
+	return n
+}
+
+func (r *NMEA) Process(messages cellularlog.Message) (cellularlog.LogEntry, error) {
+	return messages.Process(r)
+}
+
+// LatestPosition implements cellularlog.PositionProvider using the newest GGA with a fix.
+func (r *NMEA) LatestPosition() (cellularlog.Position, bool) {
+	v, ok := r.store.Get("GGA")
+	if !ok {
+		return cellularlog.Position{}, false
+	}
+
+	gga := v.Data.(*GGA)
+	if gga.FixQuality == 0 {
+		return cellularlog.Position{}, false
+	}
+
+	return cellularlog.Position{
+		Time:      v.Time,
+		Latitude:  gga.Latitude,
+		Longitude: gga.Longitude,
+		Altitude:  gga.Altitude,
+		Source:    RequesterName,
+	}, true
+}
+
+// Status implements cellularlog.StatusReporter.
+func (r *NMEA) Status() string {
+	select {
+	case <-r.store.Done():
+		return fmt.Sprintf("stopped: %v", r.store.Err())
+	default:
+	}
+
+	updated := r.store.Updated()
+	if updated.IsZero() {
+		return "waiting for sentences"
+	}
+	if age := time.Since(updated); age > silentAfter {
+		return fmt.Sprintf("silent for %s", age.Round(time.Second))
+	}
+
+	return "receiving"
+}
+
+// silentAfter is how long without a sentence Status reports the receiver as silent.
+const silentAfter = 5 * time.Second
+
+func (r *NMEA) loop() {
+	scanner := bufio.NewScanner(r.r)
+	for scanner.Scan() {
+		sentence, err := Parse(scanner.Text())
+		if err != nil {
+			// Receivers emit proprietary sentences and the first line after opening is often
+			// truncated; neither is worth stopping for.
+			continue
+		}
+
+		if gsv, ok := sentence.(*GSV); ok {
+			merged := r.mergeGSV(gsv)
+			if merged == nil {
+				continue
+			}
+			sentence = merged
+		}
+
+		r.store.Put(sentence.Type(), sentence)
+	}
+
+	r.store.Stop(scanner.Err())
+}
+
+// mergeGSV accumulates the parts of a GSV report and returns the merged report once its last part
+// arrives. Out-of-order parts discard the partial report.
+func (r *NMEA) mergeGSV(part *GSV) *GSV {
+	if part.MessageNumber <= 1 {
+		merged := *part
+		merged.Satellites = append([]SatelliteInView(nil), part.Satellites...)
+		r.gsv[part.Talker] = &merged
+	} else {
+		merged, ok := r.gsv[part.Talker]
+		if !ok || merged.MessageNumber+1 != part.MessageNumber {
+			delete(r.gsv, part.Talker)
+			return nil
+		}
+		merged.MessageNumber = part.MessageNumber
+		merged.Satellites = append(merged.Satellites, part.Satellites...)
+	}
+
+	merged := r.gsv[part.Talker]
+	if merged.MessageNumber < merged.TotalMessages {
+		return nil
+	}
+
+	delete(r.gsv, part.Talker)
+	return merged
+}
+
+func (r *NMEA) Close() error {
+	var err error
+	r.once.Do(func() {
+		// Closing the port ends the read loop, which stops the store.
+		err = r.r.Close()
+	})
+	return err
+}
+
+type Message struct {
+	index     uint64
+	history   *cellularlog.History
+	sentence  string
+	seen      uint64
+	requester string
+}
+
+// NewMessage example
+//
+//	NewMessage("GGA")
+//	NewMessage("RMC")
+func NewMessage(sentence string) (*Message, error) {
+	sentence = strings.ToUpper(sentence)
+
+	for _, s := range SupportedSentences {
+		if s == sentence {
+			return &Message{
+				history:   cellularlog.NewHistory(cellularlog.DefaultHistoryCapacity, 0),
+				sentence:  sentence,
+				requester: RequesterName,
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported sentence: %s (supported: %s)", sentence, strings.Join(SupportedSentences, ", "))
+}
+
+// SetRequester points the message at a requester registered under a name other than RequesterName,
+// e.g. when logging from two receivers.
+func (m *Message) SetRequester(name string) {
+	m.requester = name
+}
+
+func (m *Message) GetRequester() string {
+	return m.requester
+}
+
+func (m *Message) Process(requester cellularlog.Requester) (cellularlog.LogEntry, error) {
+	defer func() { m.index++ }()
+
+	requestTime := time.Now()
+	log := cellularlog.LogEntry{
+		Index:       m.index,
+		MessageType: m.GetType(),
+		Success:     false,
+		RequestTime: requestTime,
+	}
+
+	r, ok := requester.(*NMEA)
+	if !ok {
+		log.Error = "errors interface mismatch"
+
+		m.add(log)
+		return log, errors.New("error interface mismatch")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	v, err := r.store.Next(ctx, m.sentence, m.seen)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			log.Error = "request timeout"
+		} else {
+			log.Error = err.Error()
+		}
+
+		m.add(log)
+		return log, err
+	}
+
+	m.seen = v.Seq
+
+	log.Success = true
+	log.Data = v.Data
+	log.Metadata = map[string]interface{}{"received_time": v.Time}
+	log.ResponseTime = time.Now()
+	log.Duration = log.ResponseTime.Sub(log.RequestTime)
+
+	m.add(log)
+
+	return log, nil
+}
+
+func (m *Message) add(log cellularlog.LogEntry) {
+	m.history.Add(log)
+}
+
+func (m *Message) GetType() string {
+	return fmt.Sprintf("nmea-%s", m.sentence)
+}
+
+func (m *Message) GetAllEntries() []cellularlog.LogEntry {
+	return m.history.All()
+}
+
+// History returns the bounded record of the message's entries.
+func (m *Message) History() *cellularlog.History {
+	return m.history
+}
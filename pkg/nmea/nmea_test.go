@@ -0,0 +1,117 @@
+package nmea_test
+
+import (
+	"errors"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging/pkg/nmea"
+)
+
+const (
+	gga  = "$GNGGA,123519.00,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*77"
+	rmc  = "$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W,A*07"
+	gsa  = "$GNGSA,A,3,04,05,,09,12,,,24,,,,,2.5,1.3,2.1,1*3A"
+	gsv1 = "$GPGSV,2,1,07,07,79,048,42,02,51,062,43,26,36,256,42,27,27,138,42*71"
+	gsv2 = "$GPGSV,2,2,07,09,23,313,42,04,19,159,41,15,12,041,*47"
+	vtg  = "$GPVTG,054.7,T,034.4,M,005.5,N,010.2,K,A*25"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestParse(t *testing.T) {
+	s, err := nmea.Parse(gga)
+	if err != nil {
+		t.Fatalf("GGA: %v", err)
+	}
+	g := s.(*nmea.GGA)
+	if g.Talker != "GN" || g.FixQuality != 1 || g.Satellites != 8 || !near(g.Altitude, 545.4) {
+		t.Errorf("GGA: unexpected %+v", g)
+	}
+	if !near(g.Latitude, 48+7.038/60) || !near(g.Longitude, 11+31.0/60) {
+		t.Errorf("GGA: unexpected position %v, %v", g.Latitude, g.Longitude)
+	}
+	if g.Time.Hour() != 12 || g.Time.Minute() != 35 || g.Time.Second() != 19 {
+		t.Errorf("GGA: unexpected time %v", g.Time)
+	}
+
+	s, err = nmea.Parse(rmc)
+	if err != nil {
+		t.Fatalf("RMC: %v", err)
+	}
+	r := s.(*nmea.RMC)
+	want := time.Date(1994, 3, 23, 12, 35, 19, 0, time.UTC)
+	if !r.Valid || !r.Time.Equal(want) || !near(r.SpeedKnots, 22.4) || !near(r.MagneticVariation, -3.1) {
+		t.Errorf("RMC: unexpected %+v", r)
+	}
+
+	s, err = nmea.Parse(gsa)
+	if err != nil {
+		t.Fatalf("GSA: %v", err)
+	}
+	a := s.(*nmea.GSA)
+	if a.FixType != 3 || len(a.SatelliteIDs) != 5 || !near(a.HDOP, 1.3) || a.SystemID != 1 {
+		t.Errorf("GSA: unexpected %+v", a)
+	}
+
+	s, err = nmea.Parse(vtg)
+	if err != nil {
+		t.Fatalf("VTG: %v", err)
+	}
+	v := s.(*nmea.VTG)
+	if !near(v.TrueCourse, 54.7) || !near(v.SpeedKmh, 10.2) || v.Mode != "A" {
+		t.Errorf("VTG: unexpected %+v", v)
+	}
+
+	if _, err := nmea.Parse(gga[:len(gga)-2] + "00"); !errors.Is(err, nmea.ErrChecksum) {
+		t.Errorf("expected checksum error, got %v", err)
+	}
+	if _, err := nmea.Parse("$GPZDA,160012.71,11,03,2004,-1,00*7D"); err == nil {
+		t.Error("expected unsupported sentence error")
+	}
+}
+
+func TestRequester(t *testing.T) {
+	pr, pw := io.Pipe()
+	requester := nmea.NewNMEAFromReader(pr, 200*time.Millisecond)
+	defer requester.Close()
+
+	ggaMessage, _ := nmea.NewMessage("GGA")
+	gsvMessage, _ := nmea.NewMessage("gsv")
+
+	go func() {
+		for _, line := range []string{"$PUBX,00*33", gga, gsv1, gsv2} {
+			_, _ = io.WriteString(pw, line+"\r\n")
+		}
+	}()
+
+	log, err := requester.Process(ggaMessage)
+	if err != nil || !log.Success {
+		t.Fatalf("GGA: expected success, got %+v (%v)", log, err)
+	}
+	if log.MessageType != "nmea-GGA" {
+		t.Errorf("unexpected message type %q", log.MessageType)
+	}
+
+	log, err = requester.Process(gsvMessage)
+	if err != nil || !log.Success {
+		t.Fatalf("GSV: expected success, got %+v (%v)", log, err)
+	}
+	if gsv := log.Data.(*nmea.GSV); len(gsv.Satellites) != 7 {
+		t.Errorf("GSV: expected the two parts merged into 7 satellites, got %d", len(gsv.Satellites))
+	}
+
+	// The same GGA must not be logged twice.
+	if _, err := requester.Process(ggaMessage); err == nil {
+		t.Error("expected a timeout waiting for a fresh GGA")
+	}
+
+	_ = pw.Close()
+	if log, err := requester.Process(ggaMessage); err == nil || log.Success {
+		t.Errorf("expected an error after the receiver stopped, got %+v", log)
+	}
+}
@@ -0,0 +1,364 @@
+package nmea
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sentence is a parsed NMEA 0183 sentence.
+type Sentence interface {
+	// Type returns the sentence formatter without the talker, e.g. "GGA".
+	Type() string
+}
+
+// GGA is the global positioning system fix data.
+type GGA struct {
+	Talker          string
+	Time            time.Time // UTC time of day; the date is not part of GGA
+	Latitude        float64   // degrees, negative south
+	Longitude       float64   // degrees, negative west
+	FixQuality      int       // 0 invalid, 1 GPS, 2 DGPS, 4 RTK fixed, 5 RTK float, 6 estimated
+	Satellites      int
+	HDOP            float64
+	Altitude        float64 // metres above mean sea level
+	GeoidSeparation float64 // metres
+	DGPSAge         float64 // seconds
+	DGPSStationID   string
+}
+
+func (GGA) Type() string { return "GGA" }
+
+// RMC is the recommended minimum specific GNSS data.
+type RMC struct {
+	Talker            string
+	Time              time.Time // UTC date and time
+	Valid             bool
+	Latitude          float64
+	Longitude         float64
+	SpeedKnots        float64
+	Course            float64 // degrees true
+	MagneticVariation float64 // degrees, negative west
+	Mode              string  // A autonomous, D differential, E estimated, N not valid (NMEA 2.3+)
+}
+
+func (RMC) Type() string { return "RMC" }
+
+// GSA is the DOP and active satellites.
+type GSA struct {
+	Talker       string
+	Mode         string // M manual, A automatic
+	FixType      int    // 1 no fix, 2 2D, 3 3D
+	SatelliteIDs []int
+	PDOP         float64
+	HDOP         float64
+	VDOP         float64
+	SystemID     int // NMEA 4.1+, 0 if absent
+}
+
+func (GSA) Type() string { return "GSA" }
+
+type SatelliteInView struct {
+	PRN       int
+	Elevation int // degrees
+	Azimuth   int // degrees true
+	SNR       int // dB-Hz, 0 when not tracking
+}
+
+// GSV is the satellites in view. A receiver spreads one report over several sentences; the
+// requester merges them so that a logged GSV holds every satellite of the report.
+type GSV struct {
+	Talker           string
+	TotalMessages    int
+	MessageNumber    int
+	SatellitesInView int
+	Satellites       []SatelliteInView
+}
+
+func (GSV) Type() string { return "GSV" }
+
+// VTG is the course over ground and ground speed.
+type VTG struct {
+	Talker         string
+	TrueCourse     float64
+	MagneticCourse float64
+	SpeedKnots     float64
+	SpeedKmh       float64
+	Mode           string
+}
+
+func (VTG) Type() string { return "VTG" }
+
+var ErrChecksum = errors.New("nmea checksum mismatch")
+
+// SupportedSentences lists the sentence types Parse understands.
+var SupportedSentences = []string{"GGA", "RMC", "GSA", "GSV", "VTG"}
+
+// Parse parses a single NMEA 0183 sentence such as
+//
+//	$GNGGA,123519.00,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*77
+//
+// The checksum is verified when present. Any talker is accepted.
+func Parse(line string) (Sentence, error) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "$") {
+		return nil, fmt.Errorf("not an nmea sentence: %q", line)
+	}
+	line = line[1:]
+
+	if star := strings.LastIndexByte(line, '*'); star != -1 {
+		want, err := strconv.ParseUint(line[star+1:], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid checksum field: %w", err)
+		}
+
+		var sum byte
+		for i := 0; i < star; i++ {
+			sum ^= line[i]
+		}
+		if sum != byte(want) {
+			return nil, ErrChecksum
+		}
+
+		line = line[:star]
+	}
+
+	fields := strings.Split(line, ",")
+	if len(fields[0]) < 5 {
+		return nil, fmt.Errorf("invalid address field: %q", fields[0])
+	}
+
+	talker := fields[0][:len(fields[0])-3]
+	f := fieldReader{fields: fields[1:]}
+
+	var s Sentence
+	switch fields[0][len(fields[0])-3:] {
+	case "GGA":
+		s = parseGGA(talker, &f)
+	case "RMC":
+		s = parseRMC(talker, &f)
+	case "GSA":
+		s = parseGSA(talker, &f)
+	case "GSV":
+		s = parseGSV(talker, &f)
+	case "VTG":
+		s = parseVTG(talker, &f)
+	default:
+		return nil, fmt.Errorf("unsupported sentence: %s", fields[0])
+	}
+
+	if f.err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", fields[0], f.err)
+	}
+
+	return s, nil
+}
+
+func parseGGA(talker string, f *fieldReader) *GGA {
+	return &GGA{
+		Talker:          talker,
+		Time:            f.timeOfDay(),
+		Latitude:        f.coordinate(),
+		Longitude:       f.coordinate(),
+		FixQuality:      f.int(),
+		Satellites:      f.int(),
+		HDOP:            f.float(),
+		Altitude:        f.floatUnit(),
+		GeoidSeparation: f.floatUnit(),
+		DGPSAge:         f.float(),
+		DGPSStationID:   f.string(),
+	}
+}
+
+func parseRMC(talker string, f *fieldReader) *RMC {
+	s := &RMC{Talker: talker}
+
+	tod := f.timeOfDay()
+	s.Valid = f.string() == "A"
+	s.Latitude = f.coordinate()
+	s.Longitude = f.coordinate()
+	s.SpeedKnots = f.float()
+	s.Course = f.float()
+	s.Time = f.date(tod)
+
+	s.MagneticVariation = f.float()
+	if f.string() == "W" {
+		s.MagneticVariation = -s.MagneticVariation
+	}
+	s.Mode = f.string()
+
+	return s
+}
+
+func parseGSA(talker string, f *fieldReader) *GSA {
+	s := &GSA{
+		Talker:  talker,
+		Mode:    f.string(),
+		FixType: f.int(),
+	}
+
+	for i := 0; i < 12; i++ {
+		if id := f.int(); id != 0 {
+			s.SatelliteIDs = append(s.SatelliteIDs, id)
+		}
+	}
+
+	s.PDOP = f.float()
+	s.HDOP = f.float()
+	s.VDOP = f.float()
+	s.SystemID = f.int()
+
+	return s
+}
+
+func parseGSV(talker string, f *fieldReader) *GSV {
+	s := &GSV{
+		Talker:           talker,
+		TotalMessages:    f.int(),
+		MessageNumber:    f.int(),
+		SatellitesInView: f.int(),
+	}
+
+	// Up to four satellites per sentence, optionally followed by a signal ID (NMEA 4.1+) which
+	// leaves a single field.
+	for f.remaining() >= 4 {
+		s.Satellites = append(s.Satellites, SatelliteInView{
+			PRN:       f.int(),
+			Elevation: f.int(),
+			Azimuth:   f.int(),
+			SNR:       f.int(),
+		})
+	}
+
+	return s
+}
+
+func parseVTG(talker string, f *fieldReader) *VTG {
+	return &VTG{
+		Talker:         talker,
+		TrueCourse:     f.floatUnit(),
+		MagneticCourse: f.floatUnit(),
+		SpeedKnots:     f.floatUnit(),
+		SpeedKmh:       f.floatUnit(),
+		Mode:           f.string(),
+	}
+}
+
+// fieldReader consumes comma-separated fields in order. Missing or empty fields read as zero
+// values; the first malformed field is kept in err.
+type fieldReader struct {
+	fields []string
+	err    error
+}
+
+func (f *fieldReader) remaining() int {
+	return len(f.fields)
+}
+
+func (f *fieldReader) string() string {
+	if len(f.fields) == 0 {
+		return ""
+	}
+
+	s := f.fields[0]
+	f.fields = f.fields[1:]
+	return s
+}
+
+func (f *fieldReader) int() int {
+	s := f.string()
+	if s == "" {
+		return 0
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil && f.err == nil {
+		f.err = err
+	}
+	return v
+}
+
+func (f *fieldReader) float() float64 {
+	s := f.string()
+	if s == "" {
+		return 0
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil && f.err == nil {
+		f.err = err
+	}
+	return v
+}
+
+// floatUnit reads a value followed by its unit field (e.g. "545.4,M").
+func (f *fieldReader) floatUnit() float64 {
+	v := f.float()
+	f.string()
+	return v
+}
+
+// coordinate reads a "ddmm.mmmm,N" or "dddmm.mmmm,E" pair into signed decimal degrees.
+func (f *fieldReader) coordinate() float64 {
+	raw := f.float()
+	hemisphere := f.string()
+
+	degrees := math.Trunc(raw / 100)
+	v := degrees + (raw-degrees*100)/60
+
+	if hemisphere == "S" || hemisphere == "W" {
+		v = -v
+	}
+	return v
+}
+
+// timeOfDay reads "hhmmss.sss" as a UTC time on 0000-01-01.
+func (f *fieldReader) timeOfDay() time.Time {
+	s := f.string()
+	if len(s) < 6 {
+		return time.Time{}
+	}
+
+	hh, err1 := strconv.Atoi(s[0:2])
+	mm, err2 := strconv.Atoi(s[2:4])
+	ss, err3 := strconv.ParseFloat(s[4:], 64)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		if f.err == nil {
+			f.err = err
+		}
+		return time.Time{}
+	}
+
+	sec := math.Trunc(ss)
+	return time.Date(0, 1, 1, hh, mm, int(sec), int((ss-sec)*1e9+0.5), time.UTC)
+}
+
+// date reads "ddmmyy" and combines it with a time of day.
+func (f *fieldReader) date(tod time.Time) time.Time {
+	s := f.string()
+	if len(s) != 6 {
+		return tod
+	}
+
+	dd, err1 := strconv.Atoi(s[0:2])
+	mo, err2 := strconv.Atoi(s[2:4])
+	yy, err3 := strconv.Atoi(s[4:6])
+	if err := errors.Join(err1, err2, err3); err != nil {
+		if f.err == nil {
+			f.err = err
+		}
+		return tod
+	}
+
+	// Two-digit years pivot at 1980, the start of GPS time.
+	if yy < 80 {
+		yy += 2000
+	} else {
+		yy += 1900
+	}
+
+	return time.Date(yy, time.Month(mo), dd, tod.Hour(), tod.Minute(), tod.Second(), tod.Nanosecond(), time.UTC)
+}
@@ -0,0 +1,28 @@
+//go:build linux
+
+package probe
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// bindToDevice returns a dialer Control function that pins the socket to iface with
+// SO_BINDTODEVICE, so probes leave through the modem regardless of the routing table. It needs
+// CAP_NET_RAW (root on most systems).
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	if iface == "" {
+		return nil
+	}
+
+	return func(_, _ string, c syscall.RawConn) error {
+		var err error
+		if e := c.Control(func(fd uintptr) {
+			err = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, iface)
+		}); e != nil {
+			return e
+		}
+		return err
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package probe
+
+import (
+	"errors"
+	"syscall"
+)
+
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	if iface == "" {
+		return nil
+	}
+
+	return func(_, _ string, _ syscall.RawConn) error {
+		return errors.New("binding to an interface is only supported on linux")
+	}
+}
@@ -0,0 +1,473 @@
+// Package probe actively measures the network path through the modem: TCP connect time, UDP echo
+// round trip time, jitter and loss, and short throughput bursts, against a probe Server.
+package probe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging"
+)
+
+// RequesterName is the name messages from this package target by default.
+const RequesterName = "probe"
+
+// DefaultPort is the port the probe server listens on unless told otherwise.
+const DefaultPort = 7007
+
+// Config describes where and how to probe. Zero values are replaced by the defaults noted below.
+type Config struct {
+	Address   string        // host:port of a probe Server
+	Interface string        // optional, bind sockets to this interface with SO_BINDTODEVICE
+	Timeout   time.Duration // per probe, default 5s
+
+	UDPCount    int           // datagrams per UDP echo probe, default 10
+	UDPInterval time.Duration // between datagrams, default 20ms
+	UDPSize     int           // datagram payload size, minimum and default 16
+
+	BurstSize int64 // bytes per throughput direction, default 1 MiB
+}
+
+func (c Config) withDefaults() Config {
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+	if c.UDPCount <= 0 {
+		c.UDPCount = 10
+	}
+	if c.UDPInterval <= 0 {
+		c.UDPInterval = 20 * time.Millisecond
+	}
+	if c.UDPSize < udpHeaderSize {
+		c.UDPSize = udpHeaderSize
+	}
+	if c.BurstSize <= 0 {
+		c.BurstSize = 1 << 20
+	}
+	return c
+}
+
+// TCPConnectResult is the time to complete a TCP handshake with the server.
+type TCPConnectResult struct {
+	Address     string
+	ConnectTime time.Duration
+}
+
+// UDPEchoResult summarises one train of echoed datagrams. Jitter is the mean absolute difference
+// between consecutive round trip times (RFC 3550 style, without smoothing).
+type UDPEchoResult struct {
+	Address    string
+	Sent       int
+	Received   int
+	Duplicates int
+	Loss       float64 // percent
+	RTTMin     time.Duration
+	RTTAvg     time.Duration
+	RTTMax     time.Duration
+	Jitter     time.Duration
+}
+
+// ThroughputResult is one upload burst followed by one download burst.
+type ThroughputResult struct {
+	Address               string
+	UploadBytes           int64
+	UploadDuration        time.Duration
+	UploadBitsPerSecond   float64
+	DownloadBytes         int64
+	DownloadDuration      time.Duration
+	DownloadBitsPerSecond float64
+}
+
+// Probe runs the probes of its messages against one server.
+type Probe struct {
+	config Config
+	dialer net.Dialer
+}
+
+func NewProbe(config Config) (*Probe, error) {
+	if config.Address == "" {
+		return nil, errors.New("probe server address is required")
+	}
+	if _, _, err := net.SplitHostPort(config.Address); err != nil {
+		return nil, fmt.Errorf("invalid probe server address: %w", err)
+	}
+
+	config = config.withDefaults()
+
+	return &Probe{
+		config: config,
+		dialer: net.Dialer{Control: bindToDevice(config.Interface)},
+	}, nil
+}
+
+func (r *Probe) Process(messages cellularlog.Message) (cellularlog.LogEntry, error) {
+	return messages.Process(r)
+}
+
+// Config returns the effective configuration, defaults applied.
+func (r *Probe) Config() Config {
+	return r.config
+}
+
+// TCPConnect measures the time to establish a TCP connection to the server.
+func (r *Probe) TCPConnect(ctx context.Context) (*TCPConnectResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := r.dialer.DialContext(ctx, "tcp", r.config.Address)
+	if err != nil {
+		return nil, err
+	}
+	elapsed := time.Since(start)
+	_ = conn.Close()
+
+	return &TCPConnectResult{Address: r.config.Address, ConnectTime: elapsed}, nil
+}
+
+// udpHeaderSize is the sequence number and send timestamp at the start of each datagram.
+const udpHeaderSize = 16
+
+// UDPEcho sends UDPCount datagrams UDPInterval apart and waits up to Timeout after the last one for
+// the echoes. An error is returned only if no datagram could be sent; total loss is a result.
+func (r *Probe) UDPEcho(ctx context.Context) (*UDPEchoResult, error) {
+	conn, err := r.dialer.DialContext(ctx, "udp", r.config.Address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	count := r.config.UDPCount
+	rtts := make([]time.Duration, count)
+	seen := make([]bool, count)
+	result := &UDPEchoResult{Address: r.config.Address}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		buf := make([]byte, r.config.UDPSize+1)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() || errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue // e.g. ICMP port unreachable, reported once per datagram
+			}
+			received := time.Now()
+			if n < udpHeaderSize {
+				continue
+			}
+
+			seq := binary.BigEndian.Uint64(buf[0:8])
+			if seq >= uint64(count) {
+				continue
+			}
+			if seen[seq] {
+				result.Duplicates++
+				continue
+			}
+
+			seen[seq] = true
+			rtts[seq] = received.Sub(time.Unix(0, int64(binary.BigEndian.Uint64(buf[8:16]))))
+			result.Received++
+
+			if result.Received == count {
+				return
+			}
+		}
+	}()
+
+	payload := make([]byte, r.config.UDPSize)
+	ticker := time.NewTicker(r.config.UDPInterval)
+	defer ticker.Stop()
+
+send:
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				break send
+			case <-ticker.C:
+			}
+		}
+
+		binary.BigEndian.PutUint64(payload[0:8], uint64(i))
+		binary.BigEndian.PutUint64(payload[8:16], uint64(time.Now().UnixNano()))
+		if _, err := conn.Write(payload); err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			break
+		}
+		result.Sent++
+	}
+
+	// The receive goroutine owns result and the slices until it returns.
+	_ = conn.SetReadDeadline(time.Now().Add(r.config.Timeout))
+	select {
+	case <-done:
+	case <-ctx.Done():
+		_ = conn.SetReadDeadline(time.Now())
+		<-done
+	}
+
+	summarise(result, rtts, seen)
+
+	return result, nil
+}
+
+func summarise(result *UDPEchoResult, rtts []time.Duration, seen []bool) {
+	if result.Sent > 0 {
+		result.Loss = 100 * float64(result.Sent-result.Received) / float64(result.Sent)
+	}
+	if result.Received == 0 {
+		return
+	}
+
+	var (
+		total, jitter time.Duration
+		previous      = time.Duration(-1)
+		pairs         int
+	)
+
+	result.RTTMin = time.Duration(math.MaxInt64)
+	for i, rtt := range rtts {
+		if !seen[i] {
+			previous = -1
+			continue
+		}
+
+		total += rtt
+		result.RTTMin = min(result.RTTMin, rtt)
+		result.RTTMax = max(result.RTTMax, rtt)
+
+		if previous >= 0 {
+			jitter += (rtt - previous).Abs()
+			pairs++
+		}
+		previous = rtt
+	}
+
+	result.RTTAvg = total / time.Duration(result.Received)
+	if pairs > 0 {
+		result.Jitter = jitter / time.Duration(pairs)
+	}
+}
+
+// Throughput uploads and then downloads BurstSize bytes over one TCP connection each.
+func (r *Probe) Throughput(ctx context.Context) (*ThroughputResult, error) {
+	result := &ThroughputResult{Address: r.config.Address}
+
+	uploaded, upload, err := r.burst(ctx, opUpload)
+	if err != nil {
+		return nil, fmt.Errorf("upload burst failed: %w", err)
+	}
+	result.UploadBytes = uploaded
+	result.UploadDuration = upload
+	result.UploadBitsPerSecond = bitsPerSecond(uploaded, upload)
+
+	downloaded, download, err := r.burst(ctx, opDownload)
+	if err != nil {
+		return nil, fmt.Errorf("download burst failed: %w", err)
+	}
+	result.DownloadBytes = downloaded
+	result.DownloadDuration = download
+	result.DownloadBitsPerSecond = bitsPerSecond(downloaded, download)
+
+	return result, nil
+}
+
+func (r *Probe) burst(ctx context.Context, op byte) (int64, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+
+	conn, err := r.dialer.DialContext(ctx, "tcp", r.config.Address)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	var header [9]byte
+	header[0] = op
+	binary.BigEndian.PutUint64(header[1:], uint64(r.config.BurstSize))
+
+	start := time.Now()
+	if _, err := conn.Write(header[:]); err != nil {
+		return 0, 0, err
+	}
+
+	var n int64
+	switch op {
+	case opUpload:
+		if n, err = io.CopyN(conn, zeros{}, r.config.BurstSize); err != nil {
+			return n, time.Since(start), err
+		}
+
+		// The acknowledgement marks when the server has read everything, not just when the
+		// local socket buffer accepted it.
+		var ack [8]byte
+		if _, err := io.ReadFull(conn, ack[:]); err != nil {
+			return n, time.Since(start), err
+		}
+		n = int64(binary.BigEndian.Uint64(ack[:]))
+
+	case opDownload:
+		if n, err = io.CopyN(io.Discard, conn, r.config.BurstSize); err != nil {
+			return n, time.Since(start), err
+		}
+	}
+
+	return n, time.Since(start), nil
+}
+
+func bitsPerSecond(bytes int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(bytes) * 8 / d.Seconds()
+}
+
+// SupportedProbes lists the probe kinds NewMessage accepts: TCP connect time, UDP echo round trip
+// time, jitter and loss, and upload and download burst throughput.
+var SupportedProbes = []string{"tcp", "udp", "throughput"}
+
+type Message struct {
+	index     uint64
+	history   *cellularlog.History
+	kind      string
+	interval  time.Duration
+	requester string
+	ctx       context.Context
+}
+
+// NewMessage example
+//
+//	NewMessage(ctx, "udp", 0)
+//	NewMessage(ctx, "throughput", time.Minute)
+//
+// interval is the minimum time between runs of the probe; zero runs it every polling tick.
+// Throughput probes default to DefaultBurstInterval since each one loads the link it measures.
+func NewMessage(ctx context.Context, kind string, interval time.Duration) (*Message, error) {
+	if !slices.Contains(SupportedProbes, kind) {
+		return nil, fmt.Errorf("unsupported probe: %s", kind)
+	}
+
+	if kind == "throughput" && interval <= 0 {
+		interval = DefaultBurstInterval
+	}
+
+	return &Message{
+		history:   cellularlog.NewHistory(cellularlog.DefaultHistoryCapacity, 0),
+		kind:      kind,
+		interval:  interval,
+		requester: RequesterName,
+		ctx:       ctx,
+	}, nil
+}
+
+// DefaultBurstInterval is the default minimum time between throughput probes.
+const DefaultBurstInterval = time.Minute
+
+// SetRequester points the message at a requester registered under a name other than RequesterName.
+func (m *Message) SetRequester(name string) {
+	m.requester = name
+}
+
+func (m *Message) GetRequester() string {
+	return m.requester
+}
+
+// GetInterval implements cellularlog.Scheduled.
+func (m *Message) GetInterval() time.Duration {
+	return m.interval
+}
+
+func (m *Message) Process(requester cellularlog.Requester) (cellularlog.LogEntry, error) {
+	defer func() { m.index++ }()
+
+	log := cellularlog.LogEntry{
+		Index:       m.index,
+		MessageType: m.GetType(),
+		Success:     false,
+		RequestTime: time.Now(),
+	}
+
+	r, ok := requester.(*Probe)
+	if !ok {
+		log.Error = "errors interface mismatch"
+
+		m.add(log)
+		return log, errors.New("error interface mismatch")
+	}
+
+	var (
+		data interface{}
+		err  error
+	)
+
+	switch m.kind {
+	case "tcp":
+		data, err = r.TCPConnect(m.ctx)
+	case "udp":
+		data, err = r.UDPEcho(m.ctx)
+	case "throughput":
+		data, err = r.Throughput(m.ctx)
+	}
+
+	log.ResponseTime = time.Now()
+	log.Duration = log.ResponseTime.Sub(log.RequestTime)
+
+	if err != nil {
+		if m.ctx.Err() != nil {
+			log.Error = "context cancelled"
+		} else {
+			log.Error = err.Error()
+		}
+
+		m.add(log)
+		return log, err
+	}
+
+	log.Success = true
+	log.Data = data
+	if r.config.Interface != "" {
+		log.Metadata = map[string]interface{}{"interface": r.config.Interface}
+	}
+
+	m.add(log)
+
+	return log, nil
+}
+
+func (m *Message) add(log cellularlog.LogEntry) {
+	m.history.Add(log)
+}
+
+func (m *Message) GetType() string {
+	return fmt.Sprintf("probe-%s", m.kind)
+}
+
+func (m *Message) GetAllEntries() []cellularlog.LogEntry {
+	return m.history.All()
+}
+
+// History returns the bounded record of the message's entries.
+func (m *Message) History() *cellularlog.History {
+	return m.history
+}
@@ -0,0 +1,171 @@
+package probe_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging/pkg/probe"
+)
+
+func startServer(t *testing.T) string {
+	t.Helper()
+
+	server, err := probe.NewServer(context.Background(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Start()
+	t.Cleanup(func() { _ = server.Close() })
+
+	return server.Addr()
+}
+
+func TestProbesAgainstServer(t *testing.T) {
+	ctx := context.Background()
+	requester, err := probe.NewProbe(probe.Config{
+		Address:     startServer(t),
+		Timeout:     2 * time.Second,
+		UDPCount:    5,
+		UDPInterval: 5 * time.Millisecond,
+		UDPSize:     64,
+		BurstSize:   256 << 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kind := range probe.SupportedProbes {
+		message, err := probe.NewMessage(ctx, kind, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		log, err := requester.Process(message)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", kind, err)
+		}
+		if !log.Success || log.MessageType != "probe-"+kind || log.Duration <= 0 {
+			t.Errorf("%s: unexpected entry %+v", kind, log)
+		}
+
+		switch data := log.Data.(type) {
+		case *probe.TCPConnectResult:
+			if data.ConnectTime <= 0 {
+				t.Errorf("unexpected connect time %v", data.ConnectTime)
+			}
+		case *probe.UDPEchoResult:
+			if data.Sent != 5 || data.Received != 5 || data.Loss != 0 {
+				t.Errorf("unexpected echo result %+v", data)
+			}
+			if data.RTTMin <= 0 || data.RTTMin > data.RTTAvg || data.RTTAvg > data.RTTMax {
+				t.Errorf("inconsistent round trip times %+v", data)
+			}
+		case *probe.ThroughputResult:
+			if data.UploadBytes != 256<<10 || data.DownloadBytes != 256<<10 {
+				t.Errorf("unexpected burst sizes %+v", data)
+			}
+			if data.UploadBitsPerSecond <= 0 || data.DownloadBitsPerSecond <= 0 {
+				t.Errorf("unexpected rates %+v", data)
+			}
+		default:
+			t.Errorf("%s: unexpected data %T", kind, log.Data)
+		}
+	}
+}
+
+func TestUDPEchoTotalLoss(t *testing.T) {
+	// A bound socket that never answers.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	requester, err := probe.NewProbe(probe.Config{
+		Address:     conn.LocalAddr().String(),
+		Timeout:     100 * time.Millisecond,
+		UDPCount:    3,
+		UDPInterval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := requester.UDPEcho(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Sent != 3 || result.Received != 0 || result.Loss != 100 {
+		t.Errorf("expected total loss, got %+v", result)
+	}
+}
+
+func TestThroughputIsScheduled(t *testing.T) {
+	message, err := probe.NewMessage(context.Background(), "throughput", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.GetInterval() != probe.DefaultBurstInterval {
+		t.Errorf("expected the default burst interval, got %v", message.GetInterval())
+	}
+
+	if _, err := probe.NewMessage(context.Background(), "icmp", 0); err == nil {
+		t.Error("expected an error for an unsupported probe")
+	}
+}
+
+// syncBuffer is a buffer the server's connection goroutines can print to.
+type syncBuffer struct {
+	buf bytes.Buffer
+	mux sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	return b.buf.String()
+}
+
+func TestServerPrintsToOutput(t *testing.T) {
+	server, err := probe.NewServer(context.Background(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := &syncBuffer{}
+	server.SetOutput(output)
+	server.Start()
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	header := []byte{'D', 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(header[1:], 1<<40)
+	if _, err := conn.Write(header); err != nil {
+		t.Fatal(err)
+	}
+
+	for deadline := time.Now().Add(2 * time.Second); output.String() == "" && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !strings.Contains(output.String(), "burst of 1099511627776 bytes refused") {
+		t.Errorf("expected the refused burst to be printed to the output, got %q", output.String())
+	}
+}
@@ -0,0 +1,202 @@
+package probe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging/internal/multierr"
+)
+
+// Throughput burst operations, sent as the first byte of a TCP connection followed by the burst size
+// as a big-endian uint64. Connections that send nothing are TCP connect probes.
+const (
+	opUpload   byte = 'U' // client sends size bytes, server answers with the byte count it read
+	opDownload byte = 'D' // server sends size bytes
+)
+
+// maxBurst bounds what a single burst may ask the server to send or read.
+const maxBurst = 1 << 30
+
+// Server is the far end of the probes: it echoes UDP datagrams and serves TCP connect and
+// throughput probes on the same port number.
+type Server struct {
+	udp    *net.UDPConn
+	tcp    net.Listener
+	output io.Writer // where errors of probe connections are printed
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+// NewServer listens on address for both UDP and TCP, e.g. ":7007". Port 0 picks a free port.
+func NewServer(ctx context.Context, address string) (*Server, error) {
+	tcp, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	udpAddress := net.JoinHostPort(hostOf(address), fmt.Sprintf("%d", tcp.Addr().(*net.TCPAddr).Port))
+	addr, err := net.ResolveUDPAddr("udp", udpAddress)
+	if err != nil {
+		_ = tcp.Close()
+		return nil, err
+	}
+
+	udp, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		_ = tcp.Close()
+		return nil, err
+	}
+
+	ctx2, cancel := context.WithCancel(ctx)
+
+	return &Server{
+		udp:    udp,
+		tcp:    tcp,
+		output: os.Stdout,
+		ctx:    ctx2,
+		cancel: cancel,
+	}, nil
+}
+
+func hostOf(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// SetOutput sets where the server prints the errors of probe connections, by default stdout. Set it
+// before Start.
+func (s *Server) SetOutput(w io.Writer) {
+	s.output = w
+}
+
+// Addr returns the address the server listens on, valid for both UDP and TCP.
+func (s *Server) Addr() string {
+	return s.tcp.Addr().String()
+}
+
+func (s *Server) Start() {
+	s.wg.Add(2)
+	go s.serveUDP()
+	go s.serveTCP()
+}
+
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.udp.ReadFromUDP(buf)
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		_, _ = s.udp.WriteToUDP(buf[:n], addr)
+	}
+}
+
+func (s *Server) serveTCP() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+
+			// Unblock the handler on shutdown.
+			stop := context.AfterFunc(s.ctx, func() { _ = conn.Close() })
+			defer stop()
+
+			if err := handleTCP(conn); err != nil && !errors.Is(err, io.EOF) {
+				fmt.Fprintf(s.output, "probe server: %v\n", err)
+			}
+		}()
+	}
+}
+
+func handleTCP(conn net.Conn) error {
+	var header [9]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return err // io.EOF for connect probes
+	}
+
+	size := binary.BigEndian.Uint64(header[1:])
+	if size > maxBurst {
+		return fmt.Errorf("burst of %d bytes refused", size)
+	}
+
+	switch header[0] {
+	case opUpload:
+		n, err := io.CopyN(io.Discard, conn, int64(size))
+		if err != nil {
+			return err
+		}
+
+		var ack [8]byte
+		binary.BigEndian.PutUint64(ack[:], uint64(n))
+		_, err = conn.Write(ack[:])
+		return err
+
+	case opDownload:
+		_, err := io.CopyN(conn, zeros{}, int64(size))
+		return err
+
+	default:
+		return fmt.Errorf("unknown operation %q", header[0])
+	}
+}
+
+// zeros is an endless reader of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func (s *Server) Close() error {
+	var err error
+
+	s.once.Do(func() {
+		s.cancel()
+		err = multierr.Combine(s.udp.Close(), s.tcp.Close())
+
+		done := make(chan struct{})
+		go func() {
+			s.wg.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			err = multierr.Append(err, errors.New("timeout waiting for probe connections to close"))
+		}
+	})
+
+	return err
+}
@@ -0,0 +1,447 @@
+// Package redact removes subscriber identifiers and precise positions from log entries, so that logs
+// can be shared outside the team. Identifiers (IMEI, IMSI, ICCID and phone numbers) are replaced
+// wherever they appear: under keys naming them, and in text such as raw AT responses and errors.
+// Coordinates can be coarsened to the centre of a grid cell. A Writer redacts entries on their way
+// from the processor to the writers; Redactor.Entry redacts entries read back from existing logs.
+package redact
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/harshabose/cellular_localisation_logging"
+)
+
+// Identifiers that can be redacted.
+const (
+	IMEI  = "imei"
+	IMSI  = "imsi"
+	ICCID = "iccid"
+	Phone = "phone"
+)
+
+// AllIdentifiers are the identifiers a Policy can name.
+var AllIdentifiers = []string{IMEI, IMSI, ICCID, Phone}
+
+// Mode is how identifiers are replaced.
+type Mode string
+
+const (
+	// ModeHash replaces an identifier by its class and a keyed hash (HMAC-SHA256, shortened), e.g.
+	// imei:3f9a0c5e1b2d4a67, so that entries of the same device can still be told apart and joined
+	// across logs redacted with the same key.
+	ModeHash Mode = "hash"
+	// ModeMask keeps the last four digits, e.g. ***********0123.
+	ModeMask Mode = "mask"
+)
+
+// minKeySize is the shortest hash key accepted, in bytes.
+const minKeySize = 16
+
+// Policy describes what is redacted. It is recorded in the session header, without the key.
+type Policy struct {
+	Identifiers []string `json:"identifiers,omitempty"` // of AllIdentifiers
+	Mode        Mode     `json:"mode"`                  // default ModeHash
+	// Grid is the cell size coordinates are coarsened to, in degrees, e.g. 0.01 (about 1 km). Zero
+	// keeps positions.
+	Grid float64 `json:"grid,omitempty"`
+	// Key of the hashes, at least 16 bytes. Without one a random key is used, so hashes are only
+	// comparable within the session.
+	Key []byte `json:"-"`
+	// KeyID identifies the key without revealing it: the first bytes of its SHA-256, in hex. It is
+	// set by NewRedactor.
+	KeyID string `json:"key_id,omitempty"`
+}
+
+// Redactor applies a Policy to entries. It is safe for concurrent use.
+type Redactor struct {
+	policy  Policy
+	enabled map[string]bool
+}
+
+func NewRedactor(policy Policy) (*Redactor, error) {
+	r := &Redactor{enabled: make(map[string]bool)}
+
+	for _, identifier := range policy.Identifiers {
+		switch identifier {
+		case IMEI, IMSI, ICCID, Phone:
+			r.enabled[identifier] = true
+		default:
+			return nil, fmt.Errorf("unknown identifier '%s' (supported: %s)", identifier, strings.Join(AllIdentifiers, ", "))
+		}
+	}
+
+	switch policy.Mode {
+	case "":
+		policy.Mode = ModeHash
+	case ModeHash, ModeMask:
+	default:
+		return nil, fmt.Errorf("unsupported mode '%s' (supported: %s, %s)", policy.Mode, ModeHash, ModeMask)
+	}
+
+	if policy.Grid < 0 || policy.Grid > 90 || math.IsNaN(policy.Grid) {
+		return nil, fmt.Errorf("grid must be between 0 and 90 degrees")
+	}
+
+	if policy.Mode == ModeHash {
+		if len(policy.Key) == 0 {
+			policy.Key = make([]byte, 32)
+			if _, err := rand.Read(policy.Key); err != nil {
+				return nil, fmt.Errorf("failed to generate key: %w", err)
+			}
+		} else if len(policy.Key) < minKeySize {
+			return nil, fmt.Errorf("key must be at least %d bytes", minKeySize)
+		}
+		sum := sha256.Sum256(policy.Key)
+		policy.KeyID = hex.EncodeToString(sum[:4])
+	} else {
+		policy.Key, policy.KeyID = nil, ""
+	}
+
+	r.policy = policy
+	return r, nil
+}
+
+// Policy returns the policy applied, with its KeyID.
+func (r *Redactor) Policy() Policy {
+	return r.policy
+}
+
+// Entry returns entry with its data, metadata and error redacted. Values that change are converted
+// to the generic form of their JSON encoding; the entry is returned as is if nothing was redacted.
+// Metadata["position"] and Metadata["tags"] keep their types.
+func (r *Redactor) Entry(entry cellularlog.LogEntry) cellularlog.LogEntry {
+	if entry.Data != nil {
+		if data, changed := r.redactValue("", generic(entry.Data)); changed {
+			entry.Data = data
+		}
+	}
+
+	entry.Error = r.redactText(entry.Error)
+
+	if len(entry.Metadata) > 0 {
+		metadata := make(map[string]interface{}, len(entry.Metadata))
+		for key, value := range entry.Metadata {
+			switch v := value.(type) {
+			case cellularlog.Position:
+				v.Latitude = r.coarsen(v.Latitude)
+				v.Longitude = r.coarsen(v.Longitude)
+				metadata[key] = v
+			case map[string]string:
+				tags := make(map[string]string, len(v))
+				for name, tag := range v {
+					tags[name] = r.redactString(name, tag)
+				}
+				metadata[key] = tags
+			default:
+				metadata[key] = value
+				if redacted, changed := r.redactValue(key, generic(value)); changed {
+					metadata[key] = redacted
+				}
+			}
+		}
+		entry.Metadata = metadata
+	}
+
+	return entry
+}
+
+// Header redacts a session header entry like Entry and records the policy in it.
+func (r *Redactor) Header(header cellularlog.LogEntry) cellularlog.LogEntry {
+	policy := r.Policy()
+
+	switch data := header.Data.(type) {
+	case cellularlog.SessionHeader:
+		data.Redaction = policy
+		header.Data = data
+	case map[string]interface{}: // read back from a log
+		copied := make(map[string]interface{}, len(data)+1)
+		for key, value := range data {
+			copied[key] = value
+		}
+		copied["redaction"] = policy
+		header.Data = copied
+	}
+
+	return r.Entry(header)
+}
+
+// generic converts v to the generic form of its JSON encoding, with numbers kept as json.Number so
+// that large integers survive. Values that cannot be encoded are returned as is.
+func generic(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return v
+	}
+	return value
+}
+
+// redactValue redacts a generic value found under key, reporting whether anything changed.
+func (r *Redactor) redactValue(key string, v interface{}) (interface{}, bool) {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		changed := false
+		for k, element := range value {
+			if redacted, ok := r.redactValue(k, element); ok {
+				value[k], changed = redacted, true
+			}
+		}
+		return value, changed
+
+	case []interface{}:
+		changed := false
+		for i, element := range value {
+			if redacted, ok := r.redactValue(key, element); ok {
+				value[i], changed = redacted, true
+			}
+		}
+		return value, changed
+
+	case string:
+		redacted := r.redactString(key, value)
+		return redacted, redacted != value
+
+	case json.Number:
+		if class := identifierKey(key); class != "" && r.enabled[class] {
+			return r.replace(class, value.String()), true
+		}
+		if coordinateKey(key) && r.policy.Grid > 0 {
+			redacted := r.coarsenNumber(value)
+			return redacted, redacted != value
+		}
+	}
+
+	return v, false
+}
+
+// redactString redacts the whole of a value under a key naming an identifier, otherwise the
+// identifiers in its text.
+func (r *Redactor) redactString(key, value string) string {
+	if class := identifierKey(key); class != "" && r.enabled[class] && value != "" {
+		return r.replace(class, value)
+	}
+	return r.redactText(value)
+}
+
+// identifierKey returns the identifier a key names, if any, e.g. IMEI for "imei" or "IMEI".
+func identifierKey(key string) string {
+	switch strings.ToLower(strings.ReplaceAll(key, "_", "")) {
+	case "imei", "imeisv":
+		return IMEI
+	case "imsi":
+		return IMSI
+	case "iccid", "ccid":
+		return ICCID
+	case "msisdn", "phone", "phonenumber":
+		return Phone
+	default:
+		return ""
+	}
+}
+
+func coordinateKey(key string) bool {
+	switch strings.ToLower(key) {
+	case "lat", "lon", "lng", "latitude", "longitude":
+		return true
+	default:
+		return false
+	}
+}
+
+// digitsPattern matches the digit strings identifiers may be written as: an optional + of an
+// international phone number, and the F padding some modems report ICCIDs with.
+var digitsPattern = regexp.MustCompile(`\+?\b\d{6,20}F?\b`)
+
+// redactText replaces the identifiers in free text, such as raw AT responses, recognised by their
+// length and form: ICCIDs are 19 or 20 digits starting with 89, IMEIs and IMSIs 14 to 16 digits, and
+// phone numbers 7 to 15 digits after a + or quoted starting with 0, as in +CNUM responses. With a
+// grid, positions of +QGPSLOC responses and NMEA sentences are coarsened.
+func (r *Redactor) redactText(s string) string {
+	if len(r.enabled) > 0 {
+		s = replaceMatches(s, digitsPattern, func(s string, start, end int) string {
+			if start > 0 && s[start-1] == '.' {
+				return s[start:end] // a fraction
+			}
+			if class := r.textClass(s, start, end); class != "" {
+				return r.replace(class, s[start:end])
+			}
+			return s[start:end]
+		})
+	}
+
+	if r.policy.Grid > 0 {
+		s = r.coarsenText(s)
+	}
+
+	return s
+}
+
+// textClass returns the enabled identifier s[start:end] looks like, if any.
+func (r *Redactor) textClass(s string, start, end int) string {
+	match := s[start:end]
+	digits := strings.TrimSuffix(strings.TrimPrefix(match, "+"), "F")
+	quoted := start > 0 && s[start-1] == '"'
+
+	switch {
+	case strings.HasPrefix(match, "+"):
+		if len(digits) >= 7 && len(digits) <= 15 && r.enabled[Phone] {
+			return Phone
+		}
+	case strings.HasSuffix(match, "F") || len(digits) >= 19:
+		if strings.HasPrefix(digits, "89") && len(digits) <= 20 && r.enabled[ICCID] {
+			return ICCID
+		}
+	case len(digits) >= 14 && len(digits) <= 16 && (r.enabled[IMEI] || r.enabled[IMSI]):
+		// Either is redacted when one of them is, as IMSIs cannot be told from IMEIs reliably.
+		if len(digits) == 15 && !luhn(digits) {
+			return IMSI
+		}
+		return IMEI
+	case quoted && strings.HasPrefix(digits, "0") && len(digits) >= 7 && r.enabled[Phone]:
+		return Phone
+	}
+
+	return ""
+}
+
+// luhn reports whether digits end with a valid Luhn check digit, as IMEIs do.
+func luhn(digits string) bool {
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if (len(digits)-i)%2 == 0 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// replace redacts an identifier of the class according to the mode.
+func (r *Redactor) replace(class, value string) string {
+	if r.policy.Mode == ModeMask {
+		var (
+			masked = []byte(value)
+			keep   = 4
+		)
+		for i := len(masked) - 1; i >= 0; i-- {
+			if masked[i] < '0' || masked[i] > '9' {
+				continue
+			}
+			if keep > 0 {
+				keep--
+				continue
+			}
+			masked[i] = '*'
+		}
+		return string(masked)
+	}
+
+	mac := hmac.New(sha256.New, r.policy.Key)
+	mac.Write([]byte(value)) // not the class, which text can only guess
+
+	return class + ":" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// coarsen moves a coordinate in degrees to the centre of its grid cell.
+func (r *Redactor) coarsen(degrees float64) float64 {
+	grid := r.policy.Grid
+	if grid <= 0 || degrees == 0 {
+		return degrees
+	}
+	centre := math.Floor(degrees/grid)*grid + grid/2
+	return math.Round(centre*1e7) / 1e7
+}
+
+// coarsenNumber coarsens a coordinate of a generic value. Integers beyond 360 are taken as degrees
+// times 1e7, as MAVLink sends them.
+func (r *Redactor) coarsenNumber(n json.Number) json.Number {
+	if i, err := n.Int64(); err == nil && (i > 360 || i < -360) {
+		return json.Number(strconv.FormatInt(int64(math.Round(r.coarsen(float64(i)/1e7)*1e7)), 10))
+	}
+
+	f, err := n.Float64()
+	if err != nil {
+		return n
+	}
+	return json.Number(strconv.FormatFloat(r.coarsen(f), 'f', -1, 64))
+}
+
+var (
+	// nmeaCoordinatePattern matches ddmm.mmmm or dddmm.mmmm followed by a hemisphere, as in NMEA
+	// sentences (4807.038,N) and +QGPSLOC=0 responses (4807.0380N).
+	nmeaCoordinatePattern = regexp.MustCompile(`\b(\d{2,3})(\d{2}\.\d+)(,?)([NSEW])\b`)
+	// qgpslocPattern matches the latitude and longitude in degrees of +QGPSLOC=2 responses.
+	qgpslocPattern = regexp.MustCompile(`(\+QGPSLOC:\s*[^,]*,)(-?\d+\.\d+),(-?\d+\.\d+)`)
+)
+
+func (r *Redactor) coarsenText(s string) string {
+	s = qgpslocPattern.ReplaceAllStringFunc(s, func(match string) string {
+		m := qgpslocPattern.FindStringSubmatch(match)
+		lat, _ := strconv.ParseFloat(m[2], 64)
+		lon, _ := strconv.ParseFloat(m[3], 64)
+		return m[1] + formatDegrees(r.coarsen(lat), m[2]) + "," + formatDegrees(r.coarsen(lon), m[3])
+	})
+
+	return nmeaCoordinatePattern.ReplaceAllStringFunc(s, func(match string) string {
+		m := nmeaCoordinatePattern.FindStringSubmatch(match)
+		degrees, _ := strconv.ParseFloat(m[1], 64)
+		minutes, _ := strconv.ParseFloat(m[2], 64)
+
+		sign := 1.0
+		if m[4] == "S" || m[4] == "W" {
+			sign = -1
+		}
+		coarse := math.Abs(r.coarsen(sign * (degrees + minutes/60)))
+
+		whole := math.Floor(coarse)
+		decimals := len(m[2]) - strings.Index(m[2], ".") - 1
+		return fmt.Sprintf("%0*.0f%0*.*f%s%s", len(m[1]), whole, decimals+3, decimals, (coarse-whole)*60, m[3], m[4])
+	})
+}
+
+// formatDegrees formats degrees with the number of decimals of like.
+func formatDegrees(degrees float64, like string) string {
+	return strconv.FormatFloat(degrees, 'f', len(like)-strings.Index(like, ".")-1, 64)
+}
+
+// replaceMatches replaces every match of pattern in s by the result of fn, which is given s and the
+// bounds of the match so that it can look at the text around it.
+func replaceMatches(s string, pattern *regexp.Regexp, fn func(s string, start, end int) string) string {
+	matches := pattern.FindAllStringIndex(s, -1)
+	if len(matches) == 0 {
+		return s
+	}
+
+	var (
+		builder strings.Builder
+		last    int
+	)
+	for _, m := range matches {
+		builder.WriteString(s[last:m[0]])
+		builder.WriteString(fn(s, m[0], m[1]))
+		last = m[1]
+	}
+	builder.WriteString(s[last:])
+
+	return builder.String()
+}
@@ -0,0 +1,150 @@
+package redact_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/redact"
+)
+
+const (
+	imei  = "490154203237518" // valid Luhn check digit
+	imsi  = "262011234567890"
+	iccid = "89490200001234567890"
+	phone = "+491701234567"
+)
+
+func TestRedactText(t *testing.T) {
+	redactor, err := redact.NewRedactor(redact.Policy{Identifiers: redact.AllIdentifiers, Key: []byte("0123456789abcdef")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := redactor.Entry(cellularlog.LogEntry{
+		MessageType: "at-+CNUM",
+		Success:     true,
+		Data:        []string{`+CNUM: "","` + phone + `",145`, "+CGSN: " + imei, "+CCID: " + iccid, imsi},
+		Metadata:    map[string]interface{}{"response": []string{imsi, "+CSQ: 20,99"}},
+		Error:       "SIM " + iccid + " not inserted",
+	})
+
+	encoded, _ := json.Marshal(entry)
+	for _, identifier := range []string{imei, imsi, iccid, phone} {
+		if bytes.Contains(encoded, []byte(identifier)) {
+			t.Errorf("%s was not redacted: %s", identifier, encoded)
+		}
+	}
+	for _, kept := range []string{"+CSQ: 20,99", "imei:", "imsi:", "iccid:", "phone:"} {
+		if !bytes.Contains(encoded, []byte(kept)) {
+			t.Errorf("expected %q in %s", kept, encoded)
+		}
+	}
+
+	// Equal identifiers are hashed alike, under a key as in text.
+	identity := redactor.Entry(cellularlog.LogEntry{Data: map[string]string{"imei": imei}}).Data.(map[string]interface{})
+	if hashed := identity["imei"].(string); !strings.Contains(string(encoded), hashed) {
+		t.Errorf("expected %s in %s", hashed, encoded)
+	}
+
+	// Times, cell IDs and fractions stay.
+	untouched := cellularlog.LogEntry{Data: []string{"2026-10-18T10:00:00Z", "+QENG: 262,01,1A2D001", "0.123456789012345"}}
+	if entry := redactor.Entry(untouched); !equal(entry.Data, untouched.Data) {
+		t.Errorf("unexpected redaction %+v", entry.Data)
+	}
+}
+
+func TestRedactMaskAndGrid(t *testing.T) {
+	redactor, err := redact.NewRedactor(redact.Policy{Identifiers: []string{redact.IMEI}, Mode: redact.ModeMask, Grid: 0.1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type globalPosition struct {
+		Lat, Lon int32
+		Alt      int32
+	}
+	entry := redactor.Entry(cellularlog.LogEntry{
+		Data: map[string]interface{}{
+			"imei":     imei,
+			"position": globalPosition{Lat: 481234567, Lon: -115432100, Alt: 520000},
+			"fix":      "+QGPSLOC: 093523.000,4807.4074N,01131.3208E,0.7",
+		},
+		Metadata: map[string]interface{}{
+			"position": cellularlog.Position{Latitude: 48.12345, Longitude: 11.54321},
+			"tags":     map[string]string{"antenna": "roof"},
+		},
+	})
+
+	data, _ := json.Marshal(entry.Data)
+	for _, want := range []string{`"imei":"***********7518"`, `"Lat":481500000`, `"Lon":-115500000`, `"Alt":520000`, "4809.0000N,01133.0000E"} {
+		if !bytes.Contains(data, []byte(want)) {
+			t.Errorf("expected %s in %s", want, data)
+		}
+	}
+
+	if position := entry.Metadata["position"].(cellularlog.Position); position.Latitude != 48.15 || position.Longitude != 11.55 {
+		t.Errorf("unexpected position %+v", position)
+	}
+	if tags := entry.Metadata["tags"].(map[string]string); tags["antenna"] != "roof" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+}
+
+func TestNewRedactor(t *testing.T) {
+	for _, policy := range []redact.Policy{
+		{Identifiers: []string{"email"}},
+		{Mode: "encrypt"},
+		{Grid: -1},
+		{Key: []byte("short")},
+	} {
+		if _, err := redact.NewRedactor(policy); err == nil {
+			t.Errorf("expected an error for %+v", policy)
+		}
+	}
+}
+
+func equal(a, b interface{}) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return bytes.Equal(ja, jb)
+}
+
+type recorder struct {
+	entries []cellularlog.LogEntry
+}
+
+func (r *recorder) Write(entries []cellularlog.LogEntry) error {
+	r.entries = append(r.entries, entries...)
+	return nil
+}
+
+func (r *recorder) Close() error { return nil }
+
+func TestWriterRecordsPolicy(t *testing.T) {
+	redactor, err := redact.NewRedactor(redact.Policy{Identifiers: []string{redact.IMEI, redact.IMSI}, Key: []byte("0123456789abcdef")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &recorder{}
+	writer := redact.NewWriter(out, redactor)
+
+	header := cellularlog.SessionHeader{Identities: map[string]interface{}{"modem": map[string]string{"imei": imei, "imsi": imsi}}}
+	if err := writer.WriteHeader(cellularlog.LogEntry{MessageType: cellularlog.SessionMessageType, Data: header}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Write([]cellularlog.LogEntry{{MessageType: "at-+CGSN", Data: imei}}); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, _ := json.Marshal(out.entries)
+	if bytes.Contains(encoded, []byte(imei)) || bytes.Contains(encoded, []byte(imsi)) {
+		t.Errorf("identifiers were not redacted: %s", encoded)
+	}
+	if want := `"redaction":{"identifiers":["imei","imsi"],"key_id":"` + redactor.Policy().KeyID + `","mode":"hash"}`; !bytes.Contains(encoded, []byte(want)) {
+		t.Errorf("expected %s in %s", want, encoded)
+	}
+}
@@ -0,0 +1,62 @@
+package redact
+
+import (
+	"fmt"
+
+	"github.com/harshabose/cellular_localisation_logging"
+)
+
+// Writer redacts entries before passing them on to another writer, and records its policy in the
+// session header.
+type Writer struct {
+	writer   cellularlog.Writer
+	redactor *Redactor
+}
+
+func NewWriter(writer cellularlog.Writer, redactor *Redactor) *Writer {
+	return &Writer{writer: writer, redactor: redactor}
+}
+
+func (w *Writer) Write(entries []cellularlog.LogEntry) error {
+	redacted := make([]cellularlog.LogEntry, len(entries))
+	for i, entry := range entries {
+		redacted[i] = w.redactor.Entry(entry)
+	}
+
+	return w.writer.Write(redacted)
+}
+
+func (w *Writer) WriteHeader(header cellularlog.LogEntry) error {
+	header = w.redactor.Header(header)
+
+	if hw, ok := w.writer.(cellularlog.HeaderWriter); ok {
+		return hw.WriteHeader(header)
+	}
+	return w.writer.Write([]cellularlog.LogEntry{header})
+}
+
+func (w *Writer) Files() []string {
+	if f, ok := w.writer.(cellularlog.FileWriter); ok {
+		return f.Files()
+	}
+	return nil
+}
+
+func (w *Writer) BytesWritten() uint64 {
+	if counter, ok := w.writer.(cellularlog.ByteCounter); ok {
+		return counter.BytesWritten()
+	}
+	return 0
+}
+
+func (w *Writer) Rotate() error {
+	rotator, ok := w.writer.(cellularlog.Rotator)
+	if !ok {
+		return fmt.Errorf("writer does not support rotation")
+	}
+	return rotator.Rotate()
+}
+
+func (w *Writer) Close() error {
+	return w.writer.Close()
+}
@@ -0,0 +1,338 @@
+// Package retime corrects the wall times of a log whose host clock was wrong for part of the session,
+// e.g. a companion computer that booted with a wrong clock until NTP or GPS stepped it. Every entry
+// carries its session offset on the monotonic clock; a Timeline collects the trusted times the log
+// itself holds (GNSS fixes, the network time of the modem, the host clock while NTP kept it
+// synchronised) and maps the offsets of all entries to wall times from them.
+package retime
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/AT"
+	"github.com/harshabose/cellular_localisation_logging/pkg/mavlink"
+)
+
+const (
+	// SourceGNSS is a GNSS time: NMEA RMC, gpsd TPV, or MAVLink SYSTEM_TIME and GPS sample times.
+	SourceGNSS = "gnss"
+	// SourceNTP is the host clock while the kernel considered it synchronised.
+	SourceNTP = "ntp"
+	// SourceNITZ is the modem's clock (+CCLK?), set from the network time where the network sends it.
+	SourceNITZ = "nitz"
+)
+
+// DefaultSources are the sources a Timeline uses, preferred first.
+var DefaultSources = []string{SourceGNSS, SourceNTP, SourceNITZ}
+
+// MetadataKey is the Metadata key of the Correction of a rewritten entry.
+const MetadataKey = "retime"
+
+const (
+	gnssLatency    = 100 * time.Millisecond // receivers output a fix this late, at most
+	ntpUncertainty = 10 * time.Millisecond
+	driftAllowance = 50e-6 // of the monotonic clock, away from the nearest reference
+)
+
+// minTime is the earliest time taken for a trusted one; clocks that were never set start earlier.
+var minTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Reference is a trusted wall time at a session offset.
+type Reference struct {
+	Offset      time.Duration
+	Time        time.Time
+	Source      string
+	Uncertainty time.Duration
+}
+
+// zero returns the wall time at session offset zero the reference implies.
+func (r Reference) zero() time.Time {
+	return r.Time.Add(-r.Offset)
+}
+
+// Correction is what Rewrite changed of an entry, kept in its Metadata under MetadataKey.
+type Correction struct {
+	Source      string        `json:"source"`
+	Shift       time.Duration `json:"shift"` // added to the wall times of the entry
+	Uncertainty time.Duration `json:"uncertainty"`
+	RequestTime time.Time     `json:"request_time"` // as logged
+}
+
+// Find returns the trusted time an entry holds, if any.
+func Find(entry cellularlog.LogEntry) (Reference, bool) {
+	if !entry.Success || !hasOffset(entry) {
+		return Reference{}, false
+	}
+
+	var ref Reference
+	switch {
+	case entry.MessageType == cellularlog.SessionMessageType:
+		var header struct {
+			Started           time.Time `json:"started"`
+			ClockSynchronized *bool     `json:"clock_synchronized"`
+		}
+		if !decode(entry.Data, &header) || header.ClockSynchronized == nil || !*header.ClockSynchronized {
+			return Reference{}, false
+		}
+		ref = Reference{Offset: entry.SessionOffset, Time: header.Started, Source: SourceNTP, Uncertainty: ntpUncertainty}
+
+	case entry.MessageType == cellularlog.ClockMessageType:
+		var event cellularlog.ClockEvent
+		if !decode(entry.Data, &event) || event.Synchronized == nil || !*event.Synchronized {
+			return Reference{}, false
+		}
+		ref = Reference{Offset: entry.SessionOffset, Time: event.After, Source: SourceNTP, Uncertainty: ntpUncertainty}
+
+	case entry.MessageType == "mavlink-2": // SYSTEM_TIME
+		var systemTime struct{ TimeUnixUsec uint64 }
+		if !decode(entry.Data, &systemTime) || systemTime.TimeUnixUsec == 0 {
+			return Reference{}, false
+		}
+		ref = during(entry, time.UnixMicro(int64(systemTime.TimeUnixUsec)), SourceGNSS, time.Millisecond)
+
+	case strings.HasPrefix(entry.MessageType, "mavlink-"):
+		var sample mavlink.SampleTime
+		if !decode(entry.Metadata[mavlink.SampleTimeMetadataKey], &sample) || sample.Source != mavlink.TimeSourceGPS {
+			return Reference{}, false
+		}
+		uncertainty := time.Duration(sample.UncertaintyMS * float64(time.Millisecond))
+		ref = during(entry, sample.Time, SourceGNSS, uncertainty)
+
+	case entry.MessageType == "nmea-RMC":
+		var rmc struct {
+			Time  time.Time
+			Valid bool
+		}
+		if !decode(entry.Data, &rmc) || !rmc.Valid {
+			return Reference{}, false
+		}
+		ref = received(entry, rmc.Time)
+
+	case entry.MessageType == "gpsd-TPV":
+		var tpv struct {
+			Time time.Time `json:"time"`
+			Mode int       `json:"mode"`
+		}
+		if !decode(entry.Data, &tpv) || tpv.Mode < 2 {
+			return Reference{}, false
+		}
+		ref = received(entry, tpv.Time)
+
+	case entry.MessageType == "at-+CCLK?":
+		network, ok := networkTime(entry)
+		if !ok {
+			return Reference{}, false
+		}
+		// The clock only has seconds.
+		ref = during(entry, network, SourceNITZ, time.Second)
+
+	default:
+		return Reference{}, false
+	}
+
+	if ref.Time.Before(minTime) {
+		return Reference{}, false
+	}
+	return ref, true
+}
+
+// during returns the reference of a time taken between the request and the response of an entry.
+func during(entry cellularlog.LogEntry, t time.Time, source string, uncertainty time.Duration) Reference {
+	return Reference{
+		Offset:      entry.SessionOffset + entry.Duration/2,
+		Time:        t,
+		Source:      source,
+		Uncertainty: uncertainty + entry.Duration/2,
+	}
+}
+
+// received returns the reference of a GNSS time that the host received at the entry's
+// Metadata["received_time"], which may be well before the request.
+func received(entry cellularlog.LogEntry, t time.Time) Reference {
+	offset := entry.SessionOffset
+	var metadata struct {
+		ReceivedTime time.Time `json:"received_time"`
+	}
+	if decode(entry.Metadata, &metadata) && !metadata.ReceivedTime.IsZero() {
+		offset += metadata.ReceivedTime.Sub(entry.RequestTime)
+	}
+	return Reference{Offset: offset, Time: t, Source: SourceGNSS, Uncertainty: gnssLatency}
+}
+
+// networkTime returns the time of a +CCLK? entry, parsed or raw.
+func networkTime(entry cellularlog.LogEntry) (time.Time, bool) {
+	var parsed AT.NetworkTime
+	if decode(entry.Data, &parsed) && !parsed.Time.IsZero() {
+		return parsed.Time, true
+	}
+
+	var lines []string
+	if !decode(entry.Data, &lines) {
+		return time.Time{}, false
+	}
+	data, err := AT.ParseCCLK(lines)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return data.(*AT.NetworkTime).Time, true
+}
+
+// hasOffset reports whether the session offset of an entry is one of the session it is in.
+func hasOffset(entry cellularlog.LogEntry) bool {
+	if replayed, _ := entry.Metadata["replayed"].(bool); replayed {
+		return false
+	}
+	return entry.SessionOffset != 0 || entry.MessageType == cellularlog.SessionMessageType
+}
+
+// decode converts the data of an entry, a generic JSON value when read back from a log, to v.
+func decode(data interface{}, v interface{}) bool {
+	if data == nil {
+		return false
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(raw, v) == nil
+}
+
+// Timeline maps the session offsets of a session to wall times, from the references of the most
+// preferred source it has: between two references by interpolation, which follows the drift of the
+// monotonic clock, and beyond them from the nearest one.
+type Timeline struct {
+	sources []string
+	refs    map[string][]Reference
+	sorted  bool
+}
+
+// NewTimeline returns a timeline using sources, preferred first, or DefaultSources if none are given.
+func NewTimeline(sources ...string) *Timeline {
+	if len(sources) == 0 {
+		sources = DefaultSources
+	}
+	return &Timeline{sources: sources, refs: make(map[string][]Reference)}
+}
+
+// Observe adds the reference of entry, if it holds one, and reports whether it did.
+func (t *Timeline) Observe(entry cellularlog.LogEntry) bool {
+	ref, ok := Find(entry)
+	if ok {
+		t.Add(ref)
+	}
+	return ok
+}
+
+// Add adds a reference.
+func (t *Timeline) Add(ref Reference) {
+	t.refs[ref.Source] = append(t.refs[ref.Source], ref)
+	t.sorted = false
+}
+
+// Source returns the source the timeline uses, empty if it has no references of any of its sources.
+func (t *Timeline) Source() string {
+	for _, source := range t.sources {
+		if len(t.refs[source]) > 0 {
+			return source
+		}
+	}
+	return ""
+}
+
+// References returns the number of references of each source.
+func (t *Timeline) References() map[string]int {
+	counts := make(map[string]int, len(t.refs))
+	for source, refs := range t.refs {
+		counts[source] = len(refs)
+	}
+	return counts
+}
+
+// At returns the wall time at a session offset, and its uncertainty.
+func (t *Timeline) At(offset time.Duration) (time.Time, time.Duration, bool) {
+	source := t.Source()
+	if source == "" {
+		return time.Time{}, 0, false
+	}
+
+	if !t.sorted {
+		for _, refs := range t.refs {
+			sort.Slice(refs, func(i, j int) bool { return refs[i].Offset < refs[j].Offset })
+		}
+		t.sorted = true
+	}
+	refs := t.refs[source]
+
+	i := sort.Search(len(refs), func(i int) bool { return refs[i].Offset >= offset })
+	switch {
+	case i == 0:
+		return extrapolate(refs[0], offset)
+	case i == len(refs):
+		return extrapolate(refs[len(refs)-1], offset)
+	}
+
+	before, after := refs[i-1], refs[i]
+	span := after.Offset - before.Offset
+	if span <= 0 {
+		return extrapolate(after, offset)
+	}
+	fraction := float64(offset-before.Offset) / float64(span)
+	zero := before.zero().Add(time.Duration(fraction * float64(after.zero().Sub(before.zero()))))
+	uncertainty := max(before.Uncertainty, after.Uncertainty)
+
+	return zero.Add(offset), uncertainty, true
+}
+
+func extrapolate(ref Reference, offset time.Duration) (time.Time, time.Duration, bool) {
+	distance := offset - ref.Offset
+	if distance < 0 {
+		distance = -distance
+	}
+	return ref.zero().Add(offset), ref.Uncertainty + time.Duration(driftAllowance*float64(distance)), true
+}
+
+// Rewrite returns entry with its request and response times, and the start of a session header,
+// taken from the timeline, and the Correction in its Metadata. Entries without a session offset, from
+// logs written before there were any, and entries replayed from the write-ahead log of an earlier
+// session are returned unchanged, as is every entry if the timeline has no references.
+func (t *Timeline) Rewrite(entry cellularlog.LogEntry) (cellularlog.LogEntry, bool) {
+	if !hasOffset(entry) {
+		return entry, false
+	}
+
+	at, uncertainty, ok := t.At(entry.SessionOffset)
+	if !ok {
+		return entry, false
+	}
+
+	shift := at.Sub(entry.RequestTime)
+	correction := Correction{Source: t.Source(), Shift: shift, Uncertainty: uncertainty, RequestTime: entry.RequestTime}
+
+	entry.RequestTime = at
+	if !entry.ResponseTime.IsZero() {
+		entry.ResponseTime = at.Add(entry.Duration) // even if the clock was stepped in between
+	}
+
+	if header, ok := entry.Data.(map[string]interface{}); ok && entry.MessageType == cellularlog.SessionMessageType {
+		data := make(map[string]interface{}, len(header))
+		for k, v := range header {
+			data[k] = v
+		}
+		if started, _, ok := t.At(0); ok {
+			data["started"] = started
+		}
+		entry.Data = data
+	}
+
+	metadata := make(map[string]interface{}, len(entry.Metadata)+1)
+	for k, v := range entry.Metadata {
+		metadata[k] = v
+	}
+	metadata[MetadataKey] = correction
+	entry.Metadata = metadata
+
+	return entry, true
+}
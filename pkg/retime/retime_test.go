@@ -0,0 +1,151 @@
+package retime_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v3/pkg/dialects/common"
+
+	"github.com/harshabose/cellular_localisation_logging"
+	"github.com/harshabose/cellular_localisation_logging/pkg/retime"
+)
+
+// readBack returns entry as an EntryReader returns it, with generic data.
+func readBack(t *testing.T, entry cellularlog.LogEntry) cellularlog.LogEntry {
+	t.Helper()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read cellularlog.LogEntry
+	if err := json.Unmarshal(data, &read); err != nil {
+		t.Fatal(err)
+	}
+	return read
+}
+
+func TestSteppedClock(t *testing.T) {
+	truth := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)  // at session offset zero
+	booted := time.Date(2019, 2, 14, 0, 0, 0, 0, time.UTC) // what the host clock said until the step
+	synchronized := true
+
+	at := func(offset time.Duration, wall time.Time, entry cellularlog.LogEntry) cellularlog.LogEntry {
+		entry.Success = true
+		entry.SessionOffset = offset
+		entry.RequestTime = wall.Add(offset)
+		entry.ResponseTime = entry.RequestTime.Add(entry.Duration)
+		return readBack(t, entry)
+	}
+
+	entries := []cellularlog.LogEntry{
+		at(0, booted, cellularlog.LogEntry{MessageType: cellularlog.SessionMessageType, Data: cellularlog.SessionHeader{Started: booted}}),
+		at(5*time.Second, booted, cellularlog.LogEntry{MessageType: "at-+CSQ", Data: []string{"+CSQ: 20,99"}, Duration: 30 * time.Millisecond}),
+		// The modem never had the network time, so its clock is of no use.
+		at(6*time.Second, booted, cellularlog.LogEntry{MessageType: "at-+CCLK?", Data: []string{`+CCLK: "80/01/06,00:01:02+00"`}}),
+		at(10*time.Second, truth, cellularlog.LogEntry{MessageType: cellularlog.ClockMessageType, Data: cellularlog.ClockEvent{
+			Event: cellularlog.ClockStep, After: truth.Add(10 * time.Second), Step: truth.Sub(booted), Synchronized: &synchronized,
+		}}),
+		at(20*time.Second, truth, cellularlog.LogEntry{MessageType: "mavlink-2", Duration: 20 * time.Millisecond, Data: &common.MessageSystemTime{
+			TimeUnixUsec: uint64(truth.Add(20*time.Second + 10*time.Millisecond).UnixMicro()),
+		}}),
+		at(30*time.Second, truth, cellularlog.LogEntry{MessageType: "at-+CSQ", Data: []string{"+CSQ: 21,99"}}),
+	}
+	replayed := entries[1]
+	replayed.Metadata = map[string]interface{}{"replayed": true}
+	replayed.SessionOffset = time.Hour // of the earlier session
+	entries = append(entries, replayed)
+
+	for _, sources := range [][]string{nil, {retime.SourceNTP}} {
+		timeline := retime.NewTimeline(sources...)
+		for _, entry := range entries {
+			timeline.Observe(entry)
+		}
+		if want := []string{retime.SourceGNSS, retime.SourceNTP}[len(sources)]; timeline.Source() != want {
+			t.Fatalf("%v: expected %s references, got %v", sources, want, timeline.References())
+		}
+
+		for i, entry := range entries[:len(entries)-1] {
+			rewritten, ok := timeline.Rewrite(entry)
+			if !ok {
+				t.Fatalf("%v: entry %d not rewritten", sources, i)
+			}
+			want := truth.Add(entry.SessionOffset)
+			if diff := rewritten.RequestTime.Sub(want); diff > time.Millisecond || diff < -time.Millisecond {
+				t.Errorf("%v: entry %d at %s, want %s", sources, i, rewritten.RequestTime, want)
+			}
+			if got := rewritten.ResponseTime.Sub(rewritten.RequestTime); got != entry.Duration {
+				t.Errorf("%v: entry %d lasts %v, want %v", sources, i, got, entry.Duration)
+			}
+			correction, ok := rewritten.Metadata[retime.MetadataKey].(retime.Correction)
+			if !ok || !correction.RequestTime.Equal(entry.RequestTime) {
+				t.Errorf("%v: entry %d: unexpected correction %+v", sources, i, rewritten.Metadata)
+			}
+		}
+
+		header, _ := timeline.Rewrite(entries[0])
+		if started := header.Data.(map[string]interface{})["started"].(time.Time); !started.Equal(truth) {
+			t.Errorf("%v: header started at %s, want %s", sources, started, truth)
+		}
+		if _, ok := timeline.Rewrite(entries[len(entries)-1]); ok {
+			t.Errorf("%v: replayed entry rewritten", sources)
+		}
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	timeline := retime.NewTimeline()
+	if _, _, ok := timeline.At(time.Second); ok {
+		t.Fatal("expected no time without references")
+	}
+
+	// The monotonic clock runs 50 ppm slow: 1000 s of it are 1000.05 s.
+	timeline.Add(retime.Reference{Offset: 0, Time: start, Source: retime.SourceNITZ, Uncertainty: time.Second})
+	timeline.Add(retime.Reference{Offset: 1000 * time.Second, Time: start.Add(1000*time.Second + 50*time.Millisecond), Source: retime.SourceNITZ, Uncertainty: time.Second})
+
+	for offset, want := range map[time.Duration]time.Time{
+		500 * time.Second:  start.Add(500*time.Second + 25*time.Millisecond),
+		2000 * time.Second: start.Add(2000*time.Second + 50*time.Millisecond),
+		-time.Second:       start.Add(-time.Second),
+	} {
+		got, uncertainty, ok := timeline.At(offset)
+		if !ok || !got.Equal(want) {
+			t.Errorf("at %v: got %s, want %s", offset, got, want)
+		}
+		if uncertainty < time.Second {
+			t.Errorf("at %v: uncertainty %v below that of the references", offset, uncertainty)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	fix := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	received := fix.Add(-2 * time.Hour) // by the wrong host clock, before the request
+
+	for name, entry := range map[string]cellularlog.LogEntry{
+		"rmc": {MessageType: "nmea-RMC", Data: map[string]interface{}{"Time": fix, "Valid": true}},
+		"tpv": {MessageType: "gpsd-TPV", Data: map[string]interface{}{"time": fix, "mode": 3}},
+	} {
+		entry.Success = true
+		entry.SessionOffset = time.Minute
+		entry.RequestTime = received.Add(300 * time.Millisecond)
+		entry.Metadata = map[string]interface{}{"received_time": received}
+
+		ref, ok := retime.Find(readBack(t, entry))
+		if !ok || ref.Source != retime.SourceGNSS || !ref.Time.Equal(fix) || ref.Offset != time.Minute-300*time.Millisecond {
+			t.Errorf("%s: unexpected reference %+v", name, ref)
+		}
+	}
+
+	nitz := cellularlog.LogEntry{Success: true, MessageType: "at-+CCLK?", SessionOffset: time.Minute, Data: []string{`+CCLK: "25/06/01,14:00:00+08"`}}
+	if ref, ok := retime.Find(readBack(t, nitz)); !ok || ref.Source != retime.SourceNITZ || !ref.Time.Equal(fix) {
+		t.Errorf("nitz: unexpected reference %+v", ref)
+	}
+
+	invalid := cellularlog.LogEntry{Success: true, MessageType: "nmea-RMC", SessionOffset: time.Minute, Data: map[string]interface{}{"Time": fix, "Valid": false}}
+	if ref, ok := retime.Find(invalid); ok {
+		t.Errorf("unexpected reference of an invalid fix %+v", ref)
+	}
+}
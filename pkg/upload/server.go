@@ -0,0 +1,267 @@
+package upload
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server is a minimal tus 1.0 server (creation and checksum extensions, SHA-256 only) that stores
+// completed uploads in a directory. Partial uploads are kept in memory, so they do not survive a
+// restart of the server. Uploads whose content does not match the sha256 metadata are discarded.
+// It is meant for testing the uploader and for small ground stations; mount it on a path ending in
+// a slash, e.g. /files/.
+type Server struct {
+	dir string
+
+	uploads map[string]*partial
+	mux     sync.Mutex
+}
+
+type partial struct {
+	name   string
+	sha256 string
+	length int64
+	offset int64
+	file   *os.File
+	hash   hash.Hash
+	mux    sync.Mutex
+}
+
+// NewServer stores uploads in dir, and partial uploads in dir/.partial.
+func NewServer(dir string) (*Server, error) {
+	if err := os.MkdirAll(filepath.Join(dir, ".partial"), 0o755); err != nil {
+		return nil, err
+	}
+
+	return &Server{dir: dir, uploads: make(map[string]*partial)}, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", "creation,checksum")
+		w.Header().Set("Tus-Checksum-Algorithm", "sha256")
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		s.create(w, r)
+	case http.MethodHead:
+		s.head(w, r)
+	case http.MethodPatch:
+		s.patch(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+
+	metadata, err := parseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Only the base name is used so that uploads cannot escape the directory.
+	name := filepath.Base(metadata["filename"])
+	if name == "." || name == "/" || name == ".." || strings.HasPrefix(name, ".") {
+		http.Error(w, "invalid filename metadata", http.StatusBadRequest)
+		return
+	}
+
+	id, err := newID()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	file, err := os.Create(filepath.Join(s.dir, ".partial", id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	upload := &partial{name: name, sha256: metadata["sha256"], length: length, file: file, hash: sha256.New()}
+
+	s.mux.Lock()
+	s.uploads[id] = upload
+	s.mux.Unlock()
+
+	if length == 0 {
+		s.complete(id, upload)
+	}
+
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+id)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) head(w http.ResponseWriter, r *http.Request) {
+	upload, ok := s.lookup(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	upload.mux.Lock()
+	defer upload.mux.Unlock()
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.length, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) patch(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "invalid Content-Type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	upload, ok := s.lookup(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	upload.mux.Lock()
+	defer upload.mux.Unlock()
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != upload.offset {
+		http.Error(w, fmt.Sprintf("expected Upload-Offset %d", upload.offset), http.StatusConflict)
+		return
+	}
+
+	algorithm, checksum, _ := strings.Cut(r.Header.Get("Upload-Checksum"), " ")
+	if algorithm != "" && algorithm != "sha256" {
+		http.Error(w, "unsupported checksum algorithm", http.StatusBadRequest)
+		return
+	}
+
+	chunk, err := io.ReadAll(io.LimitReader(r.Body, upload.length-upload.offset+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if int64(len(chunk)) > upload.length-upload.offset {
+		http.Error(w, "chunk exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if algorithm != "" {
+		sum := sha256.Sum256(chunk)
+		if base64.StdEncoding.EncodeToString(sum[:]) != checksum {
+			http.Error(w, "checksum mismatch", StatusChecksumMismatch)
+			return
+		}
+	}
+
+	if _, err := upload.file.WriteAt(chunk, upload.offset); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	upload.hash.Write(chunk)
+	upload.offset += int64(len(chunk))
+
+	if upload.offset == upload.length {
+		if err := s.complete(path.Base(r.URL.Path), upload); err != nil {
+			http.Error(w, err.Error(), StatusChecksumMismatch)
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// complete verifies the whole upload and moves it into the directory; rejected uploads are
+// forgotten, so the client starts over.
+func (s *Server) complete(id string, upload *partial) error {
+	s.mux.Lock()
+	delete(s.uploads, id)
+	s.mux.Unlock()
+
+	partialName := upload.file.Name()
+	if err := upload.file.Close(); err != nil {
+		return err
+	}
+
+	if sum := hex.EncodeToString(upload.hash.Sum(nil)); upload.sha256 != "" && sum != upload.sha256 {
+		_ = os.Remove(partialName)
+		return fmt.Errorf("file checksum mismatch: expected %s, got %s", upload.sha256, sum)
+	}
+
+	return os.Rename(partialName, filepath.Join(s.dir, upload.name))
+}
+
+func (s *Server) lookup(r *http.Request) (*partial, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	upload, ok := s.uploads[path.Base(r.URL.Path)]
+	return upload, ok
+}
+
+// Close discards partial uploads.
+func (s *Server) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for id, upload := range s.uploads {
+		_ = upload.file.Close()
+		_ = os.Remove(upload.file.Name())
+		delete(s.uploads, id)
+	}
+	return nil
+}
+
+// parseMetadata decodes "key base64value,key base64value".
+func parseMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value for %s", key)
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
+
+func newID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id[:]), nil
+}
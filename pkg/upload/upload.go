@@ -0,0 +1,584 @@
+// Package upload forwards closed log segments to an HTTP(S) endpoint speaking the tus resumable
+// upload protocol (https://tus.io, version 1.0 with the creation and checksum extensions), so that
+// logs reach the ground without pulling SD cards. Uploads resume where they stopped after a lost
+// connection or a restart, every chunk carries a SHA-256 checksum, and the whole file's SHA-256 is
+// sent as upload metadata for the server to verify. Progress is kept in a local JSON state file.
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const tusVersion = "1.0.0"
+
+// maxStalled is how many requests in a row may make no progress before an upload is given up until
+// the next attempt.
+const maxStalled = 5
+
+// StatusChecksumMismatch is the tus checksum extension's response to a chunk that arrived damaged.
+const StatusChecksumMismatch = 460
+
+// Config describes what to upload where. Zero values are replaced by the defaults noted below.
+type Config struct {
+	URL      string            // tus creation endpoint, e.g. https://ground.example.com/files/
+	Headers  map[string]string // added to every request, e.g. Authorization
+	Patterns []string          // globs of the segments to upload
+
+	StateFile string        // default upload-state.json
+	ChunkSize int64         // bytes per PATCH request, default 1 MiB
+	Rate      int64         // bytes per second, zero for no limit
+	Interval  time.Duration // between scans for new segments, default 30s
+	MinAge    time.Duration // since a file was last modified, default 10s
+	Timeout   time.Duration // per request, default 30s
+
+	MinBackoff time.Duration // after the first failure, default 1s
+	MaxBackoff time.Duration // default 5m
+
+	Delete bool // remove segments once uploaded
+
+	// Active returns files still being written, which are never uploaded.
+	Active func() []string
+
+	Output io.Writer // where errors of the background uploads are printed, default stdout
+}
+
+func (c Config) withDefaults() Config {
+	if c.StateFile == "" {
+		c.StateFile = "upload-state.json"
+	}
+	if c.ChunkSize <= 0 {
+		c.ChunkSize = 1 << 20
+	}
+	if c.Interval <= 0 {
+		c.Interval = 30 * time.Second
+	}
+	if c.MinAge <= 0 {
+		c.MinAge = 10 * time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 30 * time.Second
+	}
+	if c.MinBackoff <= 0 {
+		c.MinBackoff = time.Second
+	}
+	if c.MaxBackoff < c.MinBackoff {
+		c.MaxBackoff = max(5*time.Minute, c.MinBackoff)
+	}
+	if c.Output == nil {
+		c.Output = os.Stdout
+	}
+	return c
+}
+
+// Segment is the upload state of one file, as kept in the state file.
+type Segment struct {
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	SHA256   string    `json:"sha256"`
+	Location string    `json:"location,omitempty"` // of the upload in progress
+	Offset   int64     `json:"offset"`
+	Uploaded time.Time `json:"uploaded,omitempty"`
+}
+
+type Uploader struct {
+	config Config
+	client *http.Client
+
+	segments map[string]*Segment // by absolute path
+	mux      sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewUploader loads the state file, if there is one. Call Start to upload in the background.
+func NewUploader(ctx context.Context, config Config) (*Uploader, error) {
+	config = config.withDefaults()
+
+	if _, err := url.ParseRequestURI(config.URL); err != nil {
+		return nil, fmt.Errorf("invalid upload URL: %w", err)
+	}
+	for _, pattern := range config.Patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+	}
+
+	ctx2, cancel := context.WithCancel(ctx)
+	u := &Uploader{
+		config:   config,
+		client:   &http.Client{Timeout: config.Timeout},
+		segments: make(map[string]*Segment),
+		ctx:      ctx2,
+		cancel:   cancel,
+	}
+
+	data, err := os.ReadFile(config.StateFile)
+	if err == nil {
+		err = json.Unmarshal(data, &u.segments)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		cancel()
+		return nil, fmt.Errorf("error reading upload state %s: %w", config.StateFile, err)
+	}
+
+	return u, nil
+}
+
+// Start uploads pending segments every interval, backing off after failures.
+func (u *Uploader) Start() {
+	u.wg.Add(1)
+	go u.loop()
+}
+
+func (u *Uploader) loop() {
+	defer u.wg.Done()
+
+	var failures int
+	for {
+		wait := u.config.Interval
+		if err := u.UploadPending(u.ctx); err != nil && u.ctx.Err() == nil {
+			fmt.Fprintf(u.config.Output, "error uploading segments: %v\n", err)
+
+			wait = backoff(u.config.MinBackoff, u.config.MaxBackoff, failures)
+			failures++
+		} else {
+			failures = 0
+		}
+
+		select {
+		case <-u.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// backoff doubles from min to max, with 20% jitter so that a fleet does not retry in lockstep.
+func backoff(first, limit time.Duration, failures int) time.Duration {
+	d := first << min(failures, 30)
+	if d > limit || d <= 0 {
+		d = limit
+	}
+	return time.Duration(float64(d) * (0.8 + 0.4*rand.Float64()))
+}
+
+// Pending returns the segments matching the patterns that are closed and not uploaded yet, oldest
+// first.
+func (u *Uploader) Pending() ([]string, error) {
+	var active []string
+	if u.config.Active != nil {
+		for _, file := range u.config.Active() {
+			if abs, err := filepath.Abs(file); err == nil {
+				active = append(active, abs)
+			}
+		}
+	}
+
+	type candidate struct {
+		path    string
+		modTime time.Time
+	}
+	var candidates []candidate
+
+	seen := make(map[string]bool)
+	for _, pattern := range u.config.Patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, match := range matches {
+			path, err := filepath.Abs(match)
+			if err != nil || seen[path] || slices.Contains(active, path) {
+				continue
+			}
+			seen[path] = true
+
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() || time.Since(info.ModTime()) < u.config.MinAge {
+				continue
+			}
+
+			u.mux.Lock()
+			segment, ok := u.segments[path]
+			uploaded := ok && !segment.Uploaded.IsZero() && segment.Size == info.Size() && segment.ModTime.Equal(info.ModTime())
+			u.mux.Unlock()
+
+			if !uploaded {
+				candidates = append(candidates, candidate{path: path, modTime: info.ModTime()})
+			}
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].modTime.Before(candidates[j].modTime) })
+
+	pending := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		pending = append(pending, c.path)
+	}
+	return pending, nil
+}
+
+// UploadPending uploads every pending segment, one at a time, stopping at the first failure.
+func (u *Uploader) UploadPending(ctx context.Context) error {
+	u.prune()
+
+	pending, err := u.Pending()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range pending {
+		if err := u.Upload(ctx, path); err != nil {
+			return fmt.Errorf("error uploading %s: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// prune forgets files that no longer exist, e.g. removed after the upload.
+func (u *Uploader) prune() {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+
+	for path := range u.segments {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			delete(u.segments, path)
+		}
+	}
+}
+
+// Upload uploads path, resuming an earlier attempt if there was one.
+func (u *Uploader) Upload(ctx context.Context, path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	segment, err := u.segment(path, file, info)
+	if err != nil {
+		return err
+	}
+
+	if !segment.Uploaded.IsZero() {
+		return nil
+	}
+
+	var stalled int
+	for attempt := 0; segment.Offset < segment.Size || segment.Location == ""; attempt++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if stalled >= maxStalled {
+			return fmt.Errorf("no progress after %d attempts at offset %d", stalled, segment.Offset)
+		}
+
+		if segment.Location == "" {
+			location, err := u.create(ctx, path, segment)
+			if err != nil {
+				return err
+			}
+			u.update(func() { segment.Location, segment.Offset = location, 0 })
+			if err := u.save(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if attempt == 0 {
+			// The server may have more or less than the state file says after a crash.
+			if err := u.resync(ctx, segment); err != nil {
+				return err
+			}
+			continue
+		}
+
+		offset := segment.Offset
+		if err := u.patch(ctx, file, segment); err != nil {
+			return err
+		}
+		if segment.Offset > offset {
+			stalled = 0
+		} else {
+			stalled++
+		}
+		if err := u.save(); err != nil {
+			return err
+		}
+	}
+
+	u.update(func() { segment.Uploaded, segment.Location = time.Now(), "" })
+
+	if err := u.save(); err != nil {
+		return err
+	}
+
+	if !u.config.Delete {
+		return nil
+	}
+
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	u.update(func() { delete(u.segments, path) })
+	return u.save()
+}
+
+// segment returns the state of path, starting over if the file changed since it was recorded.
+func (u *Uploader) segment(path string, file *os.File, info os.FileInfo) (*Segment, error) {
+	u.mux.Lock()
+	segment, ok := u.segments[path]
+	u.mux.Unlock()
+
+	if ok && segment.Size == info.Size() && segment.ModTime.Equal(info.ModTime()) {
+		return segment, nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+
+	segment = &Segment{Size: info.Size(), ModTime: info.ModTime(), SHA256: hex.EncodeToString(hash.Sum(nil))}
+
+	u.mux.Lock()
+	u.segments[path] = segment
+	u.mux.Unlock()
+
+	return segment, nil
+}
+
+func (u *Uploader) create(ctx context.Context, path string, segment *Segment) (string, error) {
+	req, err := u.request(ctx, http.MethodPost, u.config.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Upload-Length", strconv.FormatInt(segment.Size, 10))
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte(filepath.Base(path)))+
+		",sha256 "+base64.StdEncoding.EncodeToString([]byte(segment.SHA256)))
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", unexpected(resp)
+	}
+
+	location, err := resp.Location()
+	if err != nil {
+		return "", fmt.Errorf("upload created without a location: %w", err)
+	}
+	return location.String(), nil
+}
+
+// resync asks the server how much of the upload it has.
+func (u *Uploader) resync(ctx context.Context, segment *Segment) error {
+	req, err := u.request(ctx, http.MethodHead, segment.Location, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+	case http.StatusNotFound, http.StatusGone:
+		// Expired or rejected, e.g. by the server's checksum of the whole file.
+		u.update(func() { segment.Location, segment.Offset = "", 0 })
+		return nil
+	default:
+		return unexpected(resp)
+	}
+
+	offset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 || offset > segment.Size {
+		return fmt.Errorf("invalid Upload-Offset %q", resp.Header.Get("Upload-Offset"))
+	}
+	u.update(func() { segment.Offset = offset })
+
+	return nil
+}
+
+func (u *Uploader) patch(ctx context.Context, file *os.File, segment *Segment) error {
+	chunk := make([]byte, min(u.config.ChunkSize, segment.Size-segment.Offset))
+	if _, err := file.ReadAt(chunk, segment.Offset); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(chunk)
+
+	req, err := u.request(ctx, http.MethodPatch, segment.Location, newThrottle(ctx, bytes.NewReader(chunk), u.config.Rate))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(chunk))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.FormatInt(segment.Offset, 10))
+	req.Header.Set("Upload-Checksum", "sha256 "+base64.StdEncoding.EncodeToString(sum[:]))
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK:
+	case http.StatusConflict, StatusChecksumMismatch, http.StatusNotFound, http.StatusGone:
+		// Out of step or damaged on the way; find out where to continue.
+		return u.resync(ctx, segment)
+	default:
+		return unexpected(resp)
+	}
+
+	offset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset <= segment.Offset || offset > segment.Size {
+		return fmt.Errorf("invalid Upload-Offset %q", resp.Header.Get("Upload-Offset"))
+	}
+
+	u.update(func() { segment.Offset = offset })
+
+	return nil
+}
+
+func (u *Uploader) request(ctx context.Context, method, target string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for k, v := range u.config.Headers {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+// update changes a segment under the lock Segments and save read it with.
+func (u *Uploader) update(change func()) {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+
+	change()
+}
+
+func unexpected(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s %s: %s %s", resp.Request.Method, resp.Request.URL, resp.Status, bytes.TrimSpace(body))
+}
+
+// Segments returns a copy of the upload state, by absolute path.
+func (u *Uploader) Segments() map[string]Segment {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+
+	segments := make(map[string]Segment, len(u.segments))
+	for path, segment := range u.segments {
+		segments[path] = *segment
+	}
+	return segments
+}
+
+// save writes the state file atomically.
+func (u *Uploader) save() error {
+	u.mux.Lock()
+	data, err := json.MarshalIndent(u.segments, "", "  ")
+	u.mux.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := u.config.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("error writing upload state: %w", err)
+	}
+	return os.Rename(tmp, u.config.StateFile)
+}
+
+// Close stops uploading; an upload in progress resumes from the state file next time.
+func (u *Uploader) Close() error {
+	u.cancel()
+	u.wg.Wait()
+	return nil
+}
+
+// throttle is a reader limited to a number of bytes per second, sent in small pieces so that the
+// upload never holds the link at full speed for long.
+type throttle struct {
+	ctx   context.Context
+	r     io.Reader
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+const throttlePiece = 16 << 10
+
+func newThrottle(ctx context.Context, r io.Reader, rate int64) io.Reader {
+	if rate <= 0 {
+		return r
+	}
+	return &throttle{ctx: ctx, r: r, rate: rate}
+}
+
+func (t *throttle) Read(p []byte) (int, error) {
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+
+	// Wait until the bytes read so far are within the rate.
+	due := t.start.Add(time.Duration(float64(t.read) / float64(t.rate) * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		select {
+		case <-t.ctx.Done():
+			return 0, t.ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+
+	if len(p) > throttlePiece {
+		p = p[:throttlePiece]
+	}
+	n, err := t.r.Read(p)
+	t.read += int64(n)
+
+	return n, err
+}
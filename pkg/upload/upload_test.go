@@ -0,0 +1,212 @@
+package upload_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging/pkg/upload"
+)
+
+// faults wraps the server, failing or damaging chosen PATCH requests and counting uploaded bytes.
+type faults struct {
+	server  http.Handler
+	patches int
+	bytes   int64
+	fail    map[int]func(w http.ResponseWriter, r *http.Request) bool // by PATCH number, true if handled
+	mux     sync.Mutex
+}
+
+func (f *faults) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPatch {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		f.mux.Lock()
+		f.patches++
+		f.bytes += int64(len(body))
+		fail := f.fail[f.patches]
+		f.mux.Unlock()
+
+		if fail != nil && fail(w, r) {
+			return
+		}
+	}
+
+	f.server.ServeHTTP(w, r)
+}
+
+func segment(t *testing.T, path string, size int) []byte {
+	t.Helper()
+
+	data := make([]byte, size)
+	_, _ = rand.Read(data)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestUploadResumesAfterFailures(t *testing.T) {
+	logs, received := t.TempDir(), t.TempDir()
+
+	first := segment(t, filepath.Join(logs, "session_000.json"), 300<<10)
+	second := segment(t, filepath.Join(logs, "session_001.json"), 10)
+	segment(t, filepath.Join(logs, "session_002.json"), 10) // still being written
+
+	server, err := upload.NewServer(received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	f := &faults{server: server, fail: map[int]func(http.ResponseWriter, *http.Request) bool{
+		2: func(w http.ResponseWriter, _ *http.Request) bool {
+			http.Error(w, "uplink lost", http.StatusBadGateway)
+			return true
+		},
+		4: func(_ http.ResponseWriter, r *http.Request) bool {
+			body, _ := io.ReadAll(r.Body)
+			body[0] ^= 0xff // damaged on the way, caught by the chunk checksum
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			return false
+		},
+	}}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	config := upload.Config{
+		URL:       ts.URL + "/files/",
+		Patterns:  []string{filepath.Join(logs, "session_*.json")},
+		StateFile: filepath.Join(logs, "state.json"),
+		ChunkSize: 64 << 10,
+		MinAge:    time.Nanosecond,
+		Active:    func() []string { return []string{filepath.Join(logs, "session_002.json")} },
+	}
+
+	uploader, err := upload.NewUploader(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := uploader.UploadPending(context.Background()); err == nil {
+		t.Fatal("expected the failed request to stop the upload")
+	}
+	if err := uploader.UploadPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string][]byte{"session_000.json": first, "session_001.json": second} {
+		got, err := os.ReadFile(filepath.Join(received, name))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s was not received intact (%v)", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(received, "session_002.json")); err == nil {
+		t.Error("the active segment was uploaded")
+	}
+
+	// Only the failed and the damaged chunk are sent twice.
+	if want := int64(len(first)+len(second)) + 2*64<<10; f.bytes != want {
+		t.Errorf("expected %d bytes sent, got %d", want, f.bytes)
+	}
+
+	// The state file marks both segments as uploaded for the next session.
+	uploader, err = upload.NewUploader(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending, err := uploader.Pending(); err != nil || len(pending) != 0 {
+		t.Errorf("expected nothing pending, got %v (%v)", pending, err)
+	}
+}
+
+func TestUploadResumesAfterRestart(t *testing.T) {
+	logs, received := t.TempDir(), t.TempDir()
+	data := segment(t, filepath.Join(logs, "session.bin"), 256<<10)
+
+	server, err := upload.NewServer(received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &faults{server: server, fail: map[int]func(http.ResponseWriter, *http.Request) bool{
+		3: func(http.ResponseWriter, *http.Request) bool { cancel(); return false },
+	}}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	config := upload.Config{
+		URL:       ts.URL + "/files/",
+		Patterns:  []string{filepath.Join(logs, "*.bin")},
+		StateFile: filepath.Join(logs, "state.json"),
+		ChunkSize: 64 << 10,
+		MinAge:    time.Nanosecond,
+		Delete:    true,
+	}
+
+	uploader, err := upload.NewUploader(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := uploader.UploadPending(ctx); err == nil {
+		t.Fatal("expected the upload to be interrupted")
+	}
+
+	uploader, err = upload.NewUploader(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := uploader.UploadPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := os.ReadFile(filepath.Join(received, "session.bin")); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("segment was not received intact (%v)", err)
+	}
+	if f.bytes > int64(len(data))+64<<10 {
+		t.Errorf("expected the upload to resume, %d bytes sent for %d", f.bytes, len(data))
+	}
+	if _, err := os.Stat(filepath.Join(logs, "session.bin")); err == nil {
+		t.Error("expected the uploaded segment to be deleted")
+	}
+}
+
+func TestUploadThrottle(t *testing.T) {
+	logs := t.TempDir()
+	segment(t, filepath.Join(logs, "session.json"), 100<<10)
+
+	server, err := upload.NewServer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	uploader, err := upload.NewUploader(context.Background(), upload.Config{
+		URL:       ts.URL + "/files/",
+		StateFile: filepath.Join(logs, "state.json"),
+		Rate:      200 << 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := uploader.Upload(context.Background(), filepath.Join(logs, "session.json")); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Errorf("100 KiB at 200 KiB/s took only %s", elapsed)
+	}
+}
@@ -0,0 +1,242 @@
+package cellularlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging/internal/diskqueue"
+	"github.com/harshabose/cellular_localisation_logging/internal/multierr"
+)
+
+var (
+	// ErrSpilled is wrapped by the error of a write whose batch was kept on disk to be written
+	// later. The batch is not lost, so the processor does not keep it.
+	ErrSpilled = errors.New("batch spilled to disk")
+	// ErrPartialWrite is wrapped by the error of a MultiWriter write that some writers took.
+	ErrPartialWrite = errors.New("some writers failed")
+)
+
+// delivered reports whether a write that returned err has taken the batch, so that the processor
+// need not keep it. After ErrPartialWrite, the write-ahead log still keeps it for the writers that
+// missed it.
+func delivered(err error) bool {
+	return err == nil || errors.Is(err, ErrSpilled) || errors.Is(err, ErrPartialWrite)
+}
+
+// backoffDelay doubles from first, after the first failure, up to limit.
+func backoffDelay(first, limit time.Duration, failures int) time.Duration {
+	d := first << min(max(failures-1, 0), 30)
+	if d > limit || d <= 0 {
+		d = limit
+	}
+	return d
+}
+
+// FailurePolicy is what a PolicyWriter does when its writer fails. Nothing waits inside Write: a
+// failed batch is retried by later writes, which the processor makes with the batches it keeps (see
+// Processor.SetBackpressure), or from the spill.
+type FailurePolicy struct {
+	// Retries is how many failures in a row the next write still tries the writer on at once. After
+	// that the writer backs off: writes fail, or are spilled, without trying it until the backoff
+	// has passed.
+	Retries    int
+	MinBackoff time.Duration // after the first failure beyond Retries, doubled for each one; default 100ms
+	MaxBackoff time.Duration // default 5s
+
+	// SpillDir, if set, is where failed batches, and batches written while the writer backs off or
+	// earlier ones wait, are queued, to be written before the next batch once the writer recovers.
+	SpillDir     string
+	SpillMaxSize int64 // bytes, zero for no limit
+}
+
+// PolicyWriter applies a FailurePolicy to a writer.
+type PolicyWriter struct {
+	writer    Writer
+	policy    FailurePolicy
+	spill     *diskqueue.Queue
+	failures  int       // in a row
+	lastErr   error     // of the last failure
+	retryAt   time.Time // while backing off
+	discarded int       // spilled batches that could not be decoded
+	mux       sync.Mutex
+}
+
+func NewPolicyWriter(writer Writer, policy FailurePolicy) (*PolicyWriter, error) {
+	if policy.MinBackoff <= 0 {
+		policy.MinBackoff = 100 * time.Millisecond
+	}
+	if policy.MaxBackoff < policy.MinBackoff {
+		policy.MaxBackoff = max(5*time.Second, policy.MinBackoff)
+	}
+
+	w := &PolicyWriter{writer: writer, policy: policy}
+	if policy.SpillDir != "" {
+		spill, err := diskqueue.Open(policy.SpillDir, policy.SpillMaxSize)
+		if err != nil {
+			return nil, fmt.Errorf("error opening spill queue: %w", err)
+		}
+		w.spill = spill
+	}
+
+	return w, nil
+}
+
+// Write writes the spilled batches first, then entries. The batch is spilled if it fails, if
+// earlier batches could not be written yet, or if the writer is backing off.
+func (w *PolicyWriter) Write(entries []LogEntry) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	now := time.Now()
+	if now.Before(w.retryAt) {
+		return w.spillBatch(entries, fmt.Errorf("writer backing off until %s after %d failures: %w", w.retryAt.Format(time.TimeOnly), w.failures, w.lastErr))
+	}
+
+	err := w.drain()
+	if err == nil {
+		err = w.writer.Write(entries)
+	}
+	if err != nil {
+		w.failed(now, err)
+		return w.spillBatch(entries, err)
+	}
+
+	w.failures, w.lastErr, w.retryAt = 0, nil, time.Time{}
+	return nil
+}
+
+// failed counts a failure and starts backing off once the retries are used up.
+func (w *PolicyWriter) failed(now time.Time, err error) {
+	w.failures++
+	w.lastErr = err
+	if w.failures > w.policy.Retries {
+		w.retryAt = now.Add(backoffDelay(w.policy.MinBackoff, w.policy.MaxBackoff, w.failures-w.policy.Retries))
+	}
+}
+
+// WriteHeader passes the session header on without retries; it is not spilled.
+func (w *PolicyWriter) WriteHeader(header LogEntry) error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	return writeHeader(w.writer, header)
+}
+
+// drain writes the spilled batches, oldest first, stopping at the first failure. Batches that no
+// longer decode are discarded and counted, as they would otherwise block the rest.
+func (w *PolicyWriter) drain() error {
+	if w.spill == nil {
+		return nil
+	}
+
+	for {
+		record, ok, err := w.spill.Peek()
+		if err != nil || !ok {
+			return err
+		}
+
+		var entries []LogEntry
+		if err := unmarshalRecord(record, &entries); err != nil {
+			w.discarded++
+		} else if err := w.writer.Write(entries); err != nil {
+			return err
+		}
+
+		if err := w.spill.Pop(); err != nil {
+			return err
+		}
+	}
+}
+
+// unmarshalRecord decodes a record of the write-ahead log or of a spill queue. Numbers in Data and
+// Metadata are decoded as json.Number, so that integers are not read back as floats.
+func unmarshalRecord(record []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(record))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func (w *PolicyWriter) spillBatch(entries []LogEntry, cause error) error {
+	if w.spill == nil {
+		return cause
+	}
+
+	// The caller forgets a spilled batch, and the processor truncates its write-ahead log.
+	record, err := json.Marshal(entries)
+	if err == nil {
+		err = w.spill.Push(record)
+	}
+	if err == nil {
+		err = w.spill.Sync()
+	}
+	if err != nil {
+		return multierr.Append(cause, fmt.Errorf("error spilling batch: %w", err))
+	}
+
+	return fmt.Errorf("%w (%w)", ErrSpilled, cause)
+}
+
+// Spilled returns the bytes of batches waiting in the spill queue.
+func (w *PolicyWriter) Spilled() int64 {
+	if w.spill == nil {
+		return 0
+	}
+	return w.spill.Size()
+}
+
+// Discarded returns the number of spilled batches that could not be decoded to be written.
+func (w *PolicyWriter) Discarded() int {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	return w.discarded
+}
+
+func (w *PolicyWriter) Files() []string {
+	if f, ok := w.writer.(FileWriter); ok {
+		return f.Files()
+	}
+	return nil
+}
+
+func (w *PolicyWriter) BytesWritten() uint64 {
+	if counter, ok := w.writer.(ByteCounter); ok {
+		return counter.BytesWritten()
+	}
+	return 0
+}
+
+func (w *PolicyWriter) Rotate() error {
+	rotator, ok := w.writer.(Rotator)
+	if !ok {
+		return fmt.Errorf("writer does not support rotation")
+	}
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	return rotator.Rotate()
+}
+
+// Close tries once more to write the spilled batches; those left are written by the next session.
+func (w *PolicyWriter) Close() error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if w.spill == nil {
+		return w.writer.Close()
+	}
+
+	var err error
+	if e := w.drain(); e != nil {
+		err = fmt.Errorf("%d bytes of spilled entries left for the next session: %w", w.spill.Size(), e)
+	}
+	if w.discarded > 0 {
+		err = multierr.Append(err, fmt.Errorf("%d spilled batches could not be decoded and were discarded", w.discarded))
+	}
+	return multierr.Combine(err, w.writer.Close(), w.spill.Close())
+}
@@ -0,0 +1,92 @@
+package cellularlog
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingWriter counts its writes and fails while fail is set.
+type countingWriter struct {
+	memoryWriter
+	writes int
+}
+
+func (w *countingWriter) Write(entries []LogEntry) error {
+	w.writes++
+	return w.memoryWriter.Write(entries)
+}
+
+func TestPolicyWriterBacksOffWithoutWaiting(t *testing.T) {
+	writer := &countingWriter{memoryWriter: memoryWriter{fail: true}}
+	policy, err := NewPolicyWriter(writer, FailurePolicy{Retries: 1, MinBackoff: time.Hour, SpillDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer policy.Close()
+
+	start := time.Now()
+	for i := uint64(1); i <= 4; i++ {
+		if err := policy.Write([]LogEntry{{Index: i}}); !errors.Is(err, ErrSpilled) {
+			t.Fatalf("write %d: expected the batch to be spilled, got %v", i, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("writes waited for the backoff: %v", elapsed)
+	}
+	// The first failure is retried at once, the second starts the backoff.
+	if writer.writes != 2 {
+		t.Errorf("expected the writer to be tried twice, got %d", writer.writes)
+	}
+
+	writer.fail = false
+	policy.retryAt = time.Time{} // the backoff has passed
+	if err := policy.Write([]LogEntry{{Index: 5}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := indexes(writer.entries); len(got) != 5 || got[0] != 1 || got[4] != 5 {
+		t.Errorf("expected the spilled batches before the new one, got %v", got)
+	}
+	if policy.Spilled() != 0 {
+		t.Errorf("expected the spill to be drained, %d bytes left", policy.Spilled())
+	}
+}
+
+func TestPolicyWriterWithoutSpillLeavesBatchToCaller(t *testing.T) {
+	writer := &countingWriter{memoryWriter: memoryWriter{fail: true}}
+	policy, err := NewPolicyWriter(writer, FailurePolicy{MinBackoff: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := policy.Write([]LogEntry{{Index: 1}}); err == nil || delivered(err) {
+			t.Fatalf("expected an undelivered error, got %v", err)
+		}
+	}
+	if writer.writes != 1 {
+		t.Errorf("expected the writer not to be tried while backing off, got %d writes", writer.writes)
+	}
+}
+
+func TestPolicyWriterCountsUndecodableBatches(t *testing.T) {
+	writer := &countingWriter{}
+	policy, err := NewPolicyWriter(writer, FailurePolicy{SpillDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := policy.spill.Push([]byte(`[{"index":`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.Write([]LogEntry{{Index: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := indexes(writer.entries); len(got) != 1 || policy.Discarded() != 1 || policy.Spilled() != 0 {
+		t.Errorf("expected the torn batch to be discarded and counted, got %v written and %d discarded", got, policy.Discarded())
+	}
+	if err := policy.Close(); err == nil || !strings.Contains(err.Error(), "1 spilled batches") {
+		t.Errorf("expected closing to report the discarded batch, got %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package cellularlog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/harshabose/cellular_localisation_logging/internal/multierr"
+)
+
+// EntryReader reads back the entries of a log, e.g. for offline tools. Entries carry their data as
+// generic JSON values instead of the message's types. Read returns io.EOF after the last entry.
+type EntryReader interface {
+	Read() (LogEntry, error)
+}
+
+// RawEntryReader is implemented by the EntryReaders of this package. ReadRaw returns the JSON of the
+// next entry exactly as it was written.
+type RawEntryReader interface {
+	ReadRaw() ([]byte, error)
+}
+
+type jsonReader struct {
+	decoder *json.Decoder
+}
+
+// NewJSONReader reads the output of a JSONWriter.
+func NewJSONReader(r io.Reader) EntryReader {
+	return &jsonReader{decoder: json.NewDecoder(r)}
+}
+
+func (r *jsonReader) Read() (LogEntry, error) {
+	return decodeEntry(r)
+}
+
+func (r *jsonReader) ReadRaw() ([]byte, error) {
+	var raw json.RawMessage
+	if err := r.decoder.Decode(&raw); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("invalid JSON entry: %w", err)
+	}
+	return raw, nil
+}
+
+type binaryReader struct {
+	r io.Reader
+}
+
+// NewBinaryReader reads the output of a BinaryWriter.
+func NewBinaryReader(r io.Reader) EntryReader {
+	return &binaryReader{r: bufio.NewReader(r)}
+}
+
+func (r *binaryReader) Read() (LogEntry, error) {
+	return decodeEntry(r)
+}
+
+func (r *binaryReader) ReadRaw() ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r.r, binary.LittleEndian, &length); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("truncated entry: %w", err)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, fmt.Errorf("truncated entry: %w", err)
+	}
+	return data, nil
+}
+
+func decodeEntry(r RawEntryReader) (LogEntry, error) {
+	data, err := r.ReadRaw()
+	if err != nil {
+		return LogEntry{}, err
+	}
+
+	var entry LogEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return LogEntry{}, fmt.Errorf("invalid entry: %w", err)
+	}
+	return entry, nil
+}
+
+// LogFormat returns the format of a log file by its extension, json or binary, ignoring a .gz
+// suffix, and whether it is compressed.
+func LogFormat(filename string) (format string, compressed bool, err error) {
+	name, compressed := strings.CutSuffix(filename, ".gz")
+	switch {
+	case strings.HasSuffix(name, ".enc"):
+		return "", false, fmt.Errorf("%s: encrypted, decrypt it with cmd/decrypt first", filename)
+	case strings.HasSuffix(name, ".json"):
+		return "json", compressed, nil
+	case strings.HasSuffix(name, ".bin"):
+		return "binary", compressed, nil
+	default:
+		return "", false, fmt.Errorf("%s: unsupported log format (supported: .json, .bin, optionally .gz)", filename)
+	}
+}
+
+// OpenLog opens a log written by a JSONWriter or BinaryWriter, compressed or not; see LogFormat.
+func OpenLog(filename string) (EntryReader, io.Closer, error) {
+	format, compressed, err := LogFormat(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		r      io.Reader = file
+		closer io.Closer = file
+	)
+	if compressed {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			_ = file.Close()
+			return nil, nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		r, closer = gz, closers{gz, file}
+	}
+
+	return NewLogReader(r, format), closer, nil
+}
+
+// NewLogReader reads a log of a format returned by LogFormat from r.
+func NewLogReader(r io.Reader, format string) EntryReader {
+	if format == "binary" {
+		return NewBinaryReader(r)
+	}
+	return NewJSONReader(r)
+}
+
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var err error
+	for _, closer := range c {
+		err = multierr.Append(err, closer.Close())
+	}
+	return err
+}
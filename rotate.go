@@ -0,0 +1,247 @@
+package cellularlog
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging/internal/multierr"
+)
+
+// RotationPolicy decides when a RotatingWriter starts a new file.
+type RotationPolicy struct {
+	MaxSize  int64         // bytes, zero disables size based rotation
+	MaxAge   time.Duration // zero disables time based rotation
+	Compress bool          // gzip each file once it is closed
+}
+
+func (p RotationPolicy) rotates() bool {
+	return p.MaxSize > 0 || p.MaxAge > 0
+}
+
+// RotatingWriter splits the output of a file based Writer into numbered files, e.g.
+// session_000.json, session_001.json, opening each one with a fresh writer so that per-file
+// headers (CSV columns, the session header) are repeated. Without size or age limits it writes a single prefix+ext file, which
+// is still compressed on close if the policy asks for it.
+type RotatingWriter struct {
+	prefix string
+	ext    string
+	policy RotationPolicy
+	open   func(filename string) (Writer, error)
+
+	writer   Writer
+	header   *LogEntry // written at the start of every file once set
+	filename string
+	opened   time.Time
+	segment  int
+	closed   uint64 // bytes written to closed files, before compression
+
+	compressing sync.WaitGroup
+	errs        error // from background compression
+	errMux      sync.Mutex
+
+	mu sync.Mutex
+}
+
+// NewRotatingWriter example
+//
+//	NewRotatingWriter("session", ".json", RotationPolicy{MaxSize: 100 << 20, Compress: true},
+//		func(filename string) (Writer, error) { return NewJSONWriter(filename) })
+func NewRotatingWriter(prefix, ext string, policy RotationPolicy, open func(filename string) (Writer, error)) (*RotatingWriter, error) {
+	w := &RotatingWriter{
+		prefix: prefix,
+		ext:    ext,
+		policy: policy,
+		open:   open,
+	}
+
+	if err := w.openNext(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *RotatingWriter) openNext() error {
+	filename := w.prefix + w.ext
+	if w.policy.rotates() {
+		filename = fmt.Sprintf("%s_%03d%s", w.prefix, w.segment, w.ext)
+	}
+
+	writer, err := w.open(filename)
+	if err != nil {
+		return err
+	}
+	// The writer may name its file differently, e.g. with the .enc of an encrypted file.
+	if f, ok := writer.(FileWriter); ok && len(f.Files()) == 1 {
+		filename = f.Files()[0]
+	}
+	if w.header != nil {
+		if err := writeHeader(writer, *w.header); err != nil {
+			_ = writer.Close()
+			return fmt.Errorf("error writing session header to %s: %w", filename, err)
+		}
+	}
+
+	w.writer = writer
+	w.filename = filename
+	w.opened = time.Now()
+	w.segment++
+
+	return nil
+}
+
+func (w *RotatingWriter) Write(entries []LogEntry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.writer != nil && w.policy.MaxAge > 0 && time.Since(w.opened) >= w.policy.MaxAge {
+		if err := w.closeCurrent(); err != nil {
+			return err
+		}
+	}
+
+	// The next file is opened on demand, so a rotation right before Close leaves no empty file.
+	if w.writer == nil {
+		if err := w.openNext(); err != nil {
+			return err
+		}
+	}
+
+	if err := w.writer.Write(entries); err != nil {
+		return err
+	}
+
+	if w.policy.MaxSize > 0 {
+		if info, err := os.Stat(w.filename); err == nil && info.Size() >= w.policy.MaxSize {
+			return w.closeCurrent()
+		}
+	}
+
+	return nil
+}
+
+// WriteHeader writes the session header to the current file and to every file opened later.
+func (w *RotatingWriter) WriteHeader(header LogEntry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.header = &header
+	if w.writer == nil {
+		return nil
+	}
+	return writeHeader(w.writer, header)
+}
+
+// Rotate closes the current file regardless of the policy limits; the next write starts a new one.
+func (w *RotatingWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.policy.rotates() {
+		return fmt.Errorf("rotation is not enabled for %s", w.filename)
+	}
+
+	return w.closeCurrent()
+}
+
+func (w *RotatingWriter) closeCurrent() error {
+	if w.writer == nil {
+		return nil
+	}
+
+	writer := w.writer
+	w.writer = nil
+	if counter, ok := writer.(ByteCounter); ok {
+		w.closed += counter.BytesWritten()
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("error closing %s: %w", w.filename, err)
+	}
+
+	if w.policy.Compress {
+		filename := w.filename
+
+		w.compressing.Add(1)
+		go func() {
+			defer w.compressing.Done()
+
+			if err := compressFile(filename); err != nil {
+				w.errMux.Lock()
+				w.errs = multierr.Append(w.errs, err)
+				w.errMux.Unlock()
+			}
+		}()
+	}
+
+	return nil
+}
+
+// Files returns the file currently or last written.
+func (w *RotatingWriter) Files() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return []string{w.filename}
+}
+
+// BytesWritten counts the bytes written by the underlying writers, across files, before compression.
+func (w *RotatingWriter) BytesWritten() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := w.closed
+	if counter, ok := w.writer.(ByteCounter); ok {
+		n += counter.BytesWritten()
+	}
+	return n
+}
+
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.closeCurrent()
+	w.compressing.Wait()
+
+	w.errMux.Lock()
+	defer w.errMux.Unlock()
+
+	return multierr.Combine(err, w.errs)
+}
+
+// compressFile replaces filename with filename.gz.
+func compressFile(filename string) (err error) {
+	in, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(filename + ".gz")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = out.Close()
+			_ = os.Remove(filename + ".gz")
+		}
+	}()
+
+	zw := gzip.NewWriter(out)
+	if _, err = io.Copy(zw, in); err != nil {
+		return fmt.Errorf("error compressing %s: %w", filename, err)
+	}
+	if err = zw.Close(); err != nil {
+		return fmt.Errorf("error compressing %s: %w", filename, err)
+	}
+	if err = out.Close(); err != nil {
+		return fmt.Errorf("error compressing %s: %w", filename, err)
+	}
+
+	return os.Remove(filename)
+}
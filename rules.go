@@ -0,0 +1,357 @@
+package cellularlog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging/internal/multierr"
+)
+
+// RuleMessageType is the MessageType of the entries that record rules firing and clearing.
+const RuleMessageType = "rule"
+
+// Comparisons of a Condition.
+const (
+	RuleBelow     = "below"      // the field is a number less than Value
+	RuleAbove     = "above"      // the field is a number greater than Value
+	RuleEquals    = "equals"     // the field equals Value, as numbers if both are
+	RuleNotEquals = "not_equals" // the field does not equal Value
+	RuleContains  = "contains"   // the field, as text, contains Value
+	RuleExcludes  = "excludes"   // the field, as text, does not contain Value
+	RuleChanged   = "changed"    // the field differs from the one of the message's previous entry
+)
+
+const (
+	// RuleFired is a rule becoming active.
+	RuleFired = "fired"
+	// RuleCleared is a rule becoming inactive, its condition no longer holding or its time being up.
+	RuleCleared = "cleared"
+)
+
+// Condition is a comparison of a field of the successful entries of a message.
+type Condition struct {
+	Message Message
+	// Field is the dot-separated path of the field in the entry's data as logged in JSON, e.g. "RSRP"
+	// or "cells.0.rsrp". Entries without the field never satisfy the condition.
+	Field string
+	Op    string
+	Value interface{} // a number for RuleBelow and RuleAbove, unused for RuleChanged
+}
+
+// Action is how the processor requests messages while a rule is active.
+type Action struct {
+	Messages []Message
+	// Requester adds every message sent through the requester of that name, except the message of
+	// the rule's condition, which must keep being requested for the rule to clear.
+	Requester string
+	Interval  time.Duration // requested at this interval instead of their own, if not zero
+	Pause     bool          // not requested at all
+}
+
+// Rule changes how messages are requested while a condition on logged values holds, e.g. polling the
+// serving cell faster while the signal is weak.
+type Rule struct {
+	Name string
+	When Condition
+	Then Action
+	// For keeps the rule active this long after its condition last held. Zero keeps it active exactly
+	// while the condition holds, which RuleChanged, true for a single entry, does not allow.
+	For time.Duration
+}
+
+// RuleEvent is the data of a RuleMessageType entry.
+type RuleEvent struct {
+	Rule  string      `json:"rule"`
+	Event string      `json:"event"`
+	Value interface{} `json:"value,omitempty"` // of the field when the rule fired
+	Until time.Time   `json:"until,omitzero"`  // when the rule clears unless its condition holds again
+}
+
+// validate returns the problems of the rule.
+func (r Rule) validate() error {
+	var err error
+	if r.Name == "" {
+		err = multierr.Append(err, errors.New("no name"))
+	}
+	if r.When.Message == nil {
+		err = multierr.Append(err, errors.New("no condition message"))
+	}
+	if r.When.Field == "" {
+		err = multierr.Append(err, errors.New("no condition field"))
+	}
+
+	switch r.When.Op {
+	case RuleBelow, RuleAbove:
+		if _, ok := number(r.When.Value); !ok {
+			err = multierr.Append(err, fmt.Errorf("%s needs a number, not %v", r.When.Op, r.When.Value))
+		}
+	case RuleEquals, RuleNotEquals, RuleContains, RuleExcludes:
+	case RuleChanged:
+		if r.For <= 0 {
+			err = multierr.Append(err, fmt.Errorf("%s needs a duration to stay active for", r.When.Op))
+		}
+	default:
+		err = multierr.Append(err, fmt.Errorf("unknown comparison '%s'", r.When.Op))
+	}
+
+	if len(r.Then.Messages) == 0 && r.Then.Requester == "" {
+		err = multierr.Append(err, errors.New("no messages or requester to act on"))
+	}
+	if r.Then.Interval <= 0 && !r.Then.Pause {
+		err = multierr.Append(err, errors.New("no interval or pause"))
+	}
+	if r.For < 0 {
+		err = multierr.Append(err, errors.New("negative duration"))
+	}
+	return err
+}
+
+// applies reports whether the rule's action applies to message.
+func (r Rule) applies(message Message) bool {
+	if message == r.When.Message && r.Then.Pause {
+		return false
+	}
+	if r.Then.Requester != "" && message.GetRequester() == r.Then.Requester {
+		return true
+	}
+	for _, m := range r.Then.Messages {
+		if m == message {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleState is a rule and whether it is active, kept by the loop goroutine.
+type ruleState struct {
+	rule     Rule
+	active   bool
+	until    time.Time   // for rules with For
+	previous interface{} // field of the last entry, for RuleChanged
+	seen     bool
+}
+
+// observe evaluates the rule on the data of a successful entry of its condition's message, decoded
+// by decodeData, and returns the event, if the rule fired or cleared.
+func (s *ruleState) observe(data interface{}, now time.Time) (RuleEvent, bool) {
+	value, found := field(data, s.rule.When.Field)
+	held := false
+	if s.rule.When.Op == RuleChanged {
+		held = found && s.seen && !equal(value, s.previous)
+		if found {
+			s.previous, s.seen = value, true
+		}
+	} else {
+		held = found && s.rule.When.holds(value)
+	}
+
+	switch {
+	case held && s.rule.For > 0:
+		s.until = now.Add(s.rule.For)
+		if s.active {
+			return RuleEvent{}, false
+		}
+		s.active = true
+		return RuleEvent{Rule: s.rule.Name, Event: RuleFired, Value: value, Until: s.until}, true
+	case held && !s.active:
+		s.active = true
+		return RuleEvent{Rule: s.rule.Name, Event: RuleFired, Value: value}, true
+	case !held && s.active && s.rule.For == 0:
+		s.active = false
+		return RuleEvent{Rule: s.rule.Name, Event: RuleCleared}, true
+	}
+	return RuleEvent{}, false
+}
+
+// expire clears the rule if its time is up.
+func (s *ruleState) expire(now time.Time) (RuleEvent, bool) {
+	if !s.active || s.rule.For == 0 || now.Before(s.until) {
+		return RuleEvent{}, false
+	}
+	s.active = false
+	return RuleEvent{Rule: s.rule.Name, Event: RuleCleared}, true
+}
+
+// holds reports whether value satisfies the condition.
+func (c Condition) holds(value interface{}) bool {
+	switch c.Op {
+	case RuleBelow, RuleAbove:
+		a, ok := number(value)
+		b, _ := number(c.Value)
+		if !ok {
+			return false
+		}
+		if c.Op == RuleBelow {
+			return a < b
+		}
+		return a > b
+	case RuleEquals:
+		return equal(value, c.Value)
+	case RuleNotEquals:
+		return !equal(value, c.Value)
+	case RuleContains:
+		return strings.Contains(text(value), text(c.Value))
+	case RuleExcludes:
+		return !strings.Contains(text(value), text(c.Value))
+	}
+	return false
+}
+
+// decodeData returns the data of an entry as it is logged in JSON, as generic values.
+func decodeData(data interface{}) interface{} {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil
+	}
+	return value
+}
+
+// field returns the value at a dot-separated path of data decoded by decodeData.
+func field(value interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, value != nil
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func text(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+func equal(a, b interface{}) bool {
+	x, ok1 := number(a)
+	y, ok2 := number(b)
+	if ok1 && ok2 {
+		return x == y
+	}
+	if ok1 != ok2 {
+		return false
+	}
+	return text(a) == text(b)
+}
+
+// SetRules replaces the rules of the processor. It must be called before Start.
+func (p *Processor) SetRules(rules []Rule) error {
+	var err error
+	names := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if e := rule.validate(); e != nil {
+			err = multierr.Append(err, fmt.Errorf("rule '%s': %w", rule.Name, e))
+		}
+		if names[rule.Name] {
+			err = multierr.Append(err, fmt.Errorf("rule '%s' declared twice", rule.Name))
+		}
+		names[rule.Name] = true
+	}
+	if err != nil {
+		return err
+	}
+
+	p.rules = make([]*ruleState, 0, len(rules))
+	for _, rule := range rules {
+		p.rules = append(p.rules, &ruleState{rule: rule})
+	}
+	return nil
+}
+
+// ruleInterval returns whether an active rule pauses message and the interval the active rules set
+// for it, the shortest if several do, zero if none does.
+func (p *Processor) ruleInterval(message Message) (bool, time.Duration) {
+	var interval time.Duration
+	for _, state := range p.rules {
+		if !state.active || !state.rule.applies(message) {
+			continue
+		}
+		if state.rule.Then.Pause {
+			return true, 0
+		}
+		if interval == 0 || state.rule.Then.Interval < interval {
+			interval = state.rule.Then.Interval
+		}
+	}
+	return false, interval
+}
+
+// expireRules clears the rules whose time is up.
+func (p *Processor) expireRules() {
+	now := time.Now()
+	for _, state := range p.rules {
+		if event, ok := state.expire(now); ok {
+			p.logRule(event, now)
+		}
+	}
+}
+
+// applyRules evaluates the rules on an entry of message. The data is decoded once for all of them.
+func (p *Processor) applyRules(message Message, entry LogEntry) {
+	if !entry.Success {
+		return
+	}
+
+	var (
+		now     time.Time
+		data    interface{}
+		decoded bool
+	)
+	for _, state := range p.rules {
+		if state.rule.When.Message != message {
+			continue
+		}
+		if !decoded {
+			now, data, decoded = time.Now(), decodeData(entry.Data), true
+		}
+		if event, ok := state.observe(data, now); ok {
+			p.logRule(event, now)
+		}
+	}
+}
+
+func (p *Processor) logRule(event RuleEvent, now time.Time) {
+	p.addLogEntry(LogEntry{
+		Index:        p.ruleIndex,
+		MessageType:  RuleMessageType,
+		Success:      true,
+		Data:         event,
+		RequestTime:  now,
+		ResponseTime: now,
+	})
+	p.ruleIndex++
+}
@@ -0,0 +1,157 @@
+package cellularlog
+
+import (
+	"testing"
+	"time"
+)
+
+// stub is a message that is only compared, never requested.
+type stub struct{ requester, messageType string }
+
+func (m *stub) Process(Requester) (LogEntry, error) { return LogEntry{}, nil }
+func (m *stub) GetRequester() string                { return m.requester }
+func (m *stub) GetType() string                     { return m.messageType }
+func (m *stub) GetAllEntries() []LogEntry           { return nil }
+
+type servingCell struct {
+	CellID string
+	RSRP   *int
+}
+
+func cell(id string, rsrp int) interface{} {
+	return decodeData(&servingCell{CellID: id, RSRP: &rsrp})
+}
+
+// observe returns the event of observing data, or "" for none.
+func observe(s *ruleState, data interface{}, now time.Time) string {
+	if event, ok := s.observe(data, now); ok {
+		return event.Event
+	}
+	return ""
+}
+
+func expire(s *ruleState, now time.Time) string {
+	if event, ok := s.expire(now); ok {
+		return event.Event
+	}
+	return ""
+}
+
+func TestRuleWithDuration(t *testing.T) {
+	qeng := &stub{requester: "modem", messageType: "at-+QENG"}
+	s := &ruleState{rule: Rule{
+		Name: "weak-signal",
+		When: Condition{Message: qeng, Field: "RSRP", Op: RuleBelow, Value: -110.0},
+		Then: Action{Messages: []Message{qeng}, Interval: time.Second},
+		For:  time.Minute,
+	}}
+	t0 := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	event, ok := s.observe(cell("1A", -115), t0)
+	if !ok || event.Event != RuleFired || event.Value != -115.0 || !event.Until.Equal(t0.Add(time.Minute)) {
+		t.Fatalf("expected the rule to fire until %v, got %+v", t0.Add(time.Minute), event)
+	}
+	if got := observe(s, cell("1A", -112), t0.Add(10*time.Second)); got != "" {
+		t.Errorf("expected no event while active, got %s", got)
+	}
+	if got := observe(s, cell("1A", -100), t0.Add(20*time.Second)); got != "" {
+		t.Errorf("expected the rule to stay active for its duration, got %s", got)
+	}
+	if got := expire(s, t0.Add(69*time.Second)); got != "" {
+		t.Errorf("expected the retrigger to extend the rule, got %s", got)
+	}
+	if got := expire(s, t0.Add(70*time.Second)); got != RuleCleared {
+		t.Errorf("expected the rule to clear a minute after it last held, got %q", got)
+	}
+	if got := expire(s, t0.Add(80*time.Second)); got != "" {
+		t.Errorf("expected a cleared rule to stay quiet, got %s", got)
+	}
+}
+
+func TestRuleWhileConditionHolds(t *testing.T) {
+	heartbeat := &stub{requester: "mavlink", messageType: "mavlink-0"}
+	s := &ruleState{rule: Rule{
+		Name: "disarmed",
+		When: Condition{Message: heartbeat, Field: "BaseMode", Op: RuleExcludes, Value: "MAV_MODE_FLAG_SAFETY_ARMED"},
+		Then: Action{Requester: "mavlink", Pause: true},
+	}}
+	armed := map[string]interface{}{"BaseMode": "MAV_MODE_FLAG_SAFETY_ARMED | MAV_MODE_FLAG_CUSTOM_MODE_ENABLED"}
+	disarmed := map[string]interface{}{"BaseMode": "MAV_MODE_FLAG_CUSTOM_MODE_ENABLED"}
+	now := time.Now()
+
+	for i, step := range []struct {
+		data interface{}
+		want string
+	}{
+		{armed, ""},
+		{disarmed, RuleFired},
+		{disarmed, ""},
+		{map[string]interface{}{}, RuleCleared}, // no field, no match
+		{disarmed, RuleFired},
+		{armed, RuleCleared},
+	} {
+		if got := observe(s, step.data, now); got != step.want {
+			t.Errorf("step %d: expected %q, got %q", i, step.want, got)
+		}
+	}
+	if got := expire(s, now.Add(time.Hour)); got != "" {
+		t.Errorf("expected a rule without duration not to expire, got %s", got)
+	}
+}
+
+func TestRuleChanged(t *testing.T) {
+	qeng := &stub{requester: "modem", messageType: "at-+QENG"}
+	s := &ruleState{rule: Rule{
+		Name: "cell-change",
+		When: Condition{Message: qeng, Field: "CellID", Op: RuleChanged},
+		Then: Action{Messages: []Message{qeng}, Interval: time.Second},
+		For:  time.Minute,
+	}}
+	t0 := time.Now()
+
+	if got := observe(s, cell("1A", -90), t0); got != "" {
+		t.Errorf("expected the first value not to count as a change, got %s", got)
+	}
+	if got := observe(s, cell("1A", -95), t0.Add(time.Second)); got != "" {
+		t.Errorf("expected no change, got %s", got)
+	}
+	if got := observe(s, cell("2B", -95), t0.Add(2*time.Second)); got != RuleFired {
+		t.Errorf("expected the cell change to fire the rule, got %q", got)
+	}
+	if got := expire(s, t0.Add(62*time.Second)); got != RuleCleared {
+		t.Errorf("expected the rule to clear after its duration, got %q", got)
+	}
+}
+
+func TestRuleActions(t *testing.T) {
+	heartbeat := &stub{requester: "mavlink", messageType: "mavlink-0"}
+	attitude := &stub{requester: "mavlink", messageType: "mavlink-30"}
+	qeng := &stub{requester: "modem", messageType: "at-+QENG"}
+
+	p := &Processor{}
+	err := p.SetRules([]Rule{
+		{Name: "disarmed", When: Condition{Message: heartbeat, Field: "BaseMode", Op: RuleEquals, Value: "0"}, Then: Action{Requester: "mavlink", Pause: true}},
+		{Name: "slow", When: Condition{Message: qeng, Field: "RSRP", Op: RuleBelow, Value: -100.0}, Then: Action{Messages: []Message{qeng}, Interval: 5 * time.Second}},
+		{Name: "fast", When: Condition{Message: qeng, Field: "RSRP", Op: RuleBelow, Value: -110.0}, Then: Action{Messages: []Message{qeng}, Interval: time.Second}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range p.rules {
+		state.active = true
+	}
+
+	if paused, _ := p.ruleInterval(attitude); !paused {
+		t.Error("expected the requester's messages to be paused")
+	}
+	if paused, _ := p.ruleInterval(heartbeat); paused {
+		t.Error("expected the condition's own message not to be paused")
+	}
+	if paused, interval := p.ruleInterval(qeng); paused || interval != time.Second {
+		t.Errorf("expected the shortest interval, got %v (paused %v)", interval, paused)
+	}
+
+	if err := p.SetRules([]Rule{{Name: "bad", When: Condition{Message: qeng, Field: "CellID", Op: RuleChanged}, Then: Action{Messages: []Message{qeng}, Interval: time.Second}}}); err == nil {
+		t.Error("expected changed without a duration to be rejected")
+	}
+}
@@ -0,0 +1,284 @@
+package cellularlog
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging/internal/sketch"
+)
+
+const (
+	statsWindow = 10 * time.Minute
+	// gapFactor is how many smoothed inter-arrival times without a successful entry make a gap.
+	gapFactor = 3
+)
+
+// StatsSnapshot describes the entries of one message type or requester since the session started.
+// Rates are percentages, latencies are of successful entries.
+type StatsSnapshot struct {
+	Name           string        `json:"name"`
+	Requests       uint64        `json:"requests"`
+	Failures       uint64        `json:"failures"`
+	SuccessRate    float64       `json:"success_rate"`
+	Requests1m     uint64        `json:"requests_1m"`
+	SuccessRate1m  float64       `json:"success_rate_1m"`
+	Requests10m    uint64        `json:"requests_10m"`
+	SuccessRate10m float64       `json:"success_rate_10m"`
+	LatencyMean    time.Duration `json:"latency_mean"`
+	LatencyP50     time.Duration `json:"latency_p50"`
+	LatencyP90     time.Duration `json:"latency_p90"`
+	LatencyP99     time.Duration `json:"latency_p99"`
+	LatencyMax     time.Duration `json:"latency_max"`
+	Errors         ErrorCounts   `json:"errors"`
+	Interval       time.Duration `json:"interval"` // smoothed time between successful entries
+	Jitter         time.Duration `json:"jitter"`   // smoothed variation of Interval, as in RFC 3550
+	Gaps           uint64        `json:"gaps"`     // times no entry succeeded for gapFactor intervals
+	LongestGap     time.Duration `json:"longest_gap"`
+	LastGap        time.Time     `json:"last_gap,omitempty"` // when the last gap ended
+	LastEntry      time.Time     `json:"last_entry,omitempty"`
+}
+
+// ErrorCounts are failed entries by ErrorClass.
+type ErrorCounts struct {
+	Timeout   uint64 `json:"timeout"`
+	Cancelled uint64 `json:"cancelled"`
+	Mismatch  uint64 `json:"mismatch"`
+	CME       uint64 `json:"cme"`
+	Parse     uint64 `json:"parse"`
+	IO        uint64 `json:"io"`
+	Other     uint64 `json:"other"`
+}
+
+func (c *ErrorCounts) add(class string) {
+	switch class {
+	case "timeout":
+		c.Timeout++
+	case "cancelled":
+		c.Cancelled++
+	case "mismatch":
+		c.Mismatch++
+	case "cme":
+		c.CME++
+	case "parse":
+		c.Parse++
+	case "io":
+		c.IO++
+	default:
+		c.Other++
+	}
+}
+
+// Stats keeps rolling statistics of entries by message type and by requester, updated as entries
+// are observed instead of by rescanning their history. It is safe for concurrent use.
+type Stats struct {
+	messages   map[string]*accumulator
+	requesters map[string]*accumulator
+	mux        sync.Mutex
+}
+
+func NewStats() *Stats {
+	return &Stats{messages: make(map[string]*accumulator), requesters: make(map[string]*accumulator)}
+}
+
+// Observe counts entry, requested through the named requester.
+func (s *Stats) Observe(requester string, entry LogEntry) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for _, key := range []struct {
+		m    map[string]*accumulator
+		name string
+	}{{s.messages, entry.MessageType}, {s.requesters, requester}} {
+		a, ok := key.m[key.name]
+		if !ok {
+			a = &accumulator{latency: sketch.New(0.01)}
+			key.m[key.name] = a
+		}
+		a.add(entry)
+	}
+}
+
+// Message returns the statistics of a message type.
+func (s *Stats) Message(messageType string) (StatsSnapshot, bool) {
+	return s.snapshot(s.messages, messageType)
+}
+
+// Requester returns the statistics of all messages requested through a requester.
+func (s *Stats) Requester(name string) (StatsSnapshot, bool) {
+	return s.snapshot(s.requesters, name)
+}
+
+// Messages returns the statistics of every message type observed, by name.
+func (s *Stats) Messages() []StatsSnapshot {
+	return s.snapshots(s.messages)
+}
+
+// Requesters returns the statistics of every requester observed, by name.
+func (s *Stats) Requesters() []StatsSnapshot {
+	return s.snapshots(s.requesters)
+}
+
+func (s *Stats) snapshot(m map[string]*accumulator, name string) (StatsSnapshot, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	a, ok := m[name]
+	if !ok {
+		return StatsSnapshot{Name: name}, false
+	}
+	return a.snapshot(name, time.Now()), true
+}
+
+func (s *Stats) snapshots(m map[string]*accumulator) []StatsSnapshot {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	now := time.Now()
+	snapshots := make([]StatsSnapshot, 0, len(m))
+	for name, a := range m {
+		snapshots = append(snapshots, a.snapshot(name, now))
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Name < snapshots[j].Name })
+
+	return snapshots
+}
+
+// accumulator holds the statistics of one message type or requester.
+type accumulator struct {
+	requests, successes uint64
+	window              [statsWindow / time.Second]windowBucket // by second of request
+	latency             *sketch.Sketch
+	errors              ErrorCounts
+
+	lastArrival time.Time
+	lastEntry   time.Time
+	interval    float64 // smoothed, nanoseconds
+	jitter      float64 // nanoseconds
+	arrivals    int
+	overdue     int // consecutive gaps, after which the interval is taken as changed
+
+	gaps       uint64
+	longestGap time.Duration
+	lastGap    time.Time
+}
+
+type windowBucket struct {
+	second              int64
+	requests, successes uint64
+}
+
+func (a *accumulator) add(entry LogEntry) {
+	at := entry.RequestTime
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	a.requests++
+	a.lastEntry = at
+
+	bucket := &a.window[at.Unix()%int64(len(a.window))]
+	if bucket.second != at.Unix() {
+		*bucket = windowBucket{second: at.Unix()}
+	}
+	bucket.requests++
+
+	if !entry.Success {
+		a.errors.add(ErrorClass(entry))
+		return
+	}
+
+	a.successes++
+	bucket.successes++
+	if entry.Duration > 0 {
+		a.latency.Add(float64(entry.Duration))
+	}
+
+	arrival := entry.ResponseTime
+	if arrival.IsZero() {
+		arrival = at
+	}
+	a.arrive(arrival)
+}
+
+// arrive updates the inter-arrival statistics with a successful entry received at t.
+func (a *accumulator) arrive(t time.Time) {
+	defer func() { a.lastArrival = t }()
+
+	if a.lastArrival.IsZero() {
+		return
+	}
+
+	d := float64(t.Sub(a.lastArrival))
+	a.arrivals++
+
+	if a.arrivals > 2 && d > gapFactor*a.interval {
+		a.gaps++
+		a.lastGap = t
+		a.longestGap = max(a.longestGap, time.Duration(d))
+
+		if a.overdue++; a.overdue < 3 {
+			return
+		}
+		a.interval, a.jitter = d, 0 // the message is requested less often now
+	}
+	a.overdue = 0
+
+	if a.arrivals == 1 {
+		a.interval = d
+		return
+	}
+
+	deviation := d - a.interval
+	if deviation < 0 {
+		deviation = -deviation
+	}
+	a.jitter += (deviation - a.jitter) / 16
+	a.interval += (d - a.interval) / 8
+}
+
+func (a *accumulator) snapshot(name string, now time.Time) StatsSnapshot {
+	s := StatsSnapshot{
+		Name:        name,
+		Requests:    a.requests,
+		Failures:    a.requests - a.successes,
+		SuccessRate: rate(a.successes, a.requests),
+		LatencyMean: time.Duration(a.latency.Mean()),
+		LatencyP50:  time.Duration(a.latency.Quantile(0.5)),
+		LatencyP90:  time.Duration(a.latency.Quantile(0.9)),
+		LatencyP99:  time.Duration(a.latency.Quantile(0.99)),
+		LatencyMax:  time.Duration(a.latency.Max()),
+		Errors:      a.errors,
+		Interval:    time.Duration(a.interval),
+		Jitter:      time.Duration(a.jitter),
+		Gaps:        a.gaps,
+		LongestGap:  a.longestGap,
+		LastGap:     a.lastGap,
+		LastEntry:   a.lastEntry,
+	}
+
+	var successes1m, successes10m uint64
+	for _, bucket := range a.window {
+		age := now.Unix() - bucket.second
+		if age < 0 || age >= int64(len(a.window)) {
+			continue
+		}
+		s.Requests10m += bucket.requests
+		successes10m += bucket.successes
+		if age < 60 {
+			s.Requests1m += bucket.requests
+			successes1m += bucket.successes
+		}
+	}
+	s.SuccessRate1m = rate(successes1m, s.Requests1m)
+	s.SuccessRate10m = rate(successes10m, s.Requests10m)
+
+	return s
+}
+
+func rate(successes, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(successes) / float64(total) * 100
+}
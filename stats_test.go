@@ -0,0 +1,93 @@
+package cellularlog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging/internal/sketch"
+)
+
+func newAccumulator() *accumulator {
+	return &accumulator{latency: sketch.New(0.01)}
+}
+
+// at adds a successful entry received at t0 plus offset seconds.
+func at(a *accumulator, t0 time.Time, offset float64) {
+	t := t0.Add(time.Duration(offset * float64(time.Second)))
+	a.add(LogEntry{Success: true, RequestTime: t, ResponseTime: t})
+}
+
+func TestStatsGaps(t *testing.T) {
+	t0 := time.Unix(1800000000, 0)
+	a := newAccumulator()
+
+	for _, offset := range []float64{0, 1, 2, 3, 4} {
+		at(a, t0, offset)
+	}
+	if s := a.snapshot("at", t0.Add(4*time.Second)); s.Interval != time.Second || s.Jitter != 0 || s.Gaps != 0 {
+		t.Fatalf("expected a steady 1s interval, got %v, jitter %v and %d gaps", s.Interval, s.Jitter, s.Gaps)
+	}
+
+	at(a, t0, 9)
+	s := a.snapshot("at", t0.Add(9*time.Second))
+	if s.Gaps != 1 || s.LongestGap != 5*time.Second || !s.LastGap.Equal(t0.Add(9*time.Second)) {
+		t.Errorf("expected a 5s gap ending at 9s, got %d gaps, longest %v, last %v", s.Gaps, s.LongestGap, s.LastGap)
+	}
+	if s.Interval != time.Second {
+		t.Errorf("expected a single gap not to change the interval, got %v", s.Interval)
+	}
+
+	// Three gaps in a row mean the message is now requested less often.
+	for _, offset := range []float64{10, 20, 30, 40} {
+		at(a, t0, offset)
+	}
+	s = a.snapshot("at", t0.Add(40*time.Second))
+	if s.Gaps != 4 || s.LongestGap != 10*time.Second || s.Interval != 10*time.Second {
+		t.Errorf("expected 4 gaps and a new 10s interval, got %d gaps, longest %v, interval %v", s.Gaps, s.LongestGap, s.Interval)
+	}
+	at(a, t0, 50)
+	if s := a.snapshot("at", t0.Add(50*time.Second)); s.Gaps != 4 {
+		t.Errorf("expected the new interval not to count as a gap, got %d gaps", s.Gaps)
+	}
+}
+
+func TestStatsJitter(t *testing.T) {
+	t0 := time.Unix(1800000000, 0)
+	a := newAccumulator()
+	for _, offset := range []float64{0, 1, 2, 3.5} {
+		at(a, t0, offset)
+	}
+	a.add(LogEntry{Success: false, RequestTime: t0.Add(4 * time.Second)})
+
+	s := a.snapshot("at", t0.Add(4*time.Second))
+	if s.Jitter != 31250*time.Microsecond || s.Interval != 1062500*time.Microsecond {
+		t.Errorf("expected a jitter of 31.25ms and an interval of 1.0625s, got %v and %v", s.Jitter, s.Interval)
+	}
+	if s.Requests != 5 || s.Failures != 1 || s.Gaps != 0 {
+		t.Errorf("expected 5 requests with 1 failure and no gap, got %+v", s)
+	}
+}
+
+func TestStatsWindowRollsOver(t *testing.T) {
+	t0 := time.Unix(1800000000, 0)
+	a := newAccumulator()
+	a.add(LogEntry{RequestTime: t0})
+	a.add(LogEntry{RequestTime: t0})
+	at(a, t0, 300)
+	at(a, t0, 600) // the bucket of t0, a window later
+
+	s := a.snapshot("at", t0.Add(600*time.Second))
+	if s.Requests != 4 || s.SuccessRate != 50 {
+		t.Errorf("expected 4 requests at 50%% over the session, got %d at %v%%", s.Requests, s.SuccessRate)
+	}
+	if s.Requests10m != 2 || s.SuccessRate10m != 100 {
+		t.Errorf("expected the failures to have left the window, got %d requests at %v%%", s.Requests10m, s.SuccessRate10m)
+	}
+	if s.Requests1m != 1 || s.SuccessRate1m != 100 {
+		t.Errorf("expected 1 request in the last minute, got %d at %v%%", s.Requests1m, s.SuccessRate1m)
+	}
+
+	if s := a.snapshot("at", t0.Add(1300*time.Second)); s.Requests10m != 0 || s.Requests1m != 0 || s.SuccessRate10m != 0 {
+		t.Errorf("expected an empty window after 10 quiet minutes, got %+v", s)
+	}
+}
@@ -0,0 +1,211 @@
+package cellularlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/harshabose/cellular_localisation_logging/internal/diskqueue"
+	"github.com/harshabose/cellular_localisation_logging/internal/multierr"
+)
+
+// SyncPolicy is when the write-ahead log is committed to stable storage.
+type SyncPolicy string
+
+const (
+	// SyncAlways commits every entry before the next request; nothing is lost on power loss.
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval commits when an entry is added at least SyncInterval after the last commit.
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever leaves committing to the OS; entries survive a crash of the process but not
+	// necessarily a kernel panic or power loss.
+	SyncNever SyncPolicy = "never"
+)
+
+// WALConfig configures the write-ahead log of the processor's buffer.
+type WALConfig struct {
+	Dir          string
+	Sync         SyncPolicy    // default SyncInterval
+	SyncInterval time.Duration // default 1s
+}
+
+// wal records buffered entries on disk until the writer has taken them. Entries are kept as JSON,
+// so replayed entries carry their data as generic maps instead of the message's types, with numbers
+// as json.Number.
+type wal struct {
+	queue    *diskqueue.Queue
+	kept     *diskqueue.Queue // entries dropped from the buffer, left for the next session
+	config   WALConfig
+	lastSync time.Time
+}
+
+func openWAL(config WALConfig) (*wal, error) {
+	switch config.Sync {
+	case "":
+		config.Sync = SyncInterval
+	case SyncAlways, SyncInterval, SyncNever:
+	default:
+		return nil, fmt.Errorf("unknown sync policy %q (supported: always, interval, never)", config.Sync)
+	}
+	if config.SyncInterval <= 0 {
+		config.SyncInterval = time.Second
+	}
+
+	queue, err := diskqueue.Open(config.Dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	kept, err := diskqueue.Open(filepath.Join(config.Dir, "dropped"), 0)
+	if err != nil {
+		_ = queue.Close()
+		return nil, err
+	}
+
+	return &wal{queue: queue, kept: kept, config: config, lastSync: time.Now()}, nil
+}
+
+func (w *wal) append(entry LogEntry) error {
+	record, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := w.queue.Push(record); err != nil {
+		return err
+	}
+
+	switch {
+	case w.config.Sync == SyncAlways:
+		return w.queue.Sync()
+	case w.config.Sync == SyncInterval && time.Since(w.lastSync) >= w.config.SyncInterval:
+		w.lastSync = time.Now()
+		return w.queue.Sync()
+	}
+	return nil
+}
+
+// keep sets aside entries the processor dropped without writing them, so that clearing the log
+// does not lose them. They are committed before the log can be cleared, whatever the policy.
+func (w *wal) keep(entries []LogEntry) error {
+	records := make([][]byte, 0, len(entries))
+	for _, entry := range entries {
+		record, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+	if err := w.kept.Push(records...); err != nil {
+		return err
+	}
+	return w.kept.Sync()
+}
+
+// restore moves the entries set aside by an earlier session back into the log. A crash in between
+// replays them twice rather than not at all.
+func (w *wal) restore() error {
+	records, err := w.kept.Records()
+	if err != nil || len(records) == 0 {
+		return err
+	}
+	if err := w.queue.Push(records...); err != nil {
+		return err
+	}
+	if err := w.queue.Sync(); err != nil {
+		return err
+	}
+	if err := w.kept.Clear(); err != nil {
+		return err
+	}
+	return w.kept.Sync()
+}
+
+// entries returns the entries still in the log. Records that no longer decode are skipped, and
+// counted in the error.
+func (w *wal) entries() ([]LogEntry, error) {
+	records, err := w.queue.Records()
+
+	var undecodable int
+	entries := make([]LogEntry, 0, len(records))
+	for _, record := range records {
+		var entry LogEntry
+		if e := unmarshalRecord(record, &entry); e != nil {
+			undecodable++
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if undecodable > 0 {
+		err = multierr.Append(err, fmt.Errorf("%d records could not be decoded and are lost", undecodable))
+	}
+
+	return entries, err
+}
+
+// clear is called once the writer has acknowledged every entry in the log that was not set aside.
+func (w *wal) clear() error {
+	if err := w.queue.Clear(); err != nil {
+		return err
+	}
+	if w.config.Sync != SyncNever {
+		return w.queue.Sync()
+	}
+	return nil
+}
+
+func (w *wal) close() error {
+	if w.config.Sync != SyncNever {
+		if err := w.queue.Sync(); err != nil {
+			_ = w.queue.Close()
+			_ = w.kept.Close()
+			return err
+		}
+	}
+	return multierr.Combine(w.queue.Close(), w.kept.Close())
+}
+
+// EnableWAL records every entry in a write-ahead log in config.Dir as it is added to the buffer,
+// and truncates the log whenever a flush succeeds, so that a crash or power cut loses at most the
+// entries not yet committed under config.Sync. Entries the processor drops after a failed flush
+// (see SetBackpressure), and the entries of a flush some writers of a MultiWriter missed, are set
+// aside in the log instead, for the next session. Entries left in the log by an earlier session are
+// written first, with Metadata["replayed"] set as their session offsets are of that session; the
+// number of them is returned. It fails only if the log cannot be opened. Call it before Start.
+func (p *Processor) EnableWAL(config WALConfig) (int, error) {
+	w, err := openWAL(config)
+	if err != nil {
+		return 0, fmt.Errorf("error opening write-ahead log: %w", err)
+	}
+
+	if err := w.restore(); err != nil {
+		p.printf("error restoring dropped entries of the write-ahead log: %v\n", err)
+	}
+	entries, err := w.entries()
+	if err != nil {
+		p.printf("error reading write-ahead log, replaying %d entries: %v\n", len(entries), err)
+	}
+
+	p.logMux.Lock()
+	defer p.logMux.Unlock()
+
+	p.wal = w
+	if len(entries) == 0 {
+		return 0, w.clear()
+	}
+
+	for i := range entries {
+		metadata := make(map[string]interface{}, len(entries[i].Metadata)+1)
+		for k, v := range entries[i].Metadata {
+			metadata[k] = v
+		}
+		metadata["replayed"] = true
+		entries[i].Metadata = metadata
+	}
+	p.logBuffer = append(entries, p.logBuffer...)
+	p.logPriorities = append(make([]int, len(entries)), p.logPriorities...)
+	if err := p.flushLogsUnsafe(); err != nil {
+		p.printf("error replaying write-ahead log, entries not written stay in it: %v\n", err)
+	}
+
+	return len(entries), nil
+}
@@ -0,0 +1,169 @@
+package cellularlog
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// memoryWriter keeps the entries it is given, or fails while fail is set.
+type memoryWriter struct {
+	entries []LogEntry
+	fail    bool
+}
+
+func (w *memoryWriter) Write(entries []LogEntry) error {
+	if w.fail {
+		return errors.New("disk full")
+	}
+	w.entries = append(w.entries, entries...)
+	return nil
+}
+
+func (w *memoryWriter) Close() error { return nil }
+
+func newWALProcessor(t *testing.T, dir string, writer Writer) (*Processor, int) {
+	t.Helper()
+
+	p := NewProcessor(context.Background(), time.Hour, time.Hour, writer, 100)
+	replayed, err := p.EnableWAL(WALConfig{Dir: dir, Sync: SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, replayed
+}
+
+func addEntries(p *Processor, indexes ...uint64) {
+	for _, i := range indexes {
+		p.addLogEntry(LogEntry{Index: i, MessageType: "test", Success: true, RequestTime: time.Now()})
+	}
+}
+
+func indexes(entries []LogEntry) []uint64 {
+	var list []uint64
+	for _, entry := range entries {
+		list = append(list, entry.Index)
+	}
+	return list
+}
+
+func TestWALReplaysAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+
+	crashed, _ := newWALProcessor(t, dir, &memoryWriter{})
+	addEntries(crashed, 1, 2, 3)
+	// Never flushed nor closed.
+
+	writer := &memoryWriter{}
+	p, replayed := newWALProcessor(t, dir, writer)
+	defer p.Close()
+
+	if replayed != 3 || len(writer.entries) != 3 {
+		t.Fatalf("expected 3 entries replayed and written, got %d and %v", replayed, indexes(writer.entries))
+	}
+	for _, entry := range writer.entries {
+		if entry.Metadata["replayed"] != true {
+			t.Errorf("entry %d not marked as replayed: %v", entry.Index, entry.Metadata)
+		}
+	}
+	if entries, _ := p.wal.entries(); len(entries) != 0 {
+		t.Errorf("expected the log to be truncated after the replay, %d entries left", len(entries))
+	}
+}
+
+func TestWALTruncatedAfterFlush(t *testing.T) {
+	dir := t.TempDir()
+
+	writer := &memoryWriter{}
+	p, _ := newWALProcessor(t, dir, writer)
+	addEntries(p, 1, 2)
+	if entries, _ := p.wal.entries(); len(entries) != 2 {
+		t.Fatalf("expected 2 entries in the log before the flush, got %d", len(entries))
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := p.wal.entries(); len(entries) != 0 {
+		t.Errorf("expected the log to be truncated, %d entries left", len(entries))
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	next, replayed := newWALProcessor(t, dir, &memoryWriter{})
+	defer next.Close()
+	if replayed != 0 {
+		t.Errorf("expected nothing to replay, got %d entries", replayed)
+	}
+}
+
+func TestWALKeepsEntriesDroppedWithoutBackpressure(t *testing.T) {
+	dir := t.TempDir()
+
+	writer := &memoryWriter{fail: true}
+	p, _ := newWALProcessor(t, dir, writer)
+	addEntries(p, 1, 2)
+	if err := p.Flush(); err == nil {
+		t.Fatal("expected the flush to fail")
+	}
+
+	writer.fail = false
+	addEntries(p, 3)
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := indexes(writer.entries); len(got) != 1 || got[0] != 3 {
+		t.Fatalf("expected only entry 3 to be written, got %v", got)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	next := &memoryWriter{}
+	p, replayed := newWALProcessor(t, dir, next)
+	defer p.Close()
+	if got := indexes(next.entries); replayed != 2 || len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("expected the dropped entries 1 and 2 to be replayed, got %v", got)
+	}
+}
+
+func TestWALReportsUndecodableRecords(t *testing.T) {
+	p, _ := newWALProcessor(t, t.TempDir(), &memoryWriter{})
+	defer p.Close()
+
+	addEntries(p, 1)
+	if err := p.wal.queue.Push([]byte(`{"index":`)); err != nil {
+		t.Fatal(err)
+	}
+	addEntries(p, 2)
+
+	entries, err := p.wal.entries()
+	if got := indexes(entries); len(got) != 2 || err == nil {
+		t.Errorf("expected entries 1 and 2 and an error for the torn record, got %v and %v", got, err)
+	}
+}
+
+func TestWALKeepsPartiallyWrittenEntries(t *testing.T) {
+	dir := t.TempDir()
+
+	healthy, failing := &memoryWriter{}, &memoryWriter{fail: true}
+	p, _ := newWALProcessor(t, dir, NewMultiWriter(healthy, failing))
+	addEntries(p, 1, 2)
+	if err := p.Flush(); !errors.Is(err, ErrPartialWrite) {
+		t.Fatalf("expected a partial write, got %v", err)
+	}
+	if p.GetWriterStats().Buffered != 0 || len(healthy.entries) != 2 {
+		t.Fatalf("expected the batch to leave the buffer for the healthy writer, got %v", indexes(healthy.entries))
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	next := &memoryWriter{}
+	p, replayed := newWALProcessor(t, dir, next)
+	defer p.Close()
+	if got := indexes(next.entries); replayed != 2 || len(got) != 2 {
+		t.Errorf("expected the entries the failing writer missed to be replayed, got %v", got)
+	}
+}